@@ -2,21 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
-	"time"
 
-	"github.com/iKonoTelecomunicaciones/go/event"
-
-	"github.com/iKonoTelecomunicaciones/go/bridgev2"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
 	"github.com/iKonoTelecomunicaciones/go/id"
-	"github.com/rs/zerolog/hlog"
 	"go.mau.fi/util/exhttp"
 	"go.mau.fi/whatsmeow/types"
 
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
-	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
 
 //var upgrader = websocket.Upgrader{
@@ -95,402 +90,182 @@ func legacyProvContacts(w http.ResponseWriter, r *http.Request) {
 	if userLogin == nil {
 		return
 	}
-	if contacts, err := userLogin.Client.(*connector.WhatsAppClient).GetStore().Contacts.GetAllContacts(r.Context()); err != nil {
-		hlog.FromRequest(r).Err(err).Msg("Failed to fetch all contacts")
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Internal server error while fetching contact list",
-			ErrCode: "failed to get contacts",
-		})
-	} else {
-		augmentedContacts := map[types.JID]any{}
-		for jid, contact := range contacts {
-			var avatarURL id.ContentURIString
-			if puppet, _ := m.Bridge.GetExistingGhostByID(r.Context(), waid.MakeUserID(jid)); puppet != nil {
-				avatarURL = puppet.AvatarMXC
-			}
-			augmentedContacts[jid] = map[string]interface{}{
-				"Found":        contact.Found,
-				"FirstName":    contact.FirstName,
-				"FullName":     contact.FullName,
-				"PushName":     contact.PushName,
-				"BusinessName": contact.BusinessName,
-				"AvatarURL":    avatarURL,
-			}
+	contacts, err := getContacts(r.Context(), userLogin)
+	if err != nil {
+		writeLegacyError(w, err, "Internal error fetching contacts")
+		return
+	}
+	augmentedContacts := map[types.JID]any{}
+	for jid, contact := range contacts {
+		info := wrapContactInfo(r.Context(), jid, contact)
+		augmentedContacts[jid] = map[string]interface{}{
+			"Found":        info.Found,
+			"FirstName":    info.FirstName,
+			"FullName":     info.FullName,
+			"PushName":     info.PushName,
+			"BusinessName": info.BusinessName,
+			"AvatarURL":    info.AvatarURL,
 		}
-		exhttp.WriteJSONResponse(w, http.StatusOK, augmentedContacts)
 	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, augmentedContacts)
 }
 
 func legacyProvResolveIdentifier(w http.ResponseWriter, r *http.Request) {
-	number := r.PathValue("number")
 	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
 	if userLogin == nil {
 		return
 	}
 	startChat := strings.Contains(r.URL.Path, "/v1/pm/")
-	resp, err := userLogin.Client.(*connector.WhatsAppClient).ResolveIdentifier(r.Context(), number, startChat)
+	resp, err := resolveIdentifier(r.Context(), userLogin, r.PathValue("number"), startChat)
 	if err != nil {
-		hlog.FromRequest(r).Warn().Err(err).Str("identifier", number).Msg("Failed to resolve identifier")
 		matrix.RespondWithError(w, err, "Internal error resolving identifier")
 		return
 	}
-	var portal *bridgev2.Portal
-	if startChat {
-		portal, err = m.Bridge.GetPortalByKey(r.Context(), resp.Chat.PortalKey)
-		if err != nil {
-			hlog.FromRequest(r).Warn().Err(err).Stringer("portal_key", resp.Chat.PortalKey).Msg("Failed to get portal by key")
-			matrix.RespondWithError(w, err, "Internal error getting portal by key")
-			return
-		}
-		err = portal.CreateMatrixRoom(r.Context(), userLogin, nil)
-		if err != nil {
-			hlog.FromRequest(r).Warn().Err(err).Stringer("portal_key", resp.Chat.PortalKey).Msg("Failed to create matrix room for portal")
-			matrix.RespondWithError(w, err, "Internal error creating matrix room for portal")
-			return
-		}
-	} else {
-		portal, _ = m.Bridge.GetExistingPortalByKey(r.Context(), resp.Chat.PortalKey)
-	}
-	var roomID id.RoomID
-	if portal != nil {
-		roomID = portal.MXID
-	}
-	exhttp.WriteJSONResponse(w, http.StatusOK, PortalInfo{
-		RoomID: roomID,
-		OtherUser: &OtherUserInfo{
-			JID:    waid.ParseUserID(resp.UserID),
-			MXID:   resp.Ghost.Intent.GetMXID(),
-			Name:   resp.Ghost.Name,
-			Avatar: resp.Ghost.AvatarMXC,
-		},
-	})
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
 }
 
 func legacyProvPing(w http.ResponseWriter, r *http.Request) {
 	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
-
 	if userLogin == nil {
 		return
 	}
-
-	whatsappClient := userLogin.Client.(*connector.WhatsAppClient)
-	managementRoom, err := userLogin.User.GetManagementRoom(r.Context())
-
+	resp, err := getPingInfo(r.Context(), userLogin)
 	if err != nil {
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while fetching management room",
-			ErrCode: "failed to get management room",
-		})
+		writeLegacyError(w, err, "Internal error getting connection info")
 		return
 	}
-
-	whatsappConnectionInfo := ConnectionInfo{
-		HasSession:     whatsappClient.IsLoggedIn(),
-		ManagementRoom: managementRoom,
-	}
-
-	if !whatsappClient.JID.IsEmpty() {
-		whatsappConnectionInfo.JID = whatsappClient.JID.String()
-		whatsappConnectionInfo.Phone = "+" + whatsappClient.JID.User
-		if whatsappClient.Device != nil && whatsappClient.Device.Platform != "" {
-			whatsappConnectionInfo.Platform = whatsappClient.Device.Platform
-		}
-	}
-
-	if whatsappClient.Client != nil {
-		whatsappConnectionInfo.Conn = ConnInfo{
-			IsConnected: whatsappClient.Client.IsConnected(),
-			IsLoggedIn:  whatsappClient.Client.IsLoggedIn(),
-		}
-	}
-
-	resp := PingInfo{
-		WhatsappConnectionInfo: whatsappConnectionInfo,
-		Mxid:                   whatsappClient.UserLogin.User.MXID,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
 	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
 }
 
-func legacyProvRoomInfo(w http.ResponseWriter, r *http.Request) {
+func legacyProvHistorySyncProgress(w http.ResponseWriter, r *http.Request) {
 	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
-
 	if userLogin == nil {
 		return
 	}
-
-	room_id := r.URL.Query().Get("room_id")
-
-	if room_id == "" {
-		exhttp.WriteJSONResponse(w, http.StatusBadRequest, Error{
-			Error:   "Missing room_id",
-			ErrCode: "missing room_id",
-		})
+	progress := userLogin.Client.(*connector.WhatsAppClient).GetHistorySyncProgress()
+	if progress == nil {
+		writeLegacyError(w, ErrProvHistorySyncNotFound, "")
 		return
 	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, progress)
+}
 
-	portal, err := m.Bridge.GetPortalByMXID(r.Context(), id.RoomID(room_id))
-
-	if err != nil {
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while fetching portal",
-			ErrCode: "failed to get portal",
-		})
-		return
-	}
+type BridgeConversationsBody struct {
+	ChatJIDs []types.JID `json:"chat_jids"`
+}
 
-	if portal == nil {
-		exhttp.WriteJSONResponse(w, http.StatusNotFound, Error{
-			Error:   "Portal not found",
-			ErrCode: "portal not found",
-		})
+func legacyProvHistorySyncConversations(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
 		return
 	}
-
-	whatsappClient := userLogin.Client.(*connector.WhatsAppClient)
-	chatInfo, err := whatsappClient.GetChatInfo(r.Context(), portal)
-
+	conversations, err := getPendingConversations(r.Context(), userLogin)
 	if err != nil {
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while fetching chat info",
-			ErrCode: "failed to get chat info",
-		})
+		writeLegacyError(w, err, "Internal error getting conversations")
 		return
 	}
-
-	portalInfo := map[string]interface{}{
-		"room_id":    portal.MXID,
-		"name":       chatInfo.Name,
-		"topic":      *chatInfo.Topic,
-		"avatar":     chatInfo.Avatar,
-		"members":    &chatInfo.Members.MemberMap,
-		"join_rule":  chatInfo.JoinRule,
-		"type":       *chatInfo.Type,
-		"disappear":  chatInfo.Disappear,
-		"parent_id":  chatInfo.ParentID,
-		"user_local": *chatInfo.UserLocal,
-	}
-
-	exhttp.WriteJSONResponse(w, http.StatusOK, portalInfo)
+	exhttp.WriteJSONResponse(w, http.StatusOK, conversations)
 }
 
-func legacyProvSetPowerlevels(w http.ResponseWriter, r *http.Request) {
-	var body SetEventBody
+func legacyProvBridgeHistorySyncConversations(w http.ResponseWriter, r *http.Request) {
+	var body BridgeConversationsBody
 	err := json.NewDecoder(r.Body).Decode(&body)
-
 	if err != nil {
 		http.Error(w, "Can't read body", http.StatusBadRequest)
 		return
 	}
-
-	log := hlog.FromRequest(r)
 	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
 	if userLogin == nil {
 		return
 	}
-
-	roomID := body.RoomID
-	powerLevel := body.PowerLevel
-	userID := body.UserID
-
-	if roomID == "" {
-		exhttp.WriteJSONResponse(w, http.StatusBadRequest, Error{
-			Error:   "Missing room_id",
-			ErrCode: "missing room_id",
-		})
-		return
-	}
-
-	if powerLevel < 0 {
-		exhttp.WriteJSONResponse(w, http.StatusBadRequest, Error{
-			Error:   "Invalid power level",
-			ErrCode: "invalid power level",
-		})
+	count, err := bridgeConversations(r.Context(), userLogin, body.ChatJIDs)
+	if err != nil {
+		writeLegacyError(w, err, "Internal error bridging conversations")
 		return
 	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, Response{
+		Success: true,
+		Status:  fmt.Sprintf("Creating portals for %d chats", count),
+	})
+}
 
-	if userID == "" {
-		exhttp.WriteJSONResponse(w, http.StatusBadRequest, Error{
-			Error:   "Missing user_id",
-			ErrCode: "missing user_id",
-		})
+func legacyProvRoomInfo(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
 		return
 	}
-
-	// Get the portal by room ID
-	portal, err := m.Bridge.GetPortalByMXID(r.Context(), id.RoomID(roomID))
-
+	portalInfo, err := getRoomInfo(r.Context(), userLogin, id.RoomID(r.URL.Query().Get("room_id")))
 	if err != nil {
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while fetching portal",
-			ErrCode: "failed to get portal",
-		})
+		writeLegacyError(w, err, "Internal error getting room info")
 		return
 	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, portalInfo)
+}
 
-	if portal == nil {
-		exhttp.WriteJSONResponse(w, http.StatusNotFound, Error{
-			Error:   "Portal not found",
-			ErrCode: "portal not found",
-		})
-		return
-	}
-
-	// Get members of the portal
-	powerLevels, err := portal.Bridge.Matrix.GetPowerLevels(r.Context(), portal.MXID)
-
+func legacyProvSetPowerlevels(w http.ResponseWriter, r *http.Request) {
+	var body SetEventBody
+	err := json.NewDecoder(r.Body).Decode(&body)
 	if err != nil {
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while fetching portal members",
-			ErrCode: "failed to get portal members",
-		})
+		http.Error(w, "Can't read body", http.StatusBadRequest)
 		return
 	}
-
-	// Change the power level of the user
-	powerLevels.Users[id.UserID(userID)] = powerLevel
-
-	botIntent := m.Bridge.Matrix.BotIntent()
-
-	content := event.Content{
-		Parsed: &powerLevels,
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
 	}
-
-	// Send the state event to the portal
-	event, err := botIntent.SendState(r.Context(), portal.MXID, event.StatePowerLevels, "", &content, time.Now())
-
+	eventID, err := setUserPowerLevel(r.Context(), id.RoomID(body.RoomID), id.UserID(body.UserID), body.PowerLevel)
 	if err != nil {
-		log.Error().Err(err).Msg("Error while changing power levels")
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while changing power levels",
-			ErrCode: "failed to change power levels",
-		})
+		writeLegacyError(w, err, "Internal error setting power level")
 		return
 	}
-
-	resp := Response{
+	exhttp.WriteJSONResponse(w, http.StatusOK, Response{
 		Success: true,
-		Status: "Successfully updated power level for user " + userID +
-			". Event ID: " + event.EventID.String() + " room ID: " + roomID,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+		Status: "Successfully updated power level for user " + body.UserID +
+			". Event ID: " + eventID.String() + " room ID: " + body.RoomID,
+	})
 }
 
 func legacyProvSetRelay(w http.ResponseWriter, r *http.Request) {
 	var body SetEventBody
 	err := json.NewDecoder(r.Body).Decode(&body)
-
 	if err != nil {
 		http.Error(w, "Can't read body", http.StatusBadRequest)
 		return
 	}
-
-	log := hlog.FromRequest(r)
 	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
 	if userLogin == nil {
 		return
 	}
-
-	roomID := body.RoomID
-	if roomID == "" {
-		exhttp.WriteJSONResponse(w, http.StatusBadRequest, Error{
-			Error:   "Missing room_id",
-			ErrCode: "missing room_id",
-		})
-		return
-	}
-
-	// Get the portal by room ID
-	portal, err := m.Bridge.GetPortalByMXID(r.Context(), id.RoomID(roomID))
-
+	_, err = setRelay(r.Context(), userLogin, id.RoomID(body.RoomID))
 	if err != nil {
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while fetching portal",
-			ErrCode: "failed to get portal",
-		})
+		writeLegacyError(w, err, "Internal error setting relay")
 		return
 	}
-	if portal == nil {
-		exhttp.WriteJSONResponse(w, http.StatusNotFound, Error{
-			Error:   "Portal not found",
-			ErrCode: "portal not found",
-		})
-		return
-	}
-
-	err = portal.SetRelay(r.Context(), userLogin)
-
-	if err != nil {
-		log.Error().Err(err).Msg("Error while setting relay")
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while setting relay",
-			ErrCode: "failed to set relay",
-		})
-		return
-	}
-
-	resp := Response{
+	exhttp.WriteJSONResponse(w, http.StatusOK, Response{
 		Success: true,
-		Status:  "Successfully set relay for room " + roomID,
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+		Status:  "Successfully set relay for room " + body.RoomID,
+	})
 }
 
 func legacyProvValidateSetRelay(w http.ResponseWriter, r *http.Request) {
-	roomID := r.PathValue("roomID")
-
-	log := hlog.FromRequest(r)
 	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
 	if userLogin == nil {
 		return
 	}
-
-	// Get the portal by room ID
-	portal, err := m.Bridge.GetPortalByMXID(r.Context(), id.RoomID(roomID))
-
+	portal, err := getPortalByRoomID(r.Context(), id.RoomID(r.PathValue("roomID")))
 	if err != nil {
-		exhttp.WriteJSONResponse(w, http.StatusInternalServerError, Error{
-			Error:   "Error while fetching portal",
-			ErrCode: "failed to get portal",
-		})
+		writeLegacyError(w, err, "Internal error getting relay")
 		return
 	}
-	if portal == nil {
-		exhttp.WriteJSONResponse(w, http.StatusNotFound, Error{
-			Error:   "Portal not found",
-			ErrCode: "portal not found",
-		})
-		return
-	}
-
-	log.Debug().Str("user_login", string(userLogin.User.MXID)).Str("room_id", roomID).Msg(
-		"Validating set relay for room and user login",
-	)
-
-	var resp Response
-	var statusCode int
-	hasPortalRelay := portal.Relay
-
-	if hasPortalRelay != nil && portal.Relay.User.MXID == userLogin.User.MXID {
-		resp = Response{
+	if getRelayInfo(userLogin, portal).IsOwnRelay {
+		exhttp.WriteJSONResponse(w, http.StatusOK, Response{
 			Success: true,
 			Status:  "The room already has a relay set for this user",
-		}
-		statusCode = http.StatusOK
+		})
 	} else {
-		resp = Response{
+		exhttp.WriteJSONResponse(w, http.StatusBadRequest, Response{
 			Success: false,
 			Status:  "The room does not have a relay set for this user",
-		}
-
-		statusCode = http.StatusBadRequest
+		})
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	exhttp.WriteJSONResponse(w, statusCode, resp)
 }
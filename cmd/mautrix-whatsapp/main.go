@@ -1,7 +1,12 @@
 package main
 
 import (
+	"context"
+	"net/http"
+
+	mautrix "github.com/iKonoTelecomunicaciones/go"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix/mxmain"
+	"github.com/iKonoTelecomunicaciones/go/id"
 
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
 )
@@ -24,16 +29,62 @@ var m = mxmain.BridgeMain{
 }
 
 func main() {
+	m.PostInit = func() {
+		wa := m.Connector.(*connector.WhatsAppConnector)
+		wa.DeleteMedia = func(ctx context.Context, uri id.ContentURI) error {
+			url := m.Matrix.Bot.BuildURL(mautrix.SynapseAdminURLPath{"v1", "media", uri.Homeserver, uri.FileID})
+			_, err := m.Matrix.Bot.MakeRequest(ctx, http.MethodDelete, url, nil, nil)
+			return err
+		}
+		wa.GetRoomState = m.Matrix.Bot.FullStateEvent
+		wa.MsgConv.IsEncryptedRoom = m.Matrix.StateStore.IsEncrypted
+	}
 	m.PostStart = func() {
 		if m.Matrix.Provisioning != nil {
 			m.Matrix.Provisioning.Router.HandleFunc("GET /v1/contacts", legacyProvContacts)
 			m.Matrix.Provisioning.Router.HandleFunc("GET /v1/resolve_identifier/{number}", legacyProvResolveIdentifier)
 			m.Matrix.Provisioning.Router.HandleFunc("POST /v1/pm/{number}", legacyProvResolveIdentifier)
 			m.Matrix.Provisioning.Router.HandleFunc("GET /v1/ping", legacyProvPing)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v1/history_sync/progress", legacyProvHistorySyncProgress)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v1/history_sync/conversations", legacyProvHistorySyncConversations)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v1/history_sync/conversations", legacyProvBridgeHistorySyncConversations)
 			m.Matrix.Provisioning.Router.HandleFunc("GET /v1/room_info", legacyProvRoomInfo)
 			m.Matrix.Provisioning.Router.HandleFunc("POST /v1/set_power_level", legacyProvSetPowerlevels)
 			m.Matrix.Provisioning.Router.HandleFunc("POST /v1/set_relay", legacyProvSetRelay)
 			m.Matrix.Provisioning.Router.HandleFunc("GET /v1/set_relay/{roomID}", legacyProvValidateSetRelay)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/openapi.yaml", provV2OpenAPISpec)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/ping", provV2Ping)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/contacts", provV2Contacts)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/contacts/check", provV2CheckContacts)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/resolve_identifier/{number}", provV2ResolveIdentifier)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/pm/{number}", provV2ResolveIdentifier)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/send/{number}", provV2SendMessage)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/rooms/{roomID}", provV2RoomInfo)
+			m.Matrix.Provisioning.Router.HandleFunc("PUT /v2/rooms/{roomID}/power_levels", provV2SetPowerLevel)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/rooms/{roomID}/relay", provV2GetRelay)
+			m.Matrix.Provisioning.Router.HandleFunc("PUT /v2/rooms/{roomID}/relay", provV2SetRelay)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/rooms/{roomID}/relay/templates", provV2GetRelayTemplates)
+			m.Matrix.Provisioning.Router.HandleFunc("PUT /v2/rooms/{roomID}/relay/templates", provV2SetRelayTemplates)
+			m.Matrix.Provisioning.Router.HandleFunc("DELETE /v2/rooms/{roomID}/relay/templates", provV2SetRelayTemplates)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/rooms/{roomID}/preferred_login", provV2GetPreferredLogin)
+			m.Matrix.Provisioning.Router.HandleFunc("PUT /v2/rooms/{roomID}/preferred_login", provV2SetPreferredLogin)
+			m.Matrix.Provisioning.Router.HandleFunc("DELETE /v2/rooms/{roomID}/preferred_login", provV2SetPreferredLogin)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/history_sync/progress", provV2HistorySyncProgress)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/history_sync/conversations", provV2HistorySyncConversations)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/history_sync/conversations", provV2BridgeHistorySyncConversations)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/groups", provV2CreateGroup)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/groups/join", provV2JoinGroup)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/groups/{groupJID}/participants", provV2ChangeGroupParticipants)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/groups/{groupJID}/invite_link", provV2GroupInviteLink)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/groups/{groupJID}/invite_link/reset", provV2GroupInviteLink)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/groups/{groupJID}/leave", provV2LeaveGroup)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/webhook", provV2GetWebhook)
+			m.Matrix.Provisioning.Router.HandleFunc("PUT /v2/webhook", provV2SetWebhook)
+			m.Matrix.Provisioning.Router.HandleFunc("DELETE /v2/webhook", provV2DeleteWebhook)
+			m.Matrix.Provisioning.Router.HandleFunc("POST /v2/webhook/test", provV2TestWebhook)
+			m.Matrix.Provisioning.Router.HandleFunc("GET /v2/auto_reply", provV2GetAutoReply)
+			m.Matrix.Provisioning.Router.HandleFunc("PUT /v2/auto_reply", provV2SetAutoReply)
+			m.Matrix.Provisioning.Router.HandleFunc("DELETE /v2/auto_reply", provV2SetAutoReply)
 			m.Matrix.Provisioning.GetAuthFromRequest = legacyProvAuth
 		}
 	}
@@ -0,0 +1,77 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+type AutoReplyInfo struct {
+	*waid.AutoReplySettings
+	Timezone string `json:"timezone"`
+}
+
+func wrapAutoReplyInfo(settings *waid.AutoReplySettings, meta *waid.UserLoginMetadata) *AutoReplyInfo {
+	if settings == nil {
+		settings = &waid.AutoReplySettings{}
+	}
+	if settings.BusinessHours == nil {
+		settings.BusinessHours = []waid.BusinessHours{}
+	}
+	return &AutoReplyInfo{AutoReplySettings: settings, Timezone: meta.Timezone}
+}
+
+func provV2GetAutoReply(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	meta := userLogin.Metadata.(*waid.UserLoginMetadata)
+	exhttp.WriteJSONResponse(w, http.StatusOK, wrapAutoReplyInfo(meta.AutoReply.Clone(), meta))
+}
+
+func provV2SetAutoReply(w http.ResponseWriter, r *http.Request) {
+	var body waid.AutoReplySettings
+	if r.Method == http.MethodPut {
+		if err := readProvJSONBody(r, &body); err != nil {
+			matrix.RespondWithError(w, err, "")
+			return
+		} else if err = body.Validate(); err != nil {
+			matrix.RespondWithError(w, errProvInvalidParam("%v", err), "")
+			return
+		}
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	err := userLogin.Client.(*connector.WhatsAppClient).SetAutoReply(r.Context(), &body)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to set auto reply settings")
+		matrix.RespondWithError(w, err, "Internal error setting auto reply settings")
+		return
+	}
+	meta := userLogin.Metadata.(*waid.UserLoginMetadata)
+	exhttp.WriteJSONResponse(w, http.StatusOK, wrapAutoReplyInfo(meta.AutoReply.Clone(), meta))
+}
@@ -0,0 +1,123 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
+)
+
+const maxContactCheckBodySize = 4 * 1024 * 1024
+
+type ContactCheckBody struct {
+	Numbers []string `json:"numbers"`
+	Refresh bool     `json:"refresh,omitempty"`
+}
+
+type ContactCheckResponse struct {
+	Results []*connector.ContactCheckResult `json:"results"`
+}
+
+// readContactCheckCSV reads phone numbers from the first column of a CSV body.
+// Rows where the first column contains letters (e.g. a header row) are skipped.
+func readContactCheckCSV(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	var numbers []string
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return numbers, nil
+		} else if err != nil {
+			return nil, errProvInvalidParam("Invalid CSV: %v", err)
+		}
+		if len(record) == 0 {
+			continue
+		}
+		number := strings.TrimSpace(record[0])
+		if number == "" || strings.ContainsFunc(number, func(r rune) bool {
+			return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		}) {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+}
+
+func readContactCheckBody(w http.ResponseWriter, r *http.Request) (*ContactCheckBody, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxContactCheckBodySize)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/csv":
+		numbers, err := readContactCheckCSV(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &ContactCheckBody{
+			Numbers: numbers,
+			Refresh: r.URL.Query().Get("refresh") == "true",
+		}, nil
+	case "text/plain":
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, errProvInvalidParam("Failed to read body: %v", err)
+		}
+		return &ContactCheckBody{
+			Numbers: connector.SplitContactCheckList(string(data)),
+			Refresh: r.URL.Query().Get("refresh") == "true",
+		}, nil
+	default:
+		var body ContactCheckBody
+		if err := readProvJSONBody(r, &body); err != nil {
+			return nil, err
+		}
+		return &body, nil
+	}
+}
+
+func provV2CheckContacts(w http.ResponseWriter, r *http.Request) {
+	body, err := readContactCheckBody(w, r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	} else if len(body.Numbers) == 0 {
+		matrix.RespondWithError(w, errProvMissingParam("numbers"), "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	results, err := userLogin.Client.(*connector.WhatsAppClient).CheckContacts(r.Context(), body.Numbers, body.Refresh)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Int("number_count", len(body.Numbers)).Msg("Failed to check contacts")
+		matrix.RespondWithError(w, err, "Internal error checking contacts")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &ContactCheckResponse{Results: results})
+}
@@ -0,0 +1,847 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	mautrix "github.com/iKonoTelecomunicaciones/go"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+	"go.mau.fi/util/ptr"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+//go:embed provisioning-v2.yaml
+var provisioningV2Spec []byte
+
+var (
+	ErrProvNotJSON        = bridgev2.RespError(mautrix.MNotJSON).WithMessage("Failed to parse request body")
+	ErrProvPortalNotFound = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.PORTAL_NOT_FOUND",
+		Err:        "Portal not found",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrProvHistorySyncNotFound = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.HISTORY_SYNC_NOT_FOUND",
+		Err:        "No history sync has been tracked for this login",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrProvInvalidPowerLevel = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.INVALID_POWER_LEVEL",
+		Err:        "Invalid power level",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrProvGetPortalFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GET_PORTAL_FAILED",
+		Err:        "Error while fetching portal",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvGetContactsFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GET_CONTACTS_FAILED",
+		Err:        "Internal server error while fetching contact list",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvGetManagementRoomFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GET_MANAGEMENT_ROOM_FAILED",
+		Err:        "Error while fetching management room",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvGetChatInfoFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GET_CHAT_INFO_FAILED",
+		Err:        "Error while fetching chat info",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvGetPowerLevelsFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GET_POWER_LEVELS_FAILED",
+		Err:        "Error while fetching portal members",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvSetPowerLevelsFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.SET_POWER_LEVELS_FAILED",
+		Err:        "Error while changing power levels",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvSetRelayFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.SET_RELAY_FAILED",
+		Err:        "Error while setting relay",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvGetConversationsFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GET_CONVERSATIONS_FAILED",
+		Err:        "Internal server error while fetching history sync conversations",
+		StatusCode: http.StatusInternalServerError,
+	}
+	ErrProvBridgeConversationsFailed = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.BRIDGE_CONVERSATIONS_FAILED",
+		Err:        "Internal server error while bridging history sync conversations",
+		StatusCode: http.StatusInternalServerError,
+	}
+)
+
+// legacyErrCodes maps the error codes of the v2 API to the free-text error codes used by the v1 API.
+var legacyErrCodes = map[string]string{
+	ErrProvPortalNotFound.ErrCode:            "portal not found",
+	ErrProvHistorySyncNotFound.ErrCode:       "history sync not found",
+	ErrProvInvalidPowerLevel.ErrCode:         "invalid power level",
+	ErrProvGetPortalFailed.ErrCode:           "failed to get portal",
+	ErrProvGetContactsFailed.ErrCode:         "failed to get contacts",
+	ErrProvGetManagementRoomFailed.ErrCode:   "failed to get management room",
+	ErrProvGetChatInfoFailed.ErrCode:         "failed to get chat info",
+	ErrProvGetPowerLevelsFailed.ErrCode:      "failed to get portal members",
+	ErrProvSetPowerLevelsFailed.ErrCode:      "failed to change power levels",
+	ErrProvSetRelayFailed.ErrCode:            "failed to set relay",
+	ErrProvGetConversationsFailed.ErrCode:    "failed to get conversations",
+	ErrProvBridgeConversationsFailed.ErrCode: "failed to bridge conversations",
+}
+
+func errProvMissingParam(name string) error {
+	return bridgev2.RespError(mautrix.MMissingParam).WithMessage("Missing %s", name)
+}
+
+func errProvInvalidParam(format string, args ...any) error {
+	return bridgev2.RespError(mautrix.MInvalidParam).WithMessage(format, args...)
+}
+
+const (
+	defaultContactsLimit = 100
+	maxContactsLimit     = 1000
+)
+
+type ContactInfo struct {
+	JID          types.JID           `json:"jid"`
+	Found        bool                `json:"found"`
+	FirstName    string              `json:"first_name,omitempty"`
+	FullName     string              `json:"full_name,omitempty"`
+	PushName     string              `json:"push_name,omitempty"`
+	BusinessName string              `json:"business_name,omitempty"`
+	AvatarURL    id.ContentURIString `json:"avatar_url,omitempty"`
+}
+
+type ContactList struct {
+	Contacts  []*ContactInfo `json:"contacts"`
+	NextBatch string         `json:"next_batch,omitempty"`
+}
+
+type PowerLevelBody struct {
+	UserID     id.UserID `json:"user_id"`
+	PowerLevel *int      `json:"power_level"`
+}
+
+type PowerLevelResponse struct {
+	EventID id.EventID `json:"event_id"`
+}
+
+type RelayInfo struct {
+	RoomID     id.RoomID `json:"room_id"`
+	HasRelay   bool      `json:"has_relay"`
+	RelayMXID  id.UserID `json:"relay_mxid,omitempty"`
+	IsOwnRelay bool      `json:"is_own_relay"`
+}
+
+type HistorySyncConversationList struct {
+	Conversations []*connector.HistorySyncConversation `json:"conversations"`
+}
+
+type BridgeConversationsResponse struct {
+	Count int `json:"count"`
+}
+
+func getContacts(ctx context.Context, userLogin *bridgev2.UserLogin) (map[types.JID]types.ContactInfo, error) {
+	contacts, err := userLogin.Client.(*connector.WhatsAppClient).GetStore().Contacts.GetAllContacts(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to fetch all contacts")
+		return nil, ErrProvGetContactsFailed
+	}
+	return contacts, nil
+}
+
+func wrapContactInfo(ctx context.Context, jid types.JID, contact types.ContactInfo) *ContactInfo {
+	info := &ContactInfo{
+		JID:          jid,
+		Found:        contact.Found,
+		FirstName:    contact.FirstName,
+		FullName:     contact.FullName,
+		PushName:     contact.PushName,
+		BusinessName: contact.BusinessName,
+	}
+	if ghost, _ := m.Bridge.GetExistingGhostByID(ctx, waid.MakeUserID(jid)); ghost != nil {
+		info.AvatarURL = ghost.AvatarMXC
+	}
+	return info
+}
+
+func resolveIdentifierPortal(ctx context.Context, userLogin *bridgev2.UserLogin, number string, startChat bool) (*bridgev2.ResolveIdentifierResponse, *bridgev2.Portal, error) {
+	log := zerolog.Ctx(ctx).With().Str("identifier", number).Logger()
+	resp, err := userLogin.Client.(*connector.WhatsAppClient).ResolveIdentifier(ctx, number, startChat)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to resolve identifier")
+		return nil, nil, err
+	}
+	var portal *bridgev2.Portal
+	if startChat {
+		portal, err = m.Bridge.GetPortalByKey(ctx, resp.Chat.PortalKey)
+		if err != nil {
+			log.Warn().Err(err).Stringer("portal_key", resp.Chat.PortalKey).Msg("Failed to get portal by key")
+			return nil, nil, fmt.Errorf("failed to get portal: %w", err)
+		}
+		err = portal.CreateMatrixRoom(ctx, userLogin, nil)
+		if err != nil {
+			log.Warn().Err(err).Stringer("portal_key", resp.Chat.PortalKey).Msg("Failed to create matrix room for portal")
+			return nil, nil, fmt.Errorf("failed to create matrix room: %w", err)
+		}
+	} else {
+		portal, _ = m.Bridge.GetExistingPortalByKey(ctx, resp.Chat.PortalKey)
+	}
+	return resp, portal, nil
+}
+
+func resolveIdentifier(ctx context.Context, userLogin *bridgev2.UserLogin, number string, startChat bool) (*PortalInfo, error) {
+	resp, portal, err := resolveIdentifierPortal(ctx, userLogin, number, startChat)
+	if err != nil {
+		return nil, err
+	}
+	var roomID id.RoomID
+	if portal != nil {
+		roomID = portal.MXID
+	}
+	return &PortalInfo{
+		RoomID: roomID,
+		OtherUser: &OtherUserInfo{
+			JID:    waid.ParseUserID(resp.UserID),
+			MXID:   resp.Ghost.Intent.GetMXID(),
+			Name:   resp.Ghost.Name,
+			Avatar: resp.Ghost.AvatarMXC,
+		},
+	}, nil
+}
+
+func getPingInfo(ctx context.Context, userLogin *bridgev2.UserLogin) (*PingInfo, error) {
+	whatsappClient := userLogin.Client.(*connector.WhatsAppClient)
+	managementRoom, err := userLogin.User.GetManagementRoom(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get management room")
+		return nil, ErrProvGetManagementRoomFailed
+	}
+	connInfo := ConnectionInfo{
+		HasSession:     whatsappClient.IsLoggedIn(),
+		ManagementRoom: managementRoom,
+	}
+	if !whatsappClient.JID.IsEmpty() {
+		connInfo.JID = whatsappClient.JID.String()
+		connInfo.Phone = "+" + whatsappClient.JID.User
+		if whatsappClient.Device != nil && whatsappClient.Device.Platform != "" {
+			connInfo.Platform = whatsappClient.Device.Platform
+		}
+	}
+	if whatsappClient.Client != nil {
+		connInfo.Conn = ConnInfo{
+			IsConnected: whatsappClient.Client.IsConnected(),
+			IsLoggedIn:  whatsappClient.Client.IsLoggedIn(),
+		}
+	}
+	return &PingInfo{
+		WhatsappConnectionInfo: connInfo,
+		Mxid:                   userLogin.User.MXID,
+	}, nil
+}
+
+func getPortalByRoomID(ctx context.Context, roomID id.RoomID) (*bridgev2.Portal, error) {
+	if roomID == "" {
+		return nil, errProvMissingParam("room_id")
+	}
+	portal, err := m.Bridge.GetPortalByMXID(ctx, roomID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to get portal")
+		return nil, ErrProvGetPortalFailed
+	} else if portal == nil {
+		return nil, ErrProvPortalNotFound
+	}
+	return portal, nil
+}
+
+func getRoomInfo(ctx context.Context, userLogin *bridgev2.UserLogin, roomID id.RoomID) (map[string]any, error) {
+	portal, err := getPortalByRoomID(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	chatInfo, err := userLogin.Client.(*connector.WhatsAppClient).GetChatInfo(ctx, portal)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to get chat info")
+		return nil, ErrProvGetChatInfoFailed
+	}
+	var members any
+	if chatInfo.Members != nil {
+		members = &chatInfo.Members.MemberMap
+	}
+	return map[string]any{
+		"room_id":    portal.MXID,
+		"name":       chatInfo.Name,
+		"topic":      ptr.Val(chatInfo.Topic),
+		"avatar":     chatInfo.Avatar,
+		"members":    members,
+		"join_rule":  chatInfo.JoinRule,
+		"type":       ptr.Val(chatInfo.Type),
+		"disappear":  chatInfo.Disappear,
+		"parent_id":  chatInfo.ParentID,
+		"user_local": ptr.Val(chatInfo.UserLocal),
+	}, nil
+}
+
+func setUserPowerLevel(ctx context.Context, roomID id.RoomID, userID id.UserID, powerLevel int) (id.EventID, error) {
+	if roomID == "" {
+		return "", errProvMissingParam("room_id")
+	} else if powerLevel < 0 {
+		return "", ErrProvInvalidPowerLevel
+	} else if userID == "" {
+		return "", errProvMissingParam("user_id")
+	}
+	portal, err := getPortalByRoomID(ctx, roomID)
+	if err != nil {
+		return "", err
+	}
+	log := zerolog.Ctx(ctx).With().Stringer("room_id", roomID).Stringer("user_id", userID).Logger()
+	powerLevels, err := m.Bridge.Matrix.GetPowerLevels(ctx, portal.MXID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get power levels")
+		return "", ErrProvGetPowerLevelsFailed
+	}
+	powerLevels.SetUserLevel(userID, powerLevel)
+	resp, err := m.Bridge.Matrix.BotIntent().SendState(ctx, portal.MXID, event.StatePowerLevels, "", &event.Content{
+		Parsed: powerLevels,
+	}, time.Now())
+	if err != nil {
+		log.Err(err).Msg("Failed to change power levels")
+		return "", ErrProvSetPowerLevelsFailed
+	}
+	return resp.EventID, nil
+}
+
+func getRelayInfo(userLogin *bridgev2.UserLogin, portal *bridgev2.Portal) *RelayInfo {
+	info := &RelayInfo{RoomID: portal.MXID}
+	if portal.Relay != nil {
+		info.HasRelay = true
+		info.RelayMXID = portal.Relay.User.MXID
+		info.IsOwnRelay = portal.Relay.User.MXID == userLogin.User.MXID
+	}
+	return info
+}
+
+func setRelay(ctx context.Context, userLogin *bridgev2.UserLogin, roomID id.RoomID) (*bridgev2.Portal, error) {
+	portal, err := getPortalByRoomID(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	err = portal.SetRelay(ctx, userLogin)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("room_id", roomID).Msg("Failed to set relay")
+		return nil, ErrProvSetRelayFailed
+	}
+	return portal, nil
+}
+
+func getPendingConversations(ctx context.Context, userLogin *bridgev2.UserLogin) ([]*connector.HistorySyncConversation, error) {
+	conversations, err := userLogin.Client.(*connector.WhatsAppClient).GetPendingHistorySyncConversations(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get pending history sync conversations")
+		return nil, ErrProvGetConversationsFailed
+	}
+	return conversations, nil
+}
+
+func bridgeConversations(ctx context.Context, userLogin *bridgev2.UserLogin, chatJIDs []types.JID) (int, error) {
+	if len(chatJIDs) == 0 {
+		return 0, errProvMissingParam("chat_jids")
+	}
+	count, err := userLogin.Client.(*connector.WhatsAppClient).BridgeHistorySyncConversations(ctx, chatJIDs)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to bridge history sync conversations")
+		return 0, ErrProvBridgeConversationsFailed
+	}
+	return count, nil
+}
+
+func readProvJSONBody(r *http.Request, into any) error {
+	err := json.NewDecoder(r.Body).Decode(into)
+	if err != nil {
+		return ErrProvNotJSON
+	}
+	return nil
+}
+
+func parseProvRoomID(r *http.Request) (id.RoomID, error) {
+	roomID := id.RoomID(r.PathValue("roomID"))
+	if !strings.HasPrefix(string(roomID), "!") || !strings.Contains(string(roomID), ":") {
+		return "", errProvInvalidParam("Invalid room ID %q", roomID)
+	}
+	return roomID, nil
+}
+
+func provV2OpenAPISpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(provisioningV2Spec)
+}
+
+func provV2Ping(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	resp, err := getPingInfo(r.Context(), userLogin)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting connection info")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
+
+func provV2Contacts(w http.ResponseWriter, r *http.Request) {
+	limit := defaultContactsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		limit, err = strconv.Atoi(limitStr)
+		if err != nil || limit <= 0 || limit > maxContactsLimit {
+			matrix.RespondWithError(w, errProvInvalidParam("limit must be an integer between 1 and %d", maxContactsLimit), "")
+			return
+		}
+	}
+	from := r.URL.Query().Get("from")
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	contacts, err := getContacts(r.Context(), userLogin)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error fetching contacts")
+		return
+	}
+	jids := make([]types.JID, 0, len(contacts))
+	for jid := range contacts {
+		if from == "" || jid.String() > from {
+			jids = append(jids, jid)
+		}
+	}
+	slices.SortFunc(jids, func(a, b types.JID) int {
+		return strings.Compare(a.String(), b.String())
+	})
+	var resp ContactList
+	if len(jids) > limit {
+		jids = jids[:limit]
+		resp.NextBatch = jids[limit-1].String()
+	}
+	resp.Contacts = make([]*ContactInfo, len(jids))
+	for i, jid := range jids {
+		resp.Contacts[i] = wrapContactInfo(r.Context(), jid, contacts[jid])
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
+
+func provV2ResolveIdentifier(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	resp, err := resolveIdentifier(r.Context(), userLogin, r.PathValue("number"), r.Method == http.MethodPost)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error resolving identifier")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
+
+func provV2RoomInfo(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	userLogin := getLoginForRoomRequest(w, r, roomID)
+	if userLogin == nil {
+		return
+	}
+	resp, err := getRoomInfo(r.Context(), userLogin, roomID)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting room info")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
+
+func provV2SetPowerLevel(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	var body PowerLevelBody
+	if err = readProvJSONBody(r, &body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	} else if body.PowerLevel == nil {
+		matrix.RespondWithError(w, errProvMissingParam("power_level"), "")
+		return
+	} else if _, _, err = body.UserID.Parse(); err != nil {
+		matrix.RespondWithError(w, errProvInvalidParam("Invalid user ID %q", body.UserID), "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	eventID, err := setUserPowerLevel(r.Context(), roomID, body.UserID, *body.PowerLevel)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error setting power level")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &PowerLevelResponse{EventID: eventID})
+}
+
+func provV2GetRelay(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	userLogin := getLoginForRoomRequest(w, r, roomID)
+	if userLogin == nil {
+		return
+	}
+	portal, err := getPortalByRoomID(r.Context(), roomID)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting relay")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, getRelayInfo(userLogin, portal))
+}
+
+func provV2SetRelay(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	userLogin := getLoginForRoomRequest(w, r, roomID)
+	if userLogin == nil {
+		return
+	}
+	portal, err := setRelay(r.Context(), userLogin, roomID)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error setting relay")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, getRelayInfo(userLogin, portal))
+}
+
+func provV2HistorySyncProgress(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	progress := userLogin.Client.(*connector.WhatsAppClient).GetHistorySyncProgress()
+	if progress == nil {
+		matrix.RespondWithError(w, ErrProvHistorySyncNotFound, "")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, progress)
+}
+
+func provV2HistorySyncConversations(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	conversations, err := getPendingConversations(r.Context(), userLogin)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting conversations")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &HistorySyncConversationList{Conversations: conversations})
+}
+
+func provV2BridgeHistorySyncConversations(w http.ResponseWriter, r *http.Request) {
+	var body BridgeConversationsBody
+	if err := readProvJSONBody(r, &body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	for _, jid := range body.ChatJIDs {
+		if jid.IsEmpty() || jid.User == "" {
+			matrix.RespondWithError(w, errProvInvalidParam("Invalid chat JID %q", jid), "")
+			return
+		}
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	count, err := bridgeConversations(r.Context(), userLogin, body.ChatJIDs)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error bridging conversations")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &BridgeConversationsResponse{Count: count})
+}
+
+// writeLegacyError writes an error returned by the shared provisioning functions in the v1 API format.
+func writeLegacyError(w http.ResponseWriter, err error, message string) {
+	var respErr bridgev2.RespError
+	if !errors.As(err, &respErr) {
+		matrix.RespondWithError(w, err, message)
+		return
+	}
+	legacyCode, ok := legacyErrCodes[respErr.ErrCode]
+	if !ok && respErr.ErrCode == mautrix.MMissingParam.ErrCode {
+		legacyCode, ok = strings.ToLower(respErr.Err), true
+	}
+	if !ok {
+		matrix.RespondWithError(w, err, message)
+		return
+	}
+	exhttp.WriteJSONResponse(w, respErr.StatusCode, Error{
+		Error:   respErr.Err,
+		ErrCode: legacyCode,
+	})
+}
+
+type CreateGroupBody struct {
+	Name         string   `json:"name"`
+	Participants []string `json:"participants"`
+}
+
+type CreateGroupResponse struct {
+	GroupJID           types.JID            `json:"group_jid"`
+	RoomID             id.RoomID            `json:"room_id"`
+	FailedParticipants map[types.JID]string `json:"failed_participants,omitempty"`
+}
+
+type GroupParticipantsBody struct {
+	Action       whatsmeow.ParticipantChange `json:"action"`
+	Participants []string                    `json:"participants"`
+}
+
+type GroupParticipantsResponse struct {
+	Participants []connector.GroupParticipantResult `json:"participants"`
+}
+
+type InviteLinkResponse struct {
+	InviteLink string `json:"invite_link"`
+}
+
+type JoinGroupBody struct {
+	InviteLink string `json:"invite_link"`
+}
+
+type JoinGroupResponse struct {
+	GroupJID types.JID `json:"group_jid"`
+}
+
+func parseProvGroupJID(r *http.Request) (types.JID, error) {
+	jid, err := types.ParseJID(r.PathValue("groupJID"))
+	if err != nil || jid.Server != types.GroupServer {
+		return types.EmptyJID, errProvInvalidParam("Invalid group JID %q", r.PathValue("groupJID"))
+	}
+	return jid, nil
+}
+
+func createGroup(ctx context.Context, userLogin *bridgev2.UserLogin, name string, participants []string) (*CreateGroupResponse, error) {
+	wa := userLogin.Client.(*connector.WhatsAppClient)
+	jids, err := wa.ResolveGroupParticipants(ctx, participants)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := make([]networkid.UserID, len(jids))
+	for i, jid := range jids {
+		userIDs[i] = waid.MakeUserID(jid)
+	}
+	resp, err := wa.CreateGroup(ctx, &bridgev2.GroupCreateParams{
+		Name:         &event.RoomNameEventContent{Name: name},
+		Participants: userIDs,
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to create group")
+		return nil, err
+	}
+	portal := resp.Portal
+	if portal == nil {
+		portal, err = m.Bridge.GetPortalByKey(ctx, resp.PortalKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get portal: %w", err)
+		}
+	}
+	err = portal.CreateMatrixRoom(ctx, userLogin, resp.PortalInfo)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Stringer("portal_key", resp.PortalKey).Msg("Failed to create matrix room for group")
+		return nil, fmt.Errorf("failed to create matrix room: %w", err)
+	}
+	groupJID, _ := waid.ParsePortalID(resp.PortalKey.ID)
+	createResp := &CreateGroupResponse{
+		GroupJID: groupJID,
+		RoomID:   portal.MXID,
+	}
+	if len(resp.FailedParticipants) > 0 {
+		createResp.FailedParticipants = make(map[types.JID]string, len(resp.FailedParticipants))
+		for userID, failed := range resp.FailedParticipants {
+			createResp.FailedParticipants[waid.ParseUserID(userID)] = failed.Reason
+		}
+	}
+	return createResp, nil
+}
+
+func provV2CreateGroup(w http.ResponseWriter, r *http.Request) {
+	var body CreateGroupBody
+	if err := readProvJSONBody(r, &body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	} else if body.Name == "" {
+		matrix.RespondWithError(w, errProvMissingParam("name"), "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	resp, err := createGroup(r.Context(), userLogin, body.Name, body.Participants)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error creating group")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
+
+func provV2ChangeGroupParticipants(w http.ResponseWriter, r *http.Request) {
+	groupJID, err := parseProvGroupJID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	var body GroupParticipantsBody
+	if err = readProvJSONBody(r, &body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	} else if len(body.Participants) == 0 {
+		matrix.RespondWithError(w, errProvMissingParam("participants"), "")
+		return
+	}
+	switch body.Action {
+	case whatsmeow.ParticipantChangeAdd, whatsmeow.ParticipantChangeRemove,
+		whatsmeow.ParticipantChangePromote, whatsmeow.ParticipantChangeDemote:
+	case "":
+		matrix.RespondWithError(w, errProvMissingParam("action"), "")
+		return
+	default:
+		matrix.RespondWithError(w, errProvInvalidParam("Invalid action %q", body.Action), "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	wa := userLogin.Client.(*connector.WhatsAppClient)
+	participants, err := wa.ResolveGroupParticipants(r.Context(), body.Participants)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error resolving participants")
+		return
+	}
+	results, err := wa.ChangeGroupParticipants(r.Context(), groupJID, participants, body.Action)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Stringer("group_jid", groupJID).Msg("Failed to change group participants")
+		matrix.RespondWithError(w, err, "Internal error changing group participants")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &GroupParticipantsResponse{Participants: results})
+}
+
+func provV2GroupInviteLink(w http.ResponseWriter, r *http.Request) {
+	groupJID, err := parseProvGroupJID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	reset := r.Method == http.MethodPost
+	link, err := userLogin.Client.(*connector.WhatsAppClient).GetGroupInviteLink(r.Context(), groupJID, reset)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Stringer("group_jid", groupJID).Bool("reset", reset).Msg("Failed to get group invite link")
+		matrix.RespondWithError(w, err, "Internal error getting invite link")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &InviteLinkResponse{InviteLink: link})
+}
+
+func provV2JoinGroup(w http.ResponseWriter, r *http.Request) {
+	var body JoinGroupBody
+	if err := readProvJSONBody(r, &body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	} else if body.InviteLink == "" {
+		matrix.RespondWithError(w, errProvMissingParam("invite_link"), "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	jid, err := userLogin.Client.(*connector.WhatsAppClient).JoinGroupWithLink(r.Context(), body.InviteLink)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to join group with link")
+		matrix.RespondWithError(w, err, "Internal error joining group")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &JoinGroupResponse{GroupJID: jid})
+}
+
+func provV2LeaveGroup(w http.ResponseWriter, r *http.Request) {
+	groupJID, err := parseProvGroupJID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	err = userLogin.Client.(*connector.WhatsAppClient).LeaveGroup(r.Context(), groupJID)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Stringer("group_jid", groupJID).Msg("Failed to leave group")
+		matrix.RespondWithError(w, err, "Internal error leaving group")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, struct{}{})
+}
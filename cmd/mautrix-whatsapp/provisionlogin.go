@@ -0,0 +1,105 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
+)
+
+type PreferredLoginBody struct {
+	LoginID networkid.UserLoginID `json:"login_id"`
+}
+
+type PreferredLoginResponse struct {
+	RoomID  id.RoomID             `json:"room_id"`
+	LoginID networkid.UserLoginID `json:"login_id,omitempty"`
+}
+
+// getLoginForRoomRequest finds the login to use for a room-scoped request.
+// The login_id query parameter takes priority, followed by the user's preferred login in the portal.
+func getLoginForRoomRequest(w http.ResponseWriter, r *http.Request, roomID id.RoomID) *bridgev2.UserLogin {
+	if r.URL.Query().Has("login_id") {
+		return m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	}
+	portal, err := m.Bridge.GetPortalByMXID(r.Context(), roomID)
+	if err == nil && portal != nil {
+		wa := m.Bridge.Network.(*connector.WhatsAppConnector)
+		if login := wa.GetPreferredLogin(portal, m.Matrix.Provisioning.GetUser(r).MXID); login != nil {
+			return login
+		}
+	}
+	return m.Matrix.Provisioning.GetLoginForRequest(w, r)
+}
+
+func provV2GetPreferredLogin(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	portal, err := getPortalByRoomID(r.Context(), roomID)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting portal")
+		return
+	}
+	resp := &PreferredLoginResponse{RoomID: roomID}
+	wa := m.Bridge.Network.(*connector.WhatsAppConnector)
+	if login := wa.GetPreferredLogin(portal, m.Matrix.Provisioning.GetUser(r).MXID); login != nil {
+		resp.LoginID = login.ID
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, resp)
+}
+
+func provV2SetPreferredLogin(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	var body PreferredLoginBody
+	if r.Method == http.MethodPut {
+		if err = readProvJSONBody(r, &body); err != nil {
+			matrix.RespondWithError(w, err, "")
+			return
+		} else if body.LoginID == "" {
+			matrix.RespondWithError(w, errProvMissingParam("login_id"), "")
+			return
+		}
+	}
+	portal, err := getPortalByRoomID(r.Context(), roomID)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting portal")
+		return
+	}
+	wa := m.Bridge.Network.(*connector.WhatsAppConnector)
+	err = wa.SetPreferredLogin(r.Context(), portal, m.Matrix.Provisioning.GetUser(r).MXID, body.LoginID)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Stringer("room_id", roomID).Msg("Failed to set preferred login")
+		matrix.RespondWithError(w, err, "Internal error setting preferred login")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &PreferredLoginResponse{RoomID: roomID, LoginID: body.LoginID})
+}
@@ -0,0 +1,93 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+type RelayTemplatesResponse struct {
+	RoomID    id.RoomID            `json:"room_id"`
+	Templates *waid.RelayTemplates `json:"templates"`
+	Effective *waid.RelayTemplates `json:"effective"`
+}
+
+func getRelayTemplatesResponse(portal *bridgev2.Portal) *RelayTemplatesResponse {
+	templates := portal.Metadata.(*waid.PortalMetadata).RelayTemplates
+	if templates == nil {
+		templates = &waid.RelayTemplates{}
+	}
+	return &RelayTemplatesResponse{
+		RoomID:    portal.MXID,
+		Templates: templates,
+		Effective: m.Bridge.Network.(*connector.WhatsAppConnector).GetRelayTemplates(portal),
+	}
+}
+
+func provV2GetRelayTemplates(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	portal, err := getPortalByRoomID(r.Context(), roomID)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting portal")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, getRelayTemplatesResponse(portal))
+}
+
+func provV2SetRelayTemplates(w http.ResponseWriter, r *http.Request) {
+	roomID, err := parseProvRoomID(r)
+	if err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	var body waid.RelayTemplates
+	if r.Method == http.MethodPut {
+		if err = readProvJSONBody(r, &body); err != nil {
+			matrix.RespondWithError(w, err, "")
+			return
+		}
+	}
+	if userLogin := getLoginForRoomRequest(w, r, roomID); userLogin == nil {
+		return
+	}
+	portal, err := getPortalByRoomID(r.Context(), roomID)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error getting portal")
+		return
+	}
+	wa := m.Bridge.Network.(*connector.WhatsAppConnector)
+	err = wa.SetRelayTemplates(r.Context(), portal, &body)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Stringer("room_id", roomID).Msg("Failed to set relay templates")
+		matrix.RespondWithError(w, err, "Internal error setting relay templates")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, getRelayTemplatesResponse(portal))
+}
@@ -0,0 +1,277 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/format"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
+)
+
+const maxSendMediaSize = 100 * 1024 * 1024
+
+var ErrProvMediaDownloadFailed = bridgev2.RespError{
+	ErrCode:    "FI.MAU.WHATSAPP.MEDIA_DOWNLOAD_FAILED",
+	Err:        "Failed to download media from the given URL",
+	StatusCode: http.StatusBadRequest,
+}
+
+// sendMediaHTTPClient is used to download media URLs given to the send endpoint.
+// It refuses to connect to private addresses so that the endpoint can't be used to reach internal services.
+var sendMediaHTTPClient = connector.NewPublicHTTPClient(2 * time.Minute)
+
+var sendMediaTypes = map[string]event.MessageType{
+	"image": event.MsgImage,
+	"video": event.MsgVideo,
+	"audio": event.MsgAudio,
+	"file":  event.MsgFile,
+}
+
+type SendMediaBody struct {
+	URL      string `json:"url"`
+	Type     string `json:"type"`
+	FileName string `json:"file_name,omitempty"`
+	MimeType string `json:"mimetype,omitempty"`
+	Caption  string `json:"caption,omitempty"`
+}
+
+type SendTemplateBody struct {
+	Body   string            `json:"body"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+type SendMessageBody struct {
+	Text     string            `json:"text,omitempty"`
+	Markdown bool              `json:"markdown,omitempty"`
+	Media    *SendMediaBody    `json:"media,omitempty"`
+	Template *SendTemplateBody `json:"template,omitempty"`
+}
+
+type SendMessageResponse struct {
+	*connector.SentMessage
+	RoomID id.RoomID `json:"room_id"`
+}
+
+func (smb *SendMessageBody) validate() error {
+	payloads := 0
+	if smb.Text != "" {
+		payloads++
+	}
+	if smb.Media != nil {
+		payloads++
+		if smb.Media.URL == "" {
+			return errProvMissingParam("media.url")
+		} else if _, ok := sendMediaTypes[smb.Media.Type]; !ok {
+			return errProvInvalidParam("Invalid media type %q", smb.Media.Type)
+		}
+	}
+	if smb.Template != nil {
+		payloads++
+		if smb.Template.Body == "" {
+			return errProvMissingParam("template.body")
+		}
+	}
+	if payloads == 0 {
+		return errProvMissingParam("text, media or template")
+	} else if payloads > 1 {
+		return errProvInvalidParam("Only one of text, media or template can be specified")
+	}
+	return nil
+}
+
+func renderSendTemplate(tpl *SendTemplateBody) (string, error) {
+	parsed, err := template.New("message").Option("missingkey=error").Parse(tpl.Body)
+	if err != nil {
+		return "", errProvInvalidParam("Invalid template: %v", err)
+	}
+	var buf strings.Builder
+	err = parsed.Execute(&buf, tpl.Params)
+	if err != nil {
+		return "", errProvInvalidParam("Failed to render template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+func makeTextContent(text string, markdown bool) *event.MessageEventContent {
+	if markdown {
+		content := format.RenderMarkdown(text, true, false)
+		return &content
+	}
+	return &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	}
+}
+
+// downloadSendMedia streams the media at the given URL into the given file.
+// It returns the number of bytes written and the content type reported by the server.
+func downloadSendMedia(ctx context.Context, mediaURL string, file io.Writer) (int64, string, error) {
+	if err := connector.CheckPublicURL(ctx, mediaURL); err != nil {
+		return 0, "", ErrProvMediaDownloadFailed.WithMessage("Invalid media URL: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mediaURL, nil)
+	if err != nil {
+		return 0, "", ErrProvMediaDownloadFailed.WithMessage("Invalid media URL: %v", err)
+	}
+	resp, err := sendMediaHTTPClient.Do(req)
+	if err != nil {
+		return 0, "", ErrProvMediaDownloadFailed.WithMessage("Failed to download media: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, "", ErrProvMediaDownloadFailed.WithMessage("Failed to download media: HTTP %d", resp.StatusCode)
+	} else if resp.ContentLength > maxSendMediaSize {
+		return 0, "", ErrProvMediaDownloadFailed.WithMessage("Media is larger than %d bytes", maxSendMediaSize)
+	}
+	size, err := io.Copy(file, io.LimitReader(resp.Body, maxSendMediaSize+1))
+	if err != nil {
+		return 0, "", ErrProvMediaDownloadFailed.WithMessage("Failed to read media: %v", err)
+	} else if size > maxSendMediaSize {
+		return 0, "", ErrProvMediaDownloadFailed.WithMessage("Media is larger than %d bytes", maxSendMediaSize)
+	}
+	return size, resp.Header.Get("Content-Type"), nil
+}
+
+func makeMediaContent(ctx context.Context, portal *bridgev2.Portal, media *SendMediaBody) (*event.MessageEventContent, error) {
+	content := &event.MessageEventContent{
+		MsgType:  sendMediaTypes[media.Type],
+		FileName: media.FileName,
+		Info: &event.FileInfo{
+			MimeType: media.MimeType,
+		},
+	}
+	parsedURL, err := url.Parse(media.URL)
+	if err != nil {
+		return nil, errProvInvalidParam("Invalid media URL: %v", err)
+	}
+	if content.FileName == "" {
+		content.FileName = path.Base(parsedURL.Path)
+		if content.FileName == "" || content.FileName == "." || content.FileName == "/" {
+			content.FileName = media.Type
+		}
+	}
+	switch parsedURL.Scheme {
+	case "mxc":
+		if _, err = id.ParseContentURI(media.URL); err != nil {
+			return nil, errProvInvalidParam("Invalid media URL: %v", err)
+		}
+		content.URL = id.ContentURIString(media.URL)
+	case "http", "https":
+		content.URL, content.File, err = m.Bridge.Bot.UploadMediaStream(ctx, portal.MXID, -1, true, func(file io.Writer) (*bridgev2.FileStreamResult, error) {
+			size, mimeType, err := downloadSendMedia(ctx, media.URL, file)
+			if err != nil {
+				return nil, err
+			}
+			if content.Info.MimeType == "" {
+				content.Info.MimeType, _, _ = strings.Cut(mimeType, ";")
+			}
+			if content.Info.MimeType == "" || content.Info.MimeType == "application/octet-stream" {
+				header := make([]byte, 512)
+				n, _ := file.(*os.File).ReadAt(header, 0)
+				content.Info.MimeType = http.DetectContentType(header[:n])
+			}
+			content.Info.Size = int(size)
+			return &bridgev2.FileStreamResult{
+				FileName: content.FileName,
+				MimeType: content.Info.MimeType,
+			}, nil
+		})
+		if errors.Is(err, ErrProvMediaDownloadFailed) {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to upload media to Matrix: %w", err)
+		}
+	default:
+		return nil, errProvInvalidParam("Media URL must be a mxc, http or https URL")
+	}
+	content.Body = content.FileName
+	if media.Caption != "" {
+		content.Body = media.Caption
+	}
+	return content, nil
+}
+
+func provV2SendMessage(w http.ResponseWriter, r *http.Request) {
+	var body SendMessageBody
+	if err := readProvJSONBody(r, &body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	} else if err = body.validate(); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	var text string
+	if body.Template != nil {
+		var err error
+		text, err = renderSendTemplate(body.Template)
+		if err != nil {
+			matrix.RespondWithError(w, err, "")
+			return
+		}
+	} else {
+		text = body.Text
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	log := hlog.FromRequest(r)
+	_, portal, err := resolveIdentifierPortal(r.Context(), userLogin, r.PathValue("number"), true)
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error resolving identifier")
+		return
+	}
+	var content *event.MessageEventContent
+	if body.Media != nil {
+		content, err = makeMediaContent(r.Context(), portal, body.Media)
+		if err != nil {
+			log.Err(err).Msg("Failed to prepare media to send")
+			matrix.RespondWithError(w, err, "Internal error preparing media")
+			return
+		}
+	} else {
+		content = makeTextContent(text, body.Markdown)
+	}
+	sent, err := userLogin.Client.(*connector.WhatsAppClient).SendMessageToPortal(r.Context(), portal, content)
+	if err != nil {
+		log.Err(err).Stringer("room_id", portal.MXID).Msg("Failed to send message")
+		matrix.RespondWithError(w, err, "Internal error sending message")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, &SendMessageResponse{
+		SentMessage: sent,
+		RoomID:      portal.MXID,
+	})
+}
@@ -0,0 +1,130 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/matrix"
+	"github.com/rs/zerolog/hlog"
+	"go.mau.fi/util/exhttp"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+type WebhookInfo struct {
+	Subscribed bool                    `json:"subscribed"`
+	URL        string                  `json:"url,omitempty"`
+	HasSecret  bool                    `json:"has_secret"`
+	Events     []waid.WebhookEventType `json:"events"`
+}
+
+func wrapWebhookInfo(sub *waid.WebhookSubscription) *WebhookInfo {
+	if sub == nil {
+		return &WebhookInfo{Events: []waid.WebhookEventType{}}
+	}
+	info := &WebhookInfo{
+		Subscribed: true,
+		URL:        sub.URL,
+		HasSecret:  sub.Secret != "",
+		Events:     sub.Events,
+	}
+	if info.Events == nil {
+		info.Events = []waid.WebhookEventType{}
+	}
+	return info
+}
+
+func validateWebhookSubscription(sub *waid.WebhookSubscription) error {
+	if sub.URL == "" {
+		return errProvMissingParam("url")
+	}
+	parsedURL, err := url.Parse(sub.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return errProvInvalidParam("Webhook URL must be a http or https URL")
+	}
+	for _, evtType := range sub.Events {
+		if !evtType.IsValid() {
+			return errProvInvalidParam("Invalid webhook event type %q", evtType)
+		}
+	}
+	return nil
+}
+
+func provV2GetWebhook(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, wrapWebhookInfo(userLogin.Client.(*connector.WhatsAppClient).GetWebhook()))
+}
+
+func provV2SetWebhook(w http.ResponseWriter, r *http.Request) {
+	var body waid.WebhookSubscription
+	if err := readProvJSONBody(r, &body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	} else if err = validateWebhookSubscription(&body); err != nil {
+		matrix.RespondWithError(w, err, "")
+		return
+	}
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	err := userLogin.Client.(*connector.WhatsAppClient).SetWebhook(r.Context(), &body)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to set webhook")
+		matrix.RespondWithError(w, err, "Internal error setting webhook")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, wrapWebhookInfo(&body))
+}
+
+func provV2DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	err := userLogin.Client.(*connector.WhatsAppClient).SetWebhook(r.Context(), nil)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to delete webhook")
+		matrix.RespondWithError(w, err, "Internal error deleting webhook")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusOK, wrapWebhookInfo(nil))
+}
+
+func provV2TestWebhook(w http.ResponseWriter, r *http.Request) {
+	userLogin := m.Matrix.Provisioning.GetLoginForRequest(w, r)
+	if userLogin == nil {
+		return
+	}
+	client := userLogin.Client.(*connector.WhatsAppClient)
+	if client.GetWebhook() == nil {
+		matrix.RespondWithError(w, errProvInvalidParam("No webhook is configured for this login"), "")
+		return
+	}
+	err := client.QueueTestWebhook(r.Context())
+	if err != nil {
+		matrix.RespondWithError(w, err, "Internal error sending test webhook")
+		return
+	}
+	exhttp.WriteJSONResponse(w, http.StatusAccepted, struct{}{})
+}
@@ -0,0 +1,191 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	mautrix "github.com/iKonoTelecomunicaciones/go"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+// autoReplySkipTypes are message types that never trigger automatic replies.
+var autoReplySkipTypes = []string{
+	"reaction", "reaction remove", "encrypted reaction", "encrypted comment", "revoke", "edit",
+	"poll update", "disappearing timer change", "keep in chat",
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseBusinessHours parses business hours in the format `mon-fri 09:00-17:00`.
+// Days can be a comma-separated list of weekdays or ranges, or `all`.
+func ParseBusinessHours(days, hours string) (*waid.BusinessHours, error) {
+	var bh waid.BusinessHours
+	if strings.ToLower(days) == "all" {
+		days = "sun-sat"
+	}
+	for _, part := range strings.Split(strings.ToLower(days), ",") {
+		startName, endName, isRange := strings.Cut(part, "-")
+		start, ok := weekdayNames[startName]
+		if !ok {
+			return nil, fmt.Errorf("unknown weekday %q", startName)
+		}
+		end := start
+		if isRange {
+			if end, ok = weekdayNames[endName]; !ok {
+				return nil, fmt.Errorf("unknown weekday %q", endName)
+			} else if end < start {
+				return nil, fmt.Errorf("invalid weekday range %q", part)
+			}
+		}
+		for day := start; day <= end; day++ {
+			if !slices.Contains(bh.Days, day) {
+				bh.Days = append(bh.Days, day)
+			}
+		}
+	}
+	var ok bool
+	bh.Start, bh.End, ok = strings.Cut(hours, "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid time range %q, expected HH:MM-HH:MM", hours)
+	} else if err := bh.Validate(); err != nil {
+		return nil, err
+	}
+	return &bh, nil
+}
+
+// SetAutoReply changes the automatic reply settings of the login. Nil or empty settings remove them.
+func (wa *WhatsAppClient) SetAutoReply(ctx context.Context, settings *waid.AutoReplySettings) error {
+	if settings.IsEmpty() {
+		settings = nil
+	} else if err := settings.Validate(); err != nil {
+		return bridgev2.WrapRespErr(err, mautrix.MInvalidParam)
+	}
+	wa.UserLogin.Metadata.(*waid.UserLoginMetadata).AutoReply = settings
+	return wa.UserLogin.Save(ctx)
+}
+
+func (wa *WhatsAppClient) getUserTimezone() *time.Location {
+	tzName := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).Timezone
+	if tzName == "" {
+		return time.UTC
+	}
+	userTz, err := time.LoadLocation(tzName)
+	if err != nil {
+		return time.UTC
+	}
+	return userTz
+}
+
+// checkAutoReply checks if the message should get an automatic reply.
+func (wa *WhatsAppClient) checkAutoReply(evt *events.Message, parsedMessageType string) bool {
+	settings := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).AutoReply
+	if settings == nil || !settings.Enabled || evt.Info.IsFromMe || evt.Info.IsGroup ||
+		slices.Contains(autoReplySkipTypes, parsedMessageType) {
+		return false
+	} else if evt.Info.Chat.Server != types.DefaultUserServer && evt.Info.Chat.Server != types.HiddenUserServer {
+		return false
+	} else if maxAge := wa.Main.Config.AutoReply.MaxMessageAge; maxAge > 0 && time.Since(evt.Info.Timestamp) > maxAge {
+		return false
+	}
+	return true
+}
+
+// getAutoReplyText builds the automatic reply. The greeting is included if the chat hasn't been greeted yet.
+func (wa *WhatsAppClient) getAutoReplyText(settings *waid.AutoReplySettings, greeted bool) (text string, includesGreeting bool) {
+	var parts []string
+	if !greeted && settings.GreetingMessage != "" {
+		parts = append(parts, settings.GreetingMessage)
+		includesGreeting = true
+	}
+	if settings.AwayMessage != "" && !settings.IsOpen(time.Now().In(wa.getUserTimezone())) {
+		parts = append(parts, settings.AwayMessage)
+	}
+	if len(parts) == 0 {
+		return "", false
+	}
+	text = strings.Join(parts, "\n\n")
+	if settings.Signature != "" {
+		text += "\n" + settings.Signature
+	}
+	return text, includesGreeting
+}
+
+// sendAutoReply sends the away and/or greeting message to the given chat,
+// unless an automatic reply was already sent to the chat within the cooldown.
+// The greeting is only sent once per chat, which is tracked in the database.
+func (wa *WhatsAppClient) sendAutoReply(ctx context.Context, chatJID types.JID) {
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "send auto reply").
+		Stringer("chat_jid", chatJID).
+		Logger()
+	ctx = log.WithContext(ctx)
+	wa.autoReplyLock.Lock()
+	defer wa.autoReplyLock.Unlock()
+	settings := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).AutoReply
+	if settings == nil || !settings.Enabled {
+		return
+	}
+	lastSent, greeted, err := wa.Main.DB.AutoReply.GetState(ctx, wa.UserLogin.ID, chatJID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get auto reply state")
+		return
+	}
+	text, includesGreeting := wa.getAutoReplyText(settings, greeted)
+	if text == "" {
+		return
+	} else if cooldown := wa.Main.Config.AutoReply.Cooldown; !lastSent.IsZero() && time.Since(lastSent) < cooldown {
+		log.Debug().Time("last_sent", lastSent).Msg("Not sending auto reply, cooldown hasn't passed")
+		return
+	}
+	portal, err := wa.Main.Bridge.GetPortalByKey(ctx, wa.makeWAPortalKey(chatJID))
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal")
+		return
+	}
+	// Mark the reply as sent first, so failures don't cause the reply to be retried on every message.
+	err = wa.Main.DB.AutoReply.PutSent(ctx, wa.UserLogin.ID, chatJID, time.Now(), includesGreeting)
+	if err != nil {
+		log.Err(err).Msg("Failed to save last auto reply time")
+		return
+	}
+	sent, err := wa.SendMessageToPortal(ctx, portal, &event.MessageEventContent{
+		MsgType: event.MsgText,
+		Body:    text,
+	})
+	if err != nil {
+		log.Err(err).Msg("Failed to send auto reply")
+		return
+	}
+	log.Debug().
+		Str("message_id", sent.MessageID).
+		Bool("includes_greeting", includesGreeting).
+		Msg("Sent auto reply")
+}
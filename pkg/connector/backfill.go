@@ -15,6 +15,7 @@ import (
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/simplevent"
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
 	"go.mau.fi/util/ptr"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waE2E"
@@ -24,6 +25,7 @@ import (
 	"google.golang.org/protobuf/proto"
 
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/msgconv"
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
 
@@ -39,6 +41,9 @@ func (wa *WhatsAppClient) historySyncLoop(ctx context.Context) {
 	} else {
 		dispatchTimer.Stop()
 	}
+	if wa.isNewLogin || wa.UserLogin.Metadata.(*waid.UserLoginMetadata).HistorySyncPortalsNeedCreating {
+		wa.startHistorySyncProgress()
+	}
 	if wa.Client.ManualHistorySyncDownload {
 		// Wake up the queue once to check if there are pending notifications
 		select {
@@ -107,6 +112,12 @@ func (wa *WhatsAppClient) saveWAHistorySyncNotification(ctx context.Context, evt
 		Uint32("chunk_order", evt.GetChunkOrder()).
 		Uint32("progress", evt.GetProgress()).
 		Msg("Stored history sync notification in queue")
+	wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+		p.NotificationsReceived++
+		if evt.GetProgress() > p.PhoneProgress {
+			p.PhoneProgress = evt.GetProgress()
+		}
+	})
 	select {
 	case wa.historySyncWakeup <- struct{}{}:
 	default:
@@ -139,6 +150,14 @@ func (wa *WhatsAppClient) downloadAndSaveWAHistorySyncData(ctx context.Context,
 	})
 	if err != nil {
 		log.Err(err).Msg("Failed to store history sync notification data")
+	} else {
+		wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+			p.NotificationsProcessed++
+			if resetTimer {
+				// The payload had conversations, so the sync isn't done until another portal creation pass
+				p.portalCreationDone = false
+			}
+		})
 	}
 	return
 }
@@ -180,6 +199,7 @@ func (wa *WhatsAppClient) handleWAHistorySync(ctx context.Context, evt *waHistor
 	successfullySavedTotal := 0
 	failedToSaveTotal := 0
 	totalMessageCount := 0
+	parsedConversationCount := 0
 	for _, conv := range evt.GetConversations() {
 		log := log.With().
 			Int("msg_count", len(conv.GetMessages())).
@@ -214,6 +234,7 @@ func (wa *WhatsAppClient) handleWAHistorySync(ctx context.Context, evt *waHistor
 		log.UpdateContext(func(c zerolog.Context) zerolog.Context {
 			return c.Stringer("chat_jid", jid)
 		})
+		parsedConversationCount++
 
 		var minTime, maxTime time.Time
 		var minTimeIndex, maxTimeIndex int
@@ -275,6 +296,10 @@ func (wa *WhatsAppClient) handleWAHistorySync(ctx context.Context, evt *waHistor
 			).
 			Msg("Collected messages to save from history sync conversation")
 
+		if jid.Server == types.GroupServer {
+			// Remember the community status so that the chat filter doesn't have to fetch the group info later
+			wa.setCommunityGroup(jid, conv.GetIsParentGroup() || conv.GetParentGroupID() != "")
+		}
 		if len(messages) > 0 {
 			err = wa.Main.DB.Conversation.Put(ctx, wadb.NewConversation(wa.UserLogin.ID, jid, conv, maxTime))
 			if err != nil {
@@ -309,6 +334,10 @@ func (wa *WhatsAppClient) handleWAHistorySync(ctx context.Context, evt *waHistor
 		Int("total_message_count", totalMessageCount).
 		Dur("duration", time.Since(start)).
 		Msg("Finished storing history sync")
+	wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+		p.ConversationsParsed += parsedConversationCount
+		p.MessagesSaved += successfullySavedTotal
+	})
 	resetTimer := evt.GetSyncType() == waHistorySync.HistorySync_RECENT ||
 		evt.GetSyncType() == waHistorySync.HistorySync_FULL
 	return resetTimer, nil
@@ -320,20 +349,57 @@ func (wa *WhatsAppClient) createPortalsFromHistorySync(ctx context.Context) {
 		Logger()
 	ctx = log.WithContext(ctx)
 	limit := wa.Main.Config.HistorySync.MaxInitialConversations
+	if wa.Main.Config.HistorySync.SelectiveSync {
+		limit = -1
+	}
 	loginTS := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).LoggedInAt
-	conversations, err := wa.Main.DB.Conversation.GetRecent(ctx, wa.UserLogin.ID, limit, loginTS)
+	conversations, err := wa.getFilteredConversations(ctx, limit, loginTS)
 	if err != nil {
 		log.Err(err).Msg("Failed to get recent conversations from database")
 		return
 	}
+	if wa.Main.Config.HistorySync.SelectiveSync {
+		wa.sendHistorySyncSelectionNotice(ctx, conversations)
+		wa.markHistorySyncPortalsCreated(ctx)
+		return
+	}
 	log.Info().
 		Int("limit", limit).
 		Int("conversation_count", len(conversations)).
 		Int64("login_timestamp", loginTS.Unix()).
 		Msg("Creating portals from history sync")
+	wa.createPortalsForConversations(ctx, conversations, loginTS, func() {
+		wa.markHistorySyncPortalsCreated(ctx)
+	})
+}
+
+func (wa *WhatsAppClient) markHistorySyncPortalsCreated(ctx context.Context) {
+	wa.UserLogin.Metadata.(*waid.UserLoginMetadata).HistorySyncPortalsNeedCreating = false
+	err := wa.UserLogin.Save(ctx)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to save user login history sync portals created flag")
+	}
+	wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+		p.portalCreationDone = true
+	})
+}
+
+func (wa *WhatsAppClient) createPortalsForConversations(
+	ctx context.Context, conversations []*wadb.Conversation, loginTS jsontime.Unix, onComplete func(),
+) {
+	log := zerolog.Ctx(ctx)
+	wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+		p.PortalsToCreate += len(conversations)
+	})
 	rateLimitErrors := 0
 	var wg sync.WaitGroup
 	wg.Add(len(conversations))
+	skipConversation := func() {
+		wg.Done()
+		wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+			p.PortalsToCreate--
+		})
+	}
 	for i := 0; i < len(conversations); i++ {
 		if ctx.Err() != nil {
 			log.Warn().Err(ctx.Err()).Msg("Context cancelled, stopping history sync portal creation")
@@ -344,11 +410,14 @@ func (wa *WhatsAppClient) createPortalsFromHistorySync(ctx context.Context) {
 		}
 		conv := conversations[i]
 		if conv.ChatJID == types.StatusBroadcastJID && !wa.Main.Config.EnableStatusBroadcast {
-			wg.Done()
+			skipConversation()
 			continue
 		} else if conv.ChatJID == types.PSAJID || conv.ChatJID == types.LegacyPSAJID {
 			// We don't currently support new PSAs, so don't bother backfilling them either
-			wg.Done()
+			skipConversation()
+			continue
+		} else if !wa.shouldCreatePortal(ctx, conv.ChatJID, nil) {
+			skipConversation()
 			continue
 		}
 		// TODO can the chat info fetch be avoided entirely?
@@ -370,7 +439,7 @@ func (wa *WhatsAppClient) createPortalsFromHistorySync(ctx context.Context) {
 			if err != nil {
 				log.Err(err).Msg("Failed to delete conversation user is not in")
 			}
-			wg.Done()
+			skipConversation()
 			continue
 		} else if errors.Is(err, whatsmeow.ErrIQRateOverLimit) {
 			rateLimitErrors++
@@ -387,7 +456,7 @@ func (wa *WhatsAppClient) createPortalsFromHistorySync(ctx context.Context) {
 			continue
 		} else if err != nil {
 			log.Err(err).Stringer("chat_jid", conv.ChatJID).Msg("Failed to get chat info")
-			wg.Done()
+			skipConversation()
 			continue
 		}
 		res := wa.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
@@ -406,6 +475,9 @@ func (wa *WhatsAppClient) createPortalsFromHistorySync(ctx context.Context) {
 						zerolog.Ctx(ctx).Err(err).Msg("Failed to mark conversation as bridged")
 					}
 					wg.Done()
+					wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+						p.PortalsCreated++
+					})
 				},
 			},
 			ChatInfo:        wrappedInfo,
@@ -419,12 +491,10 @@ func (wa *WhatsAppClient) createPortalsFromHistorySync(ctx context.Context) {
 	log.Info().Int("conversation_count", len(conversations)).Msg("Finished creating portals from history sync")
 	go func() {
 		wg.Wait()
-		wa.UserLogin.Metadata.(*waid.UserLoginMetadata).HistorySyncPortalsNeedCreating = false
-		err = wa.UserLogin.Save(ctx)
-		if err != nil {
-			log.Err(err).Msg("Failed to save user login history sync portals created flag")
-		}
 		log.Info().Msg("Finished processing all history sync chat resync events")
+		if onComplete != nil {
+			onComplete()
+		}
 	}()
 }
 
@@ -537,10 +607,8 @@ func (wa *WhatsAppClient) convertHistorySyncMessage(
 	ctx context.Context, portal *bridgev2.Portal, info *types.MessageInfo, msg, rawMsg *waE2E.Message, isViewOnce bool, reactions []*waWeb.Reaction,
 ) (*bridgev2.BackfillMessage, *wadb.MediaRequest) {
 	// New messages turn these into edits, but in backfill we only have the last version,
-	// so no need to do the edit thing. Instead, just unwrap the message.
-	if msg.GetAssociatedChildMessage().GetMessage() != nil {
-		msg = msg.GetAssociatedChildMessage().GetMessage()
-	}
+	// so no need to do the edit thing. Instead, just unwrap the message, keeping the album association if there is one.
+	msg = msgconv.UnwrapAssociatedChild(msg)
 	// TODO use proper intent
 	intent := wa.Main.Bridge.Bot
 	wrapped := &bridgev2.BackfillMessage{
@@ -11,6 +11,7 @@ import (
 	"go.mau.fi/util/jsontime"
 	"go.mau.fi/util/ptr"
 
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/msgconv"
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
 
@@ -66,7 +67,7 @@ func supportedIfFFmpeg() event.CapabilitySupportLevel {
 }
 
 func capID() string {
-	base := "fi.mau.whatsapp.capabilities.2025_12_15"
+	base := "fi.mau.whatsapp.capabilities.2026_10_19"
 	if ffmpeg.Supported() {
 		return base + "+ffmpeg"
 	}
@@ -117,18 +118,24 @@ var whatsappCaps = &event.RoomFeatures{
 		event.CapMsgVoice: {
 			MimeTypes: map[string]event.CapabilitySupportLevel{
 				"audio/ogg; codecs=opus": event.CapLevelFullySupported,
-				"audio/ogg":              event.CapLevelUnsupported,
+				"audio/ogg":              event.CapLevelPartialSupport, // sent as Opus as-is if ffmpeg isn't available
+				"audio/mp4":              supportedIfFFmpeg(),
+				"audio/x-m4a":            supportedIfFFmpeg(),
+				"audio/aac":              supportedIfFFmpeg(),
+				"audio/mpeg":             supportedIfFFmpeg(),
+				"audio/webm":             supportedIfFFmpeg(),
+				"audio/wav":              supportedIfFFmpeg(),
 			},
 			Caption: event.CapLevelDropped,
 			MaxSize: WAMaxFileSize,
 		},
 		event.CapMsgSticker: {
 			MimeTypes: map[string]event.CapabilitySupportLevel{
-				"image/webp": event.CapLevelFullySupported,
-				// TODO see if sending lottie is possible
-				//"video/lottie+json": event.CapLevelFullySupported,
-				"image/png":  event.CapLevelPartialSupport,
-				"image/jpeg": event.CapLevelPartialSupport,
+				"image/webp":        event.CapLevelFullySupported,
+				"video/lottie+json": event.CapLevelFullySupported,
+				"image/gif":         event.CapLevelFullySupported,
+				"image/png":         event.CapLevelPartialSupport,
+				"image/jpeg":        event.CapLevelPartialSupport,
 			},
 			Caption: event.CapLevelDropped,
 			MaxSize: WAMaxFileSize,
@@ -144,10 +151,25 @@ var whatsappCaps = &event.RoomFeatures{
 		},
 		event.MsgVideo: {
 			MimeTypes: map[string]event.CapabilitySupportLevel{
-				"video/mp4":       event.CapLevelFullySupported,
-				"video/3gpp":      event.CapLevelFullySupported,
-				"video/webm":      supportedIfFFmpeg(),
-				"video/quicktime": supportedIfFFmpeg(),
+				"video/mp4":        event.CapLevelFullySupported,
+				"video/3gpp":       event.CapLevelFullySupported,
+				"video/webm":       supportedIfFFmpeg(),
+				"video/quicktime":  supportedIfFFmpeg(),
+				"video/x-matroska": supportedIfFFmpeg(),
+				"video/x-msvideo":  supportedIfFFmpeg(),
+				"video/mpeg":       supportedIfFFmpeg(),
+			},
+			Caption:          event.CapLevelFullySupported,
+			MaxCaptionLength: MaxTextLength,
+			MaxSize:          WAMaxFileSize,
+		},
+		msgconv.MsgGallery: {
+			MimeTypes: map[string]event.CapabilitySupportLevel{
+				"image/jpeg": event.CapLevelFullySupported,
+				"image/png":  event.CapLevelPartialSupport,
+				"image/webp": event.CapLevelPartialSupport,
+				"video/mp4":  event.CapLevelFullySupported,
+				"video/3gpp": event.CapLevelFullySupported,
 			},
 			Caption:          event.CapLevelFullySupported,
 			MaxCaptionLength: MaxTextLength,
@@ -0,0 +1,182 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/simplevent"
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+// shouldCreatePortal checks the chat filter of the login to decide whether a portal should be created for the given chat.
+// The group info is optional. If it's needed for the community check and the community status isn't known yet,
+// the group info is fetched in the background and the portal is created afterwards if the group passes the filter.
+func (wa *WhatsAppClient) shouldCreatePortal(ctx context.Context, chatJID types.JID, groupInfo *types.GroupInfo) bool {
+	filter := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).ChatFilter
+	if filter.IsEmpty() {
+		return true
+	}
+	log := zerolog.Ctx(ctx).With().Stringer("chat_jid", chatJID).Logger()
+	if !filter.Allows(chatJID) {
+		log.Debug().Msg("Not creating portal for chat blocked by chat filter")
+		return false
+	}
+	switch chatJID.Server {
+	case types.DefaultUserServer, types.HiddenUserServer:
+		if !filter.ContactsOnly {
+			return true
+		}
+		contactJID := chatJID
+		if chatJID.Server == types.HiddenUserServer {
+			// Contacts are stored with phone numbers, so LID DMs have to be mapped first
+			pn, err := wa.GetStore().LIDs.GetPNForLID(ctx, chatJID)
+			if err != nil {
+				log.Err(err).Msg("Failed to get phone number for LID to check chat filter")
+				return false
+			} else if !pn.IsEmpty() {
+				contactJID = pn
+			}
+		}
+		contact, err := wa.GetStore().Contacts.GetContact(ctx, contactJID)
+		if err != nil {
+			log.Err(err).Msg("Failed to get contact info to check chat filter")
+			return false
+		} else if contact.FullName == "" && contact.FirstName == "" {
+			log.Debug().Msg("Not creating portal for DM with non-contact")
+			return false
+		}
+	case types.GroupServer:
+		if !filter.ExcludeCommunities || wa.Client == nil {
+			return true
+		}
+		isCommunity, known, err := wa.isCommunityGroup(ctx, chatJID, groupInfo)
+		if err != nil {
+			log.Err(err).Msg("Failed to check if group is in a community")
+			return true
+		} else if !known {
+			log.Debug().Msg("Community status of group isn't known, checking it in the background before creating portal")
+			wa.checkCommunityGroupInBackground(chatJID)
+			return false
+		} else if isCommunity {
+			log.Debug().Msg("Not creating portal for community group")
+			return false
+		}
+	}
+	return true
+}
+
+// communityCheckTimeout limits how long fetching group info for the community filter may take.
+const communityCheckTimeout = 10 * time.Second
+
+// isCommunityGroup checks if the given group is a community or belongs to one using the given group info,
+// previous results (including ones from the history sync) and existing portals. The group info is never fetched from
+// the server here, if the status isn't known, known is false.
+func (wa *WhatsAppClient) isCommunityGroup(ctx context.Context, chatJID types.JID, groupInfo *types.GroupInfo) (isCommunity, known bool, err error) {
+	if groupInfo != nil {
+		return wa.cacheCommunityGroup(chatJID, groupInfo), true, nil
+	}
+	wa.communityGroupLock.Lock()
+	isCommunity, known = wa.communityGroups[chatJID]
+	wa.communityGroupLock.Unlock()
+	if known {
+		return isCommunity, true, nil
+	}
+	portal, err := wa.Main.Bridge.GetExistingPortalByKey(ctx, wa.makeWAPortalKey(chatJID))
+	if err != nil {
+		return false, false, fmt.Errorf("failed to get portal: %w", err)
+	} else if portal != nil && portal.MXID != "" {
+		// The create portal flag doesn't matter for chats that already have a room
+		return false, true, nil
+	} else if portal != nil && portal.ParentKey.ID != "" {
+		return true, true, nil
+	}
+	return false, false, nil
+}
+
+// checkCommunityGroupInBackground fetches the info of a group whose community status isn't known and queues a chat
+// resync to create the portal if the group isn't in a community. Only one check per group runs at a time.
+func (wa *WhatsAppClient) checkCommunityGroupInBackground(chatJID types.JID) {
+	wa.communityGroupLock.Lock()
+	if _, checking := wa.communityChecks[chatJID]; checking {
+		wa.communityGroupLock.Unlock()
+		return
+	}
+	wa.communityChecks[chatJID] = struct{}{}
+	wa.communityGroupLock.Unlock()
+	go func() {
+		defer func() {
+			wa.communityGroupLock.Lock()
+			delete(wa.communityChecks, chatJID)
+			wa.communityGroupLock.Unlock()
+		}()
+		log := wa.UserLogin.Log.With().
+			Str("action", "check community group").
+			Stringer("chat_jid", chatJID).
+			Logger()
+		ctx, cancel := context.WithTimeout(log.WithContext(wa.Main.Bridge.BackgroundCtx), communityCheckTimeout)
+		defer cancel()
+		client := wa.Client
+		if client == nil {
+			return
+		}
+		groupInfo, err := client.GetGroupInfo(ctx, chatJID)
+		if err != nil {
+			log.Err(err).Msg("Failed to get group info to check chat filter")
+			return
+		} else if wa.cacheCommunityGroup(chatJID, groupInfo) {
+			log.Debug().Msg("Not creating portal for community group")
+			return
+		}
+		log.Debug().Msg("Group isn't in a community, creating portal")
+		wa.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
+			EventMeta: simplevent.EventMeta{
+				Type:         bridgev2.RemoteEventChatResync,
+				LogContext:   nil,
+				PortalKey:    wa.makeWAPortalKey(chatJID),
+				CreatePortal: wa.shouldCreatePortal(ctx, chatJID, groupInfo),
+			},
+			ChatInfo: wa.wrapGroupInfo(ctx, groupInfo),
+		})
+	}()
+}
+
+func (wa *WhatsAppClient) cacheCommunityGroup(chatJID types.JID, groupInfo *types.GroupInfo) bool {
+	isCommunity := groupInfo.IsParent || !groupInfo.LinkedParentJID.IsEmpty()
+	wa.setCommunityGroup(chatJID, isCommunity)
+	return isCommunity
+}
+
+// setCommunityGroup stores whether the given group is a community or belongs to one.
+func (wa *WhatsAppClient) setCommunityGroup(chatJID types.JID, isCommunity bool) {
+	wa.communityGroupLock.Lock()
+	wa.communityGroups[chatJID] = isCommunity
+	wa.communityGroupLock.Unlock()
+}
+
+// forgetCommunityGroup removes the cached community status of a group, e.g. after it was linked to a community.
+func (wa *WhatsAppClient) forgetCommunityGroup(chatJID types.JID) {
+	wa.communityGroupLock.Lock()
+	delete(wa.communityGroups, chatJID)
+	wa.communityGroupLock.Unlock()
+}
@@ -51,9 +51,12 @@ func (wa *WhatsAppConnector) LoadUserLogin(ctx context.Context, login *bridgev2.
 		historySyncWakeup:  make(chan struct{}, 1),
 		resyncQueue:        make(map[types.JID]resyncQueueItem),
 		directMediaRetries: make(map[networkid.MessageID]*directMediaRetry),
+		mediaRetryWaiters:  make(map[networkid.MessageID]chan error),
 		mediaRetryLock:     semaphore.NewWeighted(wa.Config.HistorySync.MediaRequests.MaxAsyncHandle),
 		pushNamesSynced:    exsync.NewEvent(),
 		createDedup:        exsync.NewSet[types.MessageID](),
+		communityGroups:    make(map[types.JID]bool),
+		communityChecks:    make(map[types.JID]struct{}),
 	}
 	login.Client = w
 
@@ -110,12 +113,23 @@ type WhatsAppClient struct {
 	nextResync         time.Time
 	directMediaRetries map[networkid.MessageID]*directMediaRetry
 	directMediaLock    sync.Mutex
+	mediaRetryWaiters  map[networkid.MessageID]chan error
+	mediaRetryWaitLock sync.Mutex
 	mediaRetryLock     *semaphore.Weighted
+	mediaRequestLock   sync.Mutex
+	lastMediaRequest   time.Time
 	offlineSyncWaiter  atomic.Pointer[chan error]
 	isNewLogin         bool
 	pushNamesSynced    *exsync.Event
 	lastPresence       types.Presence
 	createDedup        *exsync.Set[types.MessageID]
+	hsProgress         historySyncProgressTracker
+	hsSelection        historySyncSelection
+	contactCheckLock   sync.Mutex
+	communityGroups    map[types.JID]bool
+	communityChecks    map[types.JID]struct{}
+	communityGroupLock sync.Mutex
+	autoReplyLock      sync.Mutex
 }
 
 var (
@@ -187,10 +201,10 @@ func (wa *WhatsAppClient) Connect(ctx context.Context) {
 			StateEvent: status.StateBadCredentials,
 			Error:      WANotLoggedIn,
 		}
-		wa.UserLogin.BridgeState.Send(state)
+		wa.sendBridgeState(state)
 		return
 	}
-	wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnecting})
+	wa.sendBridgeState(status.BridgeState{StateEvent: status.StateConnecting})
 	wa.Main.firstClientConnectOnce.Do(wa.Main.onFirstClientConnect)
 	if err := wa.Main.updateProxy(ctx, wa.Client, false); err != nil {
 		zerolog.Ctx(ctx).Err(err).Msg("Failed to update proxy")
@@ -210,7 +224,7 @@ func (wa *WhatsAppClient) Connect(ctx context.Context) {
 				"go_error": err.Error(),
 			},
 		}
-		wa.UserLogin.BridgeState.Send(state)
+		wa.sendBridgeState(state)
 	}
 }
 
@@ -328,7 +342,7 @@ func (wa *WhatsAppClient) startLoops() {
 	}
 	go wa.historySyncLoop(ctx)
 	go wa.ghostResyncLoop(ctx)
-	if mrc := wa.Main.Config.HistorySync.MediaRequests; mrc.AutoRequestMedia && mrc.RequestMethod == MediaRequestMethodLocalTime {
+	if mrc := wa.Main.Config.HistorySync.MediaRequests; mrc.AutoRequestMedia && (mrc.RequestMethod == MediaRequestMethodLocalTime || mrc.MaxRetries > 0) {
 		go wa.mediaRequestLoop(ctx)
 	}
 }
@@ -397,7 +411,7 @@ func (wa *WhatsAppClient) syncRemoteProfile(ctx context.Context, ghost *bridgev2
 	}
 	// FIXME this might be racy, should invent a proper way to send last state with info filled
 	if wa.Client.IsConnected() {
-		wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
+		wa.sendBridgeState(status.BridgeState{StateEvent: status.StateConnected})
 	}
 	zerolog.Ctx(ctx).Info().Msg("Remote profile updated")
 }
@@ -18,19 +18,32 @@ package connector
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/iKonoTelecomunicaciones/go/bridgev2"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/commands"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/simplevent"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
 	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/appstate"
 	"go.mau.fi/whatsmeow/types"
 
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/msgconv"
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
 
@@ -80,15 +93,15 @@ var cmdSync = &commands.FullHandler{
 	Help: commands.HelpMeta{
 		Section:     commands.HelpSectionAdmin,
 		Description: "Sync data from WhatsApp.",
-		Args:        "<group/groups/contacts>",
+		Args:        "[--login <_ID_>] <group/groups/contacts>",
 	},
 	RequiresLogin: true,
 }
 
 func fnSync(ce *commands.Event) {
-	login := ce.User.GetDefaultLogin()
-	if login == nil {
-		ce.Reply("Login not found")
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
 		return
 	}
 	if len(ce.Args) == 0 {
@@ -98,14 +111,13 @@ func fnSync(ce *commands.Event) {
 	logContext := func(c zerolog.Context) zerolog.Context {
 		return c.Stringer("triggered_by_user", ce.User.MXID)
 	}
-	wa := login.Client.(*WhatsAppClient)
 	switch strings.ToLower(ce.Args[0]) {
 	case "group", "portal", "room":
 		if ce.Portal == nil {
 			ce.Reply("`!wa sync group` can only be used in a portal room.")
 			return
 		}
-		login.QueueRemoteEvent(&simplevent.ChatResync{
+		wa.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
 			EventMeta: simplevent.EventMeta{
 				Type:       bridgev2.RemoteEventChatResync,
 				PortalKey:  ce.Portal.PortalKey,
@@ -121,7 +133,7 @@ func fnSync(ce *commands.Event) {
 			return
 		}
 		for _, group := range groups {
-			login.QueueRemoteEvent(&simplevent.ChatResync{
+			wa.UserLogin.QueueRemoteEvent(&simplevent.ChatResync{
 				EventMeta: simplevent.EventMeta{
 					Type:         bridgev2.RemoteEventChatResync,
 					PortalKey:    wa.makeWAPortalKey(group.JID),
@@ -168,16 +180,16 @@ var cmdInviteLink = &commands.FullHandler{
 	Help: commands.HelpMeta{
 		Section:     HelpSectionInvites,
 		Description: "Get an invite link to the current group chat, optionally regenerating the link and revoking the old link.",
-		Args:        "[--reset]",
+		Args:        "[--login <_ID_>] [--reset]",
 	},
 	RequiresPortal: true,
 	RequiresLogin:  true,
 }
 
 func fnInviteLink(ce *commands.Event) {
-	login := ce.User.GetDefaultLogin()
-	if login == nil {
-		ce.Reply("Login not found")
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
 		return
 	}
 	portalJID, err := waid.ParsePortalID(ce.Portal.ID)
@@ -186,7 +198,6 @@ func fnInviteLink(ce *commands.Event) {
 		return
 	}
 
-	wa := login.Client.(*WhatsAppClient)
 	reset := len(ce.Args) > 0 && strings.ToLower(ce.Args[0]) == "--reset"
 	if portalJID.Server == types.DefaultUserServer || portalJID.Server == types.HiddenUserServer {
 		ce.Reply("Can't get invite link to private chat")
@@ -205,22 +216,21 @@ var cmdResolveLink = &commands.FullHandler{
 	Help: commands.HelpMeta{
 		Section:     HelpSectionInvites,
 		Description: "Resolve a WhatsApp group invite or business message link.",
-		Args:        "<_group, contact, or message link_>",
+		Args:        "[--login <_ID_>] <_group, contact, or message link_>",
 	},
 	RequiresLogin: true,
 }
 
 func fnResolveLink(ce *commands.Event) {
-	if len(ce.Args) == 0 {
-		ce.Reply("**Usage:** `$cmdprefix resolve-link <group or message link>`")
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
 		return
 	}
-	login := ce.User.GetDefaultLogin()
-	if login == nil {
-		ce.Reply("Login not found")
+	if len(ce.Args) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix resolve-link <group or message link>`")
 		return
 	}
-	wa := login.Client.(*WhatsAppClient)
 	if strings.HasPrefix(ce.Args[0], whatsmeow.InviteLinkPrefix) {
 		group, err := wa.Client.GetGroupInfoFromLink(ce.Ctx, ce.Args[0])
 		if err != nil {
@@ -265,22 +275,21 @@ var cmdJoin = &commands.FullHandler{
 	Help: commands.HelpMeta{
 		Section:     HelpSectionInvites,
 		Description: "Join a group chat with an invite link.",
-		Args:        "<_invite link_>",
+		Args:        "[--login <_ID_>] <_invite link_>",
 	},
 	RequiresLogin: true,
 }
 
 func fnJoin(ce *commands.Event) {
-	if len(ce.Args) == 0 {
-		ce.Reply("**Usage:** `$cmdprefix join <invite link>`")
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
 		return
 	}
-	login := ce.User.GetDefaultLogin()
-	if login == nil {
-		ce.Reply("Login not found")
+	if len(ce.Args) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix join <invite link>`")
 		return
 	}
-	wa := login.Client.(*WhatsAppClient)
 
 	if strings.HasPrefix(ce.Args[0], whatsmeow.InviteLinkPrefix) {
 		jid, err := wa.Client.JoinGroupWithLink(ce.Ctx, ce.Args[0])
@@ -307,3 +316,736 @@ func fnJoin(ce *commands.Event) {
 		ce.Reply("That doesn't look like a WhatsApp invite link")
 	}
 }
+
+var cmdHistorySync = &commands.FullHandler{
+	Func: fnHistorySync,
+	Name: "history-sync",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "List chats from the history sync that haven't been bridged yet, or choose which of them to bridge.",
+		Args:        "[--login <_ID_>] <list [page]/bridge <_numbers or JIDs..._/all>>",
+	},
+	RequiresLogin: true,
+}
+
+func fnHistorySync(ce *commands.Event) {
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
+		return
+	}
+	if len(ce.Args) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix history-sync <list [page]/bridge <numbers or JIDs...|all>>`")
+		return
+	}
+	conversations, err := wa.GetPendingHistorySyncConversations(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get pending history sync conversations")
+		ce.Reply("Failed to get chats from history sync: %v", err)
+		return
+	} else if len(conversations) == 0 {
+		ce.Reply("There are no unbridged chats from the history sync")
+		return
+	}
+	switch strings.ToLower(ce.Args[0]) {
+	case "list":
+		page := 1
+		if len(ce.Args) > 1 {
+			page, err = strconv.Atoi(ce.Args[1])
+			if err != nil || page < 1 {
+				ce.Reply("Invalid page number `%s`", ce.Args[1])
+				return
+			}
+		}
+		offset := (page - 1) * historySyncListPageSize
+		if offset >= len(conversations) {
+			ce.Reply("Page %d is empty, there are only %d unbridged chats", page, len(conversations))
+			return
+		}
+		wa.setShownHistorySyncList(conversations)
+		ce.Reply("%s", formatHistorySyncConversations(conversations, offset, historySyncListPageSize))
+	case "bridge":
+		if len(ce.Args) < 2 {
+			ce.Reply("**Usage:** `$cmdprefix history-sync bridge <numbers or JIDs...|all>`")
+			return
+		}
+		var chatJIDs []types.JID
+		if len(ce.Args) == 2 && strings.ToLower(ce.Args[1]) == "all" {
+			for _, conv := range conversations {
+				chatJIDs = append(chatJIDs, conv.ChatJID)
+			}
+		} else {
+			// List numbers refer to the list the user last saw, as the current list may have changed since then
+			shown := wa.getShownHistorySyncList()
+			for _, arg := range ce.Args[1:] {
+				selected, err := parseHistorySyncSelection(arg, shown)
+				if err != nil {
+					ce.Reply("%v", err)
+					return
+				}
+				chatJIDs = append(chatJIDs, selected...)
+			}
+		}
+		count, err := wa.BridgeHistorySyncConversations(ce.Ctx, chatJIDs)
+		if err != nil {
+			ce.Log.Err(err).Msg("Failed to bridge selected history sync conversations")
+			ce.Reply("Failed to bridge chats: %v", err)
+		} else if count == 0 {
+			ce.Reply("None of the selected chats are waiting to be bridged")
+		} else {
+			ce.Reply("Creating portals for %d chats, they should appear momentarily", count)
+		}
+	default:
+		ce.Reply("Unknown subcommand `%s`", ce.Args[0])
+	}
+}
+
+// parseHistorySyncSelection parses a list number (e.g. `5`), a range of list numbers (e.g. `1-10`)
+// or a chat JID into the JIDs of the selected conversations. List numbers are resolved against the given shown list.
+func parseHistorySyncSelection(arg string, shown []types.JID) ([]types.JID, error) {
+	if strings.ContainsRune(arg, '@') {
+		jid, err := types.ParseJID(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid JID `%s`: %w", arg, err)
+		}
+		return []types.JID{jid}, nil
+	}
+	startStr, endStr, isRange := strings.Cut(arg, "-")
+	start, err := strconv.Atoi(startStr)
+	end := start
+	if err == nil && isRange {
+		end, err = strconv.Atoi(endStr)
+	}
+	if err != nil || start < 1 || end < start {
+		return nil, fmt.Errorf("invalid chat number `%s`", arg)
+	} else if len(shown) == 0 {
+		return nil, errors.New("no chat list has been shown yet, use `history-sync list` first or specify JIDs")
+	} else if end > len(shown) {
+		return nil, fmt.Errorf("invalid chat number `%s`, the last list only had %d chats", arg, len(shown))
+	}
+	return slices.Clone(shown[start-1 : end]), nil
+}
+
+var cmdFilter = &commands.FullHandler{
+	Func: fnFilter,
+	Name: "filter",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "View or change which chats portals are automatically created for.",
+		Args:        "[--login <_ID_>] [allow/deny/remove <_JID pattern_> | type <all/groups/dms> | contacts-only/exclude-communities/exclude-newsletters <on/off> | reset]",
+	},
+	RequiresLogin: true,
+}
+
+func formatChatFilter(filter *waid.ChatFilter) string {
+	if filter.IsEmpty() {
+		return "No chat filter is set, portals are created for all chats."
+	}
+	formatPatterns := func(patterns []string) string {
+		if len(patterns) == 0 {
+			return "none"
+		}
+		return "`" + strings.Join(patterns, "`, `") + "`"
+	}
+	chatType := string(filter.Type)
+	if chatType == "" {
+		chatType = "all"
+	}
+	return fmt.Sprintf(
+		"* Allowed JIDs: %s\n* Denied JIDs: %s\n* Chat type: %s\n* Contacts only: %t\n* Exclude communities: %t\n* Exclude channels: %t",
+		formatPatterns(filter.Allow), formatPatterns(filter.Deny), chatType,
+		filter.ContactsOnly, filter.ExcludeCommunities, filter.ExcludeNewsletters,
+	)
+}
+
+func parseOnOff(arg string) (bool, bool) {
+	switch strings.ToLower(arg) {
+	case "on", "true", "yes", "1":
+		return true, true
+	case "off", "false", "no", "0":
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func fnFilter(ce *commands.Event) {
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
+		return
+	}
+	meta := wa.UserLogin.Metadata.(*waid.UserLoginMetadata)
+	if len(ce.Args) == 0 || strings.ToLower(ce.Args[0]) == "show" {
+		ce.Reply("%s", formatChatFilter(meta.ChatFilter))
+		return
+	}
+	filter := meta.ChatFilter.Clone()
+	subcommand := strings.ToLower(ce.Args[0])
+	switch subcommand {
+	case "allow", "deny", "remove":
+		if len(ce.Args) < 2 {
+			ce.Reply("**Usage:** `$cmdprefix filter %s <JID pattern>`", subcommand)
+			return
+		}
+		pattern := ce.Args[1]
+		if _, err := path.Match(pattern, ""); err != nil {
+			ce.Reply("Invalid pattern `%s`: %v", pattern, err)
+			return
+		}
+		filter.Allow = slices.DeleteFunc(filter.Allow, func(s string) bool { return s == pattern })
+		filter.Deny = slices.DeleteFunc(filter.Deny, func(s string) bool { return s == pattern })
+		if subcommand == "allow" {
+			filter.Allow = append(filter.Allow, pattern)
+		} else if subcommand == "deny" {
+			filter.Deny = append(filter.Deny, pattern)
+		}
+	case "type":
+		if len(ce.Args) < 2 {
+			ce.Reply("**Usage:** `$cmdprefix filter type <all/groups/dms>`")
+			return
+		}
+		switch strings.ToLower(ce.Args[1]) {
+		case "all":
+			filter.Type = waid.ChatFilterTypeAll
+		case "groups":
+			filter.Type = waid.ChatFilterTypeGroups
+		case "dms":
+			filter.Type = waid.ChatFilterTypeDMs
+		default:
+			ce.Reply("Unknown chat type `%s`", ce.Args[1])
+			return
+		}
+	case "contacts-only", "exclude-communities", "exclude-newsletters", "exclude-channels":
+		var value, ok bool
+		if len(ce.Args) >= 2 {
+			value, ok = parseOnOff(ce.Args[1])
+		}
+		if !ok {
+			ce.Reply("**Usage:** `$cmdprefix filter %s <on/off>`", subcommand)
+			return
+		}
+		switch subcommand {
+		case "contacts-only":
+			filter.ContactsOnly = value
+		case "exclude-communities":
+			filter.ExcludeCommunities = value
+		default:
+			filter.ExcludeNewsletters = value
+		}
+	case "reset":
+		filter = nil
+	default:
+		ce.Reply("Unknown subcommand `%s`", ce.Args[0])
+		return
+	}
+	if filter.IsEmpty() {
+		filter = nil
+	}
+	meta.ChatFilter = filter
+	err = wa.UserLogin.Save(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to save chat filter")
+		ce.Reply("Failed to save chat filter: %v", err)
+		return
+	}
+	ce.Reply("Chat filter updated.\n\n%s", formatChatFilter(filter))
+}
+
+var cmdMediaRequests = &commands.FullHandler{
+	Func: fnMediaRequests,
+	Name: "media-requests",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionAdmin,
+		Description: "Show the number of media backfill requests in each status.",
+	},
+	RequiresAdmin: true,
+}
+
+func fnMediaRequests(ce *commands.Event) {
+	wa := ce.Bridge.Network.(*WhatsAppConnector)
+	counts, err := wa.DB.MediaRequest.CountByStatus(ce.Ctx)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to count media requests")
+		ce.Reply("Failed to count media requests: %v", err)
+		return
+	}
+	statuses := []wadb.MediaBackfillRequestStatus{
+		wadb.MediaBackfillRequestStatusNotRequested,
+		wadb.MediaBackfillRequestStatusRequested,
+		wadb.MediaBackfillRequestStatusRequestFailed,
+		wadb.MediaBackfillRequestStatusRequestSkipped,
+	}
+	lines := make([]string, len(statuses))
+	for i, status := range statuses {
+		lines[i] = fmt.Sprintf("* %s: %d", status, counts[status])
+	}
+	ce.Reply("Media backfill requests:\n\n%s", strings.Join(lines, "\n"))
+}
+
+var cmdRedownload = &commands.FullHandler{
+	Func: fnRedownload,
+	Name: "redownload",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Request expired media from your phone again. Reply to a failed media message, or use `all` to request all failed media in the chat.",
+		Args:        "[--login <_ID_>] [all]",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnRedownload(ce *commands.Event) {
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
+		return
+	}
+	if !wa.IsLoggedIn() {
+		ce.Reply("Not logged in")
+		return
+	}
+	if len(ce.Args) > 0 && strings.ToLower(ce.Args[0]) == "all" {
+		redownloadAllInPortal(ce, wa)
+		return
+	} else if len(ce.ReplyTo) == 0 {
+		ce.Reply("**Usage:** reply to a failed media message with `$cmdprefix redownload`, or use `$cmdprefix redownload all`")
+		return
+	}
+	msg, err := ce.Bridge.DB.Message.GetPartByMXID(ce.Ctx, ce.ReplyTo)
+	if err != nil {
+		ce.Log.Err(err).Stringer("reply_to_mxid", ce.ReplyTo).Msg("Failed to get reply target event to handle !wa redownload command")
+		ce.Reply("Failed to get reply event")
+		return
+	} else if msg == nil {
+		ce.Reply("Reply event not found")
+		return
+	} else if msg.Metadata.(*waid.MessageMetadata).Error != waid.MsgErrMediaNotFound {
+		ce.Reply("That message doesn't have expired media.")
+		return
+	}
+	ce.React("⏳")
+	err = wa.RedownloadMedia(ce.Ctx, msg)
+	if err != nil {
+		ce.Log.Err(err).Str("message_id", string(msg.ID)).Msg("Failed to redownload media")
+		ce.Reply("Failed to redownload media: %v", err)
+	} else {
+		ce.React("✅")
+	}
+}
+
+func redownloadAllInPortal(ce *commands.Event, wa *WhatsAppClient) {
+	reqs, err := wa.Main.DB.MediaRequest.GetAllForPortal(ce.Ctx, wa.UserLogin.ID, ce.Portal.PortalKey)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to get media requests for portal")
+		ce.Reply("Failed to get failed media in this chat: %v", err)
+		return
+	}
+	var messages []*database.Message
+	for _, req := range reqs {
+		msg, err := ce.Bridge.DB.Message.GetPartByID(ce.Ctx, wa.UserLogin.ID, req.MessageID, "")
+		if err != nil {
+			ce.Log.Err(err).Str("message_id", string(req.MessageID)).Msg("Failed to get message for media request")
+		} else if msg != nil && msg.Metadata.(*waid.MessageMetadata).Error == waid.MsgErrMediaNotFound {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		ce.Reply("There's no expired media in this chat")
+		return
+	}
+	ce.Reply("Requesting %d media files from your phone...", len(messages))
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	wg.Add(len(messages))
+	for _, msg := range messages {
+		go func() {
+			defer wg.Done()
+			if wa.waitMediaRequestRateLimit(ce.Ctx) != nil {
+				return
+			}
+			err := wa.RedownloadMedia(ce.Ctx, msg)
+			if err != nil {
+				ce.Log.Err(err).Str("message_id", string(msg.ID)).Msg("Failed to redownload media")
+			} else {
+				succeeded.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	ce.Reply("Redownloaded %d out of %d media files", succeeded.Load(), len(messages))
+}
+
+var cmdCheckNumbers = &commands.FullHandler{
+	Func: fnCheckNumbers,
+	Name: "check-numbers",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Check whether phone numbers are on WhatsApp.",
+		Args:        "[--login <_ID_>] [--refresh] <_numbers..._>",
+	},
+	RequiresLogin: true,
+}
+
+func fnCheckNumbers(ce *commands.Event) {
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
+		return
+	}
+	refresh := len(ce.Args) > 0 && ce.Args[0] == "--refresh"
+	rawArgs := strings.TrimSpace(strings.TrimPrefix(ce.RawArgs, "--refresh"))
+	var numbers []string
+	if strings.ContainsAny(rawArgs, ",;\n") {
+		numbers = SplitContactCheckList(rawArgs)
+	} else {
+		numbers = strings.Fields(rawArgs)
+	}
+	if len(numbers) == 0 {
+		ce.Reply("**Usage:** `$cmdprefix check-numbers [--refresh] <numbers...>`")
+		return
+	}
+	if !wa.IsLoggedIn() {
+		ce.Reply("Not logged in")
+		return
+	}
+	results, err := wa.CheckContacts(ce.Ctx, numbers, refresh)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to check numbers")
+		ce.Reply("Failed to check numbers: %v", err)
+		return
+	}
+	lines := make([]string, len(results))
+	for i, result := range results {
+		switch {
+		case result.Error != "":
+			lines[i] = fmt.Sprintf("* `%s`: %s", result.Query, result.Error)
+		case !result.IsOnWhatsApp:
+			lines[i] = fmt.Sprintf("* `%s`: not on WhatsApp", result.Phone)
+		case result.IsBusiness:
+			lines[i] = fmt.Sprintf("* `%s`: `%s` (business: %s)", result.Phone, result.JID, result.VerifiedName)
+		default:
+			lines[i] = fmt.Sprintf("* `%s`: `%s`", result.Phone, result.JID)
+		}
+	}
+	ce.Reply("%s", strings.Join(lines, "\n"))
+}
+
+// popLoginArg removes a leading `--login <id>` or `--login=<id>` flag from the command arguments.
+func popLoginArg(ce *commands.Event) networkid.UserLoginID {
+	if len(ce.Args) == 0 || !strings.HasPrefix(ce.Args[0], "--login") {
+		return ""
+	}
+	var loginID string
+	var consumed int
+	if value, ok := strings.CutPrefix(ce.Args[0], "--login="); ok {
+		loginID, consumed = value, 1
+	} else if ce.Args[0] == "--login" && len(ce.Args) > 1 {
+		loginID, consumed = ce.Args[1], 2
+	} else {
+		return ""
+	}
+	rawArgs := ce.RawArgs
+	for range consumed {
+		_, rawArgs, _ = strings.Cut(strings.TrimSpace(rawArgs), " ")
+	}
+	ce.Args = ce.Args[consumed:]
+	ce.RawArgs = strings.TrimSpace(rawArgs)
+	return networkid.UserLoginID(loginID)
+}
+
+// getCommandLogin finds the login to use for a command, honoring the `--login` flag
+// and the preferred login of the portal the command was sent in.
+func getCommandLogin(ce *commands.Event) (*WhatsAppClient, error) {
+	wa := ce.Bridge.Network.(*WhatsAppConnector)
+	login, err := wa.SelectLogin(ce.User, ce.Portal, popLoginArg(ce))
+	if err != nil {
+		return nil, err
+	}
+	return login.Client.(*WhatsAppClient), nil
+}
+
+var cmdPreferLogin = &commands.FullHandler{
+	Func: fnPreferLogin,
+	Name: "prefer-login",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Choose which of your WhatsApp accounts sends messages in this chat.",
+		Args:        "[_login ID_ | --reset]",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+func fnPreferLogin(ce *commands.Event) {
+	wa := ce.Bridge.Network.(*WhatsAppConnector)
+	if len(ce.Args) == 0 {
+		current := wa.GetPreferredLogin(ce.Portal, ce.User.MXID)
+		var lines []string
+		for _, login := range ce.User.GetUserLogins() {
+			marker := ""
+			if current != nil && login.ID == current.ID {
+				marker = " (preferred)"
+			}
+			lines = append(lines, "* `"+string(login.ID)+"` - "+login.RemoteName+marker)
+		}
+		if current == nil {
+			lines = append(lines, "", "No preferred login is set for this chat.")
+		}
+		ce.Reply("Your logins:\n\n%s", strings.Join(lines, "\n"))
+		return
+	}
+	var loginID networkid.UserLoginID
+	if ce.Args[0] != "--reset" {
+		loginID = networkid.UserLoginID(ce.Args[0])
+	}
+	err := wa.SetPreferredLogin(ce.Ctx, ce.Portal, ce.User.MXID, loginID)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to set preferred login")
+		ce.Reply("Failed to set preferred login: %v", err)
+	} else if loginID == "" {
+		ce.Reply("Removed preferred login for this chat")
+	} else {
+		ce.Reply("Messages you send in this chat will now be sent using `%s`", loginID)
+	}
+}
+
+var cmdRelayTemplate = &commands.FullHandler{
+	Func: fnRelayTemplate,
+	Name: "relay-template",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "View or change how messages from relayed users are formatted in this chat.",
+		Args:        "[text|caption|edit|reaction [_template_] | reactions-as-text <on|off> | --reset]",
+	},
+	RequiresPortal: true,
+	RequiresLogin:  true,
+}
+
+func fnRelayTemplate(ce *commands.Event) {
+	wa := ce.Bridge.Network.(*WhatsAppConnector)
+	meta := ce.Portal.Metadata.(*waid.PortalMetadata)
+	if len(ce.Args) == 0 {
+		templates := wa.GetRelayTemplates(ce.Portal)
+		reactionsAsText := templates.ReactionsAsText != nil && *templates.ReactionsAsText
+		ce.Reply(
+			"* Text: `%s`\n* Caption: `%s`\n* Edit: `%s`\n* Reaction: `%s`\n* Reactions as text: %t\n\n"+
+				"Empty templates use the default relay format. Overridden in this chat: %t",
+			templates.Text, templates.Caption, templates.Edit, templates.Reaction, reactionsAsText,
+			!meta.RelayTemplates.IsEmpty(),
+		)
+		return
+	}
+	var templates waid.RelayTemplates
+	if meta.RelayTemplates != nil {
+		templates = *meta.RelayTemplates
+	}
+	value := strings.TrimSpace(strings.TrimPrefix(ce.RawArgs, ce.Args[0]))
+	switch strings.ToLower(ce.Args[0]) {
+	case "--reset":
+		templates = waid.RelayTemplates{}
+	case "text":
+		templates.Text = value
+	case "caption":
+		templates.Caption = value
+	case "edit":
+		templates.Edit = value
+	case "reaction":
+		templates.Reaction = value
+	case "reactions-as-text":
+		switch strings.ToLower(value) {
+		case "on", "true", "yes", "1":
+			templates.ReactionsAsText = ptr.Ptr(true)
+		case "off", "false", "no", "0":
+			templates.ReactionsAsText = ptr.Ptr(false)
+		default:
+			ce.Reply("**Usage:** `$cmdprefix relay-template reactions-as-text <on|off>`")
+			return
+		}
+	default:
+		ce.Reply("**Usage:** `$cmdprefix relay-template [text|caption|edit|reaction [template] | reactions-as-text <on|off> | --reset]`")
+		return
+	}
+	err := wa.SetRelayTemplates(ce.Ctx, ce.Portal, &templates)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to set relay templates")
+		ce.Reply("Failed to set relay templates: %v", err)
+	} else if templates.IsEmpty() {
+		ce.Reply("Relay templates in this chat reset to the defaults")
+	} else {
+		ce.Reply("Relay templates updated")
+	}
+}
+
+var cmdAutoReply = &commands.FullHandler{
+	Func: fnAutoReply,
+	Name: "auto-reply",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionGeneral,
+		Description: "View or change automatic away and greeting messages sent in direct chats.",
+		Args: "[--login <_ID_>] [on/off | away/greeting/signature [_text_] | " +
+			"hours add <_days_> <_HH:MM-HH:MM_> | hours clear | reset]",
+	},
+	RequiresLogin: true,
+}
+
+func formatAutoReply(settings *waid.AutoReplySettings, timezone string) string {
+	if settings.IsEmpty() {
+		return "Automatic replies are not configured."
+	}
+	formatText := func(text string) string {
+		if text == "" {
+			return "not set"
+		}
+		return "`" + text + "`"
+	}
+	hours := "always open"
+	if len(settings.BusinessHours) > 0 {
+		ranges := make([]string, len(settings.BusinessHours))
+		for i, bh := range settings.BusinessHours {
+			days := make([]string, len(bh.Days))
+			for j, day := range bh.Days {
+				days[j] = strings.ToLower(day.String()[:3])
+			}
+			ranges[i] = fmt.Sprintf("%s %s-%s", strings.Join(days, ","), bh.Start, bh.End)
+		}
+		hours = strings.Join(ranges, "; ")
+	}
+	if timezone == "" {
+		timezone = "UTC"
+	}
+	return fmt.Sprintf(
+		"* Enabled: %t\n* Away message: %s\n* Greeting message: %s\n* Signature: %s\n* Business hours (%s): %s",
+		settings.Enabled, formatText(settings.AwayMessage), formatText(settings.GreetingMessage),
+		formatText(settings.Signature), timezone, hours,
+	)
+}
+
+func fnAutoReply(ce *commands.Event) {
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
+		return
+	}
+	meta := wa.UserLogin.Metadata.(*waid.UserLoginMetadata)
+	if len(ce.Args) == 0 || strings.ToLower(ce.Args[0]) == "show" {
+		ce.Reply("%s", formatAutoReply(meta.AutoReply, meta.Timezone))
+		return
+	}
+	settings := meta.AutoReply.Clone()
+	subcommand := strings.ToLower(ce.Args[0])
+	text := strings.TrimSpace(strings.TrimPrefix(ce.RawArgs, ce.Args[0]))
+	switch subcommand {
+	case "away":
+		settings.AwayMessage = text
+	case "greeting":
+		settings.GreetingMessage = text
+	case "signature":
+		settings.Signature = text
+	case "hours":
+		if len(ce.Args) == 2 && strings.ToLower(ce.Args[1]) == "clear" {
+			settings.BusinessHours = nil
+		} else if len(ce.Args) == 4 && strings.ToLower(ce.Args[1]) == "add" {
+			bh, err := ParseBusinessHours(ce.Args[2], ce.Args[3])
+			if err != nil {
+				ce.Reply("Invalid business hours: %v", err)
+				return
+			}
+			settings.BusinessHours = append(settings.BusinessHours, *bh)
+		} else {
+			ce.Reply("**Usage:** `$cmdprefix auto-reply hours add <days> <HH:MM-HH:MM>` or `$cmdprefix auto-reply hours clear`\n\n" +
+				"Days can be e.g. `mon-fri`, `sat,sun` or `all`")
+			return
+		}
+	case "reset":
+		settings = nil
+	default:
+		value, ok := parseOnOff(subcommand)
+		if !ok {
+			ce.Reply("Unknown subcommand `%s`", ce.Args[0])
+			return
+		}
+		settings.Enabled = value
+	}
+	err = wa.SetAutoReply(ce.Ctx, settings)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to save auto reply settings")
+		ce.Reply("Failed to save automatic reply settings: %v", err)
+		return
+	}
+	ce.Reply("Automatic replies updated.\n\n%s", formatAutoReply(meta.AutoReply, meta.Timezone))
+}
+
+var cmdStickerPack = &commands.FullHandler{
+	Func: fnStickerPack,
+	Name: "sticker-pack",
+	Help: commands.HelpMeta{
+		Section:     commands.HelpSectionChats,
+		Description: "Send an image pack from a Matrix room to this chat as a WhatsApp sticker pack.",
+		Args:        "[--login <_ID_>] [_room ID_] [_pack state key_]",
+	},
+	RequiresLogin:  true,
+	RequiresPortal: true,
+}
+
+func fnStickerPack(ce *commands.Event) {
+	wa, err := getCommandLogin(ce)
+	if err != nil {
+		ce.Reply("Failed to select login: %v", err)
+		return
+	} else if !wa.IsLoggedIn() {
+		ce.Reply("Not logged in")
+		return
+	} else if wa.Main.GetRoomState == nil {
+		ce.Reply("Fetching image packs is not supported on this bridge")
+		return
+	}
+	roomID := ce.Portal.MXID
+	args := ce.Args
+	if len(args) > 0 && strings.HasPrefix(args[0], "!") {
+		roomID = id.RoomID(args[0])
+		args = args[1:]
+	}
+	var stateKey string
+	if len(args) > 0 {
+		stateKey = args[0]
+	}
+	if roomID != ce.Portal.MXID {
+		member, err := ce.Bridge.Matrix.GetMemberInfo(ce.Ctx, roomID, ce.User.MXID)
+		if err != nil || member == nil || member.Membership != event.MembershipJoin {
+			ce.Reply("You must be in the room to use its image packs")
+			return
+		}
+	}
+	evt, err := wa.Main.GetRoomState(ce.Ctx, roomID, msgconv.ImagePackEventType, stateKey)
+	if err != nil {
+		ce.Log.Err(err).Stringer("room_id", roomID).Str("state_key", stateKey).Msg("Failed to get image pack")
+		ce.Reply("Failed to get image pack: %v", err)
+		return
+	}
+	var pack msgconv.ImagePack
+	err = json.Unmarshal(evt.Content.VeryRaw, &pack)
+	if err != nil {
+		ce.Reply("Failed to parse image pack: %v", err)
+		return
+	}
+	chatJID, err := waid.ParsePortalID(ce.Portal.ID)
+	if err != nil {
+		ce.Reply("Failed to parse chat ID: %v", err)
+		return
+	}
+	ce.React("⏳")
+	msg, err := wa.Main.MsgConv.ImagePackToWhatsApp(ce.Ctx, wa.Client, &pack, wa.UserLogin.RemoteName)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to convert image pack")
+		ce.Reply("Failed to convert image pack: %v", err)
+		return
+	}
+	_, err = wa.Client.SendMessage(ce.Ctx, chatJID, msg)
+	if err != nil {
+		ce.Log.Err(err).Msg("Failed to send sticker pack")
+		ce.Reply("Failed to send sticker pack: %v", err)
+		return
+	}
+	ce.Reply("Sent sticker pack **%s** with %d stickers", msg.GetStickerPackMessage().GetName(), len(msg.GetStickerPackMessage().GetStickers()))
+}
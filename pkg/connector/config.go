@@ -2,6 +2,9 @@ package connector
 
 import (
 	_ "embed"
+	"fmt"
+	"path"
+	"slices"
 	"strings"
 	"text/template"
 	"time"
@@ -12,6 +15,7 @@ import (
 	"gopkg.in/yaml.v3"
 
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/msgconv"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
 
 type MediaRequestMethod string
@@ -21,6 +25,28 @@ const (
 	MediaRequestMethodLocalTime MediaRequestMethod = "local_time"
 )
 
+// ConversationFilter decides which history sync conversations are eligible for portal creation.
+type ConversationFilter struct {
+	Include     []string `yaml:"include"`
+	Exclude     []string `yaml:"exclude"`
+	Groups      bool     `yaml:"groups"`
+	DirectChats bool     `yaml:"direct_chats"`
+	Archived    bool     `yaml:"archived"`
+}
+
+// WebhookConfig configures the outbound webhooks for bridge events.
+type WebhookConfig struct {
+	Enabled         bool                    `yaml:"enabled"`
+	URL             string                  `yaml:"url"`
+	Secret          string                  `yaml:"secret"`
+	Events          []waid.WebhookEventType `yaml:"events"`
+	Timeout         time.Duration           `yaml:"timeout"`
+	MaxAttempts     int                     `yaml:"max_attempts"`
+	RetryBackoff    time.Duration           `yaml:"retry_backoff"`
+	MaxRetryBackoff time.Duration           `yaml:"max_retry_backoff"`
+	Concurrency     int                     `yaml:"concurrency"`
+}
+
 //go:embed example-config.yaml
 var ExampleConfig string
 
@@ -57,20 +83,51 @@ type Config struct {
 		MaxInitialConversations int           `yaml:"max_initial_conversations"`
 		RequestFullSync         bool          `yaml:"request_full_sync"`
 		DispatchWait            time.Duration `yaml:"dispatch_wait"`
+		ProgressNotices         bool          `yaml:"progress_notices"`
+		SelectiveSync           bool          `yaml:"selective_sync"`
 		FullSyncConfig          struct {
 			DaysLimit    uint32 `yaml:"days_limit"`
 			SizeLimit    uint32 `yaml:"size_mb_limit"`
 			StorageQuota uint32 `yaml:"storage_quota_mb"`
 		} `yaml:"full_sync_config"`
 
+		ConversationFilter ConversationFilter `yaml:"conversation_filter"`
+
 		MediaRequests struct {
 			AutoRequestMedia bool               `yaml:"auto_request_media"`
 			RequestMethod    MediaRequestMethod `yaml:"request_method"`
 			RequestLocalTime int                `yaml:"request_local_time"`
 			MaxAsyncHandle   int64              `yaml:"max_async_handle"`
+			RequestInterval  time.Duration      `yaml:"request_interval"`
+			MaxRetries       int                `yaml:"max_retries"`
+			RetryBackoff     time.Duration      `yaml:"retry_backoff"`
 		} `yaml:"media_requests"`
 	} `yaml:"history_sync"`
 
+	Webhooks WebhookConfig `yaml:"webhooks"`
+
+	RelayTemplates waid.RelayTemplates `yaml:"relay_templates"`
+
+	ContactCheck struct {
+		ChunkSize  int           `yaml:"chunk_size"`
+		ChunkDelay time.Duration `yaml:"chunk_delay"`
+		MaxNumbers int           `yaml:"max_numbers"`
+		CacheTTL   time.Duration `yaml:"cache_ttl"`
+	} `yaml:"contact_check"`
+
+	AutoReply struct {
+		Cooldown      time.Duration `yaml:"cooldown"`
+		MaxMessageAge time.Duration `yaml:"max_message_age"`
+	} `yaml:"auto_reply"`
+
+	ViewOnce struct {
+		Expire      bool          `yaml:"expire"`
+		Timeout     time.Duration `yaml:"timeout"`
+		DeleteMedia bool          `yaml:"delete_media"`
+	} `yaml:"view_once"`
+
+	MediaCache msgconv.MediaCacheConfig `yaml:"media_cache"`
+
 	displaynameTemplate *template.Template `yaml:"-"`
 }
 
@@ -87,7 +144,26 @@ func (c *Config) UnmarshalYAML(node *yaml.Node) error {
 func (c *Config) PostProcess() error {
 	var err error
 	c.displaynameTemplate, err = template.New("displayname").Parse(c.DisplaynameTemplate)
-	return err
+	if err != nil {
+		return err
+	}
+	for _, pattern := range slices.Concat(c.HistorySync.ConversationFilter.Include, c.HistorySync.ConversationFilter.Exclude) {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid conversation filter pattern %q: %w", pattern, err)
+		}
+	}
+	for _, evtType := range c.Webhooks.Events {
+		if !evtType.IsValid() {
+			return fmt.Errorf("invalid webhook event type %q", evtType)
+		}
+	}
+	if err = ValidateRelayTemplates(&c.RelayTemplates); err != nil {
+		return err
+	}
+	if c.ContactCheck.ChunkSize <= 0 {
+		return fmt.Errorf("contact_check.chunk_size must be positive")
+	}
+	return nil
 }
 
 func upgradeConfig(helper up.Helper) {
@@ -118,6 +194,7 @@ func upgradeConfig(helper up.Helper) {
 	helper.Copy(up.Bool, "initial_auto_reconnect")
 
 	helper.Copy(up.Str, "animated_sticker", "target")
+	helper.Copy(up.Str, "animated_sticker", "renderer")
 	helper.Copy(up.Int, "animated_sticker", "args", "width")
 	helper.Copy(up.Int, "animated_sticker", "args", "height")
 	helper.Copy(up.Int, "animated_sticker", "args", "fps")
@@ -125,13 +202,53 @@ func upgradeConfig(helper up.Helper) {
 	helper.Copy(up.Int, "history_sync", "max_initial_conversations")
 	helper.Copy(up.Bool, "history_sync", "request_full_sync")
 	helper.Copy(up.Str|up.Int, "history_sync", "dispatch_wait")
+	helper.Copy(up.Bool, "history_sync", "progress_notices")
+	helper.Copy(up.Bool, "history_sync", "selective_sync")
 	helper.Copy(up.Int|up.Null, "history_sync", "full_sync_config", "days_limit")
 	helper.Copy(up.Int|up.Null, "history_sync", "full_sync_config", "size_mb_limit")
 	helper.Copy(up.Int|up.Null, "history_sync", "full_sync_config", "storage_quota_mb")
+	helper.Copy(up.List, "history_sync", "conversation_filter", "include")
+	helper.Copy(up.List, "history_sync", "conversation_filter", "exclude")
+	helper.Copy(up.Bool, "history_sync", "conversation_filter", "groups")
+	helper.Copy(up.Bool, "history_sync", "conversation_filter", "direct_chats")
+	helper.Copy(up.Bool, "history_sync", "conversation_filter", "archived")
 	helper.Copy(up.Bool, "history_sync", "media_requests", "auto_request_media")
 	helper.Copy(up.Str, "history_sync", "media_requests", "request_method")
 	helper.Copy(up.Int, "history_sync", "media_requests", "request_local_time")
 	helper.Copy(up.Int, "history_sync", "media_requests", "max_async_handle")
+	helper.Copy(up.Str|up.Int, "history_sync", "media_requests", "request_interval")
+	helper.Copy(up.Int, "history_sync", "media_requests", "max_retries")
+	helper.Copy(up.Str|up.Int, "history_sync", "media_requests", "retry_backoff")
+
+	helper.Copy(up.Bool, "webhooks", "enabled")
+	helper.Copy(up.Str, "webhooks", "url")
+	helper.Copy(up.Str, "webhooks", "secret")
+	helper.Copy(up.List, "webhooks", "events")
+	helper.Copy(up.Str|up.Int, "webhooks", "timeout")
+	helper.Copy(up.Int, "webhooks", "max_attempts")
+	helper.Copy(up.Str|up.Int, "webhooks", "retry_backoff")
+	helper.Copy(up.Str|up.Int, "webhooks", "max_retry_backoff")
+	helper.Copy(up.Int, "webhooks", "concurrency")
+
+	helper.Copy(up.Str, "relay_templates", "text")
+	helper.Copy(up.Str, "relay_templates", "caption")
+	helper.Copy(up.Str, "relay_templates", "edit")
+	helper.Copy(up.Str, "relay_templates", "reaction")
+	helper.Copy(up.Bool, "relay_templates", "reactions_as_text")
+
+	helper.Copy(up.Int, "contact_check", "chunk_size")
+	helper.Copy(up.Str|up.Int, "contact_check", "chunk_delay")
+	helper.Copy(up.Int, "contact_check", "max_numbers")
+	helper.Copy(up.Str|up.Int, "contact_check", "cache_ttl")
+
+	helper.Copy(up.Str|up.Int, "auto_reply", "cooldown")
+	helper.Copy(up.Str|up.Int, "auto_reply", "max_message_age")
+	helper.Copy(up.Bool, "view_once", "expire")
+	helper.Copy(up.Str|up.Int, "view_once", "timeout")
+	helper.Copy(up.Bool, "view_once", "delete_media")
+	helper.Copy(up.Bool, "media_cache", "enabled")
+	helper.Copy(up.Str|up.Int, "media_cache", "matrix_ttl")
+	helper.Copy(up.Str|up.Int, "media_cache", "whatsapp_ttl")
 }
 
 type DisplaynameParams struct {
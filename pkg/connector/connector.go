@@ -25,6 +25,7 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/iKonoTelecomunicaciones/go/bridgev2"
@@ -64,6 +65,28 @@ type WhatsAppConnector struct {
 	mediaEditCache         MediaEditCache
 	mediaEditCacheLock     sync.RWMutex
 	stopMediaEditCacheLoop atomic.Pointer[context.CancelFunc]
+
+	relayTemplates    map[relayTemplateKey]*template.Template
+	relayTemplateLock sync.Mutex
+
+	webhookWakeup chan struct{}
+	webhookQueue  chan *wadb.WebhookDelivery
+	webhookClient *http.Client
+	// webhookPublicClient is used for per-login webhook URLs, which must not point at private addresses.
+	webhookPublicClient *http.Client
+	stopWebhookLoop     atomic.Pointer[context.CancelFunc]
+
+	viewOnceQueue    *redactionQueue[*wadb.ViewOnceMessage]
+	stopViewOnceLoop atomic.Pointer[context.CancelFunc]
+
+	disappearingQueue    *redactionQueue[*wadb.DisappearingMessage]
+	stopDisappearingLoop atomic.Pointer[context.CancelFunc]
+
+	// DeleteMedia deletes a file from the media repo. It's set by the main package,
+	// as deleting media requires homeserver-specific admin APIs.
+	DeleteMedia func(ctx context.Context, uri id.ContentURI) error
+	// GetRoomState fetches a state event from a Matrix room. It's set by the main package.
+	GetRoomState func(ctx context.Context, roomID id.RoomID, evtType event.Type, stateKey string) (*event.Event, error)
 }
 
 func init() {
@@ -101,6 +124,8 @@ func (wa *WhatsAppConnector) Init(bridge *bridgev2.Bridge) {
 	wa.MsgConv.DisableViewOnce = wa.Config.DisableViewOnce
 	wa.MsgConv.OldMediaSuffix = "Requesting old media is not enabled on this bridge."
 	wa.MsgConv.FetchURLPreviews = wa.Config.URLPreviews
+	wa.MsgConv.MediaCache = wa.Config.MediaCache
+	wa.MsgConv.MaxWhatsAppFileSize = WAMaxFileSize
 	if wa.Config.HistorySync.MediaRequests.AutoRequestMedia {
 		if wa.Config.HistorySync.MediaRequests.RequestMethod == MediaRequestMethodImmediate {
 			wa.MsgConv.OldMediaSuffix = "Media will be requested from your phone automatically soon."
@@ -111,9 +136,17 @@ func (wa *WhatsAppConnector) Init(bridge *bridgev2.Bridge) {
 	wa.DB = wadb.New(bridge.ID, bridge.DB.Database, bridge.Log.With().Str("db_section", "whatsapp").Logger())
 	wa.MsgConv.DB = wa.DB
 	wa.Bridge.Commands.(*commands.Processor).AddHandlers(
-		cmdAccept, cmdSync, cmdInviteLink, cmdResolveLink, cmdJoin,
+		cmdAccept, cmdSync, cmdInviteLink, cmdResolveLink, cmdJoin, cmdHistorySync, cmdFilter,
+		cmdMediaRequests, cmdRedownload, cmdCheckNumbers, cmdPreferLogin, cmdRelayTemplate,
+		cmdAutoReply, cmdStickerPack,
 	)
 	wa.mediaEditCache = make(MediaEditCache)
+	wa.webhookWakeup = make(chan struct{}, 1)
+	wa.webhookQueue = make(chan *wadb.WebhookDelivery, webhookQueueSize)
+	wa.viewOnceQueue = wa.newViewOnceQueue()
+	wa.disappearingQueue = wa.newDisappearingQueue()
+	wa.webhookClient = &http.Client{Timeout: wa.Config.Webhooks.Timeout}
+	wa.webhookPublicClient = NewPublicHTTPClient(wa.Config.Webhooks.Timeout)
 
 	whatsmeowDBLog := bridge.Log.With().Str("db_section", "whatsmeow").Logger()
 	wa.DeviceStore = sqlstore.NewWithWrappedDB(
@@ -161,6 +194,29 @@ func (wa *WhatsAppConnector) Start(ctx context.Context) error {
 		wa.deleteLIDDMsMigration(ctx)
 	}
 
+	if !wa.Bridge.Background && wa.Config.Webhooks.Enabled {
+		webhookCtx, cancel := context.WithCancel(wa.Bridge.BackgroundCtx)
+		wa.stopWebhookLoop.Store(&cancel)
+		go wa.webhookQueueLoop(webhookCtx)
+		go wa.webhookLoop(webhookCtx)
+	}
+	if !wa.Bridge.Background && wa.Config.MediaCache.Enabled {
+		err = wa.DB.MediaCache.DeleteExpired(ctx)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to delete expired media cache entries")
+		}
+	}
+	if !wa.Bridge.Background && wa.viewOnceExpiryEnabled() {
+		viewOnceCtx, cancel := context.WithCancel(wa.Bridge.BackgroundCtx)
+		wa.stopViewOnceLoop.Store(&cancel)
+		go wa.viewOnceQueue.loop(viewOnceCtx)
+	}
+	if !wa.Bridge.Background {
+		disappearingCtx, cancel := context.WithCancel(wa.Bridge.BackgroundCtx)
+		wa.stopDisappearingLoop.Store(&cancel)
+		go wa.disappearingQueue.loop(disappearingCtx)
+	}
+
 	return nil
 }
 
@@ -235,6 +291,15 @@ func (wa *WhatsAppConnector) Stop() {
 	if stop := wa.stopMediaEditCacheLoop.Swap(nil); stop != nil {
 		(*stop)()
 	}
+	if stop := wa.stopWebhookLoop.Swap(nil); stop != nil {
+		(*stop)()
+	}
+	if stop := wa.stopViewOnceLoop.Swap(nil); stop != nil {
+		(*stop)()
+	}
+	if stop := wa.stopDisappearingLoop.Swap(nil); stop != nil {
+		(*stop)()
+	}
 }
 
 const kvWAVersion = "whatsapp_web_version"
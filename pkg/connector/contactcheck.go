@@ -0,0 +1,228 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+)
+
+var ErrTooManyNumbers = bridgev2.RespError{
+	ErrCode:    "FI.MAU.WHATSAPP.TOO_MANY_NUMBERS",
+	Err:        "Too many numbers in a single request",
+	StatusCode: http.StatusRequestEntityTooLarge,
+}
+
+// ContactCheckResult is the result of checking whether a single phone number is on WhatsApp.
+type ContactCheckResult struct {
+	Query        string     `json:"query"`
+	Phone        string     `json:"phone,omitempty"`
+	IsOnWhatsApp bool       `json:"is_on_whatsapp"`
+	JID          *types.JID `json:"jid,omitempty"`
+	LID          *types.JID `json:"lid,omitempty"`
+	IsBusiness   bool       `json:"is_business"`
+	VerifiedName string     `json:"verified_name,omitempty"`
+	CheckedAt    time.Time  `json:"checked_at,omitzero"`
+	Cached       bool       `json:"cached"`
+	Error        string     `json:"error,omitempty"`
+}
+
+// SplitContactCheckList splits a list of phone numbers separated by commas, semicolons or newlines.
+func SplitContactCheckList(list string) []string {
+	parts := strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ';' || r == '\n' || r == '\r'
+	})
+	numbers := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			numbers = append(numbers, part)
+		}
+	}
+	return numbers
+}
+
+// normalizeCheckNumber strips formatting characters from a phone number and returns the digits.
+func normalizeCheckNumber(number string) (string, bool) {
+	number = strings.TrimSpace(number)
+	number = strings.TrimSuffix(number, "@"+types.DefaultUserServer)
+	var digits strings.Builder
+	for i, char := range number {
+		switch {
+		case char >= '0' && char <= '9':
+			digits.WriteRune(char)
+		case char == '+' && i == 0, char == ' ', char == '-', char == '.', char == '(', char == ')':
+		default:
+			return "", false
+		}
+	}
+	if digits.Len() < 7 || digits.Len() > 15 {
+		return "", false
+	}
+	return digits.String(), true
+}
+
+func (ccr *ContactCheckResult) fill(cc *wadb.ContactCheck) {
+	ccr.IsOnWhatsApp = cc.IsIn
+	if !cc.JID.IsEmpty() {
+		ccr.JID = &cc.JID
+	}
+	if !cc.LID.IsEmpty() {
+		ccr.LID = &cc.LID
+	}
+	ccr.IsBusiness = cc.IsBusiness
+	ccr.VerifiedName = cc.VerifiedName
+	ccr.CheckedAt = cc.CheckedAt
+}
+
+// CheckContacts checks whether the given phone numbers are on WhatsApp.
+//
+// Numbers are sent to WhatsApp in chunks with a delay in between, and results are cached in the database.
+// Cached results are ignored if refresh is true. The results are in the same order as the input.
+// If checking a chunk fails, the error is set on the results of that chunk and the remaining chunks are
+// still checked, so numbers that were already resolved aren't lost.
+func (wa *WhatsAppClient) CheckContacts(ctx context.Context, numbers []string, refresh bool) ([]*ContactCheckResult, error) {
+	cfg := &wa.Main.Config.ContactCheck
+	if cfg.MaxNumbers > 0 && len(numbers) > cfg.MaxNumbers {
+		return nil, ErrTooManyNumbers.WithMessage("Can't check more than %d numbers at once", cfg.MaxNumbers)
+	} else if wa.Client == nil || !wa.Client.IsLoggedIn() {
+		return nil, bridgev2.ErrNotLoggedIn
+	}
+	log := zerolog.Ctx(ctx)
+	results := make([]*ContactCheckResult, len(numbers))
+	pending := make(map[string][]*ContactCheckResult)
+	var toQuery []string
+	for i, number := range numbers {
+		result := &ContactCheckResult{Query: number}
+		results[i] = result
+		phone, ok := normalizeCheckNumber(number)
+		if !ok {
+			result.Error = "invalid phone number"
+			continue
+		}
+		result.Phone = "+" + phone
+		if existing, ok := pending[phone]; ok {
+			pending[phone] = append(existing, result)
+			continue
+		}
+		if !refresh && cfg.CacheTTL > 0 {
+			cached, err := wa.Main.DB.ContactCheck.Get(ctx, phone)
+			if err != nil {
+				log.Err(err).Str("phone", phone).Msg("Failed to get cached contact check")
+			} else if cached != nil && time.Since(cached.CheckedAt) < cfg.CacheTTL {
+				result.fill(cached)
+				result.Cached = true
+				continue
+			}
+		}
+		pending[phone] = []*ContactCheckResult{result}
+		toQuery = append(toQuery, phone)
+	}
+	if len(toQuery) == 0 {
+		return results, nil
+	}
+	if cfg.CacheTTL > 0 {
+		err := wa.Main.DB.ContactCheck.DeleteOlderThan(ctx, time.Now().Add(-cfg.CacheTTL))
+		if err != nil {
+			log.Err(err).Msg("Failed to delete expired contact checks")
+		}
+	}
+
+	wa.contactCheckLock.Lock()
+	defer wa.contactCheckLock.Unlock()
+	for start := 0; start < len(toQuery); start += cfg.ChunkSize {
+		if start > 0 && cfg.ChunkDelay > 0 {
+			select {
+			case <-time.After(cfg.ChunkDelay):
+			case <-ctx.Done():
+			}
+		}
+		chunk := toQuery[start:min(start+cfg.ChunkSize, len(toQuery))]
+		if ctx.Err() != nil {
+			// Return what was resolved so far instead of throwing away the previous chunks
+			setContactCheckChunkError(pending, toQuery[start:], fmt.Sprintf("check was cancelled: %v", ctx.Err()))
+			break
+		}
+		queries := make([]string, len(chunk))
+		for i, phone := range chunk {
+			queries[i] = "+" + phone
+		}
+		resp, err := wa.Client.IsOnWhatsApp(ctx, queries)
+		if err != nil {
+			log.Err(err).
+				Int("chunk_start", start).
+				Int("chunk_size", len(chunk)).
+				Msg("Failed to check chunk of numbers on WhatsApp")
+			setContactCheckChunkError(pending, chunk, fmt.Sprintf("failed to check if number is on WhatsApp: %v", err))
+			continue
+		}
+		log.Debug().
+			Int("chunk_start", start).
+			Int("chunk_size", len(chunk)).
+			Int("response_count", len(resp)).
+			Msg("Checked chunk of numbers on WhatsApp")
+		now := time.Now()
+		for _, item := range resp {
+			phone := strings.TrimPrefix(item.Query, "+")
+			cc := &wadb.ContactCheck{
+				Phone:      phone,
+				IsIn:       item.IsIn,
+				JID:        item.JID,
+				IsBusiness: item.VerifiedName != nil,
+				CheckedAt:  now,
+			}
+			if item.VerifiedName != nil {
+				cc.VerifiedName = item.VerifiedName.Details.GetVerifiedName()
+			}
+			if item.IsIn {
+				cc.LID, err = wa.GetStore().LIDs.GetLIDForPN(ctx, item.JID)
+				if err != nil {
+					log.Warn().Err(err).Stringer("jid", item.JID).Msg("Failed to get LID for checked number")
+				}
+			}
+			if cfg.CacheTTL > 0 {
+				if err = wa.Main.DB.ContactCheck.Put(ctx, cc); err != nil {
+					log.Err(err).Str("phone", phone).Msg("Failed to cache contact check")
+				}
+			}
+			for _, result := range pending[phone] {
+				result.fill(cc)
+			}
+			delete(pending, phone)
+		}
+		setContactCheckChunkError(pending, chunk, "no response from server")
+	}
+	return results, nil
+}
+
+// setContactCheckChunkError sets the error of all pending results for the given numbers.
+func setContactCheckChunkError(pending map[string][]*ContactCheckResult, chunk []string, errMsg string) {
+	for _, phone := range chunk {
+		for _, result := range pending[phone] {
+			result.Error = errMsg
+		}
+		delete(pending, phone)
+	}
+}
@@ -0,0 +1,139 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/msgconv"
+)
+
+// trackDisappearing stores the Matrix events of a bridged disappearing message, so they can be redacted
+// by disappearingQueue. The bridge schedules these itself instead of using the bridgev2 disappearing loop,
+// as timers in that loop can't be cancelled when the message is kept in the chat. Messages sent from Matrix
+// are tracked in handleConvertedMatrixMessage.
+func (wa *WhatsAppClient) trackDisappearing(
+	ctx context.Context,
+	portal *bridgev2.Portal,
+	messageID networkid.MessageID,
+	disappearAt time.Time,
+) {
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "track disappearing message").
+		Str("message_id", string(messageID)).
+		Logger()
+	dbParts, err := wa.Main.Bridge.DB.Message.GetAllPartsByID(ctx, portal.Receiver, messageID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get message parts")
+		return
+	}
+	wa.putDisappearingParts(ctx, portal, dbParts, disappearAt)
+}
+
+func (wa *WhatsAppClient) putDisappearingParts(ctx context.Context, portal *bridgev2.Portal, parts []*database.Message, disappearAt time.Time) {
+	for _, part := range parts {
+		if part.MXID == "" {
+			continue
+		}
+		err := wa.Main.DB.Disappearing.Put(ctx, &wadb.DisappearingMessage{
+			UserLoginID: wa.UserLogin.ID,
+			MessageID:   part.ID,
+			RoomID:      portal.MXID,
+			EventID:     part.MXID,
+			DisappearAt: disappearAt,
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Stringer("event_id", part.MXID).Msg("Failed to save disappearing message")
+		}
+	}
+	wa.Main.disappearingQueue.wake()
+}
+
+// handleWAKeepInChat cancels the scheduled Matrix-side redaction of a message that was kept in a chat
+// with disappearing messages, or reschedules it based on the portal timer if the message was unkept.
+func (wa *WhatsAppClient) handleWAKeepInChat(ctx context.Context, evt *events.Message) {
+	keep := evt.Message.GetKeepInChatMessage()
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "handle keep in chat").
+		Str("message_id", evt.Info.ID).
+		Stringer("keep_type", keep.GetKeepType()).
+		Logger()
+	portal, err := wa.Main.Bridge.GetExistingPortalByKey(ctx, wa.getPortalKeyByMessageSource(evt.Info.MessageSource))
+	if err != nil {
+		log.Err(err).Msg("Failed to get portal")
+		return
+	} else if portal == nil || portal.MXID == "" {
+		return
+	}
+	targetID := msgconv.KeyToMessageID(ctx, wa.Client, evt.Info.Chat, evt.Info.Sender, keep.GetKey())
+	log = log.With().Str("target_message_id", string(targetID)).Logger()
+	parts, err := wa.Main.Bridge.DB.Message.GetAllPartsByID(ctx, portal.Receiver, targetID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get target message")
+		return
+	}
+	switch keep.GetKeepType() {
+	case waE2E.KeepType_KEEP_FOR_ALL:
+		err = wa.Main.DB.Disappearing.DeleteByMessageID(ctx, wa.UserLogin.ID, targetID)
+		if err != nil {
+			log.Err(err).Msg("Failed to cancel disappearing of kept message")
+		}
+		// Messages bridged before the bridge tracked disappearing messages itself may still be in the bridgev2 loop
+		for _, part := range parts {
+			if part.MXID == "" {
+				continue
+			}
+			err = wa.Main.Bridge.DB.DisappearingMessage.Delete(ctx, part.MXID)
+			if err != nil {
+				log.Err(err).Stringer("event_id", part.MXID).Msg("Failed to cancel disappearing of kept message")
+			}
+		}
+	case waE2E.KeepType_UNDO_KEEP_FOR_ALL:
+		if portal.Disappear.Timer > 0 && len(parts) > 0 {
+			wa.putDisappearingParts(ctx, portal, parts, parts[0].Timestamp.Add(portal.Disappear.Timer))
+		}
+	}
+	log.Debug().
+		Int("part_count", len(parts)).
+		Msg("Updated disappearing schedule of kept message")
+}
+
+func (wa *WhatsAppConnector) newDisappearingQueue() *redactionQueue[*wadb.DisappearingMessage] {
+	return &redactionQueue[*wadb.DisappearingMessage]{
+		name:   "disappearing messages",
+		reason: "Message disappeared",
+		bridge: wa.Bridge,
+		wakeup: make(chan struct{}, 1),
+
+		getDue: wa.DB.Disappearing.GetDue,
+		target: func(dm *wadb.DisappearingMessage) (id.RoomID, id.EventID, int) {
+			return dm.RoomID, dm.EventID, dm.Attempts
+		},
+		reschedule: wa.DB.Disappearing.Reschedule,
+		delete:     wa.DB.Disappearing.Delete,
+	}
+}
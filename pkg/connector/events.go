@@ -19,6 +19,7 @@ package connector
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
 	"time"
@@ -57,7 +58,8 @@ type MessageInfoWrapper struct {
 }
 
 func (evt *MessageInfoWrapper) ShouldCreatePortal() bool {
-	return true
+	ctx := evt.wa.UserLogin.Log.WithContext(evt.wa.Main.Bridge.BackgroundCtx)
+	return evt.wa.shouldCreatePortal(ctx, evt.Info.Chat, nil)
 }
 
 func (evt *MessageInfoWrapper) GetPortalKey() networkid.PortalKey {
@@ -167,6 +169,19 @@ func (evt *WAMessageEvent) PostHandle(ctx context.Context, portal *bridgev2.Port
 	}
 }
 
+// addPostHandle adds a function to be called after the message is handled, in addition to any previously added ones.
+func (evt *WAMessageEvent) addPostHandle(fn func()) {
+	prev := evt.postHandle
+	if prev == nil {
+		evt.postHandle = fn
+		return
+	}
+	evt.postHandle = func() {
+		prev()
+		fn()
+	}
+}
+
 func (evt *WAMessageEvent) ConvertEdit(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message) (*bridgev2.ConvertedEdit, error) {
 	if len(existing) > 1 {
 		zerolog.Ctx(ctx).Warn().Msg("Got edit to message with multiple parts")
@@ -279,6 +294,28 @@ func (evt *WAMessageEvent) ConvertMessage(ctx context.Context, portal *bridgev2.
 		}
 	} else if len(converted.Parts) > 0 {
 		evt.wa.Main.AddMediaEditCache(portal, evt.GetID(), converted.Parts[0])
+		if evt.isViewOnce() && evt.wa.Main.viewOnceExpiryEnabled() {
+			parts := make(map[networkid.PartID]*event.MessageEventContent, len(converted.Parts))
+			for _, part := range converted.Parts {
+				parts[part.ID] = part.Content
+			}
+			evt.postHandle = func() {
+				evt.wa.trackViewOnce(ctx, portal, evt.GetID(), parts)
+			}
+		}
+	}
+	if converted.Disappear.Type != "" {
+		// Disappearing is scheduled by the bridge rather than bridgev2, so it can be cancelled if the message is kept
+		disappearAt := converted.Disappear.DisappearAt
+		converted.Disappear = database.DisappearingSetting{}
+		evt.addPostHandle(func() {
+			evt.wa.trackDisappearing(ctx, portal, evt.GetID(), disappearAt)
+		})
+	}
+	if stickerPack := evt.Message.GetStickerPackMessage(); stickerPack != nil && len(converted.Parts) > 0 {
+		evt.addPostHandle(func() {
+			evt.wa.saveStickerPack(ctx, portal, intent, stickerPack)
+		})
 	}
 	return converted, nil
 }
@@ -378,6 +415,9 @@ func (evt *WAUndecryptableMessage) GetStreamOrder() int64 {
 type WAMediaRetry struct {
 	*events.MediaRetry
 	wa *WhatsAppClient
+
+	resultTarget networkid.MessageID
+	result       error
 }
 
 func (evt *WAMediaRetry) GetType() bridgev2.RemoteEventType {
@@ -459,6 +499,7 @@ func (evt *WAMediaRetry) ConvertEdit(ctx context.Context, portal *bridgev2.Porta
 	retryData, err := whatsmeow.DecryptMediaRetryNotification(evt.MediaRetry, mediaMeta.FailedKeys.Key)
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to decrypt media retry notification")
+		evt.setResult(ctx, existing[0].ID, err, true)
 		return evt.makeErrorEdit(existing[0], &mediaMeta, err), nil
 	} else if retryData.GetResult() != waMmsRetry.MediaRetryNotification_SUCCESS {
 		errorName := waMmsRetry.MediaRetryNotification_ResultType_name[int32(retryData.GetResult())]
@@ -466,9 +507,12 @@ func (evt *WAMediaRetry) ConvertEdit(ctx context.Context, portal *bridgev2.Porta
 			log.Warn().Str("error_name", errorName).Msg("Got error response in media retry notification")
 			log.Debug().Any("error_content", retryData).Msg("Full error response content")
 			if retryData.GetResult() == waMmsRetry.MediaRetryNotification_NOT_FOUND {
+				evt.setResult(ctx, existing[0].ID, whatsmeow.ErrMediaNotAvailableOnPhone, false)
 				return evt.makeErrorEdit(existing[0], &mediaMeta, whatsmeow.ErrMediaNotAvailableOnPhone), nil
 			}
-			return evt.makeErrorEdit(existing[0], &mediaMeta, fmt.Errorf("phone sent error response: %s", errorName)), nil
+			err = fmt.Errorf("phone sent error response: %s", errorName)
+			evt.setResult(ctx, existing[0].ID, err, true)
+			return evt.makeErrorEdit(existing[0], &mediaMeta, err), nil
 		} else {
 			log.Debug().Msg("Got error response in media retry notification, but response also contains a new download URL - trying to download")
 		}
@@ -480,7 +524,28 @@ func (evt *WAMediaRetry) ConvertEdit(ctx context.Context, portal *bridgev2.Porta
 	defer evt.wa.mediaRetryLock.Release(1)
 
 	mediaMeta.FailedKeys.DirectPath = retryData.GetDirectPath()
-	return evt.wa.Main.MsgConv.MediaRetryToMatrix(ctx, &mediaMeta, evt.wa.Client, intent, portal, existing[0]), nil
+	converted, err := evt.wa.Main.MsgConv.MediaRetryToMatrix(ctx, &mediaMeta, evt.wa.Client, intent, portal, existing[0])
+	evt.setResult(ctx, existing[0].ID, err, true)
+	return converted, nil
+}
+
+func (evt *WAMediaRetry) setResult(ctx context.Context, msgID networkid.MessageID, err error, retryable bool) {
+	evt.wa.handleMediaRequestResult(ctx, msgID, err, retryable)
+	evt.resultTarget = msgID
+	evt.result = err
+}
+
+// notifyWaiter tells a pending RedownloadMedia call about the result of the retry. It's called after the event
+// has been handled, so the waiter is only told that the media is ready once the edit has been sent to Matrix.
+func (evt *WAMediaRetry) notifyWaiter(res bridgev2.EventHandlingResult) {
+	if evt.resultTarget == "" {
+		return
+	}
+	err := evt.result
+	if err == nil && !res.Success {
+		err = errors.New("failed to send reuploaded media to Matrix")
+	}
+	evt.wa.notifyMediaRetryWaiter(evt.resultTarget, err)
 }
 
 var (
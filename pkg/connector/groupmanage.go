@@ -0,0 +1,194 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	mautrix "github.com/iKonoTelecomunicaciones/go"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+)
+
+var (
+	ErrNotAGroup = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.NOT_A_GROUP",
+		Err:        "That chat is not a WhatsApp group",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrGroupNotFound = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GROUP_NOT_FOUND",
+		Err:        "That group does not exist",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrNotInGroup = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.NOT_IN_GROUP",
+		Err:        "You're not participating in that group",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrGroupNotAuthorized = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.GROUP_NOT_AUTHORIZED",
+		Err:        "You don't have the permission to do that in the group",
+		StatusCode: http.StatusForbidden,
+	}
+	ErrInviteLinkInvalid = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.INVITE_LINK_INVALID",
+		Err:        "That group invite link is not valid",
+		StatusCode: http.StatusBadRequest,
+	}
+	ErrInviteLinkRevoked = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.INVITE_LINK_REVOKED",
+		Err:        "That group invite link has been revoked",
+		StatusCode: http.StatusGone,
+	}
+)
+
+// GroupParticipantResult is the result of changing a single participant of a group.
+// The error is the status code returned by WhatsApp, or zero if the change was successful.
+type GroupParticipantResult struct {
+	JID   types.JID `json:"jid"`
+	Error int       `json:"error,omitempty"`
+}
+
+func wrapGroupError(err error) error {
+	switch {
+	case errors.Is(err, whatsmeow.ErrGroupNotFound):
+		return ErrGroupNotFound
+	case errors.Is(err, whatsmeow.ErrNotInGroup):
+		return ErrNotInGroup
+	case errors.Is(err, whatsmeow.ErrGroupInviteLinkUnauthorized), errors.Is(err, whatsmeow.ErrIQNotAuthorized):
+		return ErrGroupNotAuthorized
+	case errors.Is(err, whatsmeow.ErrInviteLinkInvalid):
+		return ErrInviteLinkInvalid
+	case errors.Is(err, whatsmeow.ErrInviteLinkRevoked):
+		return ErrInviteLinkRevoked
+	default:
+		return err
+	}
+}
+
+func (wa *WhatsAppClient) checkGroupRequest(groupJID types.JID) error {
+	if wa.Client == nil || !wa.Client.IsLoggedIn() {
+		return bridgev2.ErrNotLoggedIn
+	} else if groupJID.Server != types.GroupServer {
+		return ErrNotAGroup
+	}
+	return nil
+}
+
+// ResolveGroupParticipants converts phone numbers and user JIDs into JIDs that can be added to groups.
+// Phone numbers are checked with WhatsApp in a single query.
+func (wa *WhatsAppClient) ResolveGroupParticipants(ctx context.Context, identifiers []string) ([]types.JID, error) {
+	if wa.Client == nil || !wa.Client.IsLoggedIn() {
+		return nil, bridgev2.ErrNotLoggedIn
+	}
+	jids := make([]types.JID, 0, len(identifiers))
+	var numbers []string
+	for _, identifier := range identifiers {
+		if strings.ContainsRune(identifier, '@') {
+			jid, err := types.ParseJID(identifier)
+			if err != nil || (jid.Server != types.DefaultUserServer && jid.Server != types.HiddenUserServer) {
+				return nil, bridgev2.WrapRespErr(fmt.Errorf("invalid participant %q", identifier), mautrix.MInvalidParam)
+			}
+			jids = append(jids, jid.ToNonAD())
+		} else if looksEmaily(identifier) {
+			return nil, ErrInputLooksLikeEmail
+		} else {
+			numbers = append(numbers, "+"+strings.TrimPrefix(identifier, "+"))
+		}
+	}
+	if len(numbers) == 0 {
+		return jids, nil
+	}
+	resp, err := wa.Client.IsOnWhatsApp(ctx, numbers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if numbers are on WhatsApp: %w", err)
+	}
+	for _, item := range resp {
+		if !item.IsIn {
+			return nil, bridgev2.WrapRespErr(fmt.Errorf("the server said %s is not on WhatsApp", item.Query), mautrix.MNotFound)
+		}
+		jids = append(jids, item.JID)
+	}
+	return jids, nil
+}
+
+// ChangeGroupParticipants adds, removes, promotes or demotes the given participants in a group.
+func (wa *WhatsAppClient) ChangeGroupParticipants(ctx context.Context, groupJID types.JID, participants []types.JID, action whatsmeow.ParticipantChange) ([]GroupParticipantResult, error) {
+	if err := wa.checkGroupRequest(groupJID); err != nil {
+		return nil, err
+	}
+	resp, err := wa.Client.UpdateGroupParticipants(ctx, groupJID, participants, action)
+	if err != nil {
+		return nil, wrapGroupError(err)
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("group_jid", groupJID).
+		Str("action", string(action)).
+		Any("change_response", resp).
+		Msg("Changed group participants")
+	results := make([]GroupParticipantResult, len(resp))
+	for i, pcp := range resp {
+		results[i] = GroupParticipantResult{JID: pcp.JID, Error: pcp.Error}
+	}
+	return results, nil
+}
+
+// GetGroupInviteLink gets the invite link of a group, optionally revoking the old link first.
+func (wa *WhatsAppClient) GetGroupInviteLink(ctx context.Context, groupJID types.JID, reset bool) (string, error) {
+	if err := wa.checkGroupRequest(groupJID); err != nil {
+		return "", err
+	}
+	link, err := wa.Client.GetGroupInviteLink(ctx, groupJID, reset)
+	if err != nil {
+		return "", wrapGroupError(err)
+	}
+	return link, nil
+}
+
+// JoinGroupWithLink joins a group using an invite link. The portal is created when WhatsApp sends the join event.
+func (wa *WhatsAppClient) JoinGroupWithLink(ctx context.Context, link string) (types.JID, error) {
+	if wa.Client == nil || !wa.Client.IsLoggedIn() {
+		return types.EmptyJID, bridgev2.ErrNotLoggedIn
+	} else if !strings.HasPrefix(link, whatsmeow.InviteLinkPrefix) {
+		return types.EmptyJID, ErrInviteLinkInvalid
+	}
+	jid, err := wa.Client.JoinGroupWithLink(ctx, link)
+	if err != nil {
+		return types.EmptyJID, wrapGroupError(err)
+	}
+	zerolog.Ctx(ctx).Debug().Stringer("group_jid", jid).Msg("Joined WhatsApp group with link")
+	return jid, nil
+}
+
+// LeaveGroup leaves a WhatsApp group.
+func (wa *WhatsAppClient) LeaveGroup(ctx context.Context, groupJID types.JID) error {
+	if err := wa.checkGroupRequest(groupJID); err != nil {
+		return err
+	}
+	err := wa.Client.LeaveGroup(ctx, groupJID)
+	if err != nil {
+		return wrapGroupError(err)
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
 	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/ptr"
 	"go.mau.fi/util/variationselector"
@@ -26,6 +27,7 @@ import (
 	"golang.org/x/image/draw"
 	"google.golang.org/protobuf/proto"
 
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/msgconv"
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
@@ -49,6 +51,9 @@ var (
 )
 
 func (wa *WhatsAppClient) HandleMatrixPollStart(ctx context.Context, msg *bridgev2.MatrixPollStart) (*bridgev2.MatrixMessageResponse, error) {
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, msg.Event.Sender); preferred != nil {
+		return preferred.HandleMatrixPollStart(ctx, msg)
+	}
 	waMsg, optionMap, err := wa.Main.MsgConv.PollStartToWhatsApp(ctx, msg.Content, msg.ReplyTo, msg.Portal)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert poll vote: %w", err)
@@ -68,6 +73,9 @@ func (wa *WhatsAppClient) HandleMatrixPollStart(ctx context.Context, msg *bridge
 }
 
 func (wa *WhatsAppClient) HandleMatrixPollVote(ctx context.Context, msg *bridgev2.MatrixPollVote) (*bridgev2.MatrixMessageResponse, error) {
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, msg.Event.Sender); preferred != nil {
+		return preferred.HandleMatrixPollVote(ctx, msg)
+	}
 	waMsg, err := wa.Main.MsgConv.PollVoteToWhatsApp(ctx, wa.Client, msg.Content, msg.VoteTo)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert poll vote: %w", err)
@@ -76,13 +84,131 @@ func (wa *WhatsAppClient) HandleMatrixPollVote(ctx context.Context, msg *bridgev
 }
 
 func (wa *WhatsAppClient) HandleMatrixMessage(ctx context.Context, msg *bridgev2.MatrixMessage) (*bridgev2.MatrixMessageResponse, error) {
-	waMsg, req, err := wa.Main.MsgConv.ToWhatsApp(ctx, wa.Client, msg.Event, msg.Content, msg.ReplyTo, msg.ThreadRoot, msg.Portal)
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, msg.Event.Sender); preferred != nil {
+		zerolog.Ctx(ctx).Debug().
+			Str("preferred_login_id", string(preferred.UserLogin.ID)).
+			Msg("Sending message using preferred login of portal")
+		return preferred.HandleMatrixMessage(ctx, msg)
+	}
+	content := msg.Content
+	if msg.OrigSender != nil {
+		content = wa.Main.applyRelayTemplate(ctx, msg.Portal, msg.OrigSender, msg.Event.Content.AsMessage(), msg.Content, false)
+	}
+	if content.MsgType == msgconv.MsgGallery {
+		return wa.handleMatrixGallery(ctx, msg, content)
+	}
+	waMsg, req, err := wa.Main.MsgConv.ToWhatsApp(ctx, wa.Client, msg.Event, content, msg.ReplyTo, msg.ThreadRoot, msg.Portal)
 	if err != nil {
 		return nil, fmt.Errorf("failed to convert message: %w", err)
 	}
-	return wa.handleConvertedMatrixMessage(ctx, msg, waMsg, req)
+	resp, err := wa.handleConvertedMatrixMessage(ctx, msg, waMsg, req)
+	if err == nil && wa.Main.viewOnceExpiryEnabled() && msgconv.IsViewOnceRequested(msg.Event.Content.Raw) {
+		mediaURI := content.URL
+		if content.File != nil {
+			mediaURI = content.File.URL
+		}
+		var expireAt time.Time
+		if timeout := wa.Main.Config.ViewOnce.Timeout; timeout > 0 {
+			expireAt = time.Now().Add(timeout)
+		}
+		err = wa.Main.DB.ViewOnce.Put(ctx, &wadb.ViewOnceMessage{
+			UserLoginID: wa.UserLogin.ID,
+			MessageID:   resp.DB.ID,
+			RoomID:      msg.Portal.MXID,
+			EventID:     msg.Event.ID,
+			MediaURI:    mediaURI,
+			ExpireAt:    expireAt,
+		})
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to save sent view-once message")
+			err = nil
+		}
+	}
+	return resp, err
 }
 
+// handleMatrixGallery sends an MSC4274 gallery as a WhatsApp album. The Matrix event is mapped to the album message,
+// while the media items are sent as separate messages associated with the album. If some of the items fail to send,
+// the album is still saved, but ErrGalleryPartiallySent is returned.
+func (wa *WhatsAppClient) handleMatrixGallery(ctx context.Context, msg *bridgev2.MatrixMessage, content *event.MessageEventContent) (*bridgev2.MatrixMessageResponse, error) {
+	albumMsg, children, err := wa.Main.MsgConv.GalleryToWhatsApp(ctx, wa.Client, msg.Event, content, msg.ReplyTo, msg.Portal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert gallery: %w", err)
+	}
+	resp, err := wa.handleConvertedMatrixMessage(ctx, msg, albumMsg, nil)
+	if err != nil {
+		return nil, err
+	}
+	chatJID, err := waid.ParsePortalID(msg.Portal.ID)
+	if err != nil {
+		return nil, err
+	}
+	albumID, err := waid.ParseMessageID(resp.DB.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse album message ID: %w", err)
+	}
+	albumKey := &waCommon.MessageKey{
+		RemoteJID: proto.String(chatJID.String()),
+		FromMe:    proto.Bool(true),
+		ID:        proto.String(albumID.ID),
+	}
+	log := zerolog.Ctx(ctx)
+	var failed int
+	for i, child := range children {
+		msgconv.SetAlbumParent(child, albumKey)
+		childID := wa.Client.GenerateMessageID()
+		wrappedChildID := waid.MakeMessageID(chatJID, wa.JID, childID)
+		wrappedChildID2 := waid.MakeMessageID(chatJID, wa.GetStore().GetLID(), childID)
+		msg.AddPendingToIgnore(networkid.TransactionID(wrappedChildID))
+		msg.AddPendingToIgnore(networkid.TransactionID(wrappedChildID2))
+		childResp, err := wa.Client.SendMessage(ctx, chatJID, child, whatsmeow.SendRequestExtra{ID: childID})
+		if err != nil {
+			log.Err(err).Int("item_index", i).Msg("Failed to send gallery item")
+			msg.RemovePending(networkid.TransactionID(wrappedChildID))
+			msg.RemovePending(networkid.TransactionID(wrappedChildID2))
+			failed++
+			continue
+		}
+		pickedChildID := wrappedChildID
+		if childResp.Sender == wa.GetStore().GetLID() && chatJID.Server != types.DefaultUserServer {
+			pickedChildID = wrappedChildID2
+		}
+		// The items don't have their own Matrix events, so they're saved with fake event IDs
+		// to let WhatsApp replies and reactions to them be found.
+		err = wa.Main.Bridge.DB.Message.Insert(ctx, &database.Message{
+			ID:         pickedChildID,
+			MXID:       id.EventID(fmt.Sprintf("~fake:%s:%d", msg.Event.ID, i)),
+			Room:       msg.Portal.PortalKey,
+			SenderID:   waid.MakeUserID(childResp.Sender),
+			SenderMXID: msg.Event.Sender,
+			Timestamp:  childResp.Timestamp,
+			ThreadRoot: resp.DB.ID,
+			Metadata: &waid.MessageMetadata{
+				SenderDeviceID: wa.JID.Device,
+			},
+		})
+		if err != nil {
+			log.Err(err).Int("item_index", i).Msg("Failed to save sent gallery item to database")
+		}
+		msg.RemovePending(networkid.TransactionID(wrappedChildID))
+		msg.RemovePending(networkid.TransactionID(wrappedChildID2))
+	}
+	if failed > 0 {
+		// The album itself was already sent, but returning an error means it won't be saved by the caller
+		resp.DB.MXID = msg.Event.ID
+		resp.DB.Room = msg.Portal.PortalKey
+		resp.DB.SenderMXID = msg.Event.Sender
+		err = wa.Main.Bridge.DB.Message.Insert(ctx, resp.DB)
+		if err != nil {
+			log.Err(err).Msg("Failed to save partially sent gallery to database")
+		}
+		msg.RemovePending(resp.RemovePending)
+		return nil, fmt.Errorf("%w: %d of %d items failed", ErrGalleryPartiallySent, failed, len(children))
+	}
+	return resp, nil
+}
+
+var ErrGalleryPartiallySent = bridgev2.WrapErrorInStatus(errors.New("some gallery items failed to send")).WithErrorAsMessage().WithIsCertain(true).WithSendNotice(true).WithErrorReason(event.MessageStatusGenericError)
 var ErrBroadcastSendDisabled = bridgev2.WrapErrorInStatus(errors.New("sending status messages is disabled")).WithErrorAsMessage().WithIsCertain(true).WithSendNotice(true).WithErrorReason(event.MessageStatusUnsupported)
 var ErrBroadcastReactionUnsupported = bridgev2.WrapErrorInStatus(errors.New("reacting to status messages is not currently supported")).WithErrorAsMessage().WithIsCertain(true).WithSendNotice(true).WithErrorReason(event.MessageStatusUnsupported)
 
@@ -119,6 +245,12 @@ func (wa *WhatsAppClient) handleConvertedMatrixMessage(ctx context.Context, msg
 		pickedMessageID = wrappedMsgID
 		msg.RemovePending(networkid.TransactionID(wrappedMsgID2))
 	}
+	if timer := msg.Portal.Disappear.Timer; timer > 0 {
+		wa.putDisappearingParts(ctx, msg.Portal, []*database.Message{{
+			ID:   pickedMessageID,
+			MXID: msg.Event.ID,
+		}}, resp.Timestamp.Add(timer))
+	}
 	return &bridgev2.MatrixMessageResponse{
 		DB: &database.Message{
 			ID:        pickedMessageID,
@@ -133,12 +265,17 @@ func (wa *WhatsAppClient) handleConvertedMatrixMessage(ctx context.Context, msg
 	}, nil
 }
 
-func (wa *WhatsAppClient) PreHandleMatrixReaction(_ context.Context, msg *bridgev2.MatrixReaction) (bridgev2.MatrixReactionPreResponse, error) {
+func (wa *WhatsAppClient) PreHandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (bridgev2.MatrixReactionPreResponse, error) {
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, msg.Event.Sender); preferred != nil {
+		return preferred.PreHandleMatrixReaction(ctx, msg)
+	}
 	portalJID, err := waid.ParsePortalID(msg.Portal.ID)
 	if err != nil {
 		return bridgev2.MatrixReactionPreResponse{}, fmt.Errorf("failed to parse portal ID: %w", err)
 	} else if portalJID == types.StatusBroadcastJID {
 		return bridgev2.MatrixReactionPreResponse{}, ErrBroadcastReactionUnsupported
+	} else if msg.OrigSender != nil {
+		return wa.preHandleRelayedReaction(msg)
 	}
 	sender := wa.JID
 	if portalJID.Server == types.HiddenUserServer ||
@@ -154,6 +291,9 @@ func (wa *WhatsAppClient) PreHandleMatrixReaction(_ context.Context, msg *bridge
 }
 
 func (wa *WhatsAppClient) HandleMatrixReaction(ctx context.Context, msg *bridgev2.MatrixReaction) (*database.Reaction, error) {
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, msg.Event.Sender); preferred != nil {
+		return preferred.HandleMatrixReaction(ctx, msg)
+	}
 	messageID, err := waid.ParseMessageID(msg.TargetMessage.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse target message ID: %w", err)
@@ -162,6 +302,8 @@ func (wa *WhatsAppClient) HandleMatrixReaction(ctx context.Context, msg *bridgev
 	portalJID, err := waid.ParsePortalID(msg.Portal.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse portal ID: %w", err)
+	} else if msg.OrigSender != nil {
+		return wa.handleRelayedReaction(ctx, msg, portalJID)
 	}
 	reactionMsg := &waE2E.Message{
 		ReactionMessage: &waE2E.ReactionMessage{
@@ -192,6 +334,9 @@ func (wa *WhatsAppClient) HandleMatrixReaction(ctx context.Context, msg *bridgev
 }
 
 func (wa *WhatsAppClient) HandleMatrixReactionRemove(ctx context.Context, msg *bridgev2.MatrixReactionRemove) error {
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, msg.Event.Sender); preferred != nil {
+		return preferred.HandleMatrixReactionRemove(ctx, msg)
+	}
 	messageID, err := waid.ParseMessageID(msg.TargetReaction.MessageID)
 	if err != nil {
 		return fmt.Errorf("failed to parse target message ID: %w", err)
@@ -202,6 +347,18 @@ func (wa *WhatsAppClient) HandleMatrixReactionRemove(ctx context.Context, msg *b
 		return fmt.Errorf("failed to parse portal ID: %w", err)
 	}
 
+	extra := whatsmeow.SendRequestExtra{}
+	if strings.HasPrefix(string(msg.InputTransactionID), whatsmeow.WebMessageIDPrefix) {
+		extra.ID = types.MessageID(msg.InputTransactionID)
+	}
+
+	if meta, ok := msg.TargetReaction.Metadata.(*waid.ReactionMetadata); ok && meta.RelayedMessageID != "" {
+		// Reactions of relayed users are sent as text messages, so delete the message instead
+		resp, err := wa.Client.SendMessage(ctx, portalJID, wa.Client.BuildRevoke(portalJID, types.EmptyJID, meta.RelayedMessageID), extra)
+		zerolog.Ctx(ctx).Trace().Any("response", resp).Msg("WhatsApp relayed reaction delete response")
+		return err
+	}
+
 	reactionMsg := &waE2E.Message{
 		ReactionMessage: &waE2E.ReactionMessage{
 			Key:               wa.messageIDToKey(messageID),
@@ -210,17 +367,15 @@ func (wa *WhatsAppClient) HandleMatrixReactionRemove(ctx context.Context, msg *b
 		},
 	}
 
-	extra := whatsmeow.SendRequestExtra{}
-	if strings.HasPrefix(string(msg.InputTransactionID), whatsmeow.WebMessageIDPrefix) {
-		extra.ID = types.MessageID(msg.InputTransactionID)
-	}
-
 	resp, err := wa.Client.SendMessage(ctx, portalJID, reactionMsg, extra)
 	zerolog.Ctx(ctx).Trace().Any("response", resp).Msg("WhatsApp reaction response")
 	return err
 }
 
 func (wa *WhatsAppClient) HandleMatrixEdit(ctx context.Context, edit *bridgev2.MatrixEdit) error {
+	if preferred := wa.preferredClientFor(ctx, edit.Portal, edit.Event.Sender); preferred != nil {
+		return preferred.HandleMatrixEdit(ctx, edit)
+	}
 	log := zerolog.Ctx(ctx)
 
 	var editID types.MessageID
@@ -240,7 +395,11 @@ func (wa *WhatsAppClient) HandleMatrixEdit(ctx context.Context, edit *bridgev2.M
 		return fmt.Errorf("failed to parse portal ID: %w", err)
 	}
 
-	waMsg, _, err := wa.Main.MsgConv.ToWhatsApp(ctx, wa.Client, edit.Event, edit.Content, nil, nil, edit.Portal)
+	content := edit.Content
+	if edit.OrigSender != nil {
+		content = wa.Main.applyRelayTemplate(ctx, edit.Portal, edit.OrigSender, edit.Event.Content.AsMessage().NewContent, edit.Content, true)
+	}
+	waMsg, _, err := wa.Main.MsgConv.ToWhatsApp(ctx, wa.Client, edit.Event, content, nil, nil, edit.Portal)
 	if err != nil {
 		return fmt.Errorf("failed to convert message: %w", err)
 	}
@@ -259,6 +418,9 @@ func (wa *WhatsAppClient) HandleMatrixEdit(ctx context.Context, edit *bridgev2.M
 }
 
 func (wa *WhatsAppClient) HandleMatrixMessageRemove(ctx context.Context, msg *bridgev2.MatrixMessageRemove) error {
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, msg.Event.Sender); preferred != nil {
+		return preferred.HandleMatrixMessageRemove(ctx, msg)
+	}
 	log := zerolog.Ctx(ctx)
 	messageID, err := waid.ParseMessageID(msg.TargetMessage.ID)
 	if err != nil {
@@ -283,6 +445,10 @@ func (wa *WhatsAppClient) HandleMatrixMessageRemove(ctx context.Context, msg *br
 }
 
 func (wa *WhatsAppClient) HandleMatrixReadReceipt(ctx context.Context, receipt *bridgev2.MatrixReadReceipt) error {
+	// Receipts and typing notifications are only bridged for the user who owns the login
+	if preferred := wa.preferredClientFor(ctx, receipt.Portal, wa.UserLogin.UserMXID); preferred != nil {
+		return preferred.HandleMatrixReadReceipt(ctx, receipt)
+	}
 	if !receipt.ReadUpTo.After(receipt.LastRead) {
 		return nil
 	}
@@ -306,6 +472,7 @@ func (wa *WhatsAppClient) HandleMatrixReadReceipt(ctx context.Context, receipt *
 		Int("message_count", len(messages)).
 		Msg("Handling read receipt")
 	messagesToRead := make(map[types.JID][]string)
+	var viewedMessages []networkid.MessageID
 	for _, msg := range messages {
 		parsed, err := waid.ParseMessageID(msg.ID)
 		if err != nil {
@@ -314,6 +481,11 @@ func (wa *WhatsAppClient) HandleMatrixReadReceipt(ctx context.Context, receipt *
 		if parsed.Sender.User == wa.GetStore().GetLID().User || parsed.Sender.User == wa.JID.User {
 			continue
 		}
+		// Clients send read receipts automatically when a room is opened, so only an explicit receipt
+		// on the view-once event itself counts as viewing it.
+		if receipt.ExactMessage != nil && receipt.ExactMessage.ID == msg.ID {
+			viewedMessages = append(viewedMessages, msg.ID)
+		}
 		var key types.JID
 		// In group chats, group receipts by sender. In DMs, just use blank key (no participant field).
 		if parsed.Sender != parsed.Chat {
@@ -321,6 +493,7 @@ func (wa *WhatsAppClient) HandleMatrixReadReceipt(ctx context.Context, receipt *
 		}
 		messagesToRead[key] = append(messagesToRead[key], parsed.ID)
 	}
+	wa.expireViewOnce(ctx, viewedMessages)
 	for messageSender, ids := range messagesToRead {
 		err = wa.Client.MarkRead(ctx, ids, receipt.Receipt.Timestamp, portalJID, messageSender)
 		if err != nil {
@@ -331,6 +504,9 @@ func (wa *WhatsAppClient) HandleMatrixReadReceipt(ctx context.Context, receipt *
 }
 
 func (wa *WhatsAppClient) HandleMatrixTyping(ctx context.Context, msg *bridgev2.MatrixTyping) error {
+	if preferred := wa.preferredClientFor(ctx, msg.Portal, wa.UserLogin.UserMXID); preferred != nil {
+		return preferred.HandleMatrixTyping(ctx, msg)
+	}
 	portalJID, err := waid.ParsePortalID(msg.Portal.ID)
 	if err != nil {
 		return err
@@ -18,6 +18,7 @@ package connector
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -35,6 +36,7 @@ import (
 	"go.mau.fi/whatsmeow/types"
 	"go.mau.fi/whatsmeow/types/events"
 
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/msgconv"
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
 
@@ -80,8 +82,10 @@ func (wa *WhatsAppClient) handleWAEvent(rawEvt any) (success bool) {
 	switch evt := rawEvt.(type) {
 	case *events.Message:
 		success = wa.handleWAMessage(ctx, evt)
+		wa.queueMessageWebhook(ctx, evt)
 	case *events.Receipt:
 		success = wa.handleWAReceipt(ctx, evt)
+		wa.queueReceiptWebhook(ctx, evt)
 	case *events.ChatPresence:
 		wa.handleWAChatPresence(ctx, evt)
 	case *events.UndecryptableMessage:
@@ -114,12 +118,17 @@ func (wa *WhatsAppClient) handleWAEvent(rawEvt any) (success bool) {
 		}
 	case *events.MediaRetry:
 		wa.phoneSeen(evt.Timestamp)
-		success = wa.UserLogin.QueueRemoteEvent(&WAMediaRetry{MediaRetry: evt, wa: wa}).Success
+		retryEvt := &WAMediaRetry{MediaRetry: evt, wa: wa}
+		res := wa.UserLogin.QueueRemoteEvent(retryEvt)
+		success = res.Success
+		retryEvt.notifyWaiter(res)
 
 	case *events.GroupInfo:
 		success = wa.handleWAGroupInfoChange(ctx, evt)
+		wa.queueGroupChangeWebhook(ctx, evt)
 	case *events.JoinedGroup:
 		success = wa.handleWAJoinedGroup(ctx, evt)
+		wa.queueJoinedGroupWebhook(ctx, evt)
 	case *events.NewsletterJoin:
 		success = wa.handleWANewsletterJoin(ctx, evt)
 	case *events.NewsletterLeave:
@@ -164,7 +173,7 @@ func (wa *WhatsAppClient) handleWAEvent(rawEvt any) (success bool) {
 
 	case *events.Connected:
 		log.Debug().Msg("Connected to WhatsApp socket")
-		wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
+		wa.sendBridgeState(status.BridgeState{StateEvent: status.StateConnected})
 		if len(wa.GetStore().PushName) > 0 {
 			go func() {
 				err := wa.updatePresence(ctx, types.PresenceUnavailable)
@@ -181,6 +190,10 @@ func (wa *WhatsAppClient) handleWAEvent(rawEvt any) (success bool) {
 			Int("notification_count", evt.Notifications).
 			Int("app_data_change_count", evt.AppDataChanges).
 			Msg("Server sent number of events that were missed during downtime")
+		wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+			p.OfflineMessagesExpected = evt.Messages
+			p.OfflineSyncCompleted = false
+		})
 	case *events.OfflineSyncCompleted:
 		if !wa.PhoneRecentlySeen(true) {
 			log.Info().
@@ -192,16 +205,23 @@ func (wa *WhatsAppClient) handleWAEvent(rawEvt any) (success bool) {
 				Int("evt_count", evt.Count).
 				Msg("Offline sync completed")
 		}
-		wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
+		wa.sendBridgeState(status.BridgeState{StateEvent: status.StateConnected})
 		wa.notifyOfflineSyncWaiter(nil)
+		wa.updateHistorySyncProgress(func(p *HistorySyncProgress) {
+			p.OfflineSyncCompleted = true
+		})
 	case *events.LoggedOut:
 		wa.handleWALogout(evt.Reason, evt.OnConnect)
+		wa.queueWebhook(ctx, waid.WebhookEventLogout, &webhookLogoutData{
+			Reason:    evt.Reason.String(),
+			OnConnect: evt.OnConnect,
+		})
 		wa.notifyOfflineSyncWaiter(fmt.Errorf("logged out: %s", evt.Reason))
 	case *events.Disconnected:
 		// Don't send the normal transient disconnect state if we're already in a different transient disconnect state.
 		// TODO remove this if/when the phone offline state is moved to a sub-state of CONNECTED
 		if wa.UserLogin.BridgeState.GetPrev().Error != WAPhoneOffline && wa.PhoneRecentlySeen(false) {
-			wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateTransientDisconnect, Error: WADisconnected})
+			wa.sendBridgeState(status.BridgeState{StateEvent: status.StateTransientDisconnect, Error: WADisconnected})
 		}
 		wa.notifyOfflineSyncWaiter(fmt.Errorf("disconnected"))
 	case *events.StreamError:
@@ -213,22 +233,22 @@ func (wa *WhatsAppClient) handleWAEvent(rawEvt any) (success bool) {
 		} else {
 			message = "Unknown stream error"
 		}
-		wa.UserLogin.BridgeState.Send(status.BridgeState{
+		wa.sendBridgeState(status.BridgeState{
 			StateEvent: status.StateUnknownError,
 			Error:      WAStreamError,
 			Message:    message,
 		})
 		wa.notifyOfflineSyncWaiter(fmt.Errorf("stream error: %s", message))
 	case *events.StreamReplaced:
-		wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateUnknownError, Error: WAStreamReplaced})
+		wa.sendBridgeState(status.BridgeState{StateEvent: status.StateUnknownError, Error: WAStreamReplaced})
 		wa.notifyOfflineSyncWaiter(fmt.Errorf("stream replaced"))
 	case *events.KeepAliveTimeout:
-		wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateTransientDisconnect, Error: WAKeepaliveTimeout})
+		wa.sendBridgeState(status.BridgeState{StateEvent: status.StateTransientDisconnect, Error: WAKeepaliveTimeout})
 	case *events.KeepAliveRestored:
 		log.Info().Msg("Keepalive restored after timeouts, sending connected event")
-		wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
+		wa.sendBridgeState(status.BridgeState{StateEvent: status.StateConnected})
 	case *events.ConnectFailure:
-		wa.UserLogin.BridgeState.Send(status.BridgeState{
+		wa.sendBridgeState(status.BridgeState{
 			StateEvent: status.StateUnknownError,
 			Error:      status.BridgeStateErrorCode(fmt.Sprintf("wa-connect-failure-%d", evt.Reason)),
 			Message:    fmt.Sprintf("Unknown connection failure: %s (%s)", evt.Reason, evt.Message),
@@ -236,10 +256,10 @@ func (wa *WhatsAppClient) handleWAEvent(rawEvt any) (success bool) {
 		wa.notifyOfflineSyncWaiter(fmt.Errorf("connection failure: %s (%s)", evt.Reason, evt.Message))
 	case *events.ClientOutdated:
 		wa.UserLogin.Log.Error().Msg("Got a client outdated connect failure. The bridge is likely out of date, please update immediately.")
-		wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateUnknownError, Error: WAClientOutdated})
+		wa.sendBridgeState(status.BridgeState{StateEvent: status.StateUnknownError, Error: WAClientOutdated})
 		wa.notifyOfflineSyncWaiter(fmt.Errorf("client outdated"))
 	case *events.TemporaryBan:
-		wa.UserLogin.BridgeState.Send(status.BridgeState{
+		wa.sendBridgeState(status.BridgeState{
 			StateEvent: status.StateBadCredentials,
 			Error:      WATemporaryBan,
 			Message:    evt.String(),
@@ -347,6 +367,8 @@ func (wa *WhatsAppClient) handleWAMessage(ctx context.Context, evt *events.Messa
 		evt.Message = &waE2E.Message{
 			ProtocolMessage: protocolMsg,
 		}
+	} else if assocType == waE2E.MessageAssociation_MEDIA_ALBUM {
+		evt.Message = msgconv.UnwrapAssociatedChild(evt.Message)
 	} else if assocType == waE2E.MessageAssociation_MOTION_PHOTO {
 		//evt.Message = evt.Message.GetAssociatedChildMessage().GetMessage()
 		wa.UserLogin.Log.Debug().
@@ -388,6 +410,7 @@ func (wa *WhatsAppClient) handleWAMessage(ctx context.Context, evt *events.Messa
 		evt.UnwrapRaw()
 		parsedMessageType = getMessageType(evt.Message)
 	}
+	autoReply := wa.checkAutoReply(evt, parsedMessageType)
 	res := wa.UserLogin.QueueRemoteEvent(&WAMessageEvent{
 		MessageInfoWrapper: &MessageInfoWrapper{
 			Info: evt.Info,
@@ -398,6 +421,12 @@ func (wa *WhatsAppClient) handleWAMessage(ctx context.Context, evt *events.Messa
 
 		parsedMessageType: parsedMessageType,
 	})
+	if autoReply && res.Success {
+		go wa.sendAutoReply(ctx, evt.Info.Chat)
+	}
+	if evt.Message.GetKeepInChatMessage() != nil {
+		wa.handleWAKeepInChat(ctx, evt)
+	}
 	return res.Success
 }
 
@@ -432,9 +461,15 @@ func (wa *WhatsAppClient) handleWAReceipt(ctx context.Context, evt *events.Recei
 		wa.phoneSeen(evt.Timestamp)
 	}
 	var evtType bridgev2.RemoteEventType
+	var viewed bool
 	switch evt.Type {
 	case types.ReceiptTypeRead, types.ReceiptTypeReadSelf:
 		evtType = bridgev2.RemoteEventReadReceipt
+		viewed = true
+	case types.ReceiptTypePlayed, types.ReceiptTypePlayedSelf:
+		// Played receipts aren't bridged, but they still mean that view-once media was viewed.
+		evtType = bridgev2.RemoteEventUnknown
+		viewed = true
 	case types.ReceiptTypeDelivered:
 		evtType = bridgev2.RemoteEventDeliveryReceipt
 	case types.ReceiptTypeSender:
@@ -459,6 +494,12 @@ func (wa *WhatsAppClient) handleWAReceipt(ctx context.Context, evt *events.Recei
 	for i, id := range evt.MessageIDs {
 		targets[i] = waid.MakeMessageID(evt.Chat, messageSender, id)
 	}
+	if viewed {
+		wa.expireViewOnce(ctx, targets)
+	}
+	if evtType == bridgev2.RemoteEventUnknown {
+		return true
+	}
 	res := wa.UserLogin.QueueRemoteEvent(&simplevent.Receipt{
 		EventMeta: simplevent.EventMeta{
 			Type:      evtType,
@@ -514,7 +555,7 @@ func (wa *WhatsAppClient) handleWALogout(reason events.ConnectFailureReason, onC
 	wa.Client = nil
 	wa.JID = types.EmptyJID
 	wa.UserLogin.Metadata.(*waid.UserLoginMetadata).WADeviceID = 0
-	wa.UserLogin.BridgeState.Send(status.BridgeState{
+	wa.sendBridgeState(status.BridgeState{
 		StateEvent: status.StateBadCredentials,
 		Error:      errorCode,
 	})
@@ -544,7 +585,7 @@ func (wa *WhatsAppClient) handleWACallStart(ctx context.Context, group, sender,
 			LogContext:   nil,
 			PortalKey:    wa.makeWAPortalKey(chat),
 			Sender:       wa.makeEventSender(ctx, sender),
-			CreatePortal: true,
+			CreatePortal: wa.shouldCreatePortal(ctx, chat, nil),
 			Timestamp:    ts,
 			StreamOrder:  ts.Unix(),
 		},
@@ -706,11 +747,18 @@ func (wa *WhatsAppClient) handleWAPictureUpdate(ctx context.Context, evt *events
 }
 
 func (wa *WhatsAppClient) handleWAGroupInfoChange(ctx context.Context, evt *events.GroupInfo) bool {
+	if evt.Link != nil {
+		wa.forgetCommunityGroup(evt.JID)
+		wa.forgetCommunityGroup(evt.Link.Group.JID)
+	} else if evt.Unlink != nil {
+		wa.forgetCommunityGroup(evt.JID)
+		wa.forgetCommunityGroup(evt.Unlink.Group.JID)
+	}
 	eventMeta := simplevent.EventMeta{
 		Type:         bridgev2.RemoteEventChatInfoChange,
 		LogContext:   nil,
 		PortalKey:    wa.makeWAPortalKey(evt.JID),
-		CreatePortal: true,
+		CreatePortal: wa.shouldCreatePortal(ctx, evt.JID, nil),
 		Timestamp:    evt.Timestamp,
 	}
 	if evt.Sender != nil {
@@ -736,7 +784,7 @@ func (wa *WhatsAppClient) handleWAJoinedGroup(ctx context.Context, evt *events.J
 			Type:         bridgev2.RemoteEventChatResync,
 			LogContext:   nil,
 			PortalKey:    wa.makeWAPortalKey(evt.JID),
-			CreatePortal: true,
+			CreatePortal: wa.shouldCreatePortal(ctx, evt.JID, &evt.GroupInfo),
 		},
 		ChatInfo: wa.wrapGroupInfo(ctx, &evt.GroupInfo),
 	}).Success
@@ -748,7 +796,7 @@ func (wa *WhatsAppClient) handleWANewsletterJoin(ctx context.Context, evt *event
 			Type:         bridgev2.RemoteEventChatResync,
 			LogContext:   nil,
 			PortalKey:    wa.makeWAPortalKey(evt.ID),
-			CreatePortal: true,
+			CreatePortal: wa.shouldCreatePortal(ctx, evt.ID, nil),
 		},
 		ChatInfo: wa.wrapNewsletterInfo(ctx, &evt.NewsletterMetadata),
 	}).Success
@@ -814,3 +862,15 @@ func (wa *WhatsAppClient) handleWAPin(evt *events.Pin) bool {
 		Tag: &tag,
 	})
 }
+
+func (wa *WhatsAppClient) saveStickerPack(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, msg *waE2E.StickerPackMessage) {
+	log := zerolog.Ctx(ctx).With().Str("sticker_pack_id", msg.GetStickerPackID()).Logger()
+	err := wa.Main.MsgConv.SaveStickerPack(ctx, portal, wa.Client, intent, msg)
+	if errors.Is(err, msgconv.ErrStickerPackInEncryptedRoom) {
+		log.Debug().Msg("Not saving sticker pack in encrypted room")
+	} else if err != nil {
+		log.Err(err).Msg("Failed to save sticker pack in room")
+	} else {
+		log.Debug().Msg("Saved sticker pack in room")
+	}
+}
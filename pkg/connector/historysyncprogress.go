@@ -0,0 +1,252 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+)
+
+const historySyncNoticeInterval = 15 * time.Second
+
+// HistorySyncProgress describes how far the initial history sync of a login has gotten.
+type HistorySyncProgress struct {
+	StartedAt  time.Time  `json:"started_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	OfflineMessagesExpected int  `json:"offline_messages_expected"`
+	OfflineSyncCompleted    bool `json:"offline_sync_completed"`
+
+	NotificationsReceived  int    `json:"notifications_received"`
+	NotificationsProcessed int    `json:"notifications_processed"`
+	PhoneProgress          uint32 `json:"phone_progress"`
+	ConversationsParsed    int    `json:"conversations_parsed"`
+	MessagesSaved          int    `json:"messages_saved"`
+	PortalsToCreate        int    `json:"portals_to_create"`
+	PortalsCreated         int    `json:"portals_created"`
+
+	Remaining           int        `json:"remaining"`
+	EstimatedCompletion *time.Time `json:"estimated_completion,omitempty"`
+
+	// portalCreationDone is set when a portal creation pass has finished and cleared when a new payload
+	// with conversations is processed, as that will trigger another pass.
+	portalCreationDone bool
+}
+
+type historySyncProgressTracker struct {
+	lock     sync.Mutex
+	progress *HistorySyncProgress
+
+	// noticeLock protects the fields below. Notices are sent by a single background goroutine,
+	// so that a slow homeserver doesn't block WhatsApp event handling.
+	noticeLock    sync.Mutex
+	noticeRoom    id.RoomID
+	noticeID      id.EventID
+	lastNotice    time.Time
+	pendingNotice *HistorySyncProgress
+	noticeSending bool
+}
+
+// expectedNotifications returns the number of history sync payloads that are expected in total.
+// Until the offline sync is complete, the number of missed messages in the offline sync preview is
+// used as an upper bound, as the payload notifications of a fresh login are delivered as offline messages.
+func (p *HistorySyncProgress) expectedNotifications() int {
+	if !p.OfflineSyncCompleted && p.OfflineMessagesExpected > p.NotificationsReceived {
+		return p.OfflineMessagesExpected
+	}
+	return p.NotificationsReceived
+}
+
+func (p *HistorySyncProgress) fillEstimate(now time.Time) {
+	expected := p.expectedNotifications()
+	p.Remaining = (expected - p.NotificationsProcessed) + (p.PortalsToCreate - p.PortalsCreated)
+	p.EstimatedCompletion = nil
+	if p.FinishedAt != nil {
+		p.Remaining = 0
+		return
+	}
+	total := expected + p.PortalsToCreate
+	done := p.NotificationsProcessed + p.PortalsCreated
+	if done == 0 || total <= done {
+		return
+	}
+	elapsed := now.Sub(p.StartedAt)
+	eta := p.StartedAt.Add(time.Duration(float64(elapsed) * float64(total) / float64(done)))
+	p.EstimatedCompletion = &eta
+}
+
+func (wa *WhatsAppClient) startHistorySyncProgress() {
+	wa.hsProgress.lock.Lock()
+	defer wa.hsProgress.lock.Unlock()
+	if wa.hsProgress.progress != nil && wa.hsProgress.progress.FinishedAt == nil {
+		return
+	}
+	now := time.Now()
+	wa.hsProgress.progress = &HistorySyncProgress{
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	wa.hsProgress.noticeLock.Lock()
+	wa.hsProgress.noticeID = ""
+	wa.hsProgress.noticeLock.Unlock()
+}
+
+// GetHistorySyncProgress returns a snapshot of the current history sync progress,
+// or nil if no history sync has been tracked since the bridge was started.
+func (wa *WhatsAppClient) GetHistorySyncProgress() *HistorySyncProgress {
+	wa.hsProgress.lock.Lock()
+	defer wa.hsProgress.lock.Unlock()
+	if wa.hsProgress.progress == nil {
+		return nil
+	}
+	snapshot := *wa.hsProgress.progress
+	snapshot.fillEstimate(time.Now())
+	return &snapshot
+}
+
+// isFinished checks if all known history sync payloads have been processed and portals have been created for them.
+func (p *HistorySyncProgress) isFinished() bool {
+	return p.portalCreationDone && p.OfflineSyncCompleted && p.NotificationsProcessed >= p.NotificationsReceived
+}
+
+func (wa *WhatsAppClient) updateHistorySyncProgress(fn func(p *HistorySyncProgress)) {
+	wa.hsProgress.lock.Lock()
+	progress := wa.hsProgress.progress
+	if progress == nil || progress.FinishedAt != nil {
+		wa.hsProgress.lock.Unlock()
+		return
+	}
+	now := time.Now()
+	fn(progress)
+	progress.UpdatedAt = now
+	// Completion is checked on every update, as more payloads may still be coming in after portals have been created
+	finished := progress.isFinished()
+	if finished {
+		progress.FinishedAt = &now
+	}
+	snapshot := *progress
+	snapshot.fillEstimate(now)
+	wa.hsProgress.lock.Unlock()
+
+	if wa.Main.Config.HistorySync.ProgressNotices {
+		wa.queueHistorySyncProgressNotice(&snapshot, finished)
+	}
+}
+
+// queueHistorySyncProgressNotice queues a progress notice to be sent to the management room. Only the latest
+// queued progress is sent if the previous notice is still being sent.
+func (wa *WhatsAppClient) queueHistorySyncProgressNotice(progress *HistorySyncProgress, force bool) {
+	wa.hsProgress.noticeLock.Lock()
+	defer wa.hsProgress.noticeLock.Unlock()
+	if !force && time.Since(wa.hsProgress.lastNotice) < historySyncNoticeInterval {
+		return
+	}
+	wa.hsProgress.lastNotice = time.Now()
+	wa.hsProgress.pendingNotice = progress
+	if !wa.hsProgress.noticeSending {
+		wa.hsProgress.noticeSending = true
+		go wa.sendQueuedHistorySyncProgressNotices()
+	}
+}
+
+func (wa *WhatsAppClient) sendQueuedHistorySyncProgressNotices() {
+	log := wa.UserLogin.Log.With().Str("action", "send history sync progress notice").Logger()
+	ctx := log.WithContext(wa.Main.Bridge.BackgroundCtx)
+	for {
+		wa.hsProgress.noticeLock.Lock()
+		progress := wa.hsProgress.pendingNotice
+		wa.hsProgress.pendingNotice = nil
+		if progress == nil {
+			wa.hsProgress.noticeSending = false
+			wa.hsProgress.noticeLock.Unlock()
+			return
+		}
+		editID := wa.hsProgress.noticeID
+		wa.hsProgress.noticeLock.Unlock()
+
+		eventID := wa.sendHistorySyncProgressNotice(ctx, progress, editID)
+		if eventID != "" && editID == "" {
+			wa.hsProgress.noticeLock.Lock()
+			if wa.hsProgress.noticeID == "" {
+				wa.hsProgress.noticeID = eventID
+			}
+			wa.hsProgress.noticeLock.Unlock()
+		}
+	}
+}
+
+// sendHistorySyncProgressNotice sends a new progress notice or edits the previous one if editID is set.
+// It must only be called from the notice sending goroutine.
+func (wa *WhatsAppClient) sendHistorySyncProgressNotice(ctx context.Context, progress *HistorySyncProgress, editID id.EventID) id.EventID {
+	log := zerolog.Ctx(ctx)
+	if wa.hsProgress.noticeRoom == "" {
+		roomID, err := wa.UserLogin.User.GetManagementRoom(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to get management room to send history sync progress")
+			return ""
+		}
+		wa.hsProgress.noticeRoom = roomID
+	}
+	content := &event.MessageEventContent{
+		MsgType: event.MsgNotice,
+		Body:    formatHistorySyncProgress(progress),
+	}
+	if editID != "" {
+		content.SetEdit(editID)
+	}
+	resp, err := wa.Main.Bridge.Bot.SendMessage(ctx, wa.hsProgress.noticeRoom, event.EventMessage, &event.Content{
+		Parsed: content,
+	}, nil)
+	if err != nil {
+		log.Err(err).Msg("Failed to send history sync progress notice")
+		return ""
+	}
+	return resp.EventID
+}
+
+func formatHistorySyncProgress(p *HistorySyncProgress) string {
+	if p.FinishedAt != nil {
+		return fmt.Sprintf(
+			"History sync finished in %s: parsed %d conversations and created %d chats.",
+			p.FinishedAt.Sub(p.StartedAt).Round(time.Second), p.ConversationsParsed, p.PortalsCreated,
+		)
+	}
+	var parts []string
+	expected := p.expectedNotifications()
+	if expected > p.NotificationsReceived {
+		parts = append(parts, fmt.Sprintf("received %d of ~%d history payloads", p.NotificationsReceived, expected))
+	} else {
+		parts = append(parts, fmt.Sprintf("processed %d of %d history payloads", p.NotificationsProcessed, p.NotificationsReceived))
+	}
+	parts = append(parts, fmt.Sprintf("parsed %d conversations", p.ConversationsParsed))
+	if p.PortalsToCreate > 0 {
+		parts = append(parts, fmt.Sprintf("created %d of %d chats", p.PortalsCreated, p.PortalsToCreate))
+	}
+	text := "History sync in progress: " + strings.Join(parts, ", ") + "."
+	if p.EstimatedCompletion != nil {
+		text += fmt.Sprintf(" Estimated time remaining: %s.", time.Until(*p.EstimatedCompletion).Round(time.Second))
+	}
+	return text
+}
@@ -0,0 +1,290 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/format"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/jsontime"
+	"go.mau.fi/util/ptr"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+// HistorySyncConversation is a conversation from the history sync that hasn't been bridged yet.
+type HistorySyncConversation struct {
+	ChatJID              types.JID     `json:"chat_jid"`
+	Name                 string        `json:"name"`
+	LastMessageTimestamp jsontime.Unix `json:"last_message_timestamp"`
+	UnreadCount          uint32        `json:"unread_count"`
+	Archived             bool          `json:"archived"`
+	Pinned               bool          `json:"pinned"`
+	IsGroup              bool          `json:"is_group"`
+}
+
+// historySyncSelection stores the chat list that was last shown to the user, so that list numbers in the
+// `history-sync bridge` command refer to the chats the user saw, even if more conversations have arrived since.
+type historySyncSelection struct {
+	lock       sync.Mutex
+	shown      []types.JID
+	noticeRoom id.RoomID
+	noticeID   id.EventID
+}
+
+// setShownHistorySyncList stores the list of conversations that was shown to the user.
+func (wa *WhatsAppClient) setShownHistorySyncList(conversations []*HistorySyncConversation) {
+	shown := make([]types.JID, len(conversations))
+	for i, conv := range conversations {
+		shown[i] = conv.ChatJID
+	}
+	wa.hsSelection.lock.Lock()
+	wa.hsSelection.shown = shown
+	wa.hsSelection.lock.Unlock()
+}
+
+// getShownHistorySyncList returns the list of conversations that was last shown to the user.
+func (wa *WhatsAppClient) getShownHistorySyncList() []types.JID {
+	wa.hsSelection.lock.Lock()
+	defer wa.hsSelection.lock.Unlock()
+	return wa.hsSelection.shown
+}
+
+func (cf *ConversationFilter) isEmpty() bool {
+	return len(cf.Include) == 0 && len(cf.Exclude) == 0 && cf.Groups && cf.DirectChats && cf.Archived
+}
+
+// Allows checks whether the given history sync conversation passes the filter.
+func (cf *ConversationFilter) Allows(conv *wadb.Conversation) bool {
+	switch conv.ChatJID.Server {
+	case types.GroupServer:
+		if !cf.Groups {
+			return false
+		}
+	case types.DefaultUserServer, types.HiddenUserServer:
+		if !cf.DirectChats {
+			return false
+		}
+	}
+	if !cf.Archived && conv.Archived != nil && *conv.Archived {
+		return false
+	}
+	if waid.MatchJIDPatterns(cf.Exclude, conv.ChatJID) {
+		return false
+	}
+	return len(cf.Include) == 0 || waid.MatchJIDPatterns(cf.Include, conv.ChatJID)
+}
+
+func (wa *WhatsAppClient) getFilteredConversations(ctx context.Context, limit int, loginTS jsontime.Unix) ([]*wadb.Conversation, error) {
+	filter := &wa.Main.Config.HistorySync.ConversationFilter
+	loginFilter := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).ChatFilter
+	if filter.isEmpty() && loginFilter.IsEmpty() {
+		return wa.Main.DB.Conversation.GetRecent(ctx, wa.UserLogin.ID, limit, loginTS)
+	}
+	conversations, err := wa.Main.DB.Conversation.GetRecent(ctx, wa.UserLogin.ID, -1, loginTS)
+	if err != nil {
+		return nil, err
+	}
+	filtered := conversations[:0]
+	for _, conv := range conversations {
+		if filter.Allows(conv) && loginFilter.Allows(conv.ChatJID) {
+			filtered = append(filtered, conv)
+		}
+	}
+	if limit >= 0 && len(filtered) > limit {
+		filtered = filtered[:limit]
+	}
+	return filtered, nil
+}
+
+func (wa *WhatsAppClient) getConversationName(ctx context.Context, conv *wadb.Conversation) string {
+	if conv.Name != "" {
+		return conv.Name
+	}
+	switch conv.ChatJID.Server {
+	case types.DefaultUserServer, types.HiddenUserServer:
+		contact, err := wa.GetStore().Contacts.GetContact(ctx, conv.ChatJID)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Stringer("chat_jid", conv.ChatJID).Msg("Failed to get contact info for conversation name")
+		} else if name := cmp.Or(contact.FullName, contact.BusinessName, contact.PushName); name != "" {
+			return name
+		}
+		if conv.ChatJID.Server == types.DefaultUserServer {
+			return "+" + conv.ChatJID.User
+		}
+	case types.StatusBroadcastJID.Server:
+		if conv.ChatJID == types.StatusBroadcastJID {
+			return StatusBroadcastName
+		}
+	}
+	return conv.ChatJID.User
+}
+
+func (wa *WhatsAppClient) wrapHistorySyncConversation(ctx context.Context, conv *wadb.Conversation) *HistorySyncConversation {
+	return &HistorySyncConversation{
+		ChatJID:              conv.ChatJID,
+		Name:                 wa.getConversationName(ctx, conv),
+		LastMessageTimestamp: jsontime.U(conv.LastMessageTimestamp),
+		UnreadCount:          ptr.Val(conv.UnreadCount),
+		Archived:             ptr.Val(conv.Archived),
+		Pinned:               ptr.Val(conv.Pinned),
+		IsGroup:              conv.ChatJID.Server == types.GroupServer,
+	}
+}
+
+// GetPendingHistorySyncConversations returns the conversations from the history sync
+// which pass the conversation filter and haven't been bridged for the current login yet.
+func (wa *WhatsAppClient) GetPendingHistorySyncConversations(ctx context.Context) ([]*HistorySyncConversation, error) {
+	loginTS := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).LoggedInAt
+	conversations, err := wa.getFilteredConversations(ctx, -1, loginTS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversations from database: %w", err)
+	}
+	wrapped := make([]*HistorySyncConversation, len(conversations))
+	for i, conv := range conversations {
+		wrapped[i] = wa.wrapHistorySyncConversation(ctx, conv)
+	}
+	return wrapped, nil
+}
+
+// BridgeHistorySyncConversations creates portals for the given history sync conversations in the background.
+// Chats that aren't pending or are blocked by the conversation filter are ignored.
+// The returned number is the number of portals that will be created.
+func (wa *WhatsAppClient) BridgeHistorySyncConversations(ctx context.Context, chatJIDs []types.JID) (int, error) {
+	loginTS := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).LoggedInAt
+	pending, err := wa.getFilteredConversations(ctx, -1, loginTS)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get conversations from database: %w", err)
+	}
+	selected := make([]*wadb.Conversation, 0, len(chatJIDs))
+	for _, conv := range pending {
+		if slices.Contains(chatJIDs, conv.ChatJID) {
+			selected = append(selected, conv)
+		}
+	}
+	if len(selected) == 0 {
+		return 0, nil
+	}
+	log := wa.UserLogin.Log.With().
+		Str("action", "create selected portals from history sync").
+		Logger()
+	log.Info().
+		Int("conversation_count", len(selected)).
+		Int64("login_timestamp", loginTS.Unix()).
+		Msg("Creating portals for selected history sync conversations")
+	go wa.createPortalsForConversations(log.WithContext(wa.Main.Bridge.BackgroundCtx), selected, loginTS, nil)
+	return len(selected), nil
+}
+
+// sendHistorySyncSelectionNotice sends the list of chats that can be bridged to the management room. Portal creation
+// runs again after every history sync payload, so the first notice is edited afterwards instead of sending new ones.
+func (wa *WhatsAppClient) sendHistorySyncSelectionNotice(ctx context.Context, conversations []*wadb.Conversation) {
+	log := zerolog.Ctx(ctx)
+	log.Info().
+		Int("conversation_count", len(conversations)).
+		Msg("Selective sync enabled, asking user to choose which chats to bridge")
+	if len(conversations) == 0 {
+		return
+	}
+	wrapped := make([]*HistorySyncConversation, len(conversations))
+	for i, conv := range conversations {
+		wrapped[i] = wa.wrapHistorySyncConversation(ctx, conv)
+	}
+	wa.hsSelection.lock.Lock()
+	defer wa.hsSelection.lock.Unlock()
+	if wa.hsSelection.noticeID != "" && slices.EqualFunc(wa.hsSelection.shown, wrapped, func(jid types.JID, conv *HistorySyncConversation) bool {
+		return jid == conv.ChatJID
+	}) {
+		return
+	}
+	if wa.hsSelection.noticeRoom == "" {
+		roomID, err := wa.UserLogin.User.GetManagementRoom(ctx)
+		if err != nil {
+			log.Err(err).Msg("Failed to get management room to send history sync chat list")
+			return
+		}
+		wa.hsSelection.noticeRoom = roomID
+	}
+	text := fmt.Sprintf(
+		"History sync received %d chats that can be bridged:\n\n%s\n\n"+
+			"Use `history-sync bridge <numbers or JIDs...>` to choose which chats to bridge, "+
+			"or `history-sync bridge all` to bridge all of them.",
+		len(wrapped), formatHistorySyncConversations(wrapped, 0, historySyncListPageSize),
+	)
+	content := format.RenderMarkdown(text, true, false)
+	content.MsgType = event.MsgNotice
+	if wa.hsSelection.noticeID != "" {
+		content.SetEdit(wa.hsSelection.noticeID)
+	}
+	resp, err := wa.Main.Bridge.Bot.SendMessage(ctx, wa.hsSelection.noticeRoom, event.EventMessage, &event.Content{
+		Parsed: &content,
+	}, nil)
+	if err != nil {
+		log.Err(err).Msg("Failed to send history sync chat list")
+		return
+	}
+	if wa.hsSelection.noticeID == "" {
+		wa.hsSelection.noticeID = resp.EventID
+	}
+	wa.hsSelection.shown = make([]types.JID, len(wrapped))
+	for i, conv := range wrapped {
+		wa.hsSelection.shown[i] = conv.ChatJID
+	}
+}
+
+const historySyncListPageSize = 50
+
+func formatHistorySyncConversations(conversations []*HistorySyncConversation, offset, limit int) string {
+	var buf strings.Builder
+	end := min(offset+limit, len(conversations))
+	for i := offset; i < end; i++ {
+		conv := conversations[i]
+		if i > offset {
+			buf.WriteByte('\n')
+		}
+		_, _ = fmt.Fprintf(&buf, "%d. **%s** (`%s`)", i+1, conv.Name, conv.ChatJID)
+		var details []string
+		if !conv.LastMessageTimestamp.IsZero() {
+			details = append(details, "last message "+conv.LastMessageTimestamp.Time.Format(time.DateTime))
+		}
+		if conv.UnreadCount > 0 {
+			details = append(details, fmt.Sprintf("%d unread", conv.UnreadCount))
+		}
+		if conv.Archived {
+			details = append(details, "archived")
+		}
+		if len(details) > 0 {
+			buf.WriteString(" - ")
+			buf.WriteString(strings.Join(details, ", "))
+		}
+	}
+	if end < len(conversations) {
+		_, _ = fmt.Fprintf(&buf, "\n\n...and %d more", len(conversations)-end)
+	}
+	return buf.String()
+}
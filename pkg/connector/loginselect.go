@@ -0,0 +1,128 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"maps"
+	"net/http"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+var (
+	ErrLoginNotFound = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.LOGIN_NOT_FOUND",
+		Err:        "That login doesn't exist or doesn't belong to you",
+		StatusCode: http.StatusNotFound,
+	}
+	ErrPortalNotShared = bridgev2.RespError{
+		ErrCode:    "FI.MAU.WHATSAPP.PORTAL_NOT_SHARED",
+		Err:        "This chat belongs to a single login, so a preferred login can't be set",
+		StatusCode: http.StatusBadRequest,
+	}
+)
+
+// GetPreferredLogin returns the login the given user prefers to use in the portal,
+// or nil if there's no preference or the preferred login isn't available.
+func (wa *WhatsAppConnector) GetPreferredLogin(portal *bridgev2.Portal, userID id.UserID) *bridgev2.UserLogin {
+	if portal == nil || portal.Receiver != "" {
+		return nil
+	}
+	loginID, ok := portal.Metadata.(*waid.PortalMetadata).PreferredLogins[userID]
+	if !ok {
+		return nil
+	}
+	login := wa.Bridge.GetCachedUserLoginByID(loginID)
+	if login == nil || login.UserMXID != userID {
+		return nil
+	}
+	return login
+}
+
+// SetPreferredLogin sets the login the given user wants to use in the portal.
+// An empty login ID removes the preference.
+func (wa *WhatsAppConnector) SetPreferredLogin(ctx context.Context, portal *bridgev2.Portal, userID id.UserID, loginID networkid.UserLoginID) error {
+	if portal.Receiver != "" {
+		return ErrPortalNotShared
+	}
+	meta := portal.Metadata.(*waid.PortalMetadata)
+	if loginID == "" {
+		if _, ok := meta.PreferredLogins[userID]; !ok {
+			return nil
+		}
+		meta.PreferredLogins = maps.Clone(meta.PreferredLogins)
+		delete(meta.PreferredLogins, userID)
+	} else {
+		login := wa.Bridge.GetCachedUserLoginByID(loginID)
+		if login == nil || login.UserMXID != userID {
+			return ErrLoginNotFound
+		}
+		meta.PreferredLogins = maps.Clone(meta.PreferredLogins)
+		if meta.PreferredLogins == nil {
+			meta.PreferredLogins = make(map[id.UserID]networkid.UserLoginID)
+		}
+		meta.PreferredLogins[userID] = loginID
+	}
+	return portal.Save(ctx)
+}
+
+// SelectLogin picks the login to use for a user. An explicitly requested login takes priority,
+// followed by the preferred login of the portal and finally the user's default login.
+func (wa *WhatsAppConnector) SelectLogin(user *bridgev2.User, portal *bridgev2.Portal, explicit networkid.UserLoginID) (*bridgev2.UserLogin, error) {
+	if explicit != "" {
+		login := wa.Bridge.GetCachedUserLoginByID(explicit)
+		if login == nil || login.UserMXID != user.MXID {
+			return nil, ErrLoginNotFound
+		}
+		return login, nil
+	} else if login := wa.GetPreferredLogin(portal, user.MXID); login != nil {
+		return login, nil
+	} else if login = user.GetDefaultLogin(); login != nil {
+		return login, nil
+	}
+	return nil, ErrLoginNotFound
+}
+
+// preferredClientFor returns the client of the sender's preferred login in the portal if it's a different
+// logged-in login than the current one and it's in the chat. Messages, edits, reactions, deletions, poll votes,
+// read receipts and typing notifications are all routed through the preferred login.
+func (wa *WhatsAppClient) preferredClientFor(ctx context.Context, portal *bridgev2.Portal, sender id.UserID) *WhatsAppClient {
+	login := wa.Main.GetPreferredLogin(portal, sender)
+	if login == nil || login.ID == wa.UserLogin.ID {
+		return nil
+	}
+	client, ok := login.Client.(*WhatsAppClient)
+	if !ok || !client.IsLoggedIn() {
+		return nil
+	}
+	log := zerolog.Ctx(ctx).With().Str("preferred_login_id", string(login.ID)).Logger()
+	userPortal, err := wa.Main.Bridge.DB.UserPortal.Get(ctx, login.UserLogin, portal.PortalKey)
+	if err != nil {
+		log.Err(err).Msg("Failed to check if preferred login is in portal, using current login")
+		return nil
+	} else if userPortal == nil {
+		log.Debug().Msg("Preferred login isn't in portal, using current login")
+		return nil
+	}
+	return client
+}
@@ -18,10 +18,13 @@ package connector
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
 	"github.com/rs/zerolog"
 	"go.mau.fi/whatsmeow/types"
@@ -67,9 +70,38 @@ func (wa *WhatsAppClient) processFailedMedia(ctx context.Context, portalKey netw
 	return nil
 }
 
+const mediaRequestRetryCheckInterval = 1 * time.Minute
+
 func (wa *WhatsAppClient) mediaRequestLoop(ctx context.Context) {
 	log := wa.UserLogin.Log.With().Str("loop", "media requests").Logger()
 	ctx = log.WithContext(ctx)
+	var retryTick <-chan time.Time
+	if wa.Main.Config.HistorySync.MediaRequests.MaxRetries > 0 {
+		retryTicker := time.NewTicker(mediaRequestRetryCheckInterval)
+		defer retryTicker.Stop()
+		retryTick = retryTicker.C
+	}
+	var dailyTimer *time.Timer
+	var dailyTick <-chan time.Time
+	if wa.Main.Config.HistorySync.MediaRequests.RequestMethod == MediaRequestMethodLocalTime {
+		dailyTimer = time.NewTimer(wa.getMediaRequestStartDelay())
+		defer dailyTimer.Stop()
+		dailyTick = dailyTimer.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-dailyTick:
+			dailyTimer.Reset(24 * time.Hour)
+			wa.sendMediaRequests(ctx)
+		case <-retryTick:
+			wa.retryMediaRequests(ctx)
+		}
+	}
+}
+
+func (wa *WhatsAppClient) getMediaRequestStartDelay() time.Duration {
 	tzName := wa.UserLogin.Metadata.(*waid.UserLoginMetadata).Timezone
 	userTz, err := time.LoadLocation(tzName)
 	var startIn time.Duration
@@ -84,37 +116,100 @@ func (wa *WhatsAppClient) mediaRequestLoop(ctx context.Context) {
 	} else {
 		startIn = 8 * time.Hour
 	}
-	select {
-	case <-ctx.Done():
+	return startIn
+}
+
+func (wa *WhatsAppClient) sendMediaRequests(ctx context.Context) {
+	reqs, err := wa.Main.DB.MediaRequest.GetUnrequestedForUserLogin(ctx, wa.UserLogin.ID)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get media requests from database")
+		return
+	} else if len(reqs) == 0 {
 		return
-	case <-time.After(startIn):
 	}
-	ticker := time.NewTicker(24 * time.Hour)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
+	zerolog.Ctx(ctx).Info().Int("request_count", len(reqs)).Msg("Sending media requests")
+	for _, req := range reqs {
+		if ctx.Err() != nil {
 			return
-		case <-ticker.C:
-			wa.sendMediaRequests(ctx)
 		}
+		wa.sendMediaRequest(ctx, req)
 	}
 }
 
-func (wa *WhatsAppClient) sendMediaRequests(ctx context.Context) {
-	reqs, err := wa.Main.DB.MediaRequest.GetUnrequestedForUserLogin(ctx, wa.UserLogin.ID)
+func (wa *WhatsAppClient) retryMediaRequests(ctx context.Context) {
+	maxAttempts := wa.Main.Config.HistorySync.MediaRequests.MaxRetries + 1
+	reqs, err := wa.Main.DB.MediaRequest.GetRetryableForUserLogin(ctx, wa.UserLogin.ID, maxAttempts)
 	if err != nil {
-		zerolog.Ctx(ctx).Err(err).Msg("Failed to get media requests from database")
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to get retryable media requests from database")
 		return
 	} else if len(reqs) == 0 {
 		return
 	}
-	zerolog.Ctx(ctx).Info().Int("request_count", len(reqs)).Msg("Sending media requests")
+	zerolog.Ctx(ctx).Info().Int("request_count", len(reqs)).Msg("Retrying failed media requests")
 	for _, req := range reqs {
+		if ctx.Err() != nil {
+			return
+		}
 		wa.sendMediaRequest(ctx, req)
 	}
 }
 
+// waitMediaRequestRateLimit blocks until enough time has passed since the previous automatic media request.
+// The next free slot is reserved while holding the lock, but the waiting happens after releasing it,
+// so concurrent callers wait for their own slots in parallel.
+func (wa *WhatsAppClient) waitMediaRequestRateLimit(ctx context.Context) error {
+	wa.mediaRequestLock.Lock()
+	slot := wa.lastMediaRequest.Add(wa.Main.Config.HistorySync.MediaRequests.RequestInterval)
+	if now := time.Now(); slot.Before(now) {
+		slot = now
+	}
+	wa.lastMediaRequest = slot
+	wa.mediaRequestLock.Unlock()
+	if wait := time.Until(slot); wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// markMediaRequestFailed sets the request status to failed and schedules the next retry with exponential backoff.
+func (wa *WhatsAppClient) markMediaRequestFailed(req *wadb.MediaRequest, err error) {
+	req.Status = wadb.MediaBackfillRequestStatusRequestFailed
+	req.Error = err.Error()
+	backoff := wa.Main.Config.HistorySync.MediaRequests.RetryBackoff << max(req.Attempts-1, 0)
+	req.NextAttempt = time.Now().Add(backoff)
+}
+
+// handleMediaRequestResult updates the media request of a message after the phone has responded to the retry receipt.
+func (wa *WhatsAppClient) handleMediaRequestResult(ctx context.Context, msgID networkid.MessageID, err error, retryable bool) {
+	log := zerolog.Ctx(ctx)
+	req, dbErr := wa.Main.DB.MediaRequest.Get(ctx, wa.UserLogin.ID, msgID)
+	if dbErr != nil {
+		log.Err(dbErr).Msg("Failed to get media request from database")
+		return
+	} else if req == nil {
+		return
+	}
+	if err == nil {
+		req.Status = wadb.MediaBackfillRequestStatusRequested
+		req.Error = ""
+		req.NextAttempt = time.Time{}
+	} else {
+		wa.markMediaRequestFailed(req, err)
+		if !retryable {
+			req.Attempts = max(req.Attempts, wa.Main.Config.HistorySync.MediaRequests.MaxRetries+1)
+			req.NextAttempt = time.Time{}
+		}
+	}
+	dbErr = wa.Main.DB.MediaRequest.Put(ctx, req)
+	if dbErr != nil {
+		log.Err(dbErr).Msg("Failed to save media request status")
+	}
+}
+
 func (wa *WhatsAppClient) sendMediaRequest(ctx context.Context, req *wadb.MediaRequest) {
 	log := zerolog.Ctx(ctx).With().Str("action", "send media request").Str("message_id", string(req.MessageID)).Logger()
 	defer func() {
@@ -137,11 +232,15 @@ func (wa *WhatsAppClient) sendMediaRequest(ctx context.Context, req *wadb.MediaR
 		req.Status = wadb.MediaBackfillRequestStatusRequestSkipped
 		return
 	}
+	err = wa.waitMediaRequestRateLimit(ctx)
+	if err != nil {
+		return
+	}
+	req.Attempts++
 	err = wa.sendMediaRequestDirect(ctx, req.MessageID, req.MediaKey)
 	if err != nil {
-		log.Err(err).Msg("Failed to send media retry request")
-		req.Status = wadb.MediaBackfillRequestStatusRequestFailed
-		req.Error = err.Error()
+		log.Err(err).Int("attempts", req.Attempts).Msg("Failed to send media retry request")
+		wa.markMediaRequestFailed(req, err)
 	} else {
 		log.Debug().Msg("Sent media retry request")
 		req.Status = wadb.MediaBackfillRequestStatusRequested
@@ -163,3 +262,55 @@ func (wa *WhatsAppClient) sendMediaRequestDirect(ctx context.Context, rawMsgID n
 		},
 	}, key)
 }
+
+func (wa *WhatsAppClient) notifyMediaRetryWaiter(msgID networkid.MessageID, err error) {
+	wa.mediaRetryWaitLock.Lock()
+	defer wa.mediaRetryWaitLock.Unlock()
+	if ch, ok := wa.mediaRetryWaiters[msgID]; ok {
+		ch <- err
+		delete(wa.mediaRetryWaiters, msgID)
+	}
+}
+
+const redownloadMediaTimeout = 1 * time.Minute
+
+var errRedownloadTimeout = errors.New("phone did not respond in time")
+
+// RedownloadMedia asks the phone to reupload the media in the given message and waits until
+// the failed media event has been replaced with the reuploaded file.
+func (wa *WhatsAppClient) RedownloadMedia(ctx context.Context, msg *database.Message) error {
+	meta := msg.Metadata.(*waid.MessageMetadata)
+	if meta.Error != waid.MsgErrMediaNotFound || meta.FailedMediaMeta == nil {
+		return errors.New("message doesn't have failed media")
+	}
+	var mediaMeta msgconv.PreparedMedia
+	err := json.Unmarshal(meta.FailedMediaMeta, &mediaMeta)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal media metadata: %w", err)
+	} else if mediaMeta.FailedKeys == nil {
+		return errors.New("message doesn't have media keys")
+	}
+	ch := make(chan error, 1)
+	wa.mediaRetryWaitLock.Lock()
+	wa.mediaRetryWaiters[msg.ID] = ch
+	wa.mediaRetryWaitLock.Unlock()
+	defer func() {
+		wa.mediaRetryWaitLock.Lock()
+		if wa.mediaRetryWaiters[msg.ID] == ch {
+			delete(wa.mediaRetryWaiters, msg.ID)
+		}
+		wa.mediaRetryWaitLock.Unlock()
+	}()
+	err = wa.sendMediaRequestDirect(ctx, msg.ID, mediaMeta.FailedKeys.Key)
+	if err != nil {
+		return fmt.Errorf("failed to send media retry request: %w", err)
+	}
+	select {
+	case err = <-ch:
+		return err
+	case <-time.After(redownloadMediaTimeout):
+		return errRedownloadTimeout
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -112,7 +112,7 @@ func (wa *WhatsAppClient) phoneSeen(ts time.Time) {
 		prevStateError := wa.UserLogin.BridgeState.GetPrev().Error
 		if prevStateError == WAPhoneOffline && isConnected {
 			log.Debug().Msg("Saw phone after current bridge state said it has been offline, switching state back to connected")
-			wa.UserLogin.BridgeState.Send(status.BridgeState{StateEvent: status.StateConnected})
+			wa.sendBridgeState(status.BridgeState{StateEvent: status.StateConnected})
 		} else {
 			log.Debug().
 				Bool("is_connected", isConnected).
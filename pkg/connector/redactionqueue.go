@@ -0,0 +1,138 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	mautrix "github.com/iKonoTelecomunicaciones/go"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+)
+
+const (
+	redactionBatchSize    = 50
+	redactionPollInterval = 1 * time.Minute
+	redactionRetryDelay   = 15 * time.Minute
+	redactionMaxAttempts  = 10
+)
+
+// redactionQueue redacts Matrix events stored in a database table once they're due. Failed redactions are retried
+// after redactionRetryDelay, until they fail permanently or redactionMaxAttempts is reached.
+type redactionQueue[T any] struct {
+	name   string
+	reason string
+	bridge *bridgev2.Bridge
+	wakeup chan struct{}
+
+	getDue     func(ctx context.Context, limit int) ([]T, error)
+	target     func(item T) (roomID id.RoomID, eventID id.EventID, attempts int)
+	reschedule func(ctx context.Context, eventID id.EventID, ts time.Time) error
+	delete     func(ctx context.Context, eventID id.EventID) error
+	// afterRedact is called after an event is redacted successfully, before it's removed from the queue.
+	afterRedact func(ctx context.Context, item T)
+}
+
+func (rq *redactionQueue[T]) wake() {
+	select {
+	case rq.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (rq *redactionQueue[T]) loop(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("loop", rq.name).Logger()
+	ctx = log.WithContext(ctx)
+	ticker := time.NewTicker(redactionPollInterval)
+	defer ticker.Stop()
+	ctxDone := ctx.Done()
+	for {
+		rq.redactDue(ctx)
+		select {
+		case <-ticker.C:
+		case <-rq.wakeup:
+		case <-ctxDone:
+			return
+		}
+	}
+}
+
+func (rq *redactionQueue[T]) redactDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		due, err := rq.getDue(ctx, redactionBatchSize)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to get due redactions")
+			return
+		}
+		for _, item := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			rq.redact(ctx, item)
+		}
+		if len(due) < redactionBatchSize {
+			return
+		}
+	}
+}
+
+func (rq *redactionQueue[T]) redact(ctx context.Context, item T) {
+	roomID, eventID, attempts := rq.target(item)
+	log := zerolog.Ctx(ctx).With().
+		Stringer("room_id", roomID).
+		Stringer("event_id", eventID).
+		Logger()
+	ctx = log.WithContext(ctx)
+	_, err := rq.bridge.Bot.SendMessage(ctx, roomID, event.EventRedaction, &event.Content{
+		Parsed: &event.RedactionEventContent{
+			Redacts: eventID,
+			Reason:  rq.reason,
+		},
+	}, nil)
+	if err != nil && !isPermanentRedactionError(err) && attempts+1 < redactionMaxAttempts {
+		log.Err(err).Int("attempts", attempts+1).Msg("Failed to redact event")
+		err = rq.reschedule(ctx, eventID, time.Now().Add(redactionRetryDelay))
+		if err != nil {
+			log.Err(err).Msg("Failed to reschedule redaction")
+		}
+		return
+	} else if err != nil {
+		log.Err(err).Int("attempts", attempts+1).Msg("Giving up on redacting event")
+	} else {
+		if rq.afterRedact != nil {
+			rq.afterRedact(ctx, item)
+		}
+		log.Debug().Msg("Redacted event")
+	}
+	err = rq.delete(ctx, eventID)
+	if err != nil {
+		log.Err(err).Msg("Failed to delete redaction from queue")
+	}
+}
+
+// isPermanentRedactionError returns true if retrying a failed redaction won't help,
+// e.g. because the event or room is gone or the bot lost the permission to redact.
+func isPermanentRedactionError(err error) bool {
+	return errors.Is(err, mautrix.MForbidden) ||
+		errors.Is(err, mautrix.MNotFound) ||
+		errors.Is(err, mautrix.MBadJSON) ||
+		errors.Is(err, mautrix.MInvalidParam)
+}
@@ -0,0 +1,252 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+
+	mautrix "github.com/iKonoTelecomunicaciones/go"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
+	"go.mau.fi/util/variationselector"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+type RelaySenderParams struct {
+	UserID            id.UserID
+	DisplayName       string
+	DisambiguatedName string
+}
+
+type RelayTemplateParams struct {
+	Sender   RelaySenderParams
+	Message  string
+	FileName string
+	MsgType  event.MessageType
+	Emoji    string
+}
+
+// ValidateRelayTemplates checks that all non-empty templates can be parsed.
+func ValidateRelayTemplates(rt *waid.RelayTemplates) error {
+	for name, tpl := range map[string]string{
+		"text":     rt.Text,
+		"caption":  rt.Caption,
+		"edit":     rt.Edit,
+		"reaction": rt.Reaction,
+	} {
+		if tpl == "" {
+			continue
+		} else if _, err := template.New(name).Parse(tpl); err != nil {
+			return bridgev2.WrapRespErr(fmt.Errorf("invalid %s relay template: %w", name, err), mautrix.MInvalidParam)
+		}
+	}
+	return nil
+}
+
+type relayTemplateKey struct {
+	name, tpl string
+}
+
+// parseRelayTemplate parses a relay template. Parsed templates are cached by their source, so templates from the
+// config and from portals are only parsed once instead of on every relayed event.
+func (wa *WhatsAppConnector) parseRelayTemplate(name, tpl string) (*template.Template, error) {
+	key := relayTemplateKey{name: name, tpl: tpl}
+	wa.relayTemplateLock.Lock()
+	defer wa.relayTemplateLock.Unlock()
+	if parsed, ok := wa.relayTemplates[key]; ok {
+		return parsed, nil
+	}
+	parsed, err := template.New(name).Parse(tpl)
+	if err != nil {
+		return nil, err
+	}
+	if wa.relayTemplates == nil {
+		wa.relayTemplates = make(map[relayTemplateKey]*template.Template)
+	}
+	wa.relayTemplates[key] = parsed
+	return parsed, nil
+}
+
+func (wa *WhatsAppConnector) executeRelayTemplate(name, tpl string, params *RelayTemplateParams) (string, error) {
+	parsed, err := wa.parseRelayTemplate(name, tpl)
+	if err != nil {
+		return "", err
+	}
+	var buf strings.Builder
+	err = parsed.Execute(&buf, params)
+	return buf.String(), err
+}
+
+// GetRelayTemplates returns the relay templates of the portal merged on top of the config defaults.
+func (wa *WhatsAppConnector) GetRelayTemplates(portal *bridgev2.Portal) *waid.RelayTemplates {
+	return wa.Config.RelayTemplates.Merge(portal.Metadata.(*waid.PortalMetadata).RelayTemplates)
+}
+
+// SetRelayTemplates overrides the relay templates of the portal. Nil or empty templates reset the portal to the defaults.
+func (wa *WhatsAppConnector) SetRelayTemplates(ctx context.Context, portal *bridgev2.Portal, templates *waid.RelayTemplates) error {
+	meta := portal.Metadata.(*waid.PortalMetadata)
+	if templates.IsEmpty() {
+		if meta.RelayTemplates == nil {
+			return nil
+		}
+		meta.RelayTemplates = nil
+	} else if err := ValidateRelayTemplates(templates); err != nil {
+		return err
+	} else {
+		meta.RelayTemplates = ptr.Clone(templates)
+	}
+	return portal.Save(ctx)
+}
+
+func relaySenderParamsFromOrig(sender *bridgev2.OrigSender) RelaySenderParams {
+	params := RelaySenderParams{
+		UserID:            sender.UserID,
+		DisplayName:       sender.Displayname,
+		DisambiguatedName: sender.DisambiguatedName,
+	}
+	if params.DisplayName == "" {
+		params.DisplayName = sender.UserID.String()
+	}
+	if params.DisambiguatedName == "" {
+		params.DisambiguatedName = params.DisplayName
+	}
+	return params
+}
+
+// applyRelayTemplate formats a message from a relayed user using the relay templates of the portal.
+// The relayed content is returned as-is if there's no matching template.
+func (wa *WhatsAppConnector) applyRelayTemplate(
+	ctx context.Context,
+	portal *bridgev2.Portal,
+	sender *bridgev2.OrigSender,
+	orig, relayed *event.MessageEventContent,
+	isEdit bool,
+) *event.MessageEventContent {
+	if orig == nil {
+		return relayed
+	}
+	templates := wa.GetRelayTemplates(portal)
+	params := &RelayTemplateParams{
+		Sender:  relaySenderParamsFromOrig(sender),
+		MsgType: orig.MsgType,
+	}
+	var name, tpl string
+	switch orig.MsgType {
+	case event.MsgText, event.MsgNotice, event.MsgEmote:
+		name, tpl = "text", templates.Text
+		if isEdit && templates.Edit != "" {
+			name, tpl = "edit", templates.Edit
+		}
+		params.Message = orig.Body
+	case event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
+		name, tpl = "caption", templates.Caption
+		if isEdit && templates.Edit != "" {
+			name, tpl = "edit", templates.Edit
+		}
+		params.Message = orig.GetCaption()
+		params.FileName = orig.GetFileName()
+	}
+	if tpl == "" {
+		return relayed
+	}
+	text, err := wa.executeRelayTemplate(name, tpl, params)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("template", name).Msg("Failed to execute relay template, using default format")
+		return relayed
+	}
+	content := ptr.Clone(relayed)
+	if text != content.Body {
+		// The formatted body doesn't match the templated text anymore, so it has to be dropped
+		content.Body = text
+		content.Format = ""
+		content.FormattedBody = ""
+	}
+	if params.FileName != "" {
+		content.FileName = params.FileName
+	}
+	return content
+}
+
+var ErrRelayedReactionsDisabled = bridgev2.WrapErrorInStatus(errors.New("reactions from relayed users are not enabled in this room")).WithErrorAsMessage().WithIsCertain(true).WithSendNotice(false).WithErrorReason(event.MessageStatusUnsupported)
+
+// relayedReactionSenderID returns the sender ID used for reactions of a relayed user.
+// Each relayed user gets their own ID, so that their reactions don't replace each other.
+func relayedReactionSenderID(userID id.UserID) networkid.UserID {
+	return networkid.UserID("relayed:" + string(userID))
+}
+
+func (wa *WhatsAppClient) preHandleRelayedReaction(msg *bridgev2.MatrixReaction) (bridgev2.MatrixReactionPreResponse, error) {
+	templates := wa.Main.GetRelayTemplates(msg.Portal)
+	if templates.ReactionsAsText == nil || !*templates.ReactionsAsText || templates.Reaction == "" {
+		return bridgev2.MatrixReactionPreResponse{}, ErrRelayedReactionsDisabled
+	}
+	return bridgev2.MatrixReactionPreResponse{
+		SenderID:     relayedReactionSenderID(msg.Event.Sender),
+		Emoji:        variationselector.Remove(msg.Content.RelatesTo.Key),
+		MaxReactions: 1,
+	}, nil
+}
+
+// handleRelayedReaction sends a reaction from a relayed user as a text reply to the reacted message,
+// as WhatsApp reactions can't show who reacted through the relay.
+func (wa *WhatsAppClient) handleRelayedReaction(ctx context.Context, msg *bridgev2.MatrixReaction, portalJID types.JID) (*database.Reaction, error) {
+	templates := wa.Main.GetRelayTemplates(msg.Portal)
+	text, err := wa.Main.executeRelayTemplate("reaction", templates.Reaction, &RelayTemplateParams{
+		Sender: relaySenderParamsFromOrig(msg.OrigSender),
+		Emoji:  msg.Content.RelatesTo.Key,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute relay reaction template: %w", err)
+	}
+	msgEvt := &event.Event{
+		Type:      event.EventMessage,
+		ID:        msg.Event.ID,
+		RoomID:    msg.Event.RoomID,
+		Sender:    msg.Event.Sender,
+		Timestamp: msg.Event.Timestamp,
+	}
+	msgContent := &event.MessageEventContent{MsgType: event.MsgText, Body: text}
+	waMsg, _, err := wa.Main.MsgConv.ToWhatsApp(ctx, wa.Client, msgEvt, msgContent, msg.TargetMessage, nil, msg.Portal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert relayed reaction: %w", err)
+	}
+	resp, err := wa.Client.SendMessage(ctx, portalJID, waMsg, whatsmeow.SendRequestExtra{
+		ID: wa.Client.GenerateMessageID(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	zerolog.Ctx(ctx).Debug().Str("message_id", resp.ID).Msg("Sent relayed reaction as text")
+	return &database.Reaction{
+		Metadata: &waid.ReactionMetadata{
+			SenderDeviceID:   wa.JID.Device,
+			RelayedMessageID: resp.ID,
+		},
+	}, nil
+}
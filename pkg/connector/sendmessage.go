@@ -0,0 +1,141 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/simplevent"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+const (
+	// SentMessageStatusSent means the WhatsApp server acknowledged the message and it was bridged to Matrix.
+	SentMessageStatusSent = "sent"
+	// SentMessageStatusNotBridged means the WhatsApp server acknowledged the message,
+	// but bridging it into the Matrix room failed.
+	SentMessageStatusNotBridged = "sent_not_bridged"
+)
+
+// SentMessage is the result of sending a message with SendMessageToPortal.
+type SentMessage struct {
+	MessageID   types.MessageID     `json:"message_id"`
+	BridgeID    networkid.MessageID `json:"bridge_message_id"`
+	ChatJID     types.JID           `json:"chat_jid"`
+	Timestamp   time.Time           `json:"timestamp"`
+	Status      string              `json:"status"`
+	MatrixError string              `json:"matrix_error,omitempty"`
+}
+
+// SendMessageToPortal converts the given Matrix message content to a WhatsApp message using the normal
+// Matrix->WhatsApp path and sends it to the portal without requiring a Matrix event.
+//
+// After sending, the same content is bridged into the Matrix room as a message from the user,
+// so any media in the content must already be uploaded to Matrix.
+func (wa *WhatsAppClient) SendMessageToPortal(ctx context.Context, portal *bridgev2.Portal, content *event.MessageEventContent) (*SentMessage, error) {
+	if wa.Client == nil || !wa.Client.IsLoggedIn() {
+		return nil, bridgev2.ErrNotLoggedIn
+	}
+	chatJID, err := waid.ParsePortalID(portal.ID)
+	if err != nil {
+		return nil, err
+	} else if chatJID == types.StatusBroadcastJID && wa.Main.Config.DisableStatusBroadcastSend {
+		return nil, ErrBroadcastSendDisabled
+	}
+	evt := &event.Event{
+		Type:   event.EventMessage,
+		RoomID: portal.MXID,
+		Content: event.Content{
+			Parsed: content,
+		},
+	}
+	waMsg, req, err := wa.Main.MsgConv.ToWhatsApp(ctx, wa.Client, evt, content, nil, nil, portal)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert message: %w", err)
+	}
+	if req == nil {
+		req = &whatsmeow.SendRequestExtra{}
+	}
+	req.ID = wa.Client.GenerateMessageID()
+	resp, err := wa.Client.SendMessage(ctx, chatJID, waMsg, *req)
+	if err != nil {
+		return nil, err
+	}
+	sender := resp.Sender
+	if sender.IsEmpty() {
+		sender = wa.JID.ToNonAD()
+	}
+	zerolog.Ctx(ctx).Debug().
+		Stringer("chat_jid", chatJID).
+		Str("message_id", resp.ID).
+		Msg("Sent message without Matrix event")
+	sent := &SentMessage{
+		MessageID: resp.ID,
+		BridgeID:  waid.MakeMessageID(chatJID, sender, resp.ID),
+		ChatJID:   chatJID,
+		Timestamp: resp.Timestamp,
+		Status:    SentMessageStatusSent,
+	}
+	res := wa.UserLogin.QueueRemoteEvent(&simplevent.Message[*event.MessageEventContent]{
+		EventMeta: simplevent.EventMeta{
+			Type: bridgev2.RemoteEventMessage,
+			LogContext: func(c zerolog.Context) zerolog.Context {
+				return c.Str("message_id", resp.ID).Str("source", "send_message_to_portal")
+			},
+			PortalKey:    portal.PortalKey,
+			Sender:       wa.makeEventSender(ctx, sender),
+			CreatePortal: true,
+			Timestamp:    resp.Timestamp,
+		},
+		Data: content,
+		ID:   sent.BridgeID,
+		ConvertMessageFunc: func(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, content *event.MessageEventContent) (*bridgev2.ConvertedMessage, error) {
+			return convertSentMessage(sender, content), nil
+		},
+	})
+	if !res.Success {
+		sent.Status = SentMessageStatusNotBridged
+		sent.MatrixError = "failed to bridge sent message to Matrix"
+		if res.Error != nil {
+			sent.MatrixError = fmt.Sprintf("%s: %v", sent.MatrixError, res.Error)
+		}
+	}
+	return sent, nil
+}
+
+// convertSentMessage makes the Matrix message for a message sent with SendMessageToPortal.
+// The content is bridged as-is, as it was already converted to WhatsApp from the same content.
+func convertSentMessage(sender types.JID, content *event.MessageEventContent) *bridgev2.ConvertedMessage {
+	return &bridgev2.ConvertedMessage{
+		Parts: []*bridgev2.ConvertedMessagePart{{
+			Type:    event.EventMessage,
+			Content: content,
+			DBMetadata: &waid.MessageMetadata{
+				SenderDeviceID: sender.Device,
+			},
+		}},
+	}
+}
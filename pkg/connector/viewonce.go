@@ -0,0 +1,123 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+)
+
+func (wa *WhatsAppConnector) viewOnceExpiryEnabled() bool {
+	return wa.Config.ViewOnce.Expire && !wa.Config.DisableViewOnce
+}
+
+// trackViewOnce stores the Matrix events of a bridged view-once message, so they can be redacted after being viewed.
+func (wa *WhatsAppClient) trackViewOnce(
+	ctx context.Context,
+	portal *bridgev2.Portal,
+	messageID networkid.MessageID,
+	parts map[networkid.PartID]*event.MessageEventContent,
+) {
+	log := zerolog.Ctx(ctx).With().
+		Str("action", "track view-once message").
+		Str("message_id", string(messageID)).
+		Logger()
+	dbParts, err := wa.Main.Bridge.DB.Message.GetAllPartsByID(ctx, portal.Receiver, messageID)
+	if err != nil {
+		log.Err(err).Msg("Failed to get message parts")
+		return
+	}
+	var expireAt time.Time
+	if timeout := wa.Main.Config.ViewOnce.Timeout; timeout > 0 {
+		expireAt = time.Now().Add(timeout)
+	}
+	for _, part := range dbParts {
+		if part.MXID == "" {
+			continue
+		}
+		vo := &wadb.ViewOnceMessage{
+			UserLoginID: wa.UserLogin.ID,
+			MessageID:   messageID,
+			RoomID:      portal.MXID,
+			EventID:     part.MXID,
+			ExpireAt:    expireAt,
+		}
+		if content := parts[part.PartID]; content != nil {
+			if content.File != nil {
+				vo.MediaURI = content.File.URL
+			} else {
+				vo.MediaURI = content.URL
+			}
+		}
+		err = wa.Main.DB.ViewOnce.Put(ctx, vo)
+		if err != nil {
+			log.Err(err).Stringer("event_id", part.MXID).Msg("Failed to save view-once message")
+		}
+	}
+}
+
+// expireViewOnce marks the given messages as viewed, which makes them get redacted immediately if they're view-once.
+func (wa *WhatsAppClient) expireViewOnce(ctx context.Context, messageIDs []networkid.MessageID) {
+	if !wa.Main.viewOnceExpiryEnabled() || len(messageIDs) == 0 {
+		return
+	}
+	now := time.Now()
+	for _, msgID := range messageIDs {
+		err := wa.Main.DB.ViewOnce.ExpireByMessageID(ctx, wa.UserLogin.ID, msgID, now)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("message_id", string(msgID)).Msg("Failed to mark view-once message as viewed")
+		}
+	}
+	wa.Main.viewOnceQueue.wake()
+}
+
+func (wa *WhatsAppConnector) newViewOnceQueue() *redactionQueue[*wadb.ViewOnceMessage] {
+	return &redactionQueue[*wadb.ViewOnceMessage]{
+		name:   "view-once expiry",
+		reason: "View-once message expired",
+		bridge: wa.Bridge,
+		wakeup: make(chan struct{}, 1),
+
+		getDue: wa.DB.ViewOnce.GetDue,
+		target: func(vo *wadb.ViewOnceMessage) (id.RoomID, id.EventID, int) {
+			return vo.RoomID, vo.EventID, vo.Attempts
+		},
+		reschedule:  wa.DB.ViewOnce.Reschedule,
+		delete:      wa.DB.ViewOnce.Delete,
+		afterRedact: wa.deleteViewOnceMedia,
+	}
+}
+
+func (wa *WhatsAppConnector) deleteViewOnceMedia(ctx context.Context, vo *wadb.ViewOnceMessage) {
+	if vo.MediaURI == "" || !wa.Config.ViewOnce.DeleteMedia || wa.DeleteMedia == nil || wa.MsgConv.DirectMedia {
+		return
+	}
+	log := zerolog.Ctx(ctx).With().Str("media_uri", string(vo.MediaURI)).Logger()
+	if uri, err := vo.MediaURI.Parse(); err != nil {
+		log.Warn().Err(err).Msg("Failed to parse view-once media URI")
+	} else if err = wa.DeleteMedia(ctx, uri); err != nil {
+		log.Err(err).Msg("Failed to delete view-once media")
+	}
+}
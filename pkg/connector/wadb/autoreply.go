@@ -0,0 +1,49 @@
+package wadb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+)
+
+type AutoReplyQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.Database
+}
+
+const (
+	getAutoReplyStateQuery = `
+		SELECT last_sent_at, greeted FROM whatsapp_auto_reply
+		WHERE bridge_id=$1 AND user_login_id=$2 AND chat_jid=$3
+	`
+	putAutoReplySentQuery = `
+		INSERT INTO whatsapp_auto_reply (bridge_id, user_login_id, chat_jid, last_sent_at, greeted)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (bridge_id, user_login_id, chat_jid) DO UPDATE
+		SET last_sent_at=excluded.last_sent_at, greeted=whatsapp_auto_reply.greeted OR excluded.greeted
+	`
+)
+
+// GetState returns the time an automatic reply was last sent to the given chat (or a zero time if never)
+// and whether the greeting message has been sent to the chat.
+func (arq *AutoReplyQuery) GetState(ctx context.Context, loginID networkid.UserLoginID, chatJID types.JID) (lastSent time.Time, greeted bool, err error) {
+	var lastSentAt int64
+	err = arq.QueryRow(ctx, getAutoReplyStateQuery, arq.BridgeID, loginID, chatJID).Scan(&lastSentAt, &greeted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, err
+	}
+	return time.UnixMilli(lastSentAt), greeted, nil
+}
+
+// PutSent marks an automatic reply as sent to the given chat. Once a chat has been greeted, it stays greeted.
+func (arq *AutoReplyQuery) PutSent(ctx context.Context, loginID networkid.UserLoginID, chatJID types.JID, ts time.Time, greeted bool) error {
+	_, err := arq.Exec(ctx, putAutoReplySentQuery, arq.BridgeID, loginID, chatJID, ts.UnixMilli(), greeted)
+	return err
+}
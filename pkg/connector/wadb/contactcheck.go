@@ -0,0 +1,71 @@
+package wadb
+
+import (
+	"context"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"go.mau.fi/util/dbutil"
+	"go.mau.fi/whatsmeow/types"
+)
+
+type ContactCheckQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*ContactCheck]
+}
+
+const (
+	getContactCheckQuery = `
+		SELECT bridge_id, phone, is_in, jid, lid, is_business, verified_name, checked_at
+		FROM whatsapp_contact_check
+		WHERE bridge_id=$1 AND phone=$2
+	`
+	putContactCheckQuery = `
+		INSERT INTO whatsapp_contact_check (bridge_id, phone, is_in, jid, lid, is_business, verified_name, checked_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (bridge_id, phone) DO UPDATE
+		SET is_in=excluded.is_in, jid=excluded.jid, lid=excluded.lid, is_business=excluded.is_business,
+		    verified_name=excluded.verified_name, checked_at=excluded.checked_at
+	`
+	deleteOldContactChecksQuery = `
+		DELETE FROM whatsapp_contact_check WHERE bridge_id=$1 AND checked_at<$2
+	`
+)
+
+func (ccq *ContactCheckQuery) Get(ctx context.Context, phone string) (*ContactCheck, error) {
+	return ccq.QueryOne(ctx, getContactCheckQuery, ccq.BridgeID, phone)
+}
+
+func (ccq *ContactCheckQuery) Put(ctx context.Context, cc *ContactCheck) error {
+	cc.BridgeID = ccq.BridgeID
+	return ccq.Exec(ctx, putContactCheckQuery, cc.sqlVariables()...)
+}
+
+func (ccq *ContactCheckQuery) DeleteOlderThan(ctx context.Context, ts time.Time) error {
+	return ccq.Exec(ctx, deleteOldContactChecksQuery, ccq.BridgeID, ts.UnixMilli())
+}
+
+type ContactCheck struct {
+	BridgeID     networkid.BridgeID
+	Phone        string
+	IsIn         bool
+	JID          types.JID
+	LID          types.JID
+	IsBusiness   bool
+	VerifiedName string
+	CheckedAt    time.Time
+}
+
+func (cc *ContactCheck) Scan(row dbutil.Scannable) (*ContactCheck, error) {
+	var checkedAt int64
+	err := row.Scan(&cc.BridgeID, &cc.Phone, &cc.IsIn, &cc.JID, &cc.LID, &cc.IsBusiness, &cc.VerifiedName, &checkedAt)
+	if err != nil {
+		return nil, err
+	}
+	cc.CheckedAt = time.UnixMilli(checkedAt)
+	return cc, nil
+}
+
+func (cc *ContactCheck) sqlVariables() []any {
+	return []any{cc.BridgeID, cc.Phone, cc.IsIn, cc.JID, cc.LID, cc.IsBusiness, cc.VerifiedName, cc.CheckedAt.UnixMilli()}
+}
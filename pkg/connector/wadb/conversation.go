@@ -22,6 +22,7 @@ type Conversation struct {
 	BridgeID                  networkid.BridgeID
 	UserLoginID               networkid.UserLoginID
 	ChatJID                   types.JID
+	Name                      string
 	LastMessageTimestamp      time.Time
 	Archived                  *bool
 	Pinned                    *bool
@@ -52,6 +53,7 @@ func NewConversation(loginID networkid.UserLoginID, chatJID types.JID, conv *waH
 	return &Conversation{
 		UserLoginID:               loginID,
 		ChatJID:                   chatJID,
+		Name:                      conv.GetName(),
 		LastMessageTimestamp:      lastMessageTS,
 		Archived:                  conv.Archived,
 		Pinned:                    pinned,
@@ -67,13 +69,14 @@ func NewConversation(loginID networkid.UserLoginID, chatJID types.JID, conv *waH
 const (
 	upsertHistorySyncConversationQuery = `
 		INSERT INTO whatsapp_history_sync_conversation (
-			bridge_id, user_login_id, chat_jid, last_message_timestamp, archived, pinned, mute_end_time,
+			bridge_id, user_login_id, chat_jid, name, last_message_timestamp, archived, pinned, mute_end_time,
 			end_of_history_transfer_type, ephemeral_expiration, ephemeral_setting_timestamp, marked_as_unread,
 			unread_count
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
 		ON CONFLICT (bridge_id, user_login_id, chat_jid)
 		DO UPDATE SET
+			name=COALESCE(excluded.name, whatsapp_history_sync_conversation.name),
 			last_message_timestamp=CASE
 				WHEN whatsapp_history_sync_conversation.last_message_timestamp IS NULL
 				         OR excluded.last_message_timestamp > whatsapp_history_sync_conversation.last_message_timestamp
@@ -91,7 +94,7 @@ const (
 	`
 	getRecentConversations = `
 		SELECT
-			bridge_id, user_login_id, chat_jid, last_message_timestamp, archived, pinned, mute_end_time,
+			bridge_id, user_login_id, chat_jid, name, last_message_timestamp, archived, pinned, mute_end_time,
 			end_of_history_transfer_type, ephemeral_expiration, ephemeral_setting_timestamp, marked_as_unread,
 			unread_count
 		FROM whatsapp_history_sync_conversation
@@ -101,7 +104,7 @@ const (
 	`
 	getConversationByJID = `
 		SELECT
-			bridge_id, user_login_id, chat_jid, last_message_timestamp, archived, pinned, mute_end_time,
+			bridge_id, user_login_id, chat_jid, name, last_message_timestamp, archived, pinned, mute_end_time,
 			end_of_history_transfer_type, ephemeral_expiration, ephemeral_setting_timestamp, marked_as_unread,
 			unread_count
 		FROM whatsapp_history_sync_conversation
@@ -163,6 +166,7 @@ func (c *Conversation) sqlVariables() []any {
 		c.BridgeID,
 		c.UserLoginID,
 		c.ChatJID,
+		dbutil.StrPtr(c.Name),
 		lastMessageTS,
 		c.Archived,
 		c.Pinned,
@@ -176,11 +180,13 @@ func (c *Conversation) sqlVariables() []any {
 }
 
 func (c *Conversation) Scan(row dbutil.Scannable) (*Conversation, error) {
+	var name sql.NullString
 	var lastMessageTS, muteEndTime sql.NullInt64
 	err := row.Scan(
 		&c.BridgeID,
 		&c.UserLoginID,
 		&c.ChatJID,
+		&name,
 		&lastMessageTS,
 		&c.Archived,
 		&c.Pinned,
@@ -194,6 +200,7 @@ func (c *Conversation) Scan(row dbutil.Scannable) (*Conversation, error) {
 	if err != nil {
 		return nil, err
 	}
+	c.Name = name.String
 	if lastMessageTS.Int64 != 0 {
 		c.LastMessageTimestamp = time.Unix(lastMessageTS.Int64, 0)
 	}
@@ -16,6 +16,12 @@ type Database struct {
 	MediaRequest *MediaRequestQuery
 	HSNotif      *HistorySyncNotificationQuery
 	AvatarCache  *AvatarCacheQuery
+	Webhook      *WebhookDeliveryQuery
+	ContactCheck *ContactCheckQuery
+	AutoReply    *AutoReplyQuery
+	ViewOnce     *ViewOnceQuery
+	MediaCache   *MediaCacheQuery
+	Disappearing *DisappearingMessageQuery
 }
 
 func New(bridgeID networkid.BridgeID, db *dbutil.Database, log zerolog.Logger) *Database {
@@ -51,5 +57,37 @@ func New(bridgeID networkid.BridgeID, db *dbutil.Database, log zerolog.Logger) *
 				return &AvatarCacheEntry{}
 			}),
 		},
+		Webhook: &WebhookDeliveryQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*WebhookDelivery]) *WebhookDelivery {
+				return &WebhookDelivery{}
+			}),
+		},
+		ContactCheck: &ContactCheckQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*ContactCheck]) *ContactCheck {
+				return &ContactCheck{}
+			}),
+		},
+		AutoReply: &AutoReplyQuery{
+			BridgeID: bridgeID,
+			Database: db,
+		},
+		ViewOnce: &ViewOnceQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*ViewOnceMessage]) *ViewOnceMessage {
+				return &ViewOnceMessage{}
+			}),
+		},
+		MediaCache: &MediaCacheQuery{
+			BridgeID: bridgeID,
+			Database: db,
+		},
+		Disappearing: &DisappearingMessageQuery{
+			BridgeID: bridgeID,
+			QueryHelper: dbutil.MakeQueryHelper(db, func(_ *dbutil.QueryHelper[*DisappearingMessage]) *DisappearingMessage {
+				return &DisappearingMessage{}
+			}),
+		},
 	}
 }
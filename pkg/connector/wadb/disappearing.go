@@ -0,0 +1,86 @@
+package wadb
+
+import (
+	"context"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"go.mau.fi/util/dbutil"
+)
+
+type DisappearingMessageQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*DisappearingMessage]
+}
+
+type DisappearingMessage struct {
+	BridgeID    networkid.BridgeID
+	UserLoginID networkid.UserLoginID
+	MessageID   networkid.MessageID
+	RoomID      id.RoomID
+	EventID     id.EventID
+	DisappearAt time.Time
+	Attempts    int
+}
+
+const (
+	putDisappearingMessageQuery = `
+		INSERT INTO whatsapp_disappearing_message (bridge_id, user_login_id, message_id, room_id, event_id, disappear_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (bridge_id, event_id) DO UPDATE SET disappear_at=excluded.disappear_at, attempts=0
+	`
+	rescheduleDisappearingMessageQuery = `
+		UPDATE whatsapp_disappearing_message SET disappear_at=$3, attempts=attempts+1 WHERE bridge_id=$1 AND event_id=$2
+	`
+	getDueDisappearingMessagesQuery = `
+		SELECT bridge_id, user_login_id, message_id, room_id, event_id, disappear_at, attempts
+		FROM whatsapp_disappearing_message
+		WHERE bridge_id=$1 AND disappear_at<=$2
+		ORDER BY disappear_at
+		LIMIT $3
+	`
+	deleteDisappearingMessageQuery = `
+		DELETE FROM whatsapp_disappearing_message WHERE bridge_id=$1 AND event_id=$2
+	`
+	deleteDisappearingMessageByMessageIDQuery = `
+		DELETE FROM whatsapp_disappearing_message WHERE bridge_id=$1 AND user_login_id=$2 AND message_id=$3
+	`
+)
+
+func (dmq *DisappearingMessageQuery) Put(ctx context.Context, dm *DisappearingMessage) error {
+	dm.BridgeID = dmq.BridgeID
+	return dmq.Exec(ctx, putDisappearingMessageQuery, dm.sqlVariables()...)
+}
+
+// Reschedule moves the redaction of the given event to the given time and counts it as a failed attempt.
+func (dmq *DisappearingMessageQuery) Reschedule(ctx context.Context, eventID id.EventID, ts time.Time) error {
+	return dmq.Exec(ctx, rescheduleDisappearingMessageQuery, dmq.BridgeID, eventID, ts.UnixMilli())
+}
+
+func (dmq *DisappearingMessageQuery) GetDue(ctx context.Context, limit int) ([]*DisappearingMessage, error) {
+	return dmq.QueryMany(ctx, getDueDisappearingMessagesQuery, dmq.BridgeID, time.Now().UnixMilli(), limit)
+}
+
+func (dmq *DisappearingMessageQuery) Delete(ctx context.Context, eventID id.EventID) error {
+	return dmq.Exec(ctx, deleteDisappearingMessageQuery, dmq.BridgeID, eventID)
+}
+
+// DeleteByMessageID cancels the disappearing of all parts of the given message.
+func (dmq *DisappearingMessageQuery) DeleteByMessageID(ctx context.Context, loginID networkid.UserLoginID, messageID networkid.MessageID) error {
+	return dmq.Exec(ctx, deleteDisappearingMessageByMessageIDQuery, dmq.BridgeID, loginID, messageID)
+}
+
+func (dm *DisappearingMessage) Scan(row dbutil.Scannable) (*DisappearingMessage, error) {
+	var disappearAt int64
+	err := row.Scan(&dm.BridgeID, &dm.UserLoginID, &dm.MessageID, &dm.RoomID, &dm.EventID, &disappearAt, &dm.Attempts)
+	if err != nil {
+		return nil, err
+	}
+	dm.DisappearAt = time.UnixMilli(disappearAt)
+	return dm, nil
+}
+
+func (dm *DisappearingMessage) sqlVariables() []any {
+	return []any{dm.BridgeID, dm.UserLoginID, dm.MessageID, dm.RoomID, dm.EventID, dm.DisappearAt.UnixMilli()}
+}
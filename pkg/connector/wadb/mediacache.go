@@ -0,0 +1,180 @@
+package wadb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"go.mau.fi/util/dbutil"
+)
+
+type MediaCacheQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.Database
+}
+
+// MatrixMediaCacheEntry is a file that has already been uploaded to the Matrix media repo.
+type MatrixMediaCacheEntry struct {
+	FileSHA256    []byte
+	Encrypted     bool
+	MXC           id.ContentURIString
+	EncryptedFile *event.EncryptedFileInfo
+	MimeType      string
+	FileName      string
+	ExpiresAt     time.Time
+}
+
+// WhatsAppMediaCacheEntry is a file that has already been uploaded to the WhatsApp media servers.
+type WhatsAppMediaCacheEntry struct {
+	FileSHA256    []byte
+	MediaType     string
+	SourceMXC     id.ContentURIString
+	URL           string
+	DirectPath    string
+	MediaKey      []byte
+	FileEncSHA256 []byte
+	FileLength    uint64
+	MimeType      string
+	Thumbnail     []byte
+	PageCount     uint32
+	Seconds       uint32
+	Waveform      []byte
+	ExpiresAt     time.Time
+}
+
+const (
+	getMatrixMediaCacheQuery = `
+		SELECT file_sha256, encrypted, mxc, encrypted_file, mime_type, file_name, expires_at
+		FROM whatsapp_media_cache_matrix
+		WHERE bridge_id=$1 AND file_sha256=$2 AND encrypted=$3 AND expires_at>$4
+	`
+	putMatrixMediaCacheQuery = `
+		INSERT INTO whatsapp_media_cache_matrix (bridge_id, file_sha256, encrypted, mxc, encrypted_file, mime_type, file_name, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (bridge_id, file_sha256, encrypted) DO UPDATE
+			SET mxc=excluded.mxc, encrypted_file=excluded.encrypted_file, mime_type=excluded.mime_type,
+				file_name=excluded.file_name, expires_at=excluded.expires_at
+	`
+	getWhatsAppMediaCacheBaseQuery = `
+		SELECT file_sha256, media_type, source_mxc, url, direct_path, media_key, file_enc_sha256, file_length, mime_type, thumbnail,
+			page_count, seconds, waveform, expires_at
+		FROM whatsapp_media_cache_whatsapp
+	`
+	getWhatsAppMediaCacheQuery = getWhatsAppMediaCacheBaseQuery + `
+		WHERE bridge_id=$1 AND file_sha256=$2 AND media_type=$3 AND expires_at>$4
+	`
+	getWhatsAppMediaCacheBySourceQuery = getWhatsAppMediaCacheBaseQuery + `
+		WHERE bridge_id=$1 AND source_mxc=$2 AND media_type=$3 AND expires_at>$4
+		ORDER BY expires_at DESC
+		LIMIT 1
+	`
+	putWhatsAppMediaCacheQuery = `
+		INSERT INTO whatsapp_media_cache_whatsapp (
+			bridge_id, file_sha256, media_type, source_mxc, url, direct_path, media_key, file_enc_sha256,
+			file_length, mime_type, thumbnail, page_count, seconds, waveform, expires_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT (bridge_id, file_sha256, media_type) DO UPDATE
+			SET source_mxc=COALESCE(excluded.source_mxc, whatsapp_media_cache_whatsapp.source_mxc),
+				url=excluded.url, direct_path=excluded.direct_path, media_key=excluded.media_key,
+				file_enc_sha256=excluded.file_enc_sha256, file_length=excluded.file_length, mime_type=excluded.mime_type,
+				thumbnail=COALESCE(excluded.thumbnail, whatsapp_media_cache_whatsapp.thumbnail), page_count=excluded.page_count,
+				seconds=excluded.seconds, waveform=excluded.waveform, expires_at=excluded.expires_at
+	`
+	deleteExpiredMatrixMediaCacheQuery = `
+		DELETE FROM whatsapp_media_cache_matrix WHERE bridge_id=$1 AND expires_at<=$2
+	`
+	deleteExpiredWhatsAppMediaCacheQuery = `
+		DELETE FROM whatsapp_media_cache_whatsapp WHERE bridge_id=$1 AND expires_at<=$2
+	`
+)
+
+func (mcq *MediaCacheQuery) GetMatrix(ctx context.Context, fileSHA256 []byte, encrypted bool) (*MatrixMediaCacheEntry, error) {
+	var entry MatrixMediaCacheEntry
+	var encryptedFile sql.NullString
+	var expiresAt int64
+	err := mcq.QueryRow(ctx, getMatrixMediaCacheQuery, mcq.BridgeID, fileSHA256, encrypted, time.Now().UnixMilli()).Scan(
+		&entry.FileSHA256, &entry.Encrypted, &entry.MXC, &encryptedFile, &entry.MimeType, &entry.FileName, &expiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if encryptedFile.Valid {
+		err = json.Unmarshal([]byte(encryptedFile.String), &entry.EncryptedFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	entry.ExpiresAt = time.UnixMilli(expiresAt)
+	return &entry, nil
+}
+
+func (mcq *MediaCacheQuery) PutMatrix(ctx context.Context, entry *MatrixMediaCacheEntry) error {
+	var encryptedFile *string
+	if entry.EncryptedFile != nil {
+		data, err := json.Marshal(entry.EncryptedFile)
+		if err != nil {
+			return err
+		}
+		encryptedFile = dbutil.StrPtr(string(data))
+	}
+	_, err := mcq.Exec(
+		ctx, putMatrixMediaCacheQuery, mcq.BridgeID, entry.FileSHA256, entry.Encrypted, entry.MXC, encryptedFile,
+		entry.MimeType, entry.FileName, entry.ExpiresAt.UnixMilli(),
+	)
+	return err
+}
+
+func (mcq *MediaCacheQuery) GetWhatsApp(ctx context.Context, fileSHA256 []byte, mediaType string) (*WhatsAppMediaCacheEntry, error) {
+	return mcq.scanWhatsApp(mcq.QueryRow(ctx, getWhatsAppMediaCacheQuery, mcq.BridgeID, fileSHA256, mediaType, time.Now().UnixMilli()))
+}
+
+// GetWhatsAppBySource finds a WhatsApp upload of the given Matrix file, which allows skipping the download from Matrix.
+func (mcq *MediaCacheQuery) GetWhatsAppBySource(ctx context.Context, mxc id.ContentURIString, mediaType string) (*WhatsAppMediaCacheEntry, error) {
+	return mcq.scanWhatsApp(mcq.QueryRow(ctx, getWhatsAppMediaCacheBySourceQuery, mcq.BridgeID, mxc, mediaType, time.Now().UnixMilli()))
+}
+
+func (mcq *MediaCacheQuery) scanWhatsApp(row *sql.Row) (*WhatsAppMediaCacheEntry, error) {
+	var entry WhatsAppMediaCacheEntry
+	var sourceMXC sql.NullString
+	var expiresAt int64
+	err := row.Scan(
+		&entry.FileSHA256, &entry.MediaType, &sourceMXC, &entry.URL, &entry.DirectPath, &entry.MediaKey,
+		&entry.FileEncSHA256, &entry.FileLength, &entry.MimeType, &entry.Thumbnail,
+		&entry.PageCount, &entry.Seconds, &entry.Waveform, &expiresAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	entry.SourceMXC = id.ContentURIString(sourceMXC.String)
+	entry.ExpiresAt = time.UnixMilli(expiresAt)
+	return &entry, nil
+}
+
+func (mcq *MediaCacheQuery) PutWhatsApp(ctx context.Context, entry *WhatsAppMediaCacheEntry) error {
+	_, err := mcq.Exec(
+		ctx, putWhatsAppMediaCacheQuery, mcq.BridgeID, entry.FileSHA256, entry.MediaType, dbutil.StrPtr(entry.SourceMXC),
+		entry.URL, entry.DirectPath, entry.MediaKey, entry.FileEncSHA256, int64(entry.FileLength), entry.MimeType,
+		entry.Thumbnail, entry.PageCount, entry.Seconds, entry.Waveform, entry.ExpiresAt.UnixMilli(),
+	)
+	return err
+}
+
+func (mcq *MediaCacheQuery) DeleteExpired(ctx context.Context) error {
+	now := time.Now().UnixMilli()
+	_, err := mcq.Exec(ctx, deleteExpiredMatrixMediaCacheQuery, mcq.BridgeID, now)
+	if err != nil {
+		return err
+	}
+	_, err = mcq.Exec(ctx, deleteExpiredWhatsAppMediaCacheQuery, mcq.BridgeID, now)
+	return err
+}
@@ -2,9 +2,12 @@ package wadb
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
 	"go.mau.fi/util/dbutil"
+	"go.mau.fi/util/ptr"
 )
 
 type MediaBackfillRequestStatus int
@@ -16,6 +19,21 @@ const (
 	MediaBackfillRequestStatusRequestSkipped MediaBackfillRequestStatus = 3
 )
 
+func (status MediaBackfillRequestStatus) String() string {
+	switch status {
+	case MediaBackfillRequestStatusNotRequested:
+		return "not requested"
+	case MediaBackfillRequestStatusRequested:
+		return "requested"
+	case MediaBackfillRequestStatusRequestFailed:
+		return "failed"
+	case MediaBackfillRequestStatusRequestSkipped:
+		return "skipped"
+	default:
+		return "unknown"
+	}
+}
+
 type MediaRequestQuery struct {
 	BridgeID networkid.BridgeID
 	*dbutil.QueryHelper[*MediaRequest]
@@ -29,25 +47,61 @@ type MediaRequest struct {
 	MediaKey    []byte
 	Status      MediaBackfillRequestStatus
 	Error       string
+	Attempts    int
+	NextAttempt time.Time
 }
 
 const (
 	upsertMediaRequestQuery = `
 		INSERT INTO whatsapp_media_backfill_request (
-			bridge_id, user_login_id, message_id, portal_id, portal_receiver, media_key, status, error
+			bridge_id, user_login_id, message_id, portal_id, portal_receiver, media_key, status, error,
+			attempts, next_attempt
 		)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
 		ON CONFLICT (bridge_id, user_login_id, message_id) DO UPDATE SET
-			media_key=excluded.media_key, status=excluded.status, error=excluded.error
+			media_key=excluded.media_key, status=excluded.status, error=excluded.error,
+			attempts=excluded.attempts, next_attempt=excluded.next_attempt
 	`
 	deleteMediaRequestQuery = `
 		DELETE FROM whatsapp_media_backfill_request
 		WHERE bridge_id=$1 AND user_login_id=$2 AND message_id=$3
 	`
-	getAllUnrequestedMediaRequestsForUserLoginQuery = `
-		SELECT bridge_id, user_login_id, message_id, portal_id, portal_receiver, media_key, status, error
+	getMediaRequestBaseQuery = `
+		SELECT bridge_id, user_login_id, message_id, portal_id, portal_receiver, media_key, status, error,
+		       attempts, next_attempt
 		FROM whatsapp_media_backfill_request
+	`
+	getMediaRequestByIDQuery = getMediaRequestBaseQuery + `
+		WHERE bridge_id=$1 AND user_login_id=$2 AND message_id=$3
+	`
+	// Requests in chats with more recent activity are sent first. The latest message timestamp is computed once
+	// per chat in a join rather than in a subquery that would be evaluated for every pending request.
+	mediaRequestPriorityJoin = `
+		LEFT JOIN (
+			SELECT room_id, room_receiver, MAX(timestamp) AS last_timestamp
+			FROM message
+			WHERE bridge_id=$1 AND room_id IN (
+				SELECT portal_id FROM whatsapp_media_backfill_request WHERE bridge_id=$1 AND user_login_id=$2
+			)
+			GROUP BY room_id, room_receiver
+		) latest_message
+			ON latest_message.room_id=whatsapp_media_backfill_request.portal_id
+			AND latest_message.room_receiver=whatsapp_media_backfill_request.portal_receiver
+	`
+	mediaRequestPriorityOrder = `
+		ORDER BY latest_message.last_timestamp IS NULL, latest_message.last_timestamp DESC
+	`
+	getAllUnrequestedMediaRequestsForUserLoginQuery = getMediaRequestBaseQuery + mediaRequestPriorityJoin + `
 		WHERE bridge_id=$1 AND user_login_id=$2 AND status=0
+	` + mediaRequestPriorityOrder
+	getRetryableMediaRequestsForUserLoginQuery = getMediaRequestBaseQuery + mediaRequestPriorityJoin + `
+		WHERE bridge_id=$1 AND user_login_id=$2 AND status=2 AND attempts<$3 AND next_attempt<=$4
+	` + mediaRequestPriorityOrder
+	getMediaRequestsForPortalQuery = getMediaRequestBaseQuery + `
+		WHERE bridge_id=$1 AND user_login_id=$2 AND portal_id=$3 AND portal_receiver=$4
+	`
+	countMediaRequestsByStatusQuery = `
+		SELECT status, COUNT(*) FROM whatsapp_media_backfill_request WHERE bridge_id=$1 GROUP BY status
 	`
 )
 
@@ -60,18 +114,64 @@ func (mrq *MediaRequestQuery) Delete(ctx context.Context, loginID networkid.User
 	return mrq.Exec(ctx, deleteMediaRequestQuery, mrq.BridgeID, loginID, messageID)
 }
 
+func (mrq *MediaRequestQuery) Get(ctx context.Context, loginID networkid.UserLoginID, messageID networkid.MessageID) (*MediaRequest, error) {
+	return mrq.QueryOne(ctx, getMediaRequestByIDQuery, mrq.BridgeID, loginID, messageID)
+}
+
+func (mrq *MediaRequestQuery) GetAllForPortal(ctx context.Context, loginID networkid.UserLoginID, portalKey networkid.PortalKey) ([]*MediaRequest, error) {
+	return mrq.QueryMany(ctx, getMediaRequestsForPortalQuery, mrq.BridgeID, loginID, portalKey.ID, portalKey.Receiver)
+}
+
 func (mrq *MediaRequestQuery) GetUnrequestedForUserLogin(ctx context.Context, loginID networkid.UserLoginID) ([]*MediaRequest, error) {
 	return mrq.QueryMany(ctx, getAllUnrequestedMediaRequestsForUserLoginQuery, mrq.BridgeID, loginID)
 }
 
+func (mrq *MediaRequestQuery) GetRetryableForUserLogin(ctx context.Context, loginID networkid.UserLoginID, maxAttempts int) ([]*MediaRequest, error) {
+	return mrq.QueryMany(ctx, getRetryableMediaRequestsForUserLoginQuery, mrq.BridgeID, loginID, maxAttempts, time.Now().Unix())
+}
+
+type mediaRequestStatusCount struct {
+	status MediaBackfillRequestStatus
+	count  int
+}
+
+func (mrq *MediaRequestQuery) CountByStatus(ctx context.Context) (map[MediaBackfillRequestStatus]int, error) {
+	return dbutil.RowIterAsMap(
+		dbutil.ConvertRowFn[mediaRequestStatusCount](scanMediaRequestStatusCount).
+			NewRowIter(mrq.GetDB().Query(ctx, countMediaRequestsByStatusQuery, mrq.BridgeID)),
+		func(c mediaRequestStatusCount) (MediaBackfillRequestStatus, int) {
+			return c.status, c.count
+		},
+	)
+}
+
+func scanMediaRequestStatusCount(row dbutil.Scannable) (c mediaRequestStatusCount, err error) {
+	err = row.Scan(&c.status, &c.count)
+	return
+}
+
 func (mr *MediaRequest) Scan(row dbutil.Scannable) (*MediaRequest, error) {
-	err := row.Scan(&mr.BridgeID, &mr.UserLoginID, &mr.MessageID, &mr.PortalKey.ID, &mr.PortalKey.Receiver, &mr.MediaKey, &mr.Status, &mr.Error)
+	var nextAttempt sql.NullInt64
+	err := row.Scan(
+		&mr.BridgeID, &mr.UserLoginID, &mr.MessageID, &mr.PortalKey.ID, &mr.PortalKey.Receiver, &mr.MediaKey,
+		&mr.Status, &mr.Error, &mr.Attempts, &nextAttempt,
+	)
 	if err != nil {
 		return nil, err
 	}
+	if nextAttempt.Int64 != 0 {
+		mr.NextAttempt = time.Unix(nextAttempt.Int64, 0)
+	}
 	return mr, nil
 }
 
 func (mr *MediaRequest) sqlVariables() []any {
-	return []any{mr.BridgeID, mr.UserLoginID, mr.MessageID, mr.PortalKey.ID, mr.PortalKey.Receiver, mr.MediaKey, mr.Status, mr.Error}
+	var nextAttempt *int64
+	if !mr.NextAttempt.IsZero() {
+		nextAttempt = ptr.Ptr(mr.NextAttempt.Unix())
+	}
+	return []any{
+		mr.BridgeID, mr.UserLoginID, mr.MessageID, mr.PortalKey.ID, mr.PortalKey.Receiver, mr.MediaKey,
+		mr.Status, mr.Error, mr.Attempts, nextAttempt,
+	}
 }
@@ -1,4 +1,4 @@
--- v0 -> v9 (compatible with v3+): Latest revision
+-- v0 -> v21 (compatible with v3+): Latest revision
 
 CREATE TABLE whatsapp_poll_option_id (
     bridge_id TEXT  NOT NULL,
@@ -17,6 +17,7 @@ CREATE TABLE whatsapp_history_sync_conversation (
     user_login_id                TEXT    NOT NULL,
     chat_jid                     TEXT    NOT NULL,
 
+    name                         TEXT,
     last_message_timestamp       BIGINT,
     archived                     BOOLEAN,
     pinned                       BOOLEAN,
@@ -62,6 +63,8 @@ CREATE TABLE whatsapp_media_backfill_request (
     media_key       bytea,
     status          INTEGER NOT NULL,
     error           TEXT    NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt    BIGINT,
 
     PRIMARY KEY (bridge_id, user_login_id, message_id),
     CONSTRAINT whatsapp_media_backfill_request_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
@@ -98,3 +101,115 @@ CREATE TABLE whatsapp_avatar_cache (
 
     PRIMARY KEY (entity_jid, avatar_id)
 );
+
+CREATE TABLE whatsapp_webhook_delivery (
+    bridge_id     TEXT    NOT NULL,
+    id            TEXT    NOT NULL,
+    user_login_id TEXT    NOT NULL,
+    url           TEXT    NOT NULL,
+    secret        TEXT    NOT NULL,
+    is_global     BOOLEAN NOT NULL DEFAULT false,
+    event_type    TEXT    NOT NULL,
+    payload       TEXT    NOT NULL,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    next_attempt  BIGINT  NOT NULL,
+    last_error    TEXT    NOT NULL DEFAULT '',
+    created_at    BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, id),
+    CONSTRAINT whatsapp_webhook_delivery_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX whatsapp_webhook_delivery_next_attempt_idx ON whatsapp_webhook_delivery (bridge_id, next_attempt);
+
+CREATE TABLE whatsapp_contact_check (
+    bridge_id     TEXT    NOT NULL,
+    phone         TEXT    NOT NULL,
+    is_in         BOOLEAN NOT NULL,
+    jid           TEXT,
+    lid           TEXT,
+    is_business   BOOLEAN NOT NULL DEFAULT false,
+    verified_name TEXT    NOT NULL DEFAULT '',
+    checked_at    BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, phone)
+);
+
+CREATE TABLE whatsapp_auto_reply (
+    bridge_id     TEXT    NOT NULL,
+    user_login_id TEXT    NOT NULL,
+    chat_jid      TEXT    NOT NULL,
+    last_sent_at  BIGINT  NOT NULL,
+    greeted       BOOLEAN NOT NULL DEFAULT false,
+
+    PRIMARY KEY (bridge_id, user_login_id, chat_jid),
+    CONSTRAINT whatsapp_auto_reply_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+CREATE TABLE whatsapp_view_once (
+    bridge_id     TEXT    NOT NULL,
+    user_login_id TEXT    NOT NULL,
+    message_id    TEXT    NOT NULL,
+    room_id       TEXT    NOT NULL,
+    event_id      TEXT    NOT NULL,
+    media_uri     TEXT    NOT NULL DEFAULT '',
+    expire_at     BIGINT,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (bridge_id, event_id),
+    CONSTRAINT whatsapp_view_once_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX whatsapp_view_once_expire_idx ON whatsapp_view_once (bridge_id, expire_at);
+CREATE INDEX whatsapp_view_once_message_idx ON whatsapp_view_once (bridge_id, user_login_id, message_id);
+
+CREATE TABLE whatsapp_media_cache_matrix (
+    bridge_id      TEXT    NOT NULL,
+    file_sha256    bytea   NOT NULL,
+    encrypted      BOOLEAN NOT NULL,
+    mxc            TEXT    NOT NULL,
+    encrypted_file TEXT,
+    mime_type      TEXT    NOT NULL,
+    file_name      TEXT    NOT NULL,
+    expires_at     BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, file_sha256, encrypted)
+);
+
+CREATE TABLE whatsapp_media_cache_whatsapp (
+    bridge_id       TEXT    NOT NULL,
+    file_sha256     bytea   NOT NULL,
+    media_type      TEXT    NOT NULL,
+    source_mxc      TEXT,
+    url             TEXT    NOT NULL,
+    direct_path     TEXT    NOT NULL,
+    media_key       bytea   NOT NULL,
+    file_enc_sha256 bytea   NOT NULL,
+    file_length     BIGINT  NOT NULL,
+    mime_type       TEXT    NOT NULL,
+    thumbnail       bytea,
+    page_count      INTEGER NOT NULL DEFAULT 0,
+    seconds         INTEGER NOT NULL DEFAULT 0,
+    waveform        bytea,
+    expires_at      BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, file_sha256, media_type)
+);
+CREATE INDEX whatsapp_media_cache_whatsapp_source_idx ON whatsapp_media_cache_whatsapp (bridge_id, source_mxc);
+
+CREATE TABLE whatsapp_disappearing_message (
+    bridge_id     TEXT    NOT NULL,
+    user_login_id TEXT    NOT NULL,
+    message_id    TEXT    NOT NULL,
+    room_id       TEXT    NOT NULL,
+    event_id      TEXT    NOT NULL,
+    disappear_at  BIGINT  NOT NULL,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (bridge_id, event_id),
+    CONSTRAINT whatsapp_disappearing_message_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX whatsapp_disappearing_message_disappear_idx ON whatsapp_disappearing_message (bridge_id, disappear_at);
+CREATE INDEX whatsapp_disappearing_message_message_idx ON whatsapp_disappearing_message (bridge_id, user_login_id, message_id);
@@ -0,0 +1,2 @@
+-- v10 (compatible with v3+): Store conversation names from history sync
+ALTER TABLE whatsapp_history_sync_conversation ADD COLUMN name TEXT;
@@ -0,0 +1,3 @@
+-- v11 (compatible with v3+): Track retries of media backfill requests
+ALTER TABLE whatsapp_media_backfill_request ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE whatsapp_media_backfill_request ADD COLUMN next_attempt BIGINT;
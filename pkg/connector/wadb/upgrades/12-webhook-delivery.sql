@@ -0,0 +1,17 @@
+-- v12 (compatible with v3+): Add persistent queue for webhook deliveries
+CREATE TABLE whatsapp_webhook_delivery (
+    bridge_id     TEXT    NOT NULL,
+    id            TEXT    NOT NULL,
+    user_login_id TEXT    NOT NULL,
+    url           TEXT    NOT NULL,
+    secret        TEXT    NOT NULL,
+    event_type    TEXT    NOT NULL,
+    payload       TEXT    NOT NULL,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    next_attempt  BIGINT  NOT NULL,
+    last_error    TEXT    NOT NULL DEFAULT '',
+    created_at    BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, id)
+);
+CREATE INDEX whatsapp_webhook_delivery_next_attempt_idx ON whatsapp_webhook_delivery (bridge_id, next_attempt);
@@ -0,0 +1,13 @@
+-- v13 (compatible with v3+): Add cache for bulk WhatsApp contact checks
+CREATE TABLE whatsapp_contact_check (
+    bridge_id     TEXT    NOT NULL,
+    phone         TEXT    NOT NULL,
+    is_in         BOOLEAN NOT NULL,
+    jid           TEXT,
+    lid           TEXT,
+    is_business   BOOLEAN NOT NULL DEFAULT false,
+    verified_name TEXT    NOT NULL DEFAULT '',
+    checked_at    BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, phone)
+);
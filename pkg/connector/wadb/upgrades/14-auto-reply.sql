@@ -0,0 +1,12 @@
+-- v14 (compatible with v3+): Add tracking for automatic replies
+CREATE TABLE whatsapp_auto_reply (
+    bridge_id     TEXT    NOT NULL,
+    user_login_id TEXT    NOT NULL,
+    chat_jid      TEXT    NOT NULL,
+    last_sent_at  BIGINT  NOT NULL,
+    greeted       BOOLEAN NOT NULL DEFAULT false,
+
+    PRIMARY KEY (bridge_id, user_login_id, chat_jid),
+    CONSTRAINT whatsapp_auto_reply_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
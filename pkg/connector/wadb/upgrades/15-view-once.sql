@@ -0,0 +1,16 @@
+-- v15 (compatible with v3+): Add tracking for expiring view-once media
+CREATE TABLE whatsapp_view_once (
+    bridge_id     TEXT   NOT NULL,
+    user_login_id TEXT   NOT NULL,
+    message_id    TEXT   NOT NULL,
+    room_id       TEXT   NOT NULL,
+    event_id      TEXT   NOT NULL,
+    media_uri     TEXT   NOT NULL DEFAULT '',
+    expire_at     BIGINT,
+
+    PRIMARY KEY (bridge_id, event_id),
+    CONSTRAINT whatsapp_view_once_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX whatsapp_view_once_expire_idx ON whatsapp_view_once (bridge_id, expire_at);
+CREATE INDEX whatsapp_view_once_message_idx ON whatsapp_view_once (bridge_id, user_login_id, message_id);
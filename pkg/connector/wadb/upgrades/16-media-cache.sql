@@ -0,0 +1,31 @@
+-- v16 (compatible with v3+): Add cache for deduplicating media uploads
+CREATE TABLE whatsapp_media_cache_matrix (
+    bridge_id      TEXT    NOT NULL,
+    file_sha256    bytea   NOT NULL,
+    encrypted      BOOLEAN NOT NULL,
+    mxc            TEXT    NOT NULL,
+    encrypted_file TEXT,
+    mime_type      TEXT    NOT NULL,
+    file_name      TEXT    NOT NULL,
+    expires_at     BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, file_sha256, encrypted)
+);
+
+CREATE TABLE whatsapp_media_cache_whatsapp (
+    bridge_id       TEXT   NOT NULL,
+    file_sha256     bytea  NOT NULL,
+    media_type      TEXT   NOT NULL,
+    source_mxc      TEXT,
+    url             TEXT   NOT NULL,
+    direct_path     TEXT   NOT NULL,
+    media_key       bytea  NOT NULL,
+    file_enc_sha256 bytea  NOT NULL,
+    file_length     BIGINT NOT NULL,
+    mime_type       TEXT   NOT NULL,
+    thumbnail       bytea,
+    expires_at      BIGINT NOT NULL,
+
+    PRIMARY KEY (bridge_id, file_sha256, media_type)
+);
+CREATE INDEX whatsapp_media_cache_whatsapp_source_idx ON whatsapp_media_cache_whatsapp (bridge_id, source_mxc);
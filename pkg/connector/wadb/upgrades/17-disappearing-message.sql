@@ -0,0 +1,15 @@
+-- v17 (compatible with v3+): Add tracking for disappearing messages bridged from WhatsApp
+CREATE TABLE whatsapp_disappearing_message (
+    bridge_id     TEXT   NOT NULL,
+    user_login_id TEXT   NOT NULL,
+    message_id    TEXT   NOT NULL,
+    room_id       TEXT   NOT NULL,
+    event_id      TEXT   NOT NULL,
+    disappear_at  BIGINT NOT NULL,
+
+    PRIMARY KEY (bridge_id, event_id),
+    CONSTRAINT whatsapp_disappearing_message_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+CREATE INDEX whatsapp_disappearing_message_disappear_idx ON whatsapp_disappearing_message (bridge_id, disappear_at);
+CREATE INDEX whatsapp_disappearing_message_message_idx ON whatsapp_disappearing_message (bridge_id, user_login_id, message_id);
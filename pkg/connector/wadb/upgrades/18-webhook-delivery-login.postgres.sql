@@ -0,0 +1,9 @@
+-- v18 (compatible with v3+): Mark global webhook deliveries and delete deliveries of removed logins
+DELETE FROM whatsapp_webhook_delivery
+WHERE NOT EXISTS (
+    SELECT 1 FROM user_login
+    WHERE user_login.bridge_id=whatsapp_webhook_delivery.bridge_id AND user_login.id=whatsapp_webhook_delivery.user_login_id
+);
+ALTER TABLE whatsapp_webhook_delivery ADD COLUMN is_global BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE whatsapp_webhook_delivery ADD CONSTRAINT whatsapp_webhook_delivery_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+    REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,35 @@
+-- v18 (compatible with v3+): Mark global webhook deliveries and delete deliveries of removed logins
+-- transaction: sqlite-fkey-off
+
+CREATE TABLE whatsapp_webhook_delivery_new (
+    bridge_id     TEXT    NOT NULL,
+    id            TEXT    NOT NULL,
+    user_login_id TEXT    NOT NULL,
+    url           TEXT    NOT NULL,
+    secret        TEXT    NOT NULL,
+    is_global     BOOLEAN NOT NULL DEFAULT false,
+    event_type    TEXT    NOT NULL,
+    payload       TEXT    NOT NULL,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    next_attempt  BIGINT  NOT NULL,
+    last_error    TEXT    NOT NULL DEFAULT '',
+    created_at    BIGINT  NOT NULL,
+
+    PRIMARY KEY (bridge_id, id),
+    CONSTRAINT whatsapp_webhook_delivery_user_login_fkey FOREIGN KEY (bridge_id, user_login_id)
+        REFERENCES user_login (bridge_id, id) ON UPDATE CASCADE ON DELETE CASCADE
+);
+
+INSERT INTO whatsapp_webhook_delivery_new (
+    bridge_id, id, user_login_id, url, secret, event_type, payload, attempts, next_attempt, last_error, created_at
+)
+SELECT bridge_id, id, user_login_id, url, secret, event_type, payload, attempts, next_attempt, last_error, created_at
+FROM whatsapp_webhook_delivery
+WHERE EXISTS (
+    SELECT 1 FROM user_login
+    WHERE user_login.bridge_id=whatsapp_webhook_delivery.bridge_id AND user_login.id=whatsapp_webhook_delivery.user_login_id
+);
+
+DROP TABLE whatsapp_webhook_delivery;
+ALTER TABLE whatsapp_webhook_delivery_new RENAME TO whatsapp_webhook_delivery;
+CREATE INDEX whatsapp_webhook_delivery_next_attempt_idx ON whatsapp_webhook_delivery (bridge_id, next_attempt);
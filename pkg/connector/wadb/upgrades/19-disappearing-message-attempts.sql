@@ -0,0 +1,2 @@
+-- v19 (compatible with v3+): Count failed redaction attempts of disappearing messages
+ALTER TABLE whatsapp_disappearing_message ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
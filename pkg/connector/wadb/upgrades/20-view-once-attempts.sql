@@ -0,0 +1,2 @@
+-- v20 (compatible with v3+): Count failed redaction attempts of view-once messages
+ALTER TABLE whatsapp_view_once ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
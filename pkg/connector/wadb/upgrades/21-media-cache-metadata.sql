@@ -0,0 +1,6 @@
+-- v21 (compatible with v3+): Store message metadata of cached WhatsApp uploads
+-- Existing entries don't have the metadata, so they're dropped instead of being reused without it
+DELETE FROM whatsapp_media_cache_whatsapp;
+ALTER TABLE whatsapp_media_cache_whatsapp ADD COLUMN page_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE whatsapp_media_cache_whatsapp ADD COLUMN seconds INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE whatsapp_media_cache_whatsapp ADD COLUMN waveform bytea;
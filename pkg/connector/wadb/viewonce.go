@@ -0,0 +1,94 @@
+package wadb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"go.mau.fi/util/dbutil"
+)
+
+type ViewOnceQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*ViewOnceMessage]
+}
+
+type ViewOnceMessage struct {
+	BridgeID    networkid.BridgeID
+	UserLoginID networkid.UserLoginID
+	MessageID   networkid.MessageID
+	RoomID      id.RoomID
+	EventID     id.EventID
+	MediaURI    id.ContentURIString
+	ExpireAt    time.Time
+	Attempts    int
+}
+
+const (
+	putViewOnceQuery = `
+		INSERT INTO whatsapp_view_once (bridge_id, user_login_id, message_id, room_id, event_id, media_uri, expire_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (bridge_id, event_id) DO NOTHING
+	`
+	expireViewOnceByMessageIDQuery = `
+		UPDATE whatsapp_view_once SET expire_at=$4
+		WHERE bridge_id=$1 AND user_login_id=$2 AND message_id=$3 AND (expire_at IS NULL OR expire_at>$4)
+	`
+	rescheduleViewOnceQuery = `
+		UPDATE whatsapp_view_once SET expire_at=$3, attempts=attempts+1 WHERE bridge_id=$1 AND event_id=$2
+	`
+	getDueViewOnceQuery = `
+		SELECT bridge_id, user_login_id, message_id, room_id, event_id, media_uri, expire_at, attempts
+		FROM whatsapp_view_once
+		WHERE bridge_id=$1 AND expire_at IS NOT NULL AND expire_at<=$2
+		ORDER BY expire_at
+		LIMIT $3
+	`
+	deleteViewOnceQuery = `
+		DELETE FROM whatsapp_view_once WHERE bridge_id=$1 AND event_id=$2
+	`
+)
+
+func (voq *ViewOnceQuery) Put(ctx context.Context, vo *ViewOnceMessage) error {
+	vo.BridgeID = voq.BridgeID
+	return voq.Exec(ctx, putViewOnceQuery, vo.sqlVariables()...)
+}
+
+// ExpireByMessageID moves the expiry of the parts of the given message to the given time,
+// unless they're already set to expire earlier.
+func (voq *ViewOnceQuery) ExpireByMessageID(ctx context.Context, loginID networkid.UserLoginID, messageID networkid.MessageID, ts time.Time) error {
+	return voq.Exec(ctx, expireViewOnceByMessageIDQuery, voq.BridgeID, loginID, messageID, ts.UnixMilli())
+}
+
+// Reschedule moves the redaction of the given event to the given time and counts it as a failed attempt.
+func (voq *ViewOnceQuery) Reschedule(ctx context.Context, eventID id.EventID, ts time.Time) error {
+	return voq.Exec(ctx, rescheduleViewOnceQuery, voq.BridgeID, eventID, ts.UnixMilli())
+}
+
+func (voq *ViewOnceQuery) GetDue(ctx context.Context, limit int) ([]*ViewOnceMessage, error) {
+	return voq.QueryMany(ctx, getDueViewOnceQuery, voq.BridgeID, time.Now().UnixMilli(), limit)
+}
+
+func (voq *ViewOnceQuery) Delete(ctx context.Context, eventID id.EventID) error {
+	return voq.Exec(ctx, deleteViewOnceQuery, voq.BridgeID, eventID)
+}
+
+func (vo *ViewOnceMessage) Scan(row dbutil.Scannable) (*ViewOnceMessage, error) {
+	var expireAt sql.NullInt64
+	err := row.Scan(&vo.BridgeID, &vo.UserLoginID, &vo.MessageID, &vo.RoomID, &vo.EventID, &vo.MediaURI, &expireAt, &vo.Attempts)
+	if err != nil {
+		return nil, err
+	}
+	if expireAt.Valid {
+		vo.ExpireAt = time.UnixMilli(expireAt.Int64)
+	}
+	return vo, nil
+}
+
+func (vo *ViewOnceMessage) sqlVariables() []any {
+	return []any{
+		vo.BridgeID, vo.UserLoginID, vo.MessageID, vo.RoomID, vo.EventID, vo.MediaURI, dbutil.UnixMilliPtr(vo.ExpireAt),
+	}
+}
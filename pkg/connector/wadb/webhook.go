@@ -0,0 +1,114 @@
+package wadb
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"go.mau.fi/util/dbutil"
+)
+
+type WebhookDeliveryQuery struct {
+	BridgeID networkid.BridgeID
+	*dbutil.QueryHelper[*WebhookDelivery]
+}
+
+type WebhookDelivery struct {
+	BridgeID    networkid.BridgeID
+	ID          string
+	UserLoginID networkid.UserLoginID
+	URL         string
+	Secret      string
+	// Global is set for deliveries to the webhook URL in the bridge config, which may point at private addresses.
+	Global      bool
+	EventType   string
+	Payload     json.RawMessage
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	CreatedAt   time.Time
+}
+
+const (
+	insertWebhookDeliveryQuery = `
+		INSERT INTO whatsapp_webhook_delivery (
+			bridge_id, id, user_login_id, url, secret, is_global, event_type, payload, attempts, next_attempt, last_error,
+			created_at
+		)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+	`
+	updateWebhookDeliveryQuery = `
+		UPDATE whatsapp_webhook_delivery SET attempts=$3, next_attempt=$4, last_error=$5
+		WHERE bridge_id=$1 AND id=$2
+	`
+	deleteWebhookDeliveryQuery = `
+		DELETE FROM whatsapp_webhook_delivery WHERE bridge_id=$1 AND id=$2
+	`
+	deleteLoginWebhookDeliveriesQuery = `
+		DELETE FROM whatsapp_webhook_delivery WHERE bridge_id=$1 AND user_login_id=$2 AND is_global=false
+	`
+	getDueWebhookDeliveriesQuery = `
+		SELECT
+			bridge_id, id, user_login_id, url, secret, is_global, event_type, payload, attempts, next_attempt, last_error,
+			created_at
+		FROM whatsapp_webhook_delivery
+		WHERE bridge_id=$1 AND next_attempt<=$2
+		ORDER BY created_at, id
+		LIMIT $3
+	`
+	countWebhookDeliveriesQuery = `
+		SELECT COUNT(*) FROM whatsapp_webhook_delivery WHERE bridge_id=$1
+	`
+)
+
+func (wdq *WebhookDeliveryQuery) Insert(ctx context.Context, wd *WebhookDelivery) error {
+	wd.BridgeID = wdq.BridgeID
+	return wdq.Exec(ctx, insertWebhookDeliveryQuery, wd.sqlVariables()...)
+}
+
+func (wdq *WebhookDeliveryQuery) Update(ctx context.Context, wd *WebhookDelivery) error {
+	return wdq.Exec(ctx, updateWebhookDeliveryQuery, wdq.BridgeID, wd.ID, wd.Attempts, wd.NextAttempt.UnixMilli(), wd.LastError)
+}
+
+func (wdq *WebhookDeliveryQuery) Delete(ctx context.Context, id string) error {
+	return wdq.Exec(ctx, deleteWebhookDeliveryQuery, wdq.BridgeID, id)
+}
+
+// DeleteLoginDeliveries deletes all pending deliveries to the webhook subscription of the given login.
+// Deliveries to the global webhook URL are kept.
+func (wdq *WebhookDeliveryQuery) DeleteLoginDeliveries(ctx context.Context, loginID networkid.UserLoginID) error {
+	return wdq.Exec(ctx, deleteLoginWebhookDeliveriesQuery, wdq.BridgeID, loginID)
+}
+
+func (wdq *WebhookDeliveryQuery) GetDue(ctx context.Context, limit int) ([]*WebhookDelivery, error) {
+	return wdq.QueryMany(ctx, getDueWebhookDeliveriesQuery, wdq.BridgeID, time.Now().UnixMilli(), limit)
+}
+
+func (wdq *WebhookDeliveryQuery) Count(ctx context.Context) (count int, err error) {
+	err = wdq.GetDB().QueryRow(ctx, countWebhookDeliveriesQuery, wdq.BridgeID).Scan(&count)
+	return
+}
+
+func (wd *WebhookDelivery) Scan(row dbutil.Scannable) (*WebhookDelivery, error) {
+	var payload string
+	var nextAttempt, createdAt int64
+	err := row.Scan(
+		&wd.BridgeID, &wd.ID, &wd.UserLoginID, &wd.URL, &wd.Secret, &wd.Global, &wd.EventType, &payload,
+		&wd.Attempts, &nextAttempt, &wd.LastError, &createdAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	wd.Payload = json.RawMessage(payload)
+	wd.NextAttempt = time.UnixMilli(nextAttempt)
+	wd.CreatedAt = time.UnixMilli(createdAt)
+	return wd, nil
+}
+
+func (wd *WebhookDelivery) sqlVariables() []any {
+	return []any{
+		wd.BridgeID, wd.ID, wd.UserLoginID, wd.URL, wd.Secret, wd.Global, wd.EventType, string(wd.Payload),
+		wd.Attempts, wd.NextAttempt.UnixMilli(), wd.LastError, wd.CreatedAt.UnixMilli(),
+	}
+}
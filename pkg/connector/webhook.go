@@ -0,0 +1,524 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mautrix "github.com/iKonoTelecomunicaciones/go"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/status"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/random"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+var ErrWebhooksDisabled = bridgev2.RespError{
+	ErrCode:    "FI.MAU.WHATSAPP.WEBHOOKS_DISABLED",
+	Err:        "Webhooks are not enabled on this bridge",
+	StatusCode: http.StatusBadRequest,
+}
+
+const (
+	webhookPollInterval = 10 * time.Second
+	webhookBatchSize    = 50
+	// webhookQueueSize is the number of deliveries that can wait to be saved by the queue loop before
+	// event handlers have to save them by themselves.
+	webhookQueueSize = 1024
+)
+
+// WebhookPayload is the JSON body sent to webhook URLs.
+type WebhookPayload struct {
+	ID          string                `json:"id"`
+	Type        waid.WebhookEventType `json:"type"`
+	Timestamp   int64                 `json:"timestamp"`
+	UserLoginID string                `json:"user_login_id"`
+	UserMXID    id.UserID             `json:"user_mxid"`
+	Data        any                   `json:"data"`
+}
+
+type webhookMessageData struct {
+	ChatJID     types.JID       `json:"chat_jid"`
+	SenderJID   types.JID       `json:"sender_jid"`
+	MessageID   types.MessageID `json:"message_id"`
+	Timestamp   int64           `json:"timestamp"`
+	IsFromMe    bool            `json:"is_from_me"`
+	IsGroup     bool            `json:"is_group"`
+	PushName    string          `json:"push_name,omitempty"`
+	MessageType string          `json:"message_type"`
+	Text        string          `json:"text,omitempty"`
+}
+
+type webhookReceiptData struct {
+	ChatJID    types.JID         `json:"chat_jid"`
+	SenderJID  types.JID         `json:"sender_jid"`
+	MessageIDs []types.MessageID `json:"message_ids"`
+	Type       string            `json:"type"`
+	Timestamp  int64             `json:"timestamp"`
+}
+
+type webhookGroupChangeData struct {
+	GroupJID  types.JID   `json:"group_jid"`
+	SenderJID *types.JID  `json:"sender_jid,omitempty"`
+	Timestamp int64       `json:"timestamp"`
+	Joined    bool        `json:"joined,omitempty"`
+	Name      *string     `json:"name,omitempty"`
+	Topic     *string     `json:"topic,omitempty"`
+	Join      []types.JID `json:"join,omitempty"`
+	Leave     []types.JID `json:"leave,omitempty"`
+	Promote   []types.JID `json:"promote,omitempty"`
+	Demote    []types.JID `json:"demote,omitempty"`
+	Deleted   bool        `json:"deleted,omitempty"`
+}
+
+type webhookLogoutData struct {
+	Reason    string `json:"reason"`
+	OnConnect bool   `json:"on_connect"`
+}
+
+type webhookBridgeStateData struct {
+	StateEvent status.BridgeStateEvent     `json:"state_event"`
+	Error      status.BridgeStateErrorCode `json:"error,omitempty"`
+	Message    string                      `json:"message,omitempty"`
+}
+
+var webhookReceiptTypes = map[types.ReceiptType]string{
+	types.ReceiptTypeDelivered:  "delivered",
+	types.ReceiptTypeSender:     "sender",
+	types.ReceiptTypeRead:       "read",
+	types.ReceiptTypeReadSelf:   "read-self",
+	types.ReceiptTypePlayed:     "played",
+	types.ReceiptTypePlayedSelf: "played-self",
+}
+
+// webhookTarget is a webhook subscription that an event should be delivered to.
+type webhookTarget struct {
+	*waid.WebhookSubscription
+	// Global is set for the webhook in the bridge config. Only the global webhook may point at private addresses,
+	// as it's set by the bridge admin rather than users.
+	Global bool
+}
+
+func (wa *WhatsAppConnector) webhookTargets(login *bridgev2.UserLogin, evtType waid.WebhookEventType) []webhookTarget {
+	if !wa.Config.Webhooks.Enabled {
+		return nil
+	}
+	var targets []webhookTarget
+	global := &waid.WebhookSubscription{
+		URL:    wa.Config.Webhooks.URL,
+		Secret: wa.Config.Webhooks.Secret,
+		Events: wa.Config.Webhooks.Events,
+	}
+	if evtType != waid.WebhookEventTest && global.Wants(evtType) {
+		targets = append(targets, webhookTarget{WebhookSubscription: global, Global: true})
+	}
+	if sub := login.Metadata.(*waid.UserLoginMetadata).Webhook; sub.Wants(evtType) {
+		targets = append(targets, webhookTarget{WebhookSubscription: sub})
+	}
+	return targets
+}
+
+// queueWebhook queues an event to the webhook targets of the login. The deliveries are saved to the database by the
+// queue loop, so event handling isn't blocked by database writes.
+func (wa *WhatsAppClient) queueWebhook(ctx context.Context, evtType waid.WebhookEventType, data any) {
+	targets := wa.Main.webhookTargets(wa.UserLogin, evtType)
+	if len(targets) == 0 {
+		return
+	}
+	log := zerolog.Ctx(ctx)
+	now := time.Now()
+	payload := &WebhookPayload{
+		ID:          random.String(32),
+		Type:        evtType,
+		Timestamp:   now.UnixMilli(),
+		UserLoginID: string(wa.UserLogin.ID),
+		UserMXID:    wa.UserLogin.UserMXID,
+		Data:        data,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Err(err).Str("webhook_event_type", string(evtType)).Msg("Failed to marshal webhook payload")
+		return
+	}
+	for _, target := range targets {
+		delivery := &wadb.WebhookDelivery{
+			ID:          random.String(32),
+			UserLoginID: wa.UserLogin.ID,
+			URL:         target.URL,
+			Secret:      target.Secret,
+			Global:      target.Global,
+			EventType:   string(evtType),
+			Payload:     payloadBytes,
+			NextAttempt: now,
+			CreatedAt:   now,
+		}
+		if wa.Main.stopWebhookLoop.Load() != nil {
+			select {
+			case wa.Main.webhookQueue <- delivery:
+				continue
+			default:
+				log.Warn().Msg("Webhook queue is full, saving delivery directly")
+			}
+		}
+		wa.Main.saveWebhookDelivery(ctx, delivery)
+	}
+}
+
+func (wa *WhatsAppConnector) saveWebhookDelivery(ctx context.Context, delivery *wadb.WebhookDelivery) {
+	err := wa.DB.Webhook.Insert(ctx, delivery)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).
+			Str("webhook_event_type", delivery.EventType).
+			Str("webhook_delivery_id", delivery.ID).
+			Msg("Failed to queue webhook delivery")
+		return
+	}
+	select {
+	case wa.webhookWakeup <- struct{}{}:
+	default:
+	}
+}
+
+// webhookQueueLoop saves deliveries queued by event handlers to the database. Deliveries that are still waiting
+// when the loop is stopped are saved before returning.
+func (wa *WhatsAppConnector) webhookQueueLoop(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("loop", "webhook queue").Logger()
+	ctx = log.WithContext(ctx)
+	ctxDone := ctx.Done()
+	for {
+		select {
+		case delivery := <-wa.webhookQueue:
+			wa.saveWebhookDelivery(ctx, delivery)
+		case <-ctxDone:
+			ctx = context.WithoutCancel(ctx)
+			for {
+				select {
+				case delivery := <-wa.webhookQueue:
+					wa.saveWebhookDelivery(ctx, delivery)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// QueueTestWebhook queues a test event to the webhook subscription of the login.
+func (wa *WhatsAppClient) QueueTestWebhook(ctx context.Context) error {
+	if !wa.Main.Config.Webhooks.Enabled {
+		return ErrWebhooksDisabled
+	}
+	wa.queueWebhook(ctx, waid.WebhookEventTest, map[string]any{"message": "This is a test event"})
+	return nil
+}
+
+// SetWebhook replaces the webhook subscription of the login. A nil subscription removes the existing one.
+func (wa *WhatsAppClient) SetWebhook(ctx context.Context, sub *waid.WebhookSubscription) error {
+	if !wa.Main.Config.Webhooks.Enabled {
+		return ErrWebhooksDisabled
+	}
+	if sub != nil {
+		for _, evtType := range sub.Events {
+			if !evtType.IsValid() {
+				return bridgev2.WrapRespErr(fmt.Errorf("invalid webhook event type %q", evtType), mautrix.MInvalidParam)
+			}
+		}
+		if err := CheckPublicURL(ctx, sub.URL); err != nil {
+			return bridgev2.WrapRespErr(fmt.Errorf("webhook URL is not allowed: %w", err), mautrix.MInvalidParam)
+		}
+	}
+	meta := wa.UserLogin.Metadata.(*waid.UserLoginMetadata)
+	prev := meta.Webhook
+	meta.Webhook = sub
+	if err := wa.UserLogin.Save(ctx); err != nil {
+		return err
+	}
+	// Pending deliveries were signed up for the old subscription, so don't send them to a removed or changed target
+	if prev != nil && (sub == nil || sub.URL != prev.URL || sub.Secret != prev.Secret) {
+		if err := wa.Main.DB.Webhook.DeleteLoginDeliveries(ctx, wa.UserLogin.ID); err != nil {
+			return fmt.Errorf("failed to delete pending deliveries of old webhook: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetWebhook returns the webhook subscription of the login, or nil if there isn't one.
+func (wa *WhatsAppClient) GetWebhook() *waid.WebhookSubscription {
+	return wa.UserLogin.Metadata.(*waid.UserLoginMetadata).Webhook
+}
+
+func getWebhookMessageText(evt *events.Message) string {
+	msg := evt.Message
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	default:
+		return ""
+	}
+}
+
+func (wa *WhatsAppClient) queueMessageWebhook(ctx context.Context, evt *events.Message) {
+	msgType := getMessageType(evt.Message)
+	if msgType == "ignore" || strings.HasPrefix(msgType, "unknown_protocol_") {
+		return
+	}
+	wa.queueWebhook(ctx, waid.WebhookEventMessage, &webhookMessageData{
+		ChatJID:     evt.Info.Chat,
+		SenderJID:   evt.Info.Sender,
+		MessageID:   evt.Info.ID,
+		Timestamp:   evt.Info.Timestamp.UnixMilli(),
+		IsFromMe:    evt.Info.IsFromMe,
+		IsGroup:     evt.Info.IsGroup,
+		PushName:    evt.Info.PushName,
+		MessageType: msgType,
+		Text:        getWebhookMessageText(evt),
+	})
+}
+
+func (wa *WhatsAppClient) queueReceiptWebhook(ctx context.Context, evt *events.Receipt) {
+	receiptType, ok := webhookReceiptTypes[evt.Type]
+	if !ok {
+		return
+	}
+	wa.queueWebhook(ctx, waid.WebhookEventReceipt, &webhookReceiptData{
+		ChatJID:    evt.Chat,
+		SenderJID:  evt.Sender,
+		MessageIDs: evt.MessageIDs,
+		Type:       receiptType,
+		Timestamp:  evt.Timestamp.UnixMilli(),
+	})
+}
+
+func (wa *WhatsAppClient) queueGroupChangeWebhook(ctx context.Context, evt *events.GroupInfo) {
+	data := &webhookGroupChangeData{
+		GroupJID:  evt.JID,
+		SenderJID: evt.Sender,
+		Timestamp: evt.Timestamp.UnixMilli(),
+		Join:      evt.Join,
+		Leave:     evt.Leave,
+		Promote:   evt.Promote,
+		Demote:    evt.Demote,
+		Deleted:   evt.Delete != nil,
+	}
+	if evt.Name != nil {
+		data.Name = &evt.Name.Name
+	}
+	if evt.Topic != nil {
+		data.Topic = &evt.Topic.Topic
+	}
+	wa.queueWebhook(ctx, waid.WebhookEventGroupChange, data)
+}
+
+func (wa *WhatsAppClient) queueJoinedGroupWebhook(ctx context.Context, evt *events.JoinedGroup) {
+	wa.queueWebhook(ctx, waid.WebhookEventGroupChange, &webhookGroupChangeData{
+		GroupJID:  evt.JID,
+		SenderJID: evt.Sender,
+		Timestamp: time.Now().UnixMilli(),
+		Joined:    true,
+		Name:      &evt.Name,
+		Topic:     &evt.Topic,
+	})
+}
+
+// sendBridgeState sends the bridge state and queues a webhook if the state changed.
+func (wa *WhatsAppClient) sendBridgeState(state status.BridgeState) {
+	prev := wa.UserLogin.BridgeState.GetPrev()
+	wa.UserLogin.BridgeState.Send(state)
+	if prev.StateEvent == state.StateEvent && prev.Error == state.Error {
+		return
+	}
+	wa.queueWebhook(wa.UserLogin.Log.WithContext(wa.Main.Bridge.BackgroundCtx), waid.WebhookEventBridgeState, &webhookBridgeStateData{
+		StateEvent: state.StateEvent,
+		Error:      state.Error,
+		Message:    state.Message,
+	})
+}
+
+func (wa *WhatsAppConnector) webhookLoop(ctx context.Context) {
+	log := zerolog.Ctx(ctx).With().Str("loop", "webhook delivery").Logger()
+	ctx = log.WithContext(ctx)
+	ticker := time.NewTicker(webhookPollInterval)
+	defer ticker.Stop()
+	ctxDone := ctx.Done()
+	for {
+		wa.deliverDueWebhooks(ctx)
+		select {
+		case <-ticker.C:
+		case <-wa.webhookWakeup:
+		case <-ctxDone:
+			return
+		}
+	}
+}
+
+func (wa *WhatsAppConnector) deliverDueWebhooks(ctx context.Context) {
+	for ctx.Err() == nil {
+		deliveries, err := wa.DB.Webhook.GetDue(ctx, webhookBatchSize)
+		if err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to get due webhook deliveries")
+			return
+		}
+		wa.deliverWebhookBatch(ctx, deliveries)
+		if len(deliveries) < webhookBatchSize {
+			return
+		}
+	}
+}
+
+// deliverWebhookBatch delivers the given webhooks using a bounded number of workers.
+// Deliveries are grouped by URL, so a slow target doesn't block others, but each target still
+// receives its webhooks one at a time in the order they were queued.
+func (wa *WhatsAppConnector) deliverWebhookBatch(ctx context.Context, deliveries []*wadb.WebhookDelivery) {
+	byTarget := make(map[string][]*wadb.WebhookDelivery)
+	var targets []string
+	for _, delivery := range deliveries {
+		if _, ok := byTarget[delivery.URL]; !ok {
+			targets = append(targets, delivery.URL)
+		}
+		byTarget[delivery.URL] = append(byTarget[delivery.URL], delivery)
+	}
+	queue := make(chan []*wadb.WebhookDelivery, len(targets))
+	for _, target := range targets {
+		queue <- byTarget[target]
+	}
+	close(queue)
+	var wg sync.WaitGroup
+	for range min(max(wa.Config.Webhooks.Concurrency, 1), len(targets)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for targetDeliveries := range queue {
+				for _, delivery := range targetDeliveries {
+					if ctx.Err() != nil {
+						return
+					}
+					wa.deliverWebhook(ctx, delivery)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func signWebhook(secret, webhookID, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(webhookID))
+	mac.Write([]byte("."))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookClientFor returns the HTTP client to use for the given delivery. The global URL is set by the bridge admin,
+// so it's allowed to point at internal services, while per-login URLs must be public.
+func (wa *WhatsAppConnector) webhookClientFor(delivery *wadb.WebhookDelivery) *http.Client {
+	if delivery.Global {
+		return wa.webhookClient
+	}
+	return wa.webhookPublicClient
+}
+
+func (wa *WhatsAppConnector) sendWebhookRequest(ctx context.Context, delivery *wadb.WebhookDelivery) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to prepare request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", delivery.ID)
+	req.Header.Set("X-Webhook-Event", delivery.EventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if delivery.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", signWebhook(delivery.Secret, delivery.ID, timestamp, delivery.Payload))
+	}
+	resp, err := wa.webhookClientFor(delivery).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (wa *WhatsAppConnector) deliverWebhook(ctx context.Context, delivery *wadb.WebhookDelivery) {
+	log := zerolog.Ctx(ctx).With().
+		Str("webhook_delivery_id", delivery.ID).
+		Str("webhook_event_type", delivery.EventType).
+		Str("user_login_id", string(delivery.UserLoginID)).
+		Logger()
+	err := wa.sendWebhookRequest(ctx, delivery)
+	if err == nil {
+		log.Debug().Int("attempts", delivery.Attempts+1).Msg("Delivered webhook")
+		if err = wa.DB.Webhook.Delete(ctx, delivery.ID); err != nil {
+			log.Err(err).Msg("Failed to delete delivered webhook from queue")
+		}
+		return
+	} else if ctx.Err() != nil {
+		return
+	}
+	delivery.Attempts++
+	delivery.LastError = err.Error()
+	cfg := &wa.Config.Webhooks
+	if delivery.Attempts >= cfg.MaxAttempts {
+		log.Error().Err(err).Int("attempts", delivery.Attempts).Msg("Giving up on webhook delivery")
+		if err = wa.DB.Webhook.Delete(ctx, delivery.ID); err != nil {
+			log.Err(err).Msg("Failed to delete failed webhook from queue")
+		}
+		return
+	}
+	backoff := cfg.RetryBackoff << (delivery.Attempts - 1)
+	if backoff <= 0 || backoff > cfg.MaxRetryBackoff {
+		backoff = cfg.MaxRetryBackoff
+	}
+	delivery.NextAttempt = time.Now().Add(backoff)
+	log.Warn().Err(err).
+		Int("attempts", delivery.Attempts).
+		Time("next_attempt", delivery.NextAttempt).
+		Msg("Failed to deliver webhook, will retry")
+	if err = wa.DB.Webhook.Update(ctx, delivery); err != nil {
+		log.Err(err).Msg("Failed to update webhook delivery in queue")
+	}
+}
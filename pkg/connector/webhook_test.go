@@ -0,0 +1,183 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/dbutil"
+	_ "go.mau.fi/util/dbutil/litestream"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+func TestDeliverDueWebhooks(t *testing.T) {
+	for name, test := range map[string]struct {
+		statuses         []int
+		global           bool
+		path             string
+		rounds           int
+		expectedRequests int
+		expectedQueued   int
+		expectedError    string
+	}{
+		"delivered":                {statuses: []int{http.StatusNoContent}, global: true, rounds: 1, expectedRequests: 1},
+		"retried":                  {statuses: []int{http.StatusServiceUnavailable, http.StatusOK}, global: true, rounds: 2, expectedRequests: 2},
+		"gave up":                  {statuses: []int{http.StatusInternalServerError}, global: true, rounds: 3, expectedRequests: 3},
+		"still retrying":           {statuses: []int{http.StatusInternalServerError}, global: true, rounds: 1, expectedRequests: 1, expectedQueued: 1, expectedError: "500"},
+		"private login url":        {rounds: 1, path: "/login", expectedQueued: 1, expectedError: "not allowed"},
+		"login url same as global": {rounds: 1, expectedQueued: 1, expectedError: "not allowed"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var requests atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				expectedSignature := signWebhook("secret", r.Header.Get("X-Webhook-ID"), r.Header.Get("X-Webhook-Timestamp"), body)
+				if r.Header.Get("X-Webhook-Signature") != expectedSignature {
+					t.Errorf("invalid signature %q, expected %q", r.Header.Get("X-Webhook-Signature"), expectedSignature)
+				} else if string(body) != `{"n":1}` {
+					t.Errorf("unexpected body %q", body)
+				}
+				n := int(requests.Add(1))
+				w.WriteHeader(test.statuses[min(n, len(test.statuses))-1])
+			}))
+			defer srv.Close()
+
+			// Foreign keys aren't enforced, so deliveries can be queued without creating the bridgev2 tables
+			rawDB, err := dbutil.NewFromConfig("", dbutil.Config{
+				PoolConfig: dbutil.PoolConfig{Type: "sqlite3", URI: ":memory:", MaxOpenConns: 1, MaxIdleConns: 1},
+			}, nil)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			defer rawDB.Close()
+			ctx := context.Background()
+			wa := &WhatsAppConnector{DB: wadb.New("test", rawDB, zerolog.Nop())}
+			if err = wa.DB.Upgrade(ctx); err != nil {
+				t.Fatalf("failed to upgrade database: %v", err)
+			}
+			wa.Config.Webhooks = WebhookConfig{
+				Enabled:         true,
+				URL:             srv.URL,
+				Timeout:         5 * time.Second,
+				MaxAttempts:     3,
+				RetryBackoff:    time.Millisecond,
+				MaxRetryBackoff: time.Millisecond,
+				Concurrency:     4,
+			}
+			wa.webhookClient = srv.Client()
+			wa.webhookPublicClient = NewPublicHTTPClient(5 * time.Second)
+			err = wa.DB.Webhook.Insert(ctx, &wadb.WebhookDelivery{
+				ID:          "1",
+				UserLoginID: "login",
+				URL:         srv.URL + test.path,
+				Secret:      "secret",
+				Global:      test.global,
+				EventType:   string(waid.WebhookEventTest),
+				Payload:     []byte(`{"n":1}`),
+				NextAttempt: time.Now().Add(-time.Second),
+				CreatedAt:   time.Now(),
+			})
+			if err != nil {
+				t.Fatalf("failed to queue delivery: %v", err)
+			}
+
+			for range test.rounds {
+				wa.deliverDueWebhooks(ctx)
+				time.Sleep(10 * time.Millisecond)
+			}
+			if int(requests.Load()) != test.expectedRequests {
+				t.Errorf("expected %d requests, got %d", test.expectedRequests, requests.Load())
+			}
+			deliveries, err := wa.DB.Webhook.GetDue(ctx, 10)
+			if err != nil {
+				t.Fatalf("failed to get queued deliveries: %v", err)
+			} else if len(deliveries) != test.expectedQueued {
+				t.Fatalf("expected %d queued deliveries, found %d", test.expectedQueued, len(deliveries))
+			} else if len(deliveries) > 0 && !strings.Contains(deliveries[0].LastError, test.expectedError) {
+				t.Errorf("expected last error to contain %q, got %q", test.expectedError, deliveries[0].LastError)
+			}
+		})
+	}
+}
+
+func TestWebhookTargets(t *testing.T) {
+	const globalURL = "https://global.example.com"
+	for name, test := range map[string]struct {
+		events         []waid.WebhookEventType
+		sub            *waid.WebhookSubscription
+		evtType        waid.WebhookEventType
+		expected       []string
+		expectedGlobal []bool
+	}{
+		"global only":           {evtType: waid.WebhookEventMessage, expected: []string{globalURL}, expectedGlobal: []bool{true}},
+		"global filtered out":   {events: []waid.WebhookEventType{waid.WebhookEventReceipt}, evtType: waid.WebhookEventMessage},
+		"test skips global":     {sub: &waid.WebhookSubscription{URL: "https://login.example.com"}, evtType: waid.WebhookEventTest, expected: []string{"https://login.example.com"}, expectedGlobal: []bool{false}},
+		"login with global url": {sub: &waid.WebhookSubscription{URL: globalURL}, evtType: waid.WebhookEventMessage, expected: []string{globalURL, globalURL}, expectedGlobal: []bool{true, false}},
+		"login filtered out":    {events: []waid.WebhookEventType{waid.WebhookEventReceipt}, sub: &waid.WebhookSubscription{URL: "https://login.example.com", Events: []waid.WebhookEventType{waid.WebhookEventReceipt}}, evtType: waid.WebhookEventMessage},
+	} {
+		t.Run(name, func(t *testing.T) {
+			wa := &WhatsAppConnector{}
+			wa.Config.Webhooks = WebhookConfig{Enabled: true, URL: globalURL, Events: test.events}
+			targets := wa.webhookTargets(&bridgev2.UserLogin{UserLogin: &database.UserLogin{
+				Metadata: &waid.UserLoginMetadata{Webhook: test.sub},
+			}}, test.evtType)
+			if len(targets) != len(test.expected) {
+				t.Fatalf("expected %d targets, got %d", len(test.expected), len(targets))
+			}
+			for i, target := range targets {
+				if target.URL != test.expected[i] || target.Global != test.expectedGlobal[i] {
+					t.Errorf("unexpected target %d: url=%s global=%t", i, target.URL, target.Global)
+				}
+			}
+		})
+	}
+}
+
+func TestIsPublicAddress(t *testing.T) {
+	for addr, expected := range map[string]bool{
+		"1.1.1.1":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if IsPublicAddress(netip.MustParseAddr(addr)) != expected {
+			t.Errorf("IsPublicAddress(%s) != %t", addr, expected)
+		}
+	}
+}
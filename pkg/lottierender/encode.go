@@ -0,0 +1,158 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lottierender
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"time"
+
+	"go.mau.fi/webp"
+)
+
+// gifPalette is a transparent color followed by a 6x6x6 color cube.
+var gifPalette = func() color.Palette {
+	palette := color.Palette{color.NRGBA{}}
+	for r := range 6 {
+		for g := range 6 {
+			for b := range 6 {
+				palette = append(palette, color.NRGBA{R: uint8(r * 51), G: uint8(g * 51), B: uint8(b * 51), A: 255})
+			}
+		}
+	}
+	return palette
+}()
+
+func toPaletted(frame *image.RGBA) *image.Paletted {
+	output := image.NewPaletted(frame.Rect, gifPalette)
+	for y := frame.Rect.Min.Y; y < frame.Rect.Max.Y; y++ {
+		for x := frame.Rect.Min.X; x < frame.Rect.Max.X; x++ {
+			pix := frame.Pix[frame.PixOffset(x, y):]
+			// GIF only has binary transparency
+			if pix[3] < 128 {
+				continue
+			}
+			unpremultiply := func(value uint8) int {
+				return (min(int(value)*255/int(pix[3]), 255) + 25) / 51
+			}
+			output.Pix[output.PixOffset(x, y)] = uint8(1 + unpremultiply(pix[0])*36 + unpremultiply(pix[1])*6 + unpremultiply(pix[2]))
+		}
+	}
+	return output
+}
+
+// EncodeGIF encodes the given frames as an infinitely looping animated GIF.
+func EncodeGIF(frames []*image.RGBA, delay time.Duration) ([]byte, error) {
+	anim := &gif.GIF{LoopCount: 0}
+	// GIF delays are in hundredths of a second and most decoders don't handle delays below 2 properly
+	centiseconds := max(int(delay/(10*time.Millisecond)), 2)
+	for _, frame := range frames {
+		anim.Image = append(anim.Image, toPaletted(frame))
+		anim.Delay = append(anim.Delay, centiseconds)
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	var buf bytes.Buffer
+	err := gif.EncodeAll(&buf, anim)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func appendChunk(buf []byte, fourCC string, data []byte) []byte {
+	buf = append(buf, fourCC...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(data)))
+	buf = append(buf, data...)
+	if len(data)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func appendUint24(buf []byte, value int) []byte {
+	return append(buf, byte(value), byte(value>>8), byte(value>>16))
+}
+
+// extractImageChunks returns the chunks of a still WebP image that contain the actual image data.
+func extractImageChunks(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("invalid webp header")
+	}
+	var output []byte
+	for offset := 12; offset+8 <= len(data); {
+		fourCC := string(data[offset : offset+4])
+		size := int(binary.LittleEndian.Uint32(data[offset+4 : offset+8]))
+		end := offset + 8 + size
+		if end > len(data) {
+			return nil, fmt.Errorf("truncated %s chunk", fourCC)
+		}
+		switch fourCC {
+		case "ALPH", "VP8 ", "VP8L":
+			output = appendChunk(output, fourCC, data[offset+8:end])
+		}
+		offset = end + size%2
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("no image data in webp")
+	}
+	return output, nil
+}
+
+// EncodeWebP encodes the given frames as an infinitely looping animated WebP.
+func EncodeWebP(frames []*image.RGBA, delay time.Duration, quality float32) ([]byte, error) {
+	if len(frames) == 0 {
+		return nil, fmt.Errorf("no frames to encode")
+	}
+	width, height := frames[0].Rect.Dx(), frames[0].Rect.Dy()
+	const (
+		flagAnimation = 0x02
+		flagAlpha     = 0x10
+		// Don't blend frames with the previous canvas, every frame is a full image
+		frameNoBlend = 0x02
+	)
+	vp8x := []byte{flagAnimation | flagAlpha, 0, 0, 0}
+	vp8x = appendUint24(vp8x, width-1)
+	vp8x = appendUint24(vp8x, height-1)
+	body := []byte("WEBP")
+	body = appendChunk(body, "VP8X", vp8x)
+	// Transparent background color and infinite loop
+	body = appendChunk(body, "ANIM", []byte{0, 0, 0, 0, 0, 0})
+	durationMS := max(int(delay/time.Millisecond), 1)
+	for i, frame := range frames {
+		encoded, err := webp.EncodeRGBA(frame, quality)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode frame #%d: %w", i+1, err)
+		}
+		imageChunks, err := extractImageChunks(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse frame #%d: %w", i+1, err)
+		}
+		anmf := appendUint24(nil, 0)
+		anmf = appendUint24(anmf, 0)
+		anmf = appendUint24(anmf, frame.Rect.Dx()-1)
+		anmf = appendUint24(anmf, frame.Rect.Dy()-1)
+		anmf = appendUint24(anmf, durationMS)
+		anmf = append(anmf, frameNoBlend)
+		anmf = append(anmf, imageChunks...)
+		body = appendChunk(body, "ANMF", anmf)
+	}
+	return appendChunk(nil, "RIFF", body), nil
+}
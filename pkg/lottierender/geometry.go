@@ -0,0 +1,375 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lottierender
+
+import (
+	"math"
+)
+
+// matrix is a 2D affine transformation where x' = a*x + c*y + e and y' = b*x + d*y + f.
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+func translate(x, y float64) matrix {
+	return matrix{1, 0, 0, 1, x, y}
+}
+
+func scale(x, y float64) matrix {
+	return matrix{x, 0, 0, y, 0, 0}
+}
+
+func rotate(degrees float64) matrix {
+	sin, cos := math.Sincos(degrees * math.Pi / 180)
+	return matrix{cos, sin, -sin, cos, 0, 0}
+}
+
+// mul returns a matrix that applies n first and then m.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m matrix) apply(p point) point {
+	return point{m[0]*p[0] + m[2]*p[1] + m[4], m[1]*p[0] + m[3]*p[1] + m[5]}
+}
+
+func (m matrix) invert() (matrix, bool) {
+	det := m[0]*m[3] - m[1]*m[2]
+	if math.Abs(det) < 1e-12 {
+		return identity, false
+	}
+	return matrix{
+		m[3] / det,
+		-m[1] / det,
+		-m[2] / det,
+		m[0] / det,
+		(m[2]*m[5] - m[3]*m[4]) / det,
+		(m[1]*m[4] - m[0]*m[5]) / det,
+	}, true
+}
+
+type point [2]float64
+
+func (p point) add(o point) point {
+	return point{p[0] + o[0], p[1] + o[1]}
+}
+
+func (p point) sub(o point) point {
+	return point{p[0] - o[0], p[1] - o[1]}
+}
+
+func (p point) mul(s float64) point {
+	return point{p[0] * s, p[1] * s}
+}
+
+func (p point) length() float64 {
+	return math.Hypot(p[0], p[1])
+}
+
+func (p point) dot(o point) float64 {
+	return p[0]*o[0] + p[1]*o[1]
+}
+
+func (p point) cross(o point) float64 {
+	return p[0]*o[1] - p[1]*o[0]
+}
+
+// contour is a sequence of cubic bezier segments: the start point followed by triples of two control points and
+// the end point.
+type contour struct {
+	points []point
+	closed bool
+}
+
+type path []contour
+
+func (p path) transform(m matrix) path {
+	output := make(path, len(p))
+	for i, c := range p {
+		output[i] = contour{points: make([]point, len(c.points)), closed: c.closed}
+		for j, pt := range c.points {
+			output[i].points[j] = m.apply(pt)
+		}
+	}
+	return output
+}
+
+func (c *contour) lineTo(p point) {
+	last := c.points[len(c.points)-1]
+	c.points = append(c.points, last, p, p)
+}
+
+func (c *contour) cubeTo(c1, c2, p point) {
+	c.points = append(c.points, c1, c2, p)
+}
+
+func bezierPath(b *Bezier) path {
+	if b == nil || len(b.Vertices) == 0 {
+		return nil
+	}
+	tangent := func(list [][2]float64, i int) point {
+		if i < len(list) {
+			return list[i]
+		}
+		return point{}
+	}
+	c := contour{points: []point{b.Vertices[0]}, closed: b.Closed}
+	segment := func(from, to int) {
+		c.cubeTo(
+			point(b.Vertices[from]).add(tangent(b.OutTangents, from)),
+			point(b.Vertices[to]).add(tangent(b.InTangents, to)),
+			b.Vertices[to],
+		)
+	}
+	for i := 1; i < len(b.Vertices); i++ {
+		segment(i-1, i)
+	}
+	if b.Closed && len(b.Vertices) > 1 {
+		segment(len(b.Vertices)-1, 0)
+	}
+	return path{c}
+}
+
+// kappa is the distance of bezier control points from the vertices when approximating a quarter circle.
+const kappa = 0.5522847498
+
+func rectanglePath(center, size point, roundness float64) path {
+	hw, hh := size[0]/2, size[1]/2
+	left, right, top, bottom := center[0]-hw, center[0]+hw, center[1]-hh, center[1]+hh
+	r := min(roundness, hw, hh)
+	if r <= 0 {
+		c := contour{points: []point{{right, top}}, closed: true}
+		c.lineTo(point{right, bottom})
+		c.lineTo(point{left, bottom})
+		c.lineTo(point{left, top})
+		c.lineTo(point{right, top})
+		return path{c}
+	}
+	k := r * kappa
+	c := contour{points: []point{{right, top + r}}, closed: true}
+	c.lineTo(point{right, bottom - r})
+	c.cubeTo(point{right, bottom - r + k}, point{right - r + k, bottom}, point{right - r, bottom})
+	c.lineTo(point{left + r, bottom})
+	c.cubeTo(point{left + r - k, bottom}, point{left, bottom - r + k}, point{left, bottom - r})
+	c.lineTo(point{left, top + r})
+	c.cubeTo(point{left, top + r - k}, point{left + r - k, top}, point{left + r, top})
+	c.lineTo(point{right - r, top})
+	c.cubeTo(point{right - r + k, top}, point{right, top + r - k}, point{right, top + r})
+	return path{c}
+}
+
+func ellipsePath(center, size point) path {
+	rx, ry := size[0]/2, size[1]/2
+	kx, ky := rx*kappa, ry*kappa
+	cx, cy := center[0], center[1]
+	c := contour{points: []point{{cx, cy - ry}}, closed: true}
+	c.cubeTo(point{cx + kx, cy - ry}, point{cx + rx, cy - ky}, point{cx + rx, cy})
+	c.cubeTo(point{cx + rx, cy + ky}, point{cx + kx, cy + ry}, point{cx, cy + ry})
+	c.cubeTo(point{cx - kx, cy + ry}, point{cx - rx, cy + ky}, point{cx - rx, cy})
+	c.cubeTo(point{cx - rx, cy - ky}, point{cx - kx, cy - ry}, point{cx, cy - ry})
+	return path{c}
+}
+
+const (
+	starTypeStar    = 1
+	starTypePolygon = 2
+
+	maxStarPoints = 1000
+)
+
+func starPath(starType int, center point, points, rotation, outer, inner float64) path {
+	count := int(math.Round(points))
+	if count < 2 || count > maxStarPoints {
+		return nil
+	}
+	vertexCount := count * 2
+	if starType == starTypePolygon {
+		vertexCount = count
+	}
+	step := 2 * math.Pi / float64(vertexCount)
+	angle := (rotation - 90) * math.Pi / 180
+	var c contour
+	for i := range vertexCount {
+		radius := outer
+		if starType != starTypePolygon && i%2 == 1 {
+			radius = inner
+		}
+		sin, cos := math.Sincos(angle + float64(i)*step)
+		vertex := center.add(point{cos * radius, sin * radius})
+		if i == 0 {
+			c.points = []point{vertex}
+		} else {
+			c.lineTo(vertex)
+		}
+	}
+	c.lineTo(c.points[0])
+	c.closed = true
+	return path{c}
+}
+
+// flatten converts a contour into a polyline. The matrix is only used to decide how many line segments each curve
+// needs, the output is in the same coordinate space as the input.
+func (c *contour) flatten(m matrix) []point {
+	if len(c.points) == 0 {
+		return nil
+	}
+	output := []point{c.points[0]}
+	for i := 1; i+2 < len(c.points); i += 3 {
+		p0, p1, p2, p3 := c.points[i-1], c.points[i], c.points[i+1], c.points[i+2]
+		if p0 == p1 && p2 == p3 {
+			output = append(output, p3)
+			continue
+		}
+		deviceLength := m.apply(p1).sub(m.apply(p0)).length() +
+			m.apply(p2).sub(m.apply(p1)).length() +
+			m.apply(p3).sub(m.apply(p2)).length()
+		steps := min(max(int(deviceLength/3), 1), 100)
+		for step := 1; step <= steps; step++ {
+			t := float64(step) / float64(steps)
+			inv := 1 - t
+			output = append(output, p0.mul(inv*inv*inv).
+				add(p1.mul(3*inv*inv*t)).
+				add(p2.mul(3*inv*t*t)).
+				add(p3.mul(t*t*t)))
+		}
+	}
+	// Remove duplicate points so that every segment has a direction
+	deduped := output[:1]
+	for _, pt := range output[1:] {
+		if pt.sub(deduped[len(deduped)-1]).length() > 1e-9 {
+			deduped = append(deduped, pt)
+		}
+	}
+	return deduped
+}
+
+const (
+	lineCapButt   = 1
+	lineCapRound  = 2
+	lineCapSquare = 3
+
+	lineJoinMiter = 1
+	lineJoinRound = 2
+	lineJoinBevel = 3
+)
+
+type strokeStyle struct {
+	width      float64
+	lineCap    int
+	lineJoin   int
+	miterLimit float64
+}
+
+// strokePolygons converts the outline of a stroked path into polygons that cover the stroke when filled together.
+func strokePolygons(p path, style strokeStyle, m matrix) [][]point {
+	hw := style.width / 2
+	var polygons [][]point
+	circle := func(center point) {
+		const segments = 24
+		polygon := make([]point, segments)
+		for i := range polygon {
+			sin, cos := math.Sincos(2 * math.Pi * float64(i) / segments)
+			polygon[i] = center.add(point{cos * hw, sin * hw})
+		}
+		polygons = append(polygons, polygon)
+	}
+	for _, c := range p {
+		line := c.flatten(m)
+		if len(line) < 2 {
+			if len(line) == 1 && style.lineCap == lineCapRound {
+				circle(line[0])
+			}
+			continue
+		}
+		closed := c.closed && line[0].sub(line[len(line)-1]).length() < 1e-6
+		directions := make([]point, len(line)-1)
+		normals := make([]point, len(line)-1)
+		for i := range directions {
+			segment := line[i+1].sub(line[i])
+			directions[i] = segment.mul(1 / segment.length())
+			normals[i] = point{-directions[i][1], directions[i][0]}.mul(hw)
+			polygons = append(polygons, []point{
+				line[i].add(normals[i]), line[i+1].add(normals[i]),
+				line[i+1].sub(normals[i]), line[i].sub(normals[i]),
+			})
+		}
+		join := func(vertex point, prev, next int) {
+			n1, n2 := normals[prev], normals[next]
+			polygons = append(polygons, []point{vertex, vertex.add(n1), vertex.add(n2)})
+			polygons = append(polygons, []point{vertex, vertex.sub(n1), vertex.sub(n2)})
+			bend := directions[prev].dot(directions[next])
+			if bend > 0.99 {
+				return
+			}
+			switch style.lineJoin {
+			case lineJoinRound:
+				circle(vertex)
+			case lineJoinMiter:
+				sum := n1.add(n2)
+				sumLength := sum.length()
+				if sumLength < 1e-9 || 2*hw/sumLength > style.miterLimit {
+					return
+				}
+				// The outer side of the corner is opposite to the direction of the turn
+				side := 1.0
+				if directions[prev].cross(directions[next]) > 0 {
+					side = -1
+				}
+				miter := sum.mul(side * 2 * hw * hw / (sumLength * sumLength))
+				polygons = append(polygons, []point{vertex, vertex.add(n1.mul(side)), vertex.add(miter), vertex.add(n2.mul(side))})
+			}
+		}
+		for i := 1; i < len(line)-1; i++ {
+			join(line[i], i-1, i)
+		}
+		if closed {
+			join(line[0], len(directions)-1, 0)
+			continue
+		}
+		switch style.lineCap {
+		case lineCapRound:
+			circle(line[0])
+			circle(line[len(line)-1])
+		case lineCapSquare:
+			first, last := line[0], line[len(line)-1]
+			startExt, endExt := directions[0].mul(-hw), directions[len(directions)-1].mul(hw)
+			n0, nl := normals[0], normals[len(normals)-1]
+			polygons = append(polygons,
+				[]point{first.add(n0), first.sub(n0), first.sub(n0).add(startExt), first.add(n0).add(startExt)},
+				[]point{last.add(nl), last.sub(nl), last.sub(nl).add(endExt), last.add(nl).add(endExt)},
+			)
+		}
+	}
+	return polygons
+}
+
+// signedArea returns the signed area of a polygon, which is used to give every stroke polygon the same orientation.
+// The rasterizer accumulates coverage with signs, so polygons with opposite orientations would cancel each other out.
+func signedArea(polygon []point) float64 {
+	var area float64
+	for i, pt := range polygon {
+		area += pt.cross(polygon[(i+1)%len(polygon)])
+	}
+	return area / 2
+}
@@ -0,0 +1,321 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package lottierender contains a minimal Lottie renderer for the subset of features used by WhatsApp stickers.
+//
+// Supported features are shape, solid, image, null and precomposition layers, layer parenting, groups with
+// transforms, paths, rectangles, ellipses, polystars, solid and gradient fills, and strokes. Masks, mattes,
+// trim paths, text layers and expressions are ignored.
+package lottierender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"strings"
+
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	// MaxDuration is the longest animation Parse accepts in seconds. Stickers are only a few seconds long.
+	MaxDuration = 10
+	// MaxFrameRate is the highest frame rate Parse accepts.
+	MaxFrameRate = 120
+	// MaxFrames is the highest number of frames Parse accepts, which is also the most frames Render will return.
+	MaxFrames = 600
+	// maxImagePixels limits the size of embedded image assets.
+	maxImagePixels = 4096 * 4096
+)
+
+type Animation struct {
+	FrameRate float64  `json:"fr"`
+	InPoint   float64  `json:"ip"`
+	OutPoint  float64  `json:"op"`
+	Width     float64  `json:"w"`
+	Height    float64  `json:"h"`
+	Layers    []*Layer `json:"layers"`
+	Assets    []*Asset `json:"assets"`
+
+	assetsByID map[string]*Asset
+}
+
+type Asset struct {
+	ID     string   `json:"id"`
+	Layers []*Layer `json:"layers"`
+	Path   string   `json:"p"`
+
+	image image.Image
+}
+
+const (
+	LayerTypePrecomp = 0
+	LayerTypeSolid   = 1
+	LayerTypeImage   = 2
+	LayerTypeNull    = 3
+	LayerTypeShape   = 4
+)
+
+type Layer struct {
+	Type        int        `json:"ty"`
+	Index       *int       `json:"ind"`
+	Parent      *int       `json:"parent"`
+	InPoint     float64    `json:"ip"`
+	OutPoint    float64    `json:"op"`
+	StartTime   float64    `json:"st"`
+	Stretch     float64    `json:"sr"`
+	Hidden      bool       `json:"hd"`
+	MatteSource int        `json:"td"`
+	Transform   *Transform `json:"ks"`
+	Shapes      []*Shape   `json:"shapes"`
+	RefID       string     `json:"refId"`
+	SolidColor  string     `json:"sc"`
+	SolidWidth  float64    `json:"sw"`
+	SolidHeight float64    `json:"sh"`
+}
+
+// localTime converts a frame number of the containing composition into the time used by the layer's properties.
+func (l *Layer) localTime(frame float64) float64 {
+	stretch := l.Stretch
+	if stretch == 0 {
+		stretch = 1
+	}
+	return (frame - l.StartTime) / stretch
+}
+
+type Transform struct {
+	Anchor   *Property `json:"a"`
+	Position *Position `json:"p"`
+	Scale    *Property `json:"s"`
+	Rotation *Property `json:"r"`
+	Opacity  *Property `json:"o"`
+}
+
+func (t *Transform) matrix(frame float64) matrix {
+	if t == nil {
+		return identity
+	}
+	ax, ay := t.Anchor.vec2(frame, 0, 0)
+	px, py := t.Position.vec2(frame)
+	sx, sy := t.Scale.vec2(frame, 100, 100)
+	rotation := t.Rotation.float(frame, 0)
+	return translate(px, py).mul(rotate(rotation)).mul(scale(sx/100, sy/100)).mul(translate(-ax, -ay))
+}
+
+func (t *Transform) opacity(frame float64) float64 {
+	if t == nil {
+		return 1
+	}
+	return t.Opacity.float(frame, 100) / 100
+}
+
+// Position is a transform position, which may have separate properties for each dimension.
+type Position struct {
+	Combined *Property
+	X, Y     *Property
+}
+
+func (p *Position) UnmarshalJSON(data []byte) error {
+	var split struct {
+		Split bool      `json:"s"`
+		X     *Property `json:"x"`
+		Y     *Property `json:"y"`
+	}
+	err := json.Unmarshal(data, &split)
+	if err != nil {
+		return err
+	} else if split.Split {
+		p.X, p.Y = split.X, split.Y
+		return nil
+	}
+	p.Combined = &Property{}
+	return json.Unmarshal(data, p.Combined)
+}
+
+func (p *Position) vec2(frame float64) (float64, float64) {
+	if p == nil {
+		return 0, 0
+	} else if p.Combined != nil {
+		return p.Combined.vec2(frame, 0, 0)
+	}
+	return p.X.float(frame, 0), p.Y.float(frame, 0)
+}
+
+type Shape struct {
+	Type   string
+	Hidden bool
+
+	// Groups
+	Items []*Shape
+	// Paths
+	Path *PathProperty
+	// Rectangles, ellipses and polystars
+	Position  *Property
+	Size      *Property
+	Roundness *Property
+	StarType  int
+	Points    *Property
+	Rotation  *Property
+	Outer     *Property
+	Inner     *Property
+	// Fills, strokes and gradients
+	Color        *Property
+	Opacity      *Property
+	Width        *Property
+	LineCap      int
+	LineJoin     int
+	MiterLimit   float64
+	GradientType int
+	Gradient     *GradientStops
+	Start        *Property
+	End          *Property
+	// Group transforms
+	Transform *Transform
+}
+
+type GradientStops struct {
+	Count  int       `json:"p"`
+	Values *Property `json:"k"`
+}
+
+func (s *Shape) UnmarshalJSON(data []byte) error {
+	var base struct {
+		Type   string `json:"ty"`
+		Hidden bool   `json:"hd"`
+	}
+	err := json.Unmarshal(data, &base)
+	if err != nil {
+		return err
+	}
+	s.Type, s.Hidden = base.Type, base.Hidden
+	switch s.Type {
+	case "gr":
+		var group struct {
+			Items []*Shape `json:"it"`
+		}
+		err = json.Unmarshal(data, &group)
+		s.Items = group.Items
+	case "sh":
+		var path struct {
+			Path *PathProperty `json:"ks"`
+		}
+		err = json.Unmarshal(data, &path)
+		s.Path = path.Path
+	case "rc", "el":
+		var rect struct {
+			Position  *Property `json:"p"`
+			Size      *Property `json:"s"`
+			Roundness *Property `json:"r"`
+		}
+		err = json.Unmarshal(data, &rect)
+		s.Position, s.Size, s.Roundness = rect.Position, rect.Size, rect.Roundness
+	case "sr":
+		var star struct {
+			StarType int       `json:"sy"`
+			Position *Property `json:"p"`
+			Points   *Property `json:"pt"`
+			Rotation *Property `json:"r"`
+			Outer    *Property `json:"or"`
+			Inner    *Property `json:"ir"`
+		}
+		err = json.Unmarshal(data, &star)
+		s.StarType, s.Position, s.Points, s.Rotation, s.Outer, s.Inner =
+			star.StarType, star.Position, star.Points, star.Rotation, star.Outer, star.Inner
+	case "fl", "st":
+		var paint struct {
+			Color      *Property `json:"c"`
+			Opacity    *Property `json:"o"`
+			Width      *Property `json:"w"`
+			LineCap    int       `json:"lc"`
+			LineJoin   int       `json:"lj"`
+			MiterLimit float64   `json:"ml"`
+		}
+		err = json.Unmarshal(data, &paint)
+		s.Color, s.Opacity, s.Width = paint.Color, paint.Opacity, paint.Width
+		s.LineCap, s.LineJoin, s.MiterLimit = paint.LineCap, paint.LineJoin, paint.MiterLimit
+	case "gf", "gs":
+		var gradient struct {
+			Opacity      *Property      `json:"o"`
+			Width        *Property      `json:"w"`
+			LineCap      int            `json:"lc"`
+			LineJoin     int            `json:"lj"`
+			MiterLimit   float64        `json:"ml"`
+			GradientType int            `json:"t"`
+			Gradient     *GradientStops `json:"g"`
+			Start        *Property      `json:"s"`
+			End          *Property      `json:"e"`
+		}
+		err = json.Unmarshal(data, &gradient)
+		s.Opacity, s.Width = gradient.Opacity, gradient.Width
+		s.LineCap, s.LineJoin, s.MiterLimit = gradient.LineCap, gradient.LineJoin, gradient.MiterLimit
+		s.GradientType, s.Gradient, s.Start, s.End = gradient.GradientType, gradient.Gradient, gradient.Start, gradient.End
+	case "tr":
+		s.Transform = &Transform{}
+		err = json.Unmarshal(data, s.Transform)
+	}
+	return err
+}
+
+// Parse parses Lottie JSON and decodes any embedded images.
+func Parse(data []byte) (*Animation, error) {
+	var anim Animation
+	err := json.Unmarshal(data, &anim)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse lottie JSON: %w", err)
+	} else if anim.Width <= 0 || anim.Height <= 0 || anim.FrameRate <= 0 || anim.OutPoint <= anim.InPoint {
+		return nil, fmt.Errorf("invalid animation dimensions or duration")
+	} else if anim.FrameRate > MaxFrameRate {
+		return nil, fmt.Errorf("animation frame rate is too high (%g fps, max %d)", anim.FrameRate, MaxFrameRate)
+	} else if frames := anim.OutPoint - anim.InPoint; frames > MaxFrames {
+		return nil, fmt.Errorf("animation has too many frames (%g, max %d)", frames, MaxFrames)
+	} else if duration := frames / anim.FrameRate; duration > MaxDuration {
+		return nil, fmt.Errorf("animation is too long (%gs, max %ds)", duration, MaxDuration)
+	}
+	anim.assetsByID = make(map[string]*Asset, len(anim.Assets))
+	for _, asset := range anim.Assets {
+		anim.assetsByID[asset.ID] = asset
+		if asset.Layers == nil && strings.HasPrefix(asset.Path, "data:") {
+			asset.image, err = decodeDataURL(asset.Path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode image asset %s: %w", asset.ID, err)
+			}
+		}
+	}
+	return &anim, nil
+}
+
+func decodeDataURL(url string) (image.Image, error) {
+	_, encoded, ok := strings.Cut(url, ";base64,")
+	if !ok {
+		return nil, fmt.Errorf("unsupported data URL")
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	} else if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("invalid image size %dx%d", cfg.Width, cfg.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
@@ -0,0 +1,63 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lottierender
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	data, err := os.ReadFile("testdata/shapes.json")
+	if err != nil {
+		t.Fatalf("failed to read test animation: %v", err)
+	}
+	anim, err := Parse(data)
+	if err != nil {
+		t.Fatalf("failed to parse animation: %v", err)
+	}
+	if anim.FrameRate != 60 || anim.InPoint != 0 || anim.OutPoint != 30 || anim.Width != 256 || anim.Height != 256 {
+		t.Errorf("unexpected animation metadata: %+v", anim)
+	}
+	if len(anim.Layers) != 4 {
+		t.Errorf("expected 4 layers, got %d", len(anim.Layers))
+	}
+	if anim.assetsByID["comp_0"] == nil {
+		t.Errorf("precomposition asset wasn't indexed")
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"not json":          `not json`,
+		"truncated":         `{"fr":30,"ip":0,"op":90,"w":512,"h":512,"layers":[{"ty":4,`,
+		"no size":           `{"fr":30,"ip":0,"op":90,"w":0,"h":512}`,
+		"no frame rate":     `{"fr":0,"ip":0,"op":90,"w":512,"h":512}`,
+		"negative duration": `{"fr":30,"ip":90,"op":0,"w":512,"h":512}`,
+		"too many frames":   `{"fr":120,"ip":0,"op":100000,"w":512,"h":512}`,
+		"too long":          `{"fr":1,"ip":0,"op":60,"w":512,"h":512}`,
+		"high frame rate":   `{"fr":100000,"ip":0,"op":100,"w":512,"h":512}`,
+		"bad image asset":   `{"fr":30,"ip":0,"op":30,"w":512,"h":512,"assets":[{"id":"img","p":"data:image/png;base64,AAAA"}]}`,
+		"wrong types":       `{"fr":"30","ip":0,"op":30,"w":512,"h":512}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse([]byte(data)); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
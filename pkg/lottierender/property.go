@@ -0,0 +1,328 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lottierender
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+)
+
+type easing struct {
+	OutX, OutY, InX, InY float64
+}
+
+var linearEasing = easing{InX: 1, InY: 1}
+
+// apply maps linear progress between two keyframes through the cubic bezier easing curve.
+func (e easing) apply(t float64) float64 {
+	if e.OutX == e.OutY && e.InX == e.InY {
+		return t
+	}
+	bezier := func(s, p1, p2 float64) float64 {
+		inv := 1 - s
+		return 3*inv*inv*s*p1 + 3*inv*s*s*p2 + s*s*s
+	}
+	low, high, s := 0.0, 1.0, t
+	for range 32 {
+		x := bezier(s, e.OutX, e.InX)
+		if math.Abs(x-t) < 1e-6 {
+			break
+		} else if x < t {
+			low = s
+		} else {
+			high = s
+		}
+		s = (low + high) / 2
+	}
+	return bezier(s, e.OutY, e.InY)
+}
+
+type rawEasing struct {
+	X json.RawMessage `json:"x"`
+	Y json.RawMessage `json:"y"`
+}
+
+type rawKeyframe struct {
+	Time  float64         `json:"t"`
+	Start json.RawMessage `json:"s"`
+	End   json.RawMessage `json:"e"`
+	Hold  int             `json:"h"`
+	In    *rawEasing      `json:"i"`
+	Out   *rawEasing      `json:"o"`
+}
+
+func (rk *rawKeyframe) easing() easing {
+	if rk.In == nil || rk.Out == nil {
+		return linearEasing
+	}
+	first := func(raw json.RawMessage, def float64) float64 {
+		if values := parseFloats(raw); len(values) > 0 {
+			return values[0]
+		}
+		return def
+	}
+	return easing{
+		OutX: first(rk.Out.X, 0),
+		OutY: first(rk.Out.Y, 0),
+		InX:  first(rk.In.X, 1),
+		InY:  first(rk.In.Y, 1),
+	}
+}
+
+type rawProperty struct {
+	Value json.RawMessage `json:"k"`
+}
+
+// parseFloats parses a JSON number or an array of numbers.
+func parseFloats(raw json.RawMessage) []float64 {
+	var values []float64
+	if json.Unmarshal(raw, &values) == nil {
+		return values
+	}
+	var value float64
+	if json.Unmarshal(raw, &value) == nil {
+		return []float64{value}
+	}
+	return nil
+}
+
+// isKeyframeArray checks if the value of a property is a list of keyframes rather than a static value.
+func isKeyframeArray(raw json.RawMessage) bool {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) < 2 || raw[0] != '[' {
+		return false
+	}
+	return bytes.TrimSpace(raw[1:])[0] == '{'
+}
+
+type keyframe struct {
+	Time       float64
+	Start, End []float64
+	Hold       bool
+	Easing     easing
+}
+
+// Property is a possibly animated number or vector.
+type Property struct {
+	Static    []float64
+	Keyframes []keyframe
+}
+
+func (p *Property) UnmarshalJSON(data []byte) error {
+	var raw rawProperty
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	if !isKeyframeArray(raw.Value) {
+		p.Static = parseFloats(raw.Value)
+		return nil
+	}
+	var rawKeyframes []rawKeyframe
+	err = json.Unmarshal(raw.Value, &rawKeyframes)
+	if err != nil {
+		return err
+	}
+	p.Keyframes = make([]keyframe, len(rawKeyframes))
+	for i, rk := range rawKeyframes {
+		p.Keyframes[i] = keyframe{
+			Time:   rk.Time,
+			Start:  parseFloats(rk.Start),
+			End:    parseFloats(rk.End),
+			Hold:   rk.Hold == 1,
+			Easing: rk.easing(),
+		}
+	}
+	return nil
+}
+
+// findKeyframes finds the keyframe segment that contains the given frame. If the frame is outside the keyframes,
+// next is -1 and progress is meaningless.
+func findKeyframes(count int, timeOf func(int) float64, frame float64) (current, next int) {
+	if frame <= timeOf(0) {
+		return 0, -1
+	}
+	for i := 0; i < count-1; i++ {
+		if frame < timeOf(i+1) {
+			return i, i + 1
+		}
+	}
+	return count - 1, -1
+}
+
+func (p *Property) value(frame float64) []float64 {
+	if p == nil {
+		return nil
+	} else if len(p.Keyframes) == 0 {
+		return p.Static
+	}
+	current, next := findKeyframes(len(p.Keyframes), func(i int) float64 { return p.Keyframes[i].Time }, frame)
+	kf := p.Keyframes[current]
+	if next < 0 {
+		// Old-style animations have a final keyframe without a value, in which case the end value of the previous one is used
+		if kf.Start == nil && current > 0 {
+			return p.Keyframes[current-1].End
+		}
+		return kf.Start
+	}
+	nextKF := p.Keyframes[next]
+	end := kf.End
+	if end == nil {
+		end = nextKF.Start
+	}
+	if kf.Hold || end == nil {
+		return kf.Start
+	}
+	t := kf.Easing.apply((frame - kf.Time) / (nextKF.Time - kf.Time))
+	output := make([]float64, min(len(kf.Start), len(end)))
+	for i := range output {
+		output[i] = kf.Start[i] + (end[i]-kf.Start[i])*t
+	}
+	return output
+}
+
+func (p *Property) float(frame, def float64) float64 {
+	if values := p.value(frame); len(values) > 0 {
+		return values[0]
+	}
+	return def
+}
+
+func (p *Property) vec2(frame, defX, defY float64) (float64, float64) {
+	values := p.value(frame)
+	switch len(values) {
+	case 0:
+		return defX, defY
+	case 1:
+		return values[0], values[0]
+	default:
+		return values[0], values[1]
+	}
+}
+
+// Bezier is a path made of cubic bezier segments. Tangents are relative to their vertex.
+type Bezier struct {
+	Closed      bool         `json:"c"`
+	Vertices    [][2]float64 `json:"v"`
+	InTangents  [][2]float64 `json:"i"`
+	OutTangents [][2]float64 `json:"o"`
+}
+
+func (b *Bezier) lerp(to *Bezier, t float64) *Bezier {
+	if len(b.Vertices) != len(to.Vertices) || len(b.InTangents) != len(to.InTangents) || len(b.OutTangents) != len(to.OutTangents) {
+		return b
+	}
+	lerpPoints := func(from, to [][2]float64) [][2]float64 {
+		output := make([][2]float64, len(from))
+		for i := range from {
+			output[i][0] = from[i][0] + (to[i][0]-from[i][0])*t
+			output[i][1] = from[i][1] + (to[i][1]-from[i][1])*t
+		}
+		return output
+	}
+	return &Bezier{
+		Closed:      b.Closed,
+		Vertices:    lerpPoints(b.Vertices, to.Vertices),
+		InTangents:  lerpPoints(b.InTangents, to.InTangents),
+		OutTangents: lerpPoints(b.OutTangents, to.OutTangents),
+	}
+}
+
+type pathKeyframe struct {
+	Time       float64
+	Start, End *Bezier
+	Hold       bool
+	Easing     easing
+}
+
+// PathProperty is a possibly animated bezier path.
+type PathProperty struct {
+	Static    *Bezier
+	Keyframes []pathKeyframe
+}
+
+// parseBezier parses a bezier path, which is wrapped in a single-item array inside keyframes.
+func parseBezier(raw json.RawMessage) *Bezier {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return nil
+	} else if raw[0] == '[' {
+		var wrapped []*Bezier
+		if json.Unmarshal(raw, &wrapped) != nil || len(wrapped) == 0 {
+			return nil
+		}
+		return wrapped[0]
+	}
+	var bezier Bezier
+	if json.Unmarshal(raw, &bezier) != nil {
+		return nil
+	}
+	return &bezier
+}
+
+func (pp *PathProperty) UnmarshalJSON(data []byte) error {
+	var raw rawProperty
+	err := json.Unmarshal(data, &raw)
+	if err != nil {
+		return err
+	}
+	if !isKeyframeArray(raw.Value) {
+		pp.Static = parseBezier(raw.Value)
+		return nil
+	}
+	var rawKeyframes []rawKeyframe
+	err = json.Unmarshal(raw.Value, &rawKeyframes)
+	if err != nil {
+		return err
+	}
+	pp.Keyframes = make([]pathKeyframe, len(rawKeyframes))
+	for i, rk := range rawKeyframes {
+		pp.Keyframes[i] = pathKeyframe{
+			Time:   rk.Time,
+			Start:  parseBezier(rk.Start),
+			End:    parseBezier(rk.End),
+			Hold:   rk.Hold == 1,
+			Easing: rk.easing(),
+		}
+	}
+	return nil
+}
+
+func (pp *PathProperty) value(frame float64) *Bezier {
+	if pp == nil {
+		return nil
+	} else if len(pp.Keyframes) == 0 {
+		return pp.Static
+	}
+	current, next := findKeyframes(len(pp.Keyframes), func(i int) float64 { return pp.Keyframes[i].Time }, frame)
+	kf := pp.Keyframes[current]
+	if next < 0 {
+		if kf.Start == nil && current > 0 {
+			return pp.Keyframes[current-1].End
+		}
+		return kf.Start
+	}
+	end := kf.End
+	if end == nil {
+		end = pp.Keyframes[next].Start
+	}
+	if kf.Hold || end == nil || kf.Start == nil {
+		return kf.Start
+	}
+	return kf.Start.lerp(end, kf.Easing.apply((frame-kf.Time)/(pp.Keyframes[next].Time-kf.Time)))
+}
@@ -0,0 +1,448 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lottierender
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/vector"
+)
+
+// maxDepth limits how deep precompositions and layer parents are followed to avoid infinite loops.
+const maxDepth = 32
+
+// maxLayersPerFrame limits how many layers are drawn in a single frame, as precompositions that use the same asset
+// multiple times would otherwise grow exponentially with the depth.
+const maxLayersPerFrame = 1000
+
+type renderer struct {
+	anim   *Animation
+	canvas *image.RGBA
+	raster *vector.Rasterizer
+	mask   *image.Alpha
+	layers int
+}
+
+// Render renders the whole animation at the given size and frame rate. If fps is zero or higher than the
+// animation's own frame rate, the animation's frame rate is used. The returned duration is the delay between frames.
+// At most MaxFrames frames are rendered, so animations that weren't validated by Parse are rendered at a lower rate.
+//
+// If maxFrames is positive, the animation is cut off after that many frames instead of rendering the whole thing.
+func (a *Animation) Render(width, height int, fps float64, maxFrames int) ([]*image.RGBA, time.Duration) {
+	if fps <= 0 || fps > a.FrameRate {
+		fps = a.FrameRate
+	}
+	duration := (a.OutPoint - a.InPoint) / a.FrameRate
+	count := min(max(int(math.Round(duration*fps)), 1), MaxFrames)
+	rendered := count
+	if maxFrames > 0 {
+		rendered = min(rendered, maxFrames)
+	}
+	frames := make([]*image.RGBA, rendered)
+	for i := range frames {
+		frames[i] = a.RenderFrame(a.InPoint+float64(i)*(a.OutPoint-a.InPoint)/float64(count), width, height)
+	}
+	return frames, time.Duration(duration / float64(count) * float64(time.Second))
+}
+
+// RenderFrame renders a single frame of the animation. The animation is scaled to fit inside the given size.
+func (a *Animation) RenderFrame(frame float64, width, height int) *image.RGBA {
+	r := &renderer{
+		anim:   a,
+		canvas: image.NewRGBA(image.Rect(0, 0, width, height)),
+		raster: vector.NewRasterizer(width, height),
+		mask:   image.NewAlpha(image.Rect(0, 0, width, height)),
+	}
+	s := min(float64(width)/a.Width, float64(height)/a.Height)
+	base := translate((float64(width)-a.Width*s)/2, (float64(height)-a.Height*s)/2).mul(scale(s, s))
+	r.renderLayers(a.Layers, frame, base, 1, 0)
+	return r.canvas
+}
+
+func (r *renderer) renderLayers(layers []*Layer, frame float64, base matrix, opacity float64, depth int) {
+	byIndex := make(map[int]*Layer, len(layers))
+	for _, layer := range layers {
+		if layer.Index != nil {
+			byIndex[*layer.Index] = layer
+		}
+	}
+	// The first layer is on top, so draw in reverse order
+	for i := len(layers) - 1; i >= 0; i-- {
+		layer := layers[i]
+		if layer.Hidden || layer.MatteSource == 1 || frame < layer.InPoint || frame >= layer.OutPoint {
+			continue
+		} else if r.layers++; r.layers > maxLayersPerFrame {
+			return
+		}
+		localTime := layer.localTime(frame)
+		layerOpacity := opacity * layer.Transform.opacity(localTime)
+		if layerOpacity <= 0 {
+			continue
+		}
+		m := base.mul(layerMatrix(layer, byIndex, frame))
+		switch layer.Type {
+		case LayerTypePrecomp:
+			asset := r.anim.assetsByID[layer.RefID]
+			if asset != nil && depth < maxDepth {
+				r.renderLayers(asset.Layers, localTime, m, layerOpacity, depth+1)
+			}
+		case LayerTypeSolid:
+			fill := parseHexColor(layer.SolidColor)
+			r.fillPath(rectanglePath(point{layer.SolidWidth / 2, layer.SolidHeight / 2}, point{layer.SolidWidth, layer.SolidHeight}, 0).transform(m), fill, layerOpacity)
+		case LayerTypeImage:
+			asset := r.anim.assetsByID[layer.RefID]
+			if asset != nil && asset.image != nil {
+				r.drawImage(asset.image, m, layerOpacity)
+			}
+		case LayerTypeShape:
+			r.drawShapes(layer.Shapes, localTime, m, layerOpacity)
+		}
+	}
+}
+
+// layerMatrix returns the transformation of a layer including the transformations of all its parents.
+func layerMatrix(layer *Layer, byIndex map[int]*Layer, frame float64) matrix {
+	m := layer.Transform.matrix(layer.localTime(frame))
+	parentIndex := layer.Parent
+	for i := 0; parentIndex != nil && i < maxDepth; i++ {
+		parent, ok := byIndex[*parentIndex]
+		if !ok {
+			break
+		}
+		m = parent.Transform.matrix(parent.localTime(frame)).mul(m)
+		parentIndex = parent.Parent
+	}
+	return m
+}
+
+func groupTransform(items []*Shape, frame float64) (matrix, float64) {
+	for _, item := range items {
+		if item.Type == "tr" {
+			return item.Transform.matrix(frame), item.Transform.opacity(frame)
+		}
+	}
+	return identity, 1
+}
+
+func (r *renderer) drawShapes(items []*Shape, frame float64, m matrix, opacity float64) {
+	// Items earlier in the list are drawn on top, and paint items apply to all paths before them in the list.
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		if item.Hidden {
+			continue
+		}
+		switch item.Type {
+		case "gr":
+			groupMatrix, groupOpacity := groupTransform(item.Items, frame)
+			if groupOpacity > 0 {
+				r.drawShapes(item.Items, frame, m.mul(groupMatrix), opacity*groupOpacity)
+			}
+		case "fl":
+			paintOpacity := opacity * item.Opacity.float(frame, 100) / 100
+			if paintOpacity > 0 {
+				r.fillPath(collectPaths(items[:i], frame, identity, 0).transform(m), colorAt(item.Color, frame), paintOpacity)
+			}
+		case "st":
+			paintOpacity := opacity * item.Opacity.float(frame, 100) / 100
+			if paintOpacity > 0 {
+				r.strokePath(collectPaths(items[:i], frame, identity, 0), item, frame, m, colorAt(item.Color, frame), paintOpacity)
+			}
+		case "gf", "gs":
+			paintOpacity := opacity * item.Opacity.float(frame, 100) / 100
+			if paintOpacity <= 0 {
+				continue
+			}
+			source := newGradient(item, frame, m)
+			if source == nil {
+				continue
+			}
+			paths := collectPaths(items[:i], frame, identity, 0)
+			if item.Type == "gf" {
+				r.fillPath(paths.transform(m), source, paintOpacity)
+			} else {
+				r.strokePath(paths, item, frame, m, source, paintOpacity)
+			}
+		}
+	}
+}
+
+// collectPaths returns all the paths in the given list of shape items, including the ones inside groups.
+func collectPaths(items []*Shape, frame float64, m matrix, depth int) path {
+	var output path
+	for _, item := range items {
+		if item.Hidden {
+			continue
+		}
+		var itemPath path
+		switch item.Type {
+		case "gr":
+			if depth < maxDepth {
+				groupMatrix, _ := groupTransform(item.Items, frame)
+				output = append(output, collectPaths(item.Items, frame, m.mul(groupMatrix), depth+1)...)
+			}
+			continue
+		case "sh":
+			itemPath = bezierPath(item.Path.value(frame))
+		case "rc":
+			x, y := item.Position.vec2(frame, 0, 0)
+			w, h := item.Size.vec2(frame, 0, 0)
+			itemPath = rectanglePath(point{x, y}, point{w, h}, item.Roundness.float(frame, 0))
+		case "el":
+			x, y := item.Position.vec2(frame, 0, 0)
+			w, h := item.Size.vec2(frame, 0, 0)
+			itemPath = ellipsePath(point{x, y}, point{w, h})
+		case "sr":
+			x, y := item.Position.vec2(frame, 0, 0)
+			itemPath = starPath(
+				item.StarType, point{x, y}, item.Points.float(frame, 5), item.Rotation.float(frame, 0),
+				item.Outer.float(frame, 0), item.Inner.float(frame, 0),
+			)
+		default:
+			continue
+		}
+		output = append(output, itemPath.transform(m)...)
+	}
+	return output
+}
+
+// paint is a source for filling paths, either a solid color or a gradient.
+type paint interface{}
+
+func (r *renderer) rasterize(p path) {
+	r.raster.Reset(r.canvas.Rect.Dx(), r.canvas.Rect.Dy())
+	for _, c := range p {
+		if len(c.points) == 0 {
+			continue
+		}
+		r.raster.MoveTo(float32(c.points[0][0]), float32(c.points[0][1]))
+		for i := 1; i+2 < len(c.points); i += 3 {
+			r.raster.CubeTo(
+				float32(c.points[i][0]), float32(c.points[i][1]),
+				float32(c.points[i+1][0]), float32(c.points[i+1][1]),
+				float32(c.points[i+2][0]), float32(c.points[i+2][1]),
+			)
+		}
+		r.raster.ClosePath()
+	}
+}
+
+// fillPath fills a path that has already been transformed into canvas coordinates.
+func (r *renderer) fillPath(p path, src paint, opacity float64) {
+	if len(p) == 0 {
+		return
+	}
+	r.rasterize(p)
+	r.paint(src, opacity)
+}
+
+func (r *renderer) strokePath(p path, item *Shape, frame float64, m matrix, src paint, opacity float64) {
+	style := strokeStyle{
+		width:      item.Width.float(frame, 0),
+		lineCap:    item.LineCap,
+		lineJoin:   item.LineJoin,
+		miterLimit: item.MiterLimit,
+	}
+	if style.width <= 0 || len(p) == 0 {
+		return
+	} else if style.miterLimit <= 0 {
+		style.miterLimit = 4
+	}
+	polygons := strokePolygons(p, style, m)
+	strokeOutline := make(path, 0, len(polygons))
+	for _, polygon := range polygons {
+		c := contour{points: []point{m.apply(polygon[0])}, closed: true}
+		for _, pt := range polygon[1:] {
+			c.lineTo(m.apply(pt))
+		}
+		if signedArea(polygon) < 0 {
+			for i, j := 0, len(c.points)-1; i < j; i, j = i+1, j-1 {
+				c.points[i], c.points[j] = c.points[j], c.points[i]
+			}
+		}
+		strokeOutline = append(strokeOutline, c)
+	}
+	r.fillPath(strokeOutline, src, opacity)
+}
+
+func (r *renderer) paint(src paint, opacity float64) {
+	switch typedSrc := src.(type) {
+	case color.NRGBA:
+		typedSrc.A = uint8(float64(typedSrc.A) * min(opacity, 1))
+		r.raster.Draw(r.canvas, r.canvas.Rect, image.NewUniform(typedSrc), image.Point{})
+	case *gradient:
+		r.raster.DrawOp = draw.Src
+		r.raster.Draw(r.mask, r.mask.Rect, image.Opaque, image.Point{})
+		typedSrc.composite(r.canvas, r.mask, opacity)
+	}
+}
+
+func (r *renderer) drawImage(img image.Image, m matrix, opacity float64) {
+	var opts *xdraw.Options
+	if opacity < 1 {
+		opts = &xdraw.Options{SrcMask: image.NewUniform(color.Alpha{A: uint8(opacity * 255)})}
+	}
+	xdraw.BiLinear.Transform(r.canvas, f64.Aff3{m[0], m[2], m[4], m[1], m[3], m[5]}, img, img.Bounds(), xdraw.Over, opts)
+}
+
+func clampUnit(value float64) float64 {
+	return min(max(value, 0), 1)
+}
+
+func colorAt(prop *Property, frame float64) color.NRGBA {
+	values := prop.value(frame)
+	if len(values) < 3 {
+		return color.NRGBA{A: 255}
+	}
+	divisor := 1.0
+	// Very old animations use 0-255 instead of 0-1
+	if values[0] > 1 || values[1] > 1 || values[2] > 1 {
+		divisor = 255
+	}
+	return color.NRGBA{
+		R: uint8(clampUnit(values[0]/divisor) * 255),
+		G: uint8(clampUnit(values[1]/divisor) * 255),
+		B: uint8(clampUnit(values[2]/divisor) * 255),
+		A: 255,
+	}
+}
+
+func parseHexColor(hex string) color.NRGBA {
+	value, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil {
+		return color.NRGBA{A: 255}
+	}
+	return color.NRGBA{R: uint8(value >> 16), G: uint8(value >> 8), B: uint8(value), A: 255}
+}
+
+const (
+	gradientTypeLinear = 1
+	gradientTypeRadial = 2
+)
+
+type gradientStop struct {
+	offset float64
+	values [3]float64
+}
+
+type gradient struct {
+	radial     bool
+	start, end point
+	inverse    matrix
+	colors     []gradientStop
+	alphas     []gradientStop
+}
+
+func newGradient(item *Shape, frame float64, m matrix) *gradient {
+	if item.Gradient == nil {
+		return nil
+	}
+	inverse, ok := m.invert()
+	if !ok {
+		return nil
+	}
+	values := item.Gradient.Values.value(frame)
+	count := item.Gradient.Count
+	if count <= 0 || count > len(values)/4 {
+		return nil
+	}
+	g := &gradient{
+		radial:  item.GradientType == gradientTypeRadial,
+		inverse: inverse,
+		colors:  make([]gradientStop, count),
+	}
+	g.start[0], g.start[1] = item.Start.vec2(frame, 0, 0)
+	g.end[0], g.end[1] = item.End.vec2(frame, 0, 0)
+	for i := range g.colors {
+		g.colors[i] = gradientStop{offset: values[i*4], values: [3]float64{values[i*4+1], values[i*4+2], values[i*4+3]}}
+	}
+	for i := count * 4; i+1 < len(values); i += 2 {
+		g.alphas = append(g.alphas, gradientStop{offset: values[i], values: [3]float64{values[i+1]}})
+	}
+	return g
+}
+
+func interpolateStops(stops []gradientStop, t float64) [3]float64 {
+	if t <= stops[0].offset {
+		return stops[0].values
+	}
+	for i := 1; i < len(stops); i++ {
+		if t <= stops[i].offset {
+			prev, next := stops[i-1], stops[i]
+			progress := 0.0
+			if next.offset > prev.offset {
+				progress = (t - prev.offset) / (next.offset - prev.offset)
+			}
+			var output [3]float64
+			for j := range output {
+				output[j] = prev.values[j] + (next.values[j]-prev.values[j])*progress
+			}
+			return output
+		}
+	}
+	return stops[len(stops)-1].values
+}
+
+func (g *gradient) at(p point) (rgb [3]float64, alpha float64) {
+	local := g.inverse.apply(p)
+	axis := g.end.sub(g.start)
+	var t float64
+	if g.radial {
+		if radius := axis.length(); radius > 0 {
+			t = local.sub(g.start).length() / radius
+		}
+	} else if lengthSquared := axis.dot(axis); lengthSquared > 0 {
+		t = local.sub(g.start).dot(axis) / lengthSquared
+	}
+	t = clampUnit(t)
+	alpha = 1
+	if len(g.alphas) > 0 {
+		alpha = interpolateStops(g.alphas, t)[0]
+	}
+	return interpolateStops(g.colors, t), alpha
+}
+
+// composite draws the gradient onto the canvas through the given coverage mask.
+func (g *gradient) composite(canvas *image.RGBA, mask *image.Alpha, opacity float64) {
+	bounds := canvas.Rect
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			coverage := mask.Pix[mask.PixOffset(x, y)]
+			if coverage == 0 {
+				continue
+			}
+			rgb, alpha := g.at(point{float64(x) + 0.5, float64(y) + 0.5})
+			a := clampUnit(alpha*opacity) * float64(coverage) / 255
+			if a <= 0 {
+				continue
+			}
+			i := canvas.PixOffset(x, y)
+			pix := canvas.Pix[i : i+4 : i+4]
+			for j := range 3 {
+				pix[j] = uint8(clampUnit(rgb[j])*255*a + float64(pix[j])*(1-a))
+			}
+			pix[3] = uint8(255*a + float64(pix[3])*(1-a))
+		}
+	}
+}
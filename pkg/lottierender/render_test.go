@@ -0,0 +1,120 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package lottierender
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func parseTestAnimation(t *testing.T, name string) *Animation {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read test animation: %v", err)
+	}
+	anim, err := Parse(data)
+	if err != nil {
+		t.Fatalf("failed to parse test animation: %v", err)
+	}
+	return anim
+}
+
+func TestRender(t *testing.T) {
+	anim := parseTestAnimation(t, "rotating-rect.json")
+	frames, delay := anim.Render(64, 64, 10, 0)
+	// 90 frames at 30 fps is 3 seconds, which is 30 frames at 10 fps
+	if len(frames) != 30 {
+		t.Errorf("expected 30 frames, got %d", len(frames))
+	}
+	if delay != 100*time.Millisecond {
+		t.Errorf("expected 100ms delay, got %s", delay)
+	}
+	for i, frame := range frames {
+		if frame.Bounds().Dx() != 64 || frame.Bounds().Dy() != 64 {
+			t.Fatalf("frame %d has wrong size %s", i, frame.Bounds())
+		}
+	}
+	// The center is always covered by the rectangle
+	if c := frames[0].RGBAAt(32, 32); c.A != 255 || c.B < 200 {
+		t.Errorf("unexpected color %v in the center of the first frame", c)
+	}
+	// The corners are never covered
+	if c := frames[0].RGBAAt(0, 0); c.A != 0 {
+		t.Errorf("unexpected color %v in the corner of the first frame", c)
+	}
+}
+
+func TestRenderUsesAnimationFrameRate(t *testing.T) {
+	anim := parseTestAnimation(t, "shapes.json")
+	frames, delay := anim.Render(32, 32, 0, 0)
+	if len(frames) != 30 {
+		t.Errorf("expected 30 frames, got %d", len(frames))
+	}
+	if expected := time.Second / 60; delay < expected-time.Millisecond || delay > expected+time.Millisecond {
+		t.Errorf("expected %s delay, got %s", expected, delay)
+	}
+}
+
+func TestRenderMaxFrames(t *testing.T) {
+	anim := parseTestAnimation(t, "rotating-rect.json")
+	frames, delay := anim.Render(16, 16, 10, 5)
+	if len(frames) != 5 {
+		t.Errorf("expected 5 frames, got %d", len(frames))
+	}
+	// Cutting off the animation must not change the timing of the frames
+	if delay != 100*time.Millisecond {
+		t.Errorf("expected 100ms delay, got %s", delay)
+	}
+}
+
+func TestRenderFrameLimit(t *testing.T) {
+	// Animations that didn't go through Parse aren't validated, but Render still limits the number of frames
+	anim := &Animation{FrameRate: 60, InPoint: 0, OutPoint: 1_000_000, Width: 16, Height: 16}
+	frames, _ := anim.Render(1, 1, 0, 0)
+	if len(frames) != MaxFrames {
+		t.Errorf("expected %d frames, got %d", MaxFrames, len(frames))
+	}
+}
+
+func TestRenderRecursivePrecomp(t *testing.T) {
+	// Every level uses the same asset three times, which would take forever to draw without a layer limit
+	anim, err := Parse([]byte(`{"fr":30,"ip":0,"op":1,"w":16,"h":16,"assets":[{"id":"a","layers":[
+		{"ty":0,"refId":"a","ip":0,"op":9},{"ty":0,"refId":"a","ip":0,"op":9},{"ty":0,"refId":"a","ip":0,"op":9}
+	]}],"layers":[{"ty":0,"refId":"a","ip":0,"op":9}]}`))
+	if err != nil {
+		t.Fatalf("failed to parse animation: %v", err)
+	}
+	start := time.Now()
+	anim.Render(16, 16, 0, 0)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("rendering took too long (%s)", elapsed)
+	}
+}
+
+func TestRenderInvalidGradientCount(t *testing.T) {
+	// The stop count is multiplied when checking it against the values, which must not overflow
+	anim, err := Parse([]byte(`{"fr":30,"ip":0,"op":1,"w":16,"h":16,"layers":[{"ty":4,"ip":0,"op":1,"shapes":[
+		{"ty":"rc","p":{"a":0,"k":[8,8]},"s":{"a":0,"k":[16,16]}},
+		{"ty":"gf","o":{"a":0,"k":100},"t":1,"g":{"p":4611686018427387904,"k":{"a":0,"k":[0,0,0,0]}},"s":{"a":0,"k":[0,0]},"e":{"a":0,"k":[16,0]}}
+	]}]}`))
+	if err != nil {
+		t.Fatalf("failed to parse animation: %v", err)
+	}
+	anim.RenderFrame(0, 16, 16)
+}
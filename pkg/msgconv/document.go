@@ -0,0 +1,95 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+
+	"github.com/iKonoTelecomunicaciones/go/event"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/pdfpreview"
+)
+
+const (
+	// documentPreviewSize is the maximum width or height of generated document previews.
+	documentPreviewSize = 320
+	// maxDocumentPreviewSource limits the size of streamed pdfs that are read into memory to generate a preview.
+	// It's only slightly above uploadFileThreshold, so that previews don't undo the memory savings of streaming.
+	maxDocumentPreviewSource = 8 * 1024 * 1024
+)
+
+// PageCountField is set in the file info of documents bridged from WhatsApp if the page count is known.
+const PageCountField = "fi.mau.whatsapp.page_count"
+
+// generatePDFPreview renders the first page of a pdf into a jpeg thumbnail and counts the pages in it.
+// The page count is returned even if rendering the thumbnail fails.
+func generatePDFPreview(data []byte) (thumbnail []byte, pageCount uint32, err error) {
+	defer func() {
+		// The pdf parser is lenient with broken files, but don't let a malicious file crash the bridge
+		if panicErr := recover(); panicErr != nil {
+			err = fmt.Errorf("panic while rendering pdf: %v", panicErr)
+		}
+	}()
+	doc, err := pdfpreview.Open(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	pageCount = uint32(doc.PageCount())
+	page, err := doc.RenderFirstPage(documentPreviewSize)
+	if err != nil {
+		return nil, pageCount, err
+	}
+	var buf bytes.Buffer
+	err = jpeg.Encode(&buf, page, &jpeg.Options{Quality: 70})
+	if err != nil {
+		return nil, pageCount, fmt.Errorf("failed to encode pdf preview: %w", err)
+	}
+	return buf.Bytes(), pageCount, nil
+}
+
+// generatePDFFilePreview reads a pdf from a temporary file and generates a preview of it using generatePDFPreview.
+func generatePDFFilePreview(file *os.File) ([]byte, uint32, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to stat file: %w", err)
+	} else if info.Size() > maxDocumentPreviewSource {
+		return nil, 0, fmt.Errorf("pdf is too large to generate a preview (%d bytes)", info.Size())
+	}
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read file: %w", err)
+	}
+	return generatePDFPreview(data)
+}
+
+// getThumbnailInfo returns the Matrix file info for a thumbnail image, or nil if it can't be decoded.
+func getThumbnailInfo(thumbnail []byte) *event.FileInfo {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(thumbnail))
+	if err != nil {
+		return nil
+	}
+	return &event.FileInfo{
+		MimeType: "image/" + format,
+		Width:    cfg.Width,
+		Height:   cfg.Height,
+		Size:     len(thumbnail),
+	}
+}
@@ -19,14 +19,16 @@ package msgconv
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
+	"io"
 	"net/http"
-	"slices"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -106,11 +108,19 @@ func (mc *MessageConverter) ToWhatsApp(
 			return nil, nil, err
 		}
 	case event.MessageType(event.EventSticker.Type), event.MsgImage, event.MsgVideo, event.MsgAudio, event.MsgFile:
-		uploaded, thumbnail, mime, err := mc.reuploadFileToWhatsApp(ctx, content)
+		viewOnce := IsViewOnceRequested(evt.Content.Raw)
+		if viewOnce && !canSendViewOnce(content) {
+			return nil, nil, fmt.Errorf("%w: view-once is only supported for images, videos and voice messages", bridgev2.ErrUnsupportedMessageType)
+		}
+		var pageCount uint32
+		uploaded, thumbnail, mime, err := mc.reuploadFileToWhatsApp(ctx, content, &pageCount, viewOnce)
 		if err != nil {
 			return nil, nil, err
 		}
-		message = mc.constructMediaMessage(ctx, content, evt, uploaded, thumbnail, contextInfo, mime)
+		message = mc.constructMediaMessage(ctx, content, evt, uploaded, thumbnail, pageCount, contextInfo, mime)
+		if viewOnce {
+			message = wrapViewOnce(message)
+		}
 	case event.MsgLocation:
 		lat, long, err := parseGeoURI(content.GeoURI)
 		if err != nil {
@@ -159,12 +169,54 @@ func (mc *MessageConverter) ToWhatsApp(
 	return message, extra, nil
 }
 
+// ViewOnceField is set in the content of view-once media. Matrix clients can set it when sending
+// images, videos or voice messages to send them as view-once on WhatsApp.
+const ViewOnceField = "fi.mau.whatsapp.view_once"
+
+// IsViewOnceRequested checks if the raw content of a Matrix event has the view-once flag set.
+func IsViewOnceRequested(raw map[string]any) bool {
+	viewOnce, _ := raw[ViewOnceField].(bool)
+	return viewOnce
+}
+
+func canSendViewOnce(content *event.MessageEventContent) bool {
+	switch content.MsgType {
+	case event.MsgImage:
+		return content.Info == nil || content.Info.MimeType != "image/gif"
+	case event.MsgVideo:
+		return content.Info == nil || !content.Info.MauGIF
+	case event.MsgAudio:
+		return content.MSC3245Voice != nil
+	default:
+		return false
+	}
+}
+
+func wrapViewOnce(message *waE2E.Message) *waE2E.Message {
+	switch {
+	case message.ImageMessage != nil:
+		message.ImageMessage.ViewOnce = proto.Bool(true)
+	case message.VideoMessage != nil:
+		message.VideoMessage.ViewOnce = proto.Bool(true)
+	case message.AudioMessage != nil:
+		// View-once voice messages use a different wrapper than images and videos.
+		message.AudioMessage.ViewOnce = proto.Bool(true)
+		return &waE2E.Message{
+			ViewOnceMessageV2Extension: &waE2E.FutureProofMessage{Message: message},
+		}
+	}
+	return &waE2E.Message{
+		ViewOnceMessageV2: &waE2E.FutureProofMessage{Message: message},
+	}
+}
+
 func (mc *MessageConverter) constructMediaMessage(
 	ctx context.Context,
 	content *event.MessageEventContent,
 	evt *event.Event,
 	uploaded *whatsmeow.UploadResponse,
 	thumbnail []byte,
+	pageCount uint32,
 	contextInfo *waE2E.ContextInfo,
 	mime string,
 ) *waE2E.Message {
@@ -179,8 +231,9 @@ func (mc *MessageConverter) constructMediaMessage(
 
 		return &waE2E.Message{
 			StickerMessage: &waE2E.StickerMessage{
-				Width:  &width,
-				Height: &height,
+				Width:      &width,
+				Height:     &height,
+				IsAnimated: proto.Bool(content.Info.MauGIF),
 
 				ContextInfo:   contextInfo,
 				PngThumbnail:  thumbnail,
@@ -281,6 +334,13 @@ func (mc *MessageConverter) constructMediaMessage(
 				ContextInfo:   contextInfo,
 			},
 		}
+		if pageCount > 0 {
+			msg.DocumentMessage.PageCount = proto.Uint32(pageCount)
+		}
+		if thumbnailInfo := getThumbnailInfo(thumbnail); thumbnailInfo != nil {
+			msg.DocumentMessage.ThumbnailWidth = proto.Uint32(uint32(thumbnailInfo.Width))
+			msg.DocumentMessage.ThumbnailHeight = proto.Uint32(uint32(thumbnailInfo.Height))
+		}
 		if msg.GetDocumentMessage().GetCaption() != "" {
 			msg.DocumentWithCaptionMessage = &waE2E.FutureProofMessage{
 				Message: &waE2E.Message{
@@ -472,33 +532,99 @@ func (mc *MessageConverter) convertToWebP(img []byte) ([]byte, int, error) {
 	return webpBuffer.Bytes(), size, nil
 }
 
+// reuploadFileToWhatsApp uploads a Matrix file to WhatsApp. If the file is a document and the page count can be
+// determined, it's stored in pageCount. View-once uploads aren't stored in the media cache.
 func (mc *MessageConverter) reuploadFileToWhatsApp(
-	ctx context.Context, content *event.MessageEventContent,
+	ctx context.Context, content *event.MessageEventContent, pageCount *uint32, viewOnce bool,
 ) (*whatsmeow.UploadResponse, []byte, string, error) {
 	mime := content.GetInfo().MimeType
 	fileName := content.Body
 	if content.FileName != "" {
 		fileName = content.FileName
 	}
-	data, err := mc.Bridge.Bot.DownloadMedia(ctx, content.URL, content.File)
+	if content.MsgType != event.MessageType(event.EventSticker.Type) && mime != "" {
+		mediaType := getExpectedMediaType(content.MsgType, mime)
+		if mime == "image/gif" {
+			content.MsgType = event.MsgVideo
+		}
+		cached := mc.getCachedWhatsAppUploadBySource(ctx, getMatrixSourceURI(content), mediaType)
+		if cached != nil && canReuseCachedUpload(content, cached) {
+			*pageCount = cached.PageCount
+			if content.MSC3245Voice != nil {
+				fillCachedVoiceInfo(content, cached.Seconds, cached.Waveform)
+			}
+			thumbnail := cached.Thumbnail
+			if thumbnail == nil && mediaType != whatsmeow.MediaAudio &&
+				(content.GetInfo().ThumbnailURL != "" || content.GetInfo().ThumbnailFile != nil) {
+				thumbnail, _ = mc.downloadThumbnail(ctx, nil, content.GetInfo().ThumbnailURL, content.GetInfo().ThumbnailFile, false)
+			}
+			zerolog.Ctx(ctx).Debug().Str("direct_path", cached.DirectPath).Msg("Reusing cached WhatsApp upload of media")
+			return cachedWhatsAppUpload(cached), thumbnail, cached.MimeType, nil
+		}
+	}
+	// The file is always downloaded to a temporary file, so large files are streamed based on the actual size
+	// rather than the size the client claims. Voice messages are never streamed, as they may need to be transcoded
+	// and have a waveform generated.
+	var data []byte
+	var streamed bool
+	var streamedUpload *whatsmeow.UploadResponse
+	var streamedThumbnail []byte
+	var streamErr error
+	err := mc.Bridge.Bot.DownloadMediaToFile(ctx, content.URL, content.File, true, func(file *os.File) error {
+		if mime == "" {
+			header := make([]byte, 512)
+			n, _ := file.ReadAt(header, 0)
+			mime = http.DetectContentType(header[:n])
+		}
+		stat, err := file.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to stat file: %w", err)
+		}
+		if stat.Size() > uploadFileThreshold && content.MSC3245Voice == nil {
+			if mediaType, streamMime, ok := getStreamableMediaType(content.MsgType, mime); ok {
+				streamed = true
+				streamedUpload, streamedThumbnail, mime, streamErr = mc.streamFileToWhatsApp(ctx, file, content, mediaType, streamMime, pageCount, viewOnce)
+				return nil
+			}
+		}
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return fmt.Errorf("failed to seek to start of file: %w", err)
+		}
+		data, err = io.ReadAll(file)
+		return err
+	})
 	if err != nil {
 		return nil, nil, "", fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
+	} else if streamed {
+		return streamedUpload, streamedThumbnail, mime, streamErr
 	}
 
-	if mime == "" {
-		mime = http.DetectContentType(data)
-	}
-	if mime == "image/gif" {
+	if mime == "image/gif" && content.MsgType != event.MessageType(event.EventSticker.Type) {
 		content.MsgType = event.MsgVideo
 	}
 
 	var mediaType whatsmeow.MediaType
-	var isSticker bool
+	var isSticker, isAnimatedSticker bool
+	var generatedThumbnail []byte
 	switch content.MsgType {
 	case event.MessageType(event.EventSticker.Type):
 		isSticker = true
 		mediaType = whatsmeow.MediaImage
-		if mime != "image/webp" || content.Info.Width != content.Info.Height {
+		if isAnimatedStickerSource(data, mime) {
+			isAnimatedSticker = true
+			data, generatedThumbnail, err = mc.convertAnimatedStickerToWhatsApp(ctx, data, mime)
+			if err != nil {
+				return nil, nil, mime, fmt.Errorf("%w (%s to animated sticker): %w", bridgev2.ErrMediaConvertFailed, mime, err)
+			}
+			if mime != "image/webp" {
+				content.Info.Width = stickerSize
+				content.Info.Height = stickerSize
+			}
+			// MauGIF is used to tell constructMediaMessage that the sticker is animated
+			content.Info.MauGIF = true
+			mime = "image/webp"
+		} else if mime != "image/webp" || content.Info.Width != content.Info.Height {
 			var size int
 			data, size, err = mc.convertToWebP(data)
 			if err != nil {
@@ -528,23 +654,8 @@ func (mc *MessageConverter) reuploadFileToWhatsApp(
 			return nil, nil, mime, fmt.Errorf("%w %s in image message", bridgev2.ErrUnsupportedMediaType, mime)
 		}
 	case event.MsgVideo:
-		switch mime {
-		case "video/mp4", "video/3gpp":
-			// allowed
-		case "video/webm", "video/quicktime":
-			sourceFormat := "webm"
-			if mime == "video/quicktime" {
-				sourceFormat = "mov"
-			}
-			data, err = ffmpeg.ConvertBytes(ctx, data, ".mp4", []string{"-f", sourceFormat}, []string{
-				"-pix_fmt", "yuv420p", "-c:v", "libx264",
-				"-filter:v", "crop='floor(in_w/2)*2:floor(in_h/2)*2'",
-			}, mime)
-			if err != nil {
-				return nil, nil, mime, fmt.Errorf("%w (%s to mp4): %w", bridgev2.ErrMediaConvertFailed, sourceFormat, err)
-			}
-			mime = "video/mp4"
-		case "image/gif":
+		switch {
+		case mime == "image/gif":
 			data, err = ffmpeg.ConvertBytes(ctx, data, ".mp4", []string{"-f", "gif"}, []string{
 				"-pix_fmt", "yuv420p", "-c:v", "libx264", "-movflags", "+faststart",
 				"-filter:v", "crop='floor(in_w/2)*2:floor(in_h/2)*2'",
@@ -553,11 +664,26 @@ func (mc *MessageConverter) reuploadFileToWhatsApp(
 				return nil, nil, "image/gif", fmt.Errorf("%w (gif to mp4): %w", bridgev2.ErrMediaConvertFailed, err)
 			}
 			mime = "video/mp4"
+		case strings.HasPrefix(mime, "video/"):
+			data, mime, generatedThumbnail, err = mc.convertVideoForWhatsApp(ctx, data, mime)
+			if err != nil {
+				return nil, nil, mime, err
+			}
 		default:
 			return nil, nil, mime, fmt.Errorf("%w %s in video message", bridgev2.ErrUnsupportedMediaType, mime)
 		}
 		mediaType = whatsmeow.MediaVideo
 	case event.MsgAudio:
+		if content.MSC3245Voice != nil && mime != voiceMimeType && ffmpeg.Supported() {
+			data, err = convertToVoiceMessage(ctx, data, mime)
+			if err != nil {
+				return nil, nil, mime, fmt.Errorf("%w (%s to opus): %w", bridgev2.ErrMediaConvertFailed, mime, err)
+			}
+			mime = voiceMimeType
+		}
+		if content.MSC3245Voice != nil && ffmpeg.Supported() {
+			fillVoiceInfo(ctx, content, data, mime)
+		}
 		switch mime {
 		case "audio/aac", "audio/mp4", "audio/amr", "audio/mpeg", "audio/ogg; codecs=opus":
 			// Allowed
@@ -569,29 +695,165 @@ func (mc *MessageConverter) reuploadFileToWhatsApp(
 		}
 		mediaType = whatsmeow.MediaAudio
 	case event.MsgFile:
-		fallthrough
+		mediaType = whatsmeow.MediaDocument
+		if mime == "application/pdf" {
+			generatedThumbnail, *pageCount, err = generatePDFPreview(data)
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to generate pdf preview")
+			}
+		}
 	default:
 		mediaType = whatsmeow.MediaDocument
 	}
 
-	uploaded, err := getClient(ctx).Upload(ctx, data, mediaType)
-	if err != nil {
-		zerolog.Ctx(ctx).Debug().
-			Str("file_name", fileName).
-			Str("mime_type", mime).
-			Bool("is_voice_clip", content.MSC3245Voice != nil).
-			Msg("Failed upload media")
-		return nil, nil, "", fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
+	var uploaded whatsmeow.UploadResponse
+	fileHash := sha256.Sum256(data)
+	if cached := mc.getCachedWhatsAppUpload(ctx, fileHash[:], mediaType); cached != nil {
+		zerolog.Ctx(ctx).Debug().Str("direct_path", cached.DirectPath).Msg("Reusing cached WhatsApp upload of identical media")
+		uploaded = *cachedWhatsAppUpload(cached)
+	} else {
+		uploaded, err = getClient(ctx).Upload(ctx, data, mediaType)
+		if err != nil {
+			zerolog.Ctx(ctx).Debug().
+				Str("file_name", fileName).
+				Str("mime_type", mime).
+				Bool("is_voice_clip", content.MSC3245Voice != nil).
+				Msg("Failed upload media")
+			return nil, nil, "", fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
+		}
 	}
 	var thumbnail []byte
-	// Audio doesn't have thumbnails
-	if mediaType != whatsmeow.MediaAudio {
+	hasMatrixThumbnail := content.GetInfo().ThumbnailURL != "" || content.GetInfo().ThumbnailFile != nil
+	if generatedThumbnail != nil && !hasMatrixThumbnail {
+		thumbnail = generatedThumbnail
+	} else if mediaType != whatsmeow.MediaAudio && (!isAnimatedSticker || hasMatrixThumbnail) {
+		// Audio doesn't have thumbnails, and animated webp stickers can't be decoded to generate one
 		thumbnail, err = mc.downloadThumbnail(ctx, data, content.GetInfo().ThumbnailURL, content.GetInfo().ThumbnailFile, isSticker)
 		// Ignore format errors for non-image files, we don't care about those thumbnails
 		if err != nil && (!errors.Is(err, image.ErrFormat) || mediaType == whatsmeow.MediaImage) {
 			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to generate thumbnail for image message")
 		}
 	}
+	sourceURI := getMatrixSourceURI(content)
+	if isSticker {
+		// Stickers may be resized, so the upload isn't reusable for other messages with the same source file.
+		sourceURI = ""
+	}
+	if !viewOnce {
+		mc.cacheWhatsAppUpload(ctx, sourceURI, content, &uploaded, mediaType, mime, thumbnail, *pageCount)
+	}
+	return &uploaded, thumbnail, mime, nil
+}
+
+// getExpectedMediaType returns the WhatsApp media type that a Matrix file will be uploaded as.
+func getExpectedMediaType(msgType event.MessageType, mime string) whatsmeow.MediaType {
+	if mime == "image/gif" {
+		return whatsmeow.MediaVideo
+	}
+	switch msgType {
+	case event.MsgImage, event.MessageType(event.EventSticker.Type):
+		return whatsmeow.MediaImage
+	case event.MsgVideo:
+		return whatsmeow.MediaVideo
+	case event.MsgAudio:
+		return whatsmeow.MediaAudio
+	default:
+		return whatsmeow.MediaDocument
+	}
+}
+
+// getStreamableMediaType returns the WhatsApp media type for files that can be uploaded from a temporary file,
+// i.e. without any conversions that require the whole file to be in memory.
+func getStreamableMediaType(msgType event.MessageType, mime string) (whatsmeow.MediaType, string, bool) {
+	switch msgType {
+	case event.MsgVideo:
+		// Other video formats are transcoded from the temporary file
+		if isWhatsAppVideoMime(mime) || (strings.HasPrefix(mime, "video/") && ffmpeg.Supported()) {
+			return whatsmeow.MediaVideo, mime, true
+		}
+	case event.MsgAudio:
+		switch mime {
+		case "audio/aac", "audio/mp4", "audio/amr", "audio/mpeg", "audio/ogg; codecs=opus":
+			return whatsmeow.MediaAudio, mime, true
+		case "audio/ogg":
+			return whatsmeow.MediaAudio, "audio/ogg; codecs=opus", true
+		}
+	case event.MsgFile:
+		if mime != "image/gif" {
+			return whatsmeow.MediaDocument, mime, true
+		}
+	}
+	return "", "", false
+}
+
+// streamFileToWhatsApp uploads a downloaded Matrix file to WhatsApp while encrypting and hashing it incrementally,
+// so large files are never fully buffered in memory. The file is overwritten with the encrypted data.
+func (mc *MessageConverter) streamFileToWhatsApp(
+	ctx context.Context,
+	file *os.File,
+	content *event.MessageEventContent,
+	mediaType whatsmeow.MediaType,
+	mime string,
+	pageCount *uint32,
+	viewOnce bool,
+) (*whatsmeow.UploadResponse, []byte, string, error) {
+	var generatedThumbnail []byte
+	hasMatrixThumbnail := content.GetInfo().ThumbnailURL != "" || content.GetInfo().ThumbnailFile != nil
+	if mediaType == whatsmeow.MediaVideo && ffmpeg.Supported() {
+		converted, err := mc.convertVideoFileForStream(ctx, file, mime)
+		if err != nil {
+			return nil, nil, mime, err
+		} else if converted != nil {
+			defer func() {
+				_ = converted.Close()
+				_ = os.Remove(converted.Name())
+			}()
+			file = converted
+			mime = "video/mp4"
+		}
+		if !hasMatrixThumbnail {
+			generatedThumbnail, err = generateVideoThumbnail(ctx, file.Name())
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to generate video thumbnail")
+			}
+		}
+	} else if mediaType == whatsmeow.MediaVideo {
+		stat, err := file.Stat()
+		if err != nil {
+			return nil, nil, "", fmt.Errorf("%w: failed to stat file: %w", bridgev2.ErrMediaReuploadFailed, err)
+		} else if err = mc.checkUnconvertedVideoSize(stat.Size()); err != nil {
+			return nil, nil, mime, err
+		}
+	} else if mediaType == whatsmeow.MediaDocument && mime == "application/pdf" {
+		var err error
+		generatedThumbnail, *pageCount, err = generatePDFFilePreview(file)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to generate pdf preview")
+		}
+	}
+	_, err := file.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("%w: failed to seek to start of file: %w", bridgev2.ErrMediaReuploadFailed, err)
+	}
+	// The file is a temporary copy, so it's reused as the encryption target instead of making another copy.
+	uploaded, err := getClient(ctx).UploadReader(ctx, file, file, mediaType)
+	if err != nil {
+		zerolog.Ctx(ctx).Debug().
+			Str("mime_type", mime).
+			Uint64("file_length", uploaded.FileLength).
+			Msg("Failed to stream media upload")
+		return nil, nil, "", fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
+	}
+	thumbnail := generatedThumbnail
+	if mediaType != whatsmeow.MediaAudio && hasMatrixThumbnail {
+		thumbnail, err = mc.downloadThumbnail(ctx, nil, content.GetInfo().ThumbnailURL, content.GetInfo().ThumbnailFile, false)
+		if err != nil {
+			zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to get thumbnail for streamed media")
+		}
+	}
+	if !viewOnce {
+		mc.cacheWhatsAppUpload(ctx, getMatrixSourceURI(content), content, &uploaded, mediaType, mime, thumbnail, *pageCount)
+	}
 	return &uploaded, thumbnail, mime, nil
 }
 
@@ -614,29 +876,18 @@ func parseGeoURI(uri string) (lat, long float64, err error) {
 }
 
 func getAudioInfo(content *event.MessageEventContent) (output []byte, duration uint32) {
-	duration = uint32(content.Info.Duration / 1000)
+	durationMS := content.GetInfo().Duration
 	audioInfo := content.MSC1767Audio
-	if audioInfo == nil {
-		return
-	}
-	if duration == 0 && audioInfo.Duration != 0 {
-		duration = uint32(audioInfo.Duration / 1000)
+	if durationMS == 0 && audioInfo != nil {
+		durationMS = audioInfo.Duration
 	}
-	waveform := audioInfo.Waveform
-	if len(waveform) == 0 {
-		return
+	// Round to the nearest second, but don't let short clips become zero seconds long
+	duration = uint32((durationMS + 500) / 1000)
+	if duration == 0 && durationMS > 0 {
+		duration = 1
 	}
-
-	maxVal := slices.Max(waveform)
-	output = make([]byte, len(waveform))
-	if maxVal <= 256 {
-		for i, part := range waveform {
-			output[i] = byte(min(part, 255))
-		}
-	} else {
-		for i, part := range waveform {
-			output[i] = byte(min(part/4, 255))
-		}
+	if audioInfo != nil {
+		output = waveformToWhatsApp(audioInfo.Waveform)
 	}
 	return
 }
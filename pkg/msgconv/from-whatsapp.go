@@ -24,8 +24,10 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"strings"
+	"time"
 
 	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
 	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
 	"github.com/iKonoTelecomunicaciones/go/event"
 	"github.com/iKonoTelecomunicaciones/go/id"
@@ -201,6 +203,8 @@ func (mc *MessageConverter) ToMatrix(
 		part, status_part, contextInfo = mc.convertMediaMessage(ctx, waMsg.AudioMessage, typeName, info, isViewOnce, previouslyConvertedPart)
 	case waMsg.DocumentMessage != nil:
 		part, status_part, contextInfo = mc.convertMediaMessage(ctx, waMsg.DocumentMessage, "file attachment", info, isViewOnce, previouslyConvertedPart)
+	case waMsg.StickerPackMessage != nil:
+		part, contextInfo = mc.convertStickerPackMessage(ctx, waMsg.StickerPackMessage)
 	case waMsg.AlbumMessage != nil:
 		part, contextInfo = mc.convertAlbumMessage(ctx, waMsg.AlbumMessage)
 	case waMsg.LocationMessage != nil:
@@ -246,6 +250,15 @@ func (mc *MessageConverter) ToMatrix(
 	cm := &bridgev2.ConvertedMessage{
 		Parts: parts_to_send,
 	}
+	if expiration := contextInfo.GetExpiration(); expiration > 0 {
+		// The timer is per-message, so it's used even if the portal timer has changed since the message was sent
+		timer := time.Duration(expiration) * time.Second
+		cm.Disappear = database.DisappearingSetting{
+			Type:        event.DisappearingTypeAfterSend,
+			Timer:       timer,
+			DisappearAt: info.Timestamp.Add(timer),
+		}
+	}
 
 	if contextInfo.GetStanzaID() != "" && status_part == nil {
 		pcp, _ := types.ParseJID(contextInfo.GetParticipant())
@@ -304,6 +317,13 @@ func (mc *MessageConverter) ToMatrix(
 			chat, _ = waid.ParsePortalID(portal.ID)
 		}
 		cm.ThreadRoot = ptr.Ptr(waid.MakeMessageID(chat, pcp, commentTarget.GetID()))
+	} else if albumID := getAlbumParent(waMsg, info); albumID != nil {
+		// Group album items in a thread under the album message
+		cm.ThreadRoot = albumID
+		if part.Extra == nil {
+			part.Extra = map[string]any{}
+		}
+		part.Extra[AlbumIDField] = string(*albumID)
 	}
 
 	return cm
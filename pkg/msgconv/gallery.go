@@ -0,0 +1,157 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/database"
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"go.mau.fi/util/random"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waCommon"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
+)
+
+// MsgGallery is the msgtype of MSC4274 inline media galleries.
+const MsgGallery event.MessageType = "dm.filament.gallery"
+
+// AlbumIDField is set in the content of media that belongs to a WhatsApp album.
+const AlbumIDField = "fi.mau.whatsapp.album_id"
+
+// ParseGalleryItems extracts the items of an MSC4274 gallery as standalone message contents.
+func ParseGalleryItems(raw map[string]any) ([]*event.MessageEventContent, error) {
+	rawItems, ok := raw["itemtypes"].([]any)
+	if !ok || len(rawItems) == 0 {
+		return nil, fmt.Errorf("%w: gallery doesn't contain any items", bridgev2.ErrUnsupportedMessageType)
+	}
+	items := make([]*event.MessageEventContent, len(rawItems))
+	for i, rawItem := range rawItems {
+		itemMap, ok := rawItem.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: gallery item #%d is not an object", bridgev2.ErrUnsupportedMessageType, i+1)
+		}
+		itemType, _ := itemMap["itemtype"].(string)
+		if itemType != string(event.MsgImage) && itemType != string(event.MsgVideo) {
+			return nil, fmt.Errorf("%w %s in gallery", bridgev2.ErrUnsupportedMessageType, itemType)
+		}
+		data, err := json.Marshal(itemMap)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal gallery item #%d: %w", i+1, err)
+		}
+		items[i] = &event.MessageEventContent{}
+		err = json.Unmarshal(data, items[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gallery item #%d: %w", i+1, err)
+		}
+		items[i].MsgType = event.MessageType(itemType)
+	}
+	return items, nil
+}
+
+// GalleryToWhatsApp converts an MSC4274 gallery into a WhatsApp album message and the media messages inside it.
+// The children must be associated with the album using SetAlbumParent after the album message has been sent.
+func (mc *MessageConverter) GalleryToWhatsApp(
+	ctx context.Context,
+	client *whatsmeow.Client,
+	evt *event.Event,
+	content *event.MessageEventContent,
+	replyTo *database.Message,
+	portal *bridgev2.Portal,
+) (*waE2E.Message, []*waE2E.Message, error) {
+	ctx = context.WithValue(ctx, contextKeyClient, client)
+	ctx = context.WithValue(ctx, contextKeyPortal, portal)
+	items, err := ParseGalleryItems(evt.Content.Raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	// WhatsApp only supports a single caption per media message, so the gallery caption goes on the first item.
+	if content.Body != "" {
+		if items[0].FileName == "" {
+			items[0].FileName = items[0].Body
+		}
+		items[0].Body = content.Body
+		items[0].Format = content.Format
+		items[0].FormattedBody = content.FormattedBody
+	}
+	album := &waE2E.AlbumMessage{
+		ContextInfo: mc.generateContextInfo(ctx, replyTo, portal, content.BeeperDisappearingTimer),
+	}
+	children := make([]*waE2E.Message, len(items))
+	for i, item := range items {
+		item.BeeperDisappearingTimer = content.BeeperDisappearingTimer
+		children[i], _, err = mc.ToWhatsApp(ctx, client, evt, item, nil, nil, portal)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to convert gallery item #%d: %w", i+1, err)
+		}
+		if children[i].VideoMessage != nil {
+			album.ExpectedVideoCount = proto.Uint32(album.GetExpectedVideoCount() + 1)
+		} else {
+			album.ExpectedImageCount = proto.Uint32(album.GetExpectedImageCount() + 1)
+		}
+	}
+	return &waE2E.Message{AlbumMessage: album}, children, nil
+}
+
+// SetAlbumParent marks the given media message as a part of the album with the given key.
+func SetAlbumParent(message *waE2E.Message, albumKey *waCommon.MessageKey) {
+	if message.MessageContextInfo == nil {
+		message.MessageContextInfo = &waE2E.MessageContextInfo{}
+	}
+	if message.MessageContextInfo.MessageSecret == nil {
+		message.MessageContextInfo.MessageSecret = random.Bytes(32)
+	}
+	message.MessageContextInfo.MessageAssociation = &waE2E.MessageAssociation{
+		AssociationType:  waE2E.MessageAssociation_MEDIA_ALBUM.Enum(),
+		ParentMessageKey: albumKey,
+	}
+}
+
+// UnwrapAssociatedChild returns the message inside an associated child message wrapper. The association of the
+// wrapper is copied into the child if it doesn't have its own, so that album items can still be grouped.
+func UnwrapAssociatedChild(message *waE2E.Message) *waE2E.Message {
+	child := message.GetAssociatedChildMessage().GetMessage()
+	if child == nil {
+		return message
+	}
+	if assoc := message.GetMessageContextInfo().GetMessageAssociation(); assoc != nil && child.GetMessageContextInfo().GetMessageAssociation() == nil {
+		if child.MessageContextInfo == nil {
+			child.MessageContextInfo = &waE2E.MessageContextInfo{}
+		}
+		child.MessageContextInfo.MessageAssociation = assoc
+	}
+	return child
+}
+
+// getAlbumParent returns the ID of the album message that the given message belongs to, if any.
+func getAlbumParent(waMsg *waE2E.Message, info *types.MessageInfo) *networkid.MessageID {
+	assoc := waMsg.GetMessageContextInfo().GetMessageAssociation()
+	if assoc.GetAssociationType() != waE2E.MessageAssociation_MEDIA_ALBUM || assoc.GetParentMessageKey().GetID() == "" {
+		return nil
+	}
+	// Album items are always sent by the same user as the album itself
+	parentID := waid.MakeMessageID(info.Chat, info.Sender, assoc.GetParentMessageKey().GetID())
+	return &parentID
+}
@@ -0,0 +1,257 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ptr"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
+)
+
+type MediaCacheConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	MatrixTTL   time.Duration `yaml:"matrix_ttl"`
+	WhatsAppTTL time.Duration `yaml:"whatsapp_ttl"`
+}
+
+func (mc *MessageConverter) mediaCacheEnabled() bool {
+	return mc.MediaCache.Enabled && mc.DB != nil
+}
+
+// getCachedMatrixUpload fills the Matrix URL of the part if the same file has already been uploaded to Matrix.
+// View-once media must not be looked up, as it's deleted from Matrix after being viewed.
+func (mc *MessageConverter) getCachedMatrixUpload(ctx context.Context, message whatsmeow.DownloadableMessage, part *PreparedMedia) bool {
+	fileSHA256 := message.GetFileSHA256()
+	if !mc.mediaCacheEnabled() || mc.IsEncryptedRoom == nil || len(fileSHA256) == 0 || part.ViewOnce ||
+		(part.Type == event.EventSticker && part.Info.MimeType == "application/was") {
+		return false
+	}
+	log := zerolog.Ctx(ctx)
+	encrypted, err := mc.IsEncryptedRoom(ctx, getPortal(ctx).MXID)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to check if room is encrypted for media cache lookup")
+		return false
+	}
+	cached, err := mc.DB.MediaCache.GetMatrix(ctx, fileSHA256, encrypted)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to get cached Matrix media")
+		return false
+	} else if cached == nil {
+		return false
+	}
+	if cached.EncryptedFile != nil {
+		part.File = ptr.Clone(cached.EncryptedFile)
+	} else {
+		part.URL = cached.MXC
+	}
+	if part.Info.MimeType == "" {
+		part.Info.MimeType = cached.MimeType
+	}
+	if part.FileName == "" {
+		part.FileName = cached.FileName
+	}
+	part.FillFileName()
+	log.Debug().Str("mxc", string(cached.MXC)).Msg("Reusing cached Matrix upload of media")
+	return true
+}
+
+// cacheMatrixUpload stores the Matrix upload of a WhatsApp file, as well as the original WhatsApp upload,
+// so that forwarding the same file is deduplicated in both directions.
+//
+// The cache is keyed on the hash declared by the sender, so this must only be called after the download
+// was verified to match that hash.
+func (mc *MessageConverter) cacheMatrixUpload(ctx context.Context, message whatsmeow.DownloadableMessage, part *PreparedMedia) {
+	fileSHA256 := message.GetFileSHA256()
+	if !mc.mediaCacheEnabled() || len(fileSHA256) == 0 || part.ViewOnce {
+		return
+	}
+	mxc := part.URL
+	if part.File != nil {
+		mxc = part.File.URL
+	}
+	log := zerolog.Ctx(ctx)
+	err := mc.DB.MediaCache.PutMatrix(ctx, &wadb.MatrixMediaCacheEntry{
+		FileSHA256:    fileSHA256,
+		Encrypted:     part.File != nil,
+		MXC:           mxc,
+		EncryptedFile: part.File,
+		MimeType:      part.Info.MimeType,
+		FileName:      part.FileName,
+		ExpiresAt:     time.Now().Add(mc.MediaCache.MatrixTTL),
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to cache Matrix upload of media")
+	}
+	if message.GetDirectPath() == "" || len(message.GetMediaKey()) == 0 {
+		return
+	}
+	var url string
+	if urlable, ok := message.(interface{ GetURL() string }); ok {
+		url = urlable.GetURL()
+	}
+	var fileLength uint64
+	if mediaMsg, ok := message.(MediaMessage); ok {
+		fileLength = mediaMsg.GetFileLength()
+	}
+	var pageCount, seconds uint32
+	var waveform []byte
+	switch typedMsg := message.(type) {
+	case *waE2E.DocumentMessage:
+		pageCount = typedMsg.GetPageCount()
+	case *waE2E.AudioMessage:
+		seconds = typedMsg.GetSeconds()
+		waveform = typedMsg.GetWaveform()
+	}
+	err = mc.DB.MediaCache.PutWhatsApp(ctx, &wadb.WhatsAppMediaCacheEntry{
+		FileSHA256:    fileSHA256,
+		MediaType:     string(whatsmeow.GetMediaType(message)),
+		SourceMXC:     mxc,
+		URL:           url,
+		DirectPath:    message.GetDirectPath(),
+		MediaKey:      message.GetMediaKey(),
+		FileEncSHA256: message.GetFileEncSHA256(),
+		FileLength:    fileLength,
+		MimeType:      part.Info.MimeType,
+		PageCount:     pageCount,
+		Seconds:       seconds,
+		Waveform:      waveform,
+		ExpiresAt:     time.Now().Add(mc.MediaCache.WhatsAppTTL),
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to cache WhatsApp upload of media")
+	}
+}
+
+func cachedWhatsAppUpload(cached *wadb.WhatsAppMediaCacheEntry) *whatsmeow.UploadResponse {
+	return &whatsmeow.UploadResponse{
+		URL:           cached.URL,
+		DirectPath:    cached.DirectPath,
+		MediaKey:      cached.MediaKey,
+		FileEncSHA256: cached.FileEncSHA256,
+		FileSHA256:    cached.FileSHA256,
+		FileLength:    cached.FileLength,
+	}
+}
+
+// getCachedWhatsAppUploadBySource finds an existing WhatsApp upload of the given Matrix file. Callers must check
+// that the upload is usable with canReuseCachedUpload, as it may have been uploaded in a different format.
+func (mc *MessageConverter) getCachedWhatsAppUploadBySource(
+	ctx context.Context, mxc id.ContentURIString, mediaType whatsmeow.MediaType,
+) *wadb.WhatsAppMediaCacheEntry {
+	if !mc.mediaCacheEnabled() || mxc == "" {
+		return nil
+	}
+	cached, err := mc.DB.MediaCache.GetWhatsAppBySource(ctx, mxc, string(mediaType))
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to get cached WhatsApp media")
+		return nil
+	}
+	return cached
+}
+
+// getCachedWhatsAppUpload finds an existing WhatsApp upload of a file with the given hash.
+func (mc *MessageConverter) getCachedWhatsAppUpload(
+	ctx context.Context, fileSHA256 []byte, mediaType whatsmeow.MediaType,
+) *wadb.WhatsAppMediaCacheEntry {
+	if !mc.mediaCacheEnabled() {
+		return nil
+	}
+	cached, err := mc.DB.MediaCache.GetWhatsApp(ctx, fileSHA256, string(mediaType))
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to get cached WhatsApp media")
+		return nil
+	}
+	return cached
+}
+
+// cacheWhatsAppUpload stores the WhatsApp upload of a Matrix file along with the message metadata generated from it,
+// so that the metadata can be restored if the upload is reused without downloading the file again.
+func (mc *MessageConverter) cacheWhatsAppUpload(
+	ctx context.Context,
+	mxc id.ContentURIString,
+	content *event.MessageEventContent,
+	uploaded *whatsmeow.UploadResponse,
+	mediaType whatsmeow.MediaType,
+	mime string,
+	thumbnail []byte,
+	pageCount uint32,
+) {
+	if !mc.mediaCacheEnabled() {
+		return
+	}
+	var seconds uint32
+	var waveform []byte
+	if mediaType == whatsmeow.MediaAudio {
+		waveform, seconds = getAudioInfo(content)
+	}
+	err := mc.DB.MediaCache.PutWhatsApp(ctx, &wadb.WhatsAppMediaCacheEntry{
+		FileSHA256:    uploaded.FileSHA256,
+		MediaType:     string(mediaType),
+		SourceMXC:     mxc,
+		URL:           uploaded.URL,
+		DirectPath:    uploaded.DirectPath,
+		MediaKey:      uploaded.MediaKey,
+		FileEncSHA256: uploaded.FileEncSHA256,
+		FileLength:    uploaded.FileLength,
+		MimeType:      mime,
+		Thumbnail:     thumbnail,
+		PageCount:     pageCount,
+		Seconds:       seconds,
+		Waveform:      waveform,
+		ExpiresAt:     time.Now().Add(mc.MediaCache.WhatsAppTTL),
+	})
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to cache WhatsApp upload of media")
+	}
+}
+
+// canReuseCachedUpload checks if a cached upload found by the Matrix source file is in the format that the file
+// would be converted to for the given message, so it can be sent without downloading the file again.
+func canReuseCachedUpload(content *event.MessageEventContent, cached *wadb.WhatsAppMediaCacheEntry) bool {
+	switch content.MsgType {
+	case event.MsgImage:
+		return cached.MimeType == "image/jpeg"
+	case event.MsgVideo:
+		return isWhatsAppVideoMime(cached.MimeType)
+	case event.MsgAudio:
+		if content.MSC3245Voice != nil {
+			return cached.MimeType == voiceMimeType && cached.Seconds > 0
+		}
+		switch cached.MimeType {
+		case "audio/aac", "audio/mp4", "audio/amr", "audio/mpeg", voiceMimeType:
+			return true
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func getMatrixSourceURI(content *event.MessageEventContent) id.ContentURIString {
+	if content.File != nil {
+		return content.File.URL
+	}
+	return content.URL
+}
@@ -17,15 +17,19 @@
 package msgconv
 
 import (
+	"context"
+
 	"github.com/iKonoTelecomunicaciones/go/bridgev2"
 	"github.com/iKonoTelecomunicaciones/go/format"
+	"github.com/iKonoTelecomunicaciones/go/id"
 
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/connector/wadb"
 )
 
 type AnimatedStickerConfig struct {
-	Target string `yaml:"target"`
-	Args   struct {
+	Target   string `yaml:"target"`
+	Renderer string `yaml:"renderer"`
+	Args     struct {
 		Width  int `yaml:"width"`
 		Height int `yaml:"height"`
 		FPS    int `yaml:"fps"`
@@ -36,6 +40,7 @@ type MessageConverter struct {
 	Bridge                *bridgev2.Bridge
 	DB                    *wadb.Database
 	MaxFileSize           int64
+	MaxWhatsAppFileSize   int64
 	HTMLParser            *format.HTMLParser
 	AnimatedStickerConfig AnimatedStickerConfig
 	FetchURLPreviews      bool
@@ -43,6 +48,9 @@ type MessageConverter struct {
 	DisableViewOnce       bool
 	DirectMedia           bool
 	OldMediaSuffix        string
+	MediaCache            MediaCacheConfig
+	// IsEncryptedRoom checks if a Matrix room is encrypted. It's needed to reuse cached Matrix uploads.
+	IsEncryptedRoom func(ctx context.Context, roomID id.RoomID) (bool, error)
 }
 
 func New(br *bridgev2.Bridge) *MessageConverter {
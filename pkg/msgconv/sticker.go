@@ -0,0 +1,202 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/png"
+	"time"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/image/draw"
+
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/lottierender"
+)
+
+const (
+	// WhatsApp rejects animated stickers larger than this.
+	maxAnimatedStickerSize = 500 * 1024
+	animatedStickerFPS     = 25
+	maxAnimatedStickerTime = 10 * time.Second
+	// The lottie renderer is stopped after this many frames instead of cutting off the animation afterwards.
+	maxLottieStickerFrames = int(maxAnimatedStickerTime / time.Second * animatedStickerFPS)
+)
+
+func isLottieMime(mime string) bool {
+	return mime == "video/lottie+json" || mime == "application/json"
+}
+
+// isAnimatedStickerSource checks if a Matrix sticker should be sent as an animated sticker to WhatsApp.
+func isAnimatedStickerSource(data []byte, mime string) bool {
+	return isLottieMime(mime) || mime == "image/gif" || (mime == "image/webp" && isAnimatedWebP(data))
+}
+
+// convertAnimatedStickerToWhatsApp converts a lottie animation, gif or animated webp into a WhatsApp animated sticker.
+// The second return value is a png thumbnail of the first frame, which is nil if the input was already webp.
+func (mc *MessageConverter) convertAnimatedStickerToWhatsApp(ctx context.Context, data []byte, mime string) (converted, thumbnail []byte, err error) {
+	defer func() {
+		// The lottie renderer is only meant for well-formed stickers, so don't let a malicious file crash the bridge
+		if panicErr := recover(); panicErr != nil {
+			converted, thumbnail = nil, nil
+			err = fmt.Errorf("panic while converting animated sticker: %v", panicErr)
+		}
+	}()
+	var frames []*image.RGBA
+	var delay time.Duration
+	switch {
+	case mime == "image/webp":
+		// There's no animated webp decoder available, so webp stickers are sent as-is
+		if len(data) > maxAnimatedStickerSize {
+			return nil, nil, fmt.Errorf("animated sticker is too large (%d bytes, max %d)", len(data), maxAnimatedStickerSize)
+		}
+		return data, nil, nil
+	case isLottieMime(mime):
+		anim, err := lottierender.Parse(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		frames, delay = anim.Render(stickerSize, stickerSize, animatedStickerFPS, maxLottieStickerFrames)
+	case mime == "image/gif":
+		frames, delay, err = decodeGIFSticker(data)
+		if err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, fmt.Errorf("unsupported animated sticker type %s", mime)
+	}
+	if len(frames) == 0 {
+		return nil, nil, fmt.Errorf("animated sticker doesn't contain any frames")
+	} else if delay <= 0 {
+		return nil, nil, fmt.Errorf("invalid animated sticker frame delay %s", delay)
+	}
+	if maxFrames := max(int(maxAnimatedStickerTime/delay), 1); len(frames) > maxFrames {
+		zerolog.Ctx(ctx).Debug().
+			Int("frame_count", len(frames)).
+			Int("max_frames", maxFrames).
+			Msg("Cutting off animated sticker")
+		frames = frames[:maxFrames]
+	}
+	converted, err = encodeAnimatedSticker(frames, delay)
+	if err != nil {
+		return nil, nil, err
+	}
+	var firstFrame bytes.Buffer
+	err = png.Encode(&firstFrame, frames[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode first frame: %w", err)
+	}
+	thumbnail, err = createThumbnail(firstFrame.Bytes(), true)
+	if err != nil {
+		return nil, nil, err
+	}
+	return converted, thumbnail, nil
+}
+
+// encodeAnimatedSticker encodes frames as animated webp, reducing the quality and frame rate until it fits in the
+// WhatsApp size limit.
+func encodeAnimatedSticker(frames []*image.RGBA, delay time.Duration) ([]byte, error) {
+	for {
+		for _, quality := range []float32{75, 50, 25} {
+			data, err := lottierender.EncodeWebP(frames, delay, quality)
+			if err != nil {
+				return nil, err
+			} else if len(data) <= maxAnimatedStickerSize {
+				return data, nil
+			}
+		}
+		if len(frames) < 2 {
+			return nil, fmt.Errorf("animated sticker doesn't fit in %d bytes", maxAnimatedStickerSize)
+		}
+		halved := make([]*image.RGBA, 0, (len(frames)+1)/2)
+		for i := 0; i < len(frames); i += 2 {
+			halved = append(halved, frames[i])
+		}
+		frames = halved
+		delay *= 2
+	}
+}
+
+// decodeGIFSticker decodes all frames of a gif and fits them into the sticker size.
+// The returned delay is the average delay of the frames.
+func decodeGIFSticker(data []byte) ([]*image.RGBA, time.Duration, error) {
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decode gif: %w", err)
+	} else if len(anim.Image) == 0 {
+		return nil, 0, fmt.Errorf("gif doesn't contain any frames")
+	}
+	canvasRect := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	if canvasRect.Empty() {
+		canvasRect = anim.Image[0].Bounds()
+	}
+	canvas := image.NewRGBA(canvasRect)
+	frames := make([]*image.RGBA, 0, len(anim.Image))
+	var totalDelay int
+	for i, frame := range anim.Image {
+		// Frames after the time limit would be cut off anyway, so don't bother scaling them
+		if time.Duration(totalDelay)*10*time.Millisecond >= maxAnimatedStickerTime {
+			break
+		}
+		var disposal byte
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvasRect)
+			draw.Draw(previous, canvasRect, canvas, canvasRect.Min, draw.Src)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		frames = append(frames, fitStickerFrame(canvas))
+		if i < len(anim.Delay) {
+			totalDelay += anim.Delay[i]
+		}
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	// GIF delays are in hundredths of a second, and browsers treat very small delays as 100ms
+	delay := time.Duration(totalDelay) * 10 * time.Millisecond / time.Duration(len(frames))
+	if delay < 20*time.Millisecond {
+		delay = 100 * time.Millisecond
+	}
+	return frames, delay, nil
+}
+
+// fitStickerFrame scales an image to fit in the sticker size and pads it into a transparent square.
+func fitStickerFrame(src *image.RGBA) *image.RGBA {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width >= height {
+		height = max(height*stickerSize/width, 1)
+		width = stickerSize
+	} else {
+		width = max(width*stickerSize/height, 1)
+		height = stickerSize
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, stickerSize, stickerSize))
+	offsetX, offsetY := (stickerSize-width)/2, (stickerSize-height)/2
+	draw.BiLinear.Scale(dst, image.Rect(offsetX, offsetY, offsetX+width, offsetY+height), src, bounds, draw.Src, nil)
+	return dst
+}
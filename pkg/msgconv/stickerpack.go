@@ -0,0 +1,376 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/iKonoTelecomunicaciones/go/id"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ffmpeg"
+	"go.mau.fi/util/random"
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"google.golang.org/protobuf/proto"
+)
+
+// ImagePackEventType is the MSC2545 state event type for image packs in rooms.
+var ImagePackEventType = event.Type{Type: "im.ponies.room_emotes", Class: event.StateEventType}
+
+const StickerPackField = "fi.mau.whatsapp.sticker_pack"
+
+const (
+	// WhatsApp stickers are always 512x512 webp images.
+	stickerSize = 512
+	// WhatsApp doesn't allow more than 30 stickers in a single pack.
+	maxStickersPerPack = 30
+	// Animated stickers are cut off after this many seconds.
+	maxAnimatedStickerDuration = "10"
+	// Limits for reading sticker pack archives from WhatsApp, which are much higher than what WhatsApp itself allows.
+	maxStickerPackArchiveFiles = 100
+	maxStickerPackFileSize     = 2 * 1024 * 1024
+)
+
+// ErrStickerPackInEncryptedRoom is returned by SaveStickerPack in encrypted rooms,
+// as image packs can only refer to unencrypted media.
+var ErrStickerPackInEncryptedRoom = errors.New("sticker packs can't be saved in encrypted rooms")
+
+// ImagePack is the content of an MSC2545 image pack.
+type ImagePack struct {
+	Images map[string]*ImagePackImage `json:"images"`
+	Pack   ImagePackMeta              `json:"pack"`
+}
+
+type ImagePackImage struct {
+	URL   id.ContentURIString `json:"url"`
+	Body  string              `json:"body,omitempty"`
+	Info  *event.FileInfo     `json:"info,omitempty"`
+	Usage []string            `json:"usage,omitempty"`
+}
+
+type ImagePackMeta struct {
+	DisplayName string              `json:"display_name,omitempty"`
+	AvatarURL   id.ContentURIString `json:"avatar_url,omitempty"`
+	Usage       []string            `json:"usage,omitempty"`
+	Attribution string              `json:"attribution,omitempty"`
+}
+
+func (img *ImagePackImage) isSticker(pack *ImagePackMeta) bool {
+	usage := img.Usage
+	if len(usage) == 0 {
+		usage = pack.Usage
+	}
+	return len(usage) == 0 || slices.Contains(usage, "sticker")
+}
+
+func (mc *MessageConverter) convertStickerPackMessage(ctx context.Context, msg *waE2E.StickerPackMessage) (*bridgev2.ConvertedMessagePart, *waE2E.ContextInfo) {
+	body := fmt.Sprintf("Sent a sticker pack: %s", msg.GetName())
+	if msg.GetPublisher() != "" {
+		body += fmt.Sprintf(" by %s", msg.GetPublisher())
+	}
+	body += fmt.Sprintf(" (%d stickers)", len(msg.GetStickers()))
+	part := &bridgev2.ConvertedMessagePart{
+		Type: event.EventMessage,
+		Content: &event.MessageEventContent{
+			MsgType: event.MsgNotice,
+			Body:    body,
+		},
+		Extra: map[string]any{
+			StickerPackField: map[string]any{
+				"id":        msg.GetStickerPackID(),
+				"name":      msg.GetName(),
+				"publisher": msg.GetPublisher(),
+				"state_key": msg.GetStickerPackID(),
+			},
+		},
+	}
+	if msg.GetCaption() != "" {
+		part.Content.Body += "\n\n" + msg.GetCaption()
+	}
+	return part, msg.GetContextInfo()
+}
+
+// SaveStickerPack reuploads the stickers of a WhatsApp sticker pack and saves them as an image pack in the portal room.
+// It's separate from ToMatrix so that it's only done for new messages rather than backfill.
+func (mc *MessageConverter) SaveStickerPack(
+	ctx context.Context,
+	portal *bridgev2.Portal,
+	client *whatsmeow.Client,
+	intent bridgev2.MatrixAPI,
+	msg *waE2E.StickerPackMessage,
+) error {
+	if portal.MXID == "" {
+		return fmt.Errorf("portal room doesn't exist")
+	} else if mc.IsEncryptedRoom == nil {
+		return fmt.Errorf("can't check if room is encrypted")
+	}
+	encrypted, err := mc.IsEncryptedRoom(ctx, portal.MXID)
+	if err != nil {
+		return fmt.Errorf("failed to check if room is encrypted: %w", err)
+	} else if encrypted {
+		return ErrStickerPackInEncryptedRoom
+	}
+	ctx = context.WithValue(ctx, contextKeyClient, client)
+	ctx = context.WithValue(ctx, contextKeyIntent, intent)
+	ctx = context.WithValue(ctx, contextKeyPortal, portal)
+	pack, err := mc.reuploadStickerPack(ctx, msg)
+	if err != nil {
+		return err
+	}
+	_, err = mc.Bridge.Bot.SendState(ctx, portal.MXID, ImagePackEventType, msg.GetStickerPackID(), &event.Content{Parsed: pack}, time.Time{})
+	if err != nil {
+		return fmt.Errorf("failed to save sticker pack in room: %w", err)
+	}
+	return nil
+}
+
+// reuploadStickerPack downloads the sticker pack archive from WhatsApp and uploads all the stickers to Matrix.
+func (mc *MessageConverter) reuploadStickerPack(ctx context.Context, msg *waE2E.StickerPackMessage) (*ImagePack, error) {
+	data, err := getClient(ctx).Download(ctx, msg)
+	if errors.Is(err, whatsmeow.ErrFileLengthMismatch) || errors.Is(err, whatsmeow.ErrInvalidMediaSHA256) {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Mismatching media checksums in sticker pack. Ignoring because WhatsApp seems to ignore them too")
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sticker pack archive: %w", err)
+	} else if len(archive.File) > maxStickerPackArchiveFiles {
+		return nil, fmt.Errorf("sticker pack archive has too many files (%d)", len(archive.File))
+	}
+	pack := &ImagePack{
+		Images: make(map[string]*ImagePackImage, len(msg.GetStickers())),
+		Pack: ImagePackMeta{
+			DisplayName: msg.GetName(),
+			Usage:       []string{"sticker"},
+			Attribution: msg.GetPublisher(),
+		},
+	}
+	intent := getIntent(ctx)
+	for i, sticker := range msg.GetStickers() {
+		if sticker.GetIsLottie() {
+			zerolog.Ctx(ctx).Debug().Str("file_name", sticker.GetFileName()).Msg("Skipping lottie sticker in sticker pack")
+			continue
+		}
+		stickerData, err := readZipFile(archive, sticker.GetFileName())
+		if err != nil {
+			return nil, err
+		}
+		mime := sticker.GetMimetype()
+		if mime == "" {
+			mime = http.DetectContentType(stickerData)
+		}
+		// Image packs can't contain encrypted files, so the stickers are uploaded without a room
+		mxc, _, err := intent.UploadMedia(ctx, "", stickerData, sticker.GetFileName(), mime)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
+		}
+		body := sticker.GetAccessibilityLabel()
+		if body == "" {
+			body = strings.Join(sticker.GetEmojis(), "")
+		}
+		pack.Images[fmt.Sprintf("sticker%d", i+1)] = &ImagePackImage{
+			URL:  mxc,
+			Body: body,
+			Info: &event.FileInfo{
+				MimeType: mime,
+				Width:    stickerSize,
+				Height:   stickerSize,
+				Size:     len(stickerData),
+			},
+		}
+		if sticker.GetFileName() == msg.GetTrayIconFileName() {
+			pack.Pack.AvatarURL = mxc
+		}
+	}
+	if len(pack.Images) == 0 {
+		return nil, fmt.Errorf("sticker pack doesn't contain any supported stickers")
+	}
+	return pack, nil
+}
+
+func readZipFile(archive *zip.Reader, name string) ([]byte, error) {
+	idx := slices.IndexFunc(archive.File, func(file *zip.File) bool {
+		return file.Name == name
+	})
+	if idx < 0 {
+		return nil, fmt.Errorf("%s not found in sticker pack archive", name)
+	}
+	file := archive.File[idx]
+	if file.UncompressedSize64 > maxStickerPackFileSize {
+		return nil, fmt.Errorf("%s in sticker pack archive is too large (%d bytes)", name, file.UncompressedSize64)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s in sticker pack archive: %w", name, err)
+	}
+	defer reader.Close()
+	// The size in the header can't be trusted, so limit the actual amount of data read too
+	data, err := io.ReadAll(io.LimitReader(reader, maxStickerPackFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s in sticker pack archive: %w", name, err)
+	} else if len(data) > maxStickerPackFileSize {
+		return nil, fmt.Errorf("%s in sticker pack archive is too large", name)
+	}
+	return data, nil
+}
+
+// ImagePackToWhatsApp converts an MSC2545 image pack into a WhatsApp sticker pack message.
+// Animated images (GIFs and videos) are converted into animated webp stickers if ffmpeg is available.
+func (mc *MessageConverter) ImagePackToWhatsApp(ctx context.Context, client *whatsmeow.Client, pack *ImagePack, publisher string) (*waE2E.Message, error) {
+	log := zerolog.Ctx(ctx)
+	shortcodes := make([]string, 0, len(pack.Images))
+	for shortcode, img := range pack.Images {
+		if img.isSticker(&pack.Pack) {
+			shortcodes = append(shortcodes, shortcode)
+		}
+	}
+	if len(shortcodes) == 0 {
+		return nil, fmt.Errorf("image pack doesn't contain any stickers")
+	}
+	slices.Sort(shortcodes)
+	if len(shortcodes) > maxStickersPerPack {
+		log.Warn().Int("sticker_count", len(shortcodes)).Msg("Image pack has too many stickers, only sending the first ones")
+		shortcodes = shortcodes[:maxStickersPerPack]
+	}
+
+	var archiveBuf bytes.Buffer
+	archive := zip.NewWriter(&archiveBuf)
+	stickers := make([]*waE2E.StickerPackMessage_Sticker, 0, len(shortcodes))
+	for _, shortcode := range shortcodes {
+		img := pack.Images[shortcode]
+		data, err := mc.Bridge.Bot.DownloadMedia(ctx, img.URL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%w (%s): %w", bridgev2.ErrMediaDownloadFailed, shortcode, err)
+		}
+		mime := ""
+		if img.Info != nil {
+			mime = img.Info.MimeType
+		}
+		if mime == "" {
+			mime = http.DetectContentType(data)
+		}
+		data, animated, err := mc.convertToStickerWebP(ctx, data, mime)
+		if err != nil {
+			return nil, fmt.Errorf("%w (%s): %w", bridgev2.ErrMediaConvertFailed, shortcode, err)
+		}
+		hash := sha256.Sum256(data)
+		fileName := hex.EncodeToString(hash[:]) + ".webp"
+		writer, err := archive.Create(fileName)
+		if err == nil {
+			_, err = writer.Write(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to add sticker to archive: %w", err)
+		}
+		label := img.Body
+		if label == "" {
+			label = shortcode
+		}
+		stickers = append(stickers, &waE2E.StickerPackMessage_Sticker{
+			FileName:           proto.String(fileName),
+			IsAnimated:         proto.Bool(animated),
+			AccessibilityLabel: proto.String(label),
+			IsLottie:           proto.Bool(false),
+			Mimetype:           proto.String("image/webp"),
+		})
+	}
+	err := archive.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish sticker pack archive: %w", err)
+	}
+	archiveData := archiveBuf.Bytes()
+	uploaded, err := client.Upload(ctx, archiveData, whatsmeow.MediaStickerPack)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
+	}
+	name := pack.Pack.DisplayName
+	if name == "" {
+		name = "Matrix stickers"
+	}
+	return &waE2E.Message{
+		StickerPackMessage: &waE2E.StickerPackMessage{
+			StickerPackID:     proto.String(hex.EncodeToString(random.Bytes(16))),
+			Name:              proto.String(name),
+			Publisher:         proto.String(publisher),
+			Stickers:          stickers,
+			FileLength:        proto.Uint64(uploaded.FileLength),
+			FileSHA256:        uploaded.FileSHA256,
+			FileEncSHA256:     uploaded.FileEncSHA256,
+			MediaKey:          uploaded.MediaKey,
+			DirectPath:        proto.String(uploaded.DirectPath),
+			MediaKeyTimestamp: proto.Int64(time.Now().Unix()),
+			TrayIconFileName:  stickers[0].FileName,
+			StickerPackSize:   proto.Uint64(uint64(len(archiveData))),
+			StickerPackOrigin: waE2E.StickerPackMessage_USER_CREATED.Enum(),
+		},
+	}, nil
+}
+
+// stickerScaleFilter scales the input to fit in the sticker size and pads it into a transparent square.
+var stickerScaleFilter = fmt.Sprintf(
+	"scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease,format=rgba,pad=%[1]d:%[1]d:(ow-iw)/2:(oh-ih)/2:color=black@0",
+	stickerSize,
+)
+
+// convertToStickerWebP converts an image or a short video into a WhatsApp sticker and reports if the result is animated.
+func (mc *MessageConverter) convertToStickerWebP(ctx context.Context, data []byte, mime string) ([]byte, bool, error) {
+	switch {
+	case mime == "image/webp":
+		// Assume webp images are already suitable for stickers, as ffmpeg can't decode animated webp
+		return data, isAnimatedWebP(data), nil
+	case isLottieMime(mime) || (mime == "image/gif" && !ffmpeg.Supported()):
+		converted, _, err := mc.convertAnimatedStickerToWhatsApp(ctx, data, mime)
+		return converted, true, err
+	case mime == "image/gif" || strings.HasPrefix(mime, "video/"):
+		if !ffmpeg.Supported() {
+			return nil, false, fmt.Errorf("converting %s to animated stickers requires ffmpeg", mime)
+		}
+		converted, err := ffmpeg.ConvertBytes(ctx, data, ".webp", nil, []string{
+			"-vf", stickerScaleFilter, "-c:v", "libwebp", "-loop", "0", "-an",
+			"-t", maxAnimatedStickerDuration, "-q:v", "50",
+		}, mime)
+		return converted, true, err
+	case ffmpeg.Supported():
+		converted, err := ffmpeg.ConvertBytes(ctx, data, ".webp", nil, []string{
+			"-vf", stickerScaleFilter, "-c:v", "libwebp", "-frames:v", "1",
+		}, mime)
+		return converted, false, err
+	default:
+		converted, _, err := mc.convertToWebP(data)
+		return converted, false, err
+	}
+}
+
+// isAnimatedWebP checks if the webp file has the animation flag set in its extended header.
+func isAnimatedWebP(data []byte) bool {
+	// RIFF header (12 bytes), then a VP8X chunk header (8 bytes) followed by the flags byte
+	return len(data) > 20 && string(data[12:16]) == "VP8X" && data[20]&0x02 != 0
+}
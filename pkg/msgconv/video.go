@@ -0,0 +1,216 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/iKonoTelecomunicaciones/go/bridgev2"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/exmime"
+	"go.mau.fi/util/ffmpeg"
+)
+
+const (
+	videoAudioBitrate = 128_000
+	// Videos that would need a lower bitrate than this to fit in the size limit are rejected instead of converted.
+	minVideoBitrate = 250_000
+)
+
+func isWhatsAppVideoMime(mime string) bool {
+	return mime == "video/mp4" || mime == "video/3gpp"
+}
+
+// probeVideo returns ffprobe info about the given file, or nil if ffprobe isn't available or fails.
+func probeVideo(ctx context.Context, path string) *ffmpeg.ProbeResult {
+	if !ffmpeg.ProbeSupported() {
+		return nil
+	}
+	probe, err := ffmpeg.Probe(ctx, path)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to probe video")
+		return nil
+	}
+	return probe
+}
+
+// isWhatsAppCompatibleVideo checks if all streams in the video can be played by WhatsApp clients as-is,
+// i.e. H.264 video in yuv420p and AAC audio.
+func isWhatsAppCompatibleVideo(probe *ffmpeg.ProbeResult) bool {
+	for _, stream := range probe.Streams {
+		switch stream.CodecType {
+		case "video":
+			if stream.Disposition.AttachedPic == 1 {
+				continue
+			} else if stream.CodecName != "h264" || (stream.PixFmt != "" && stream.PixFmt != "yuv420p") {
+				return false
+			}
+		case "audio":
+			if stream.CodecName != "aac" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (mc *MessageConverter) isVideoTooLarge(size int64) bool {
+	return mc.MaxWhatsAppFileSize > 0 && size > mc.MaxWhatsAppFileSize
+}
+
+// needsVideoConversion checks if a video file has to be transcoded before sending it to WhatsApp.
+// MP4 files are only transcoded if they're over the file size limit, or if ffprobe is available
+// and reports incompatible codecs.
+func (mc *MessageConverter) needsVideoConversion(ctx context.Context, path, mime string, size int64) (bool, *ffmpeg.ProbeResult) {
+	probe := probeVideo(ctx, path)
+	if !isWhatsAppVideoMime(mime) || mc.isVideoTooLarge(size) {
+		return true, probe
+	}
+	return probe != nil && !isWhatsAppCompatibleVideo(probe), probe
+}
+
+// checkUnconvertedVideoSize returns an error if a video that can't be transcoded is over the file size limit.
+func (mc *MessageConverter) checkUnconvertedVideoSize(size int64) error {
+	if mc.isVideoTooLarge(size) {
+		return fmt.Errorf(
+			"%w: video is too large (%d bytes, limit is %d bytes) and ffmpeg isn't available to compress it",
+			bridgev2.ErrMediaConvertFailed, size, mc.MaxWhatsAppFileSize,
+		)
+	}
+	return nil
+}
+
+// convertVideoFile transcodes a video file into an MP4 that WhatsApp can play and returns the path to the output file.
+// If the duration of the video is known, the bitrate is capped so that the output fits in the WhatsApp file size limit.
+func (mc *MessageConverter) convertVideoFile(ctx context.Context, inputPath string, probe *ffmpeg.ProbeResult) (string, error) {
+	args := []string{
+		"-map", "0:v:0", "-map", "0:a:0?",
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-filter:v", "crop='floor(in_w/2)*2:floor(in_h/2)*2'",
+		"-c:a", "aac", "-b:a", fmt.Sprint(videoAudioBitrate),
+		"-movflags", "+faststart",
+	}
+	if probe != nil && probe.Format != nil && probe.Format.Duration > 0 && mc.MaxWhatsAppFileSize > 0 {
+		// Leave some room for container overhead
+		maxBitrate := int64(float64(mc.MaxWhatsAppFileSize*8)*0.95/probe.Format.Duration) - videoAudioBitrate
+		if maxBitrate < minVideoBitrate {
+			return "", fmt.Errorf("%w: video is too long to fit in the file size limit", bridgev2.ErrMediaConvertFailed)
+		}
+		args = append(args, "-maxrate", fmt.Sprint(maxBitrate), "-bufsize", fmt.Sprint(maxBitrate*2))
+	}
+	outputPath := inputPath + ".converted.mp4"
+	err := ffmpeg.ConvertPathWithDestination(ctx, inputPath, outputPath, nil, args, false)
+	if err != nil {
+		return "", fmt.Errorf("%w (to mp4): %w", bridgev2.ErrMediaConvertFailed, err)
+	}
+	stat, err := os.Stat(outputPath)
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return "", fmt.Errorf("%w: failed to stat converted video: %w", bridgev2.ErrMediaConvertFailed, err)
+	} else if mc.MaxWhatsAppFileSize > 0 && stat.Size() > mc.MaxWhatsAppFileSize {
+		_ = os.Remove(outputPath)
+		return "", fmt.Errorf("%w: converted video is too large (%d bytes)", bridgev2.ErrMediaConvertFailed, stat.Size())
+	}
+	return outputPath, nil
+}
+
+// generateVideoThumbnail extracts a representative frame from the video and turns it into a WhatsApp JPEG thumbnail.
+func generateVideoThumbnail(ctx context.Context, path string) ([]byte, error) {
+	outputPath := path + ".thumbnail.jpg"
+	err := ffmpeg.ConvertPathWithDestination(ctx, path, outputPath, nil, []string{
+		"-vf", "thumbnail", "-frames:v", "1", "-update", "1",
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = os.Remove(outputPath)
+	}()
+	frame, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, err
+	}
+	return createThumbnail(frame, false)
+}
+
+// convertVideoForWhatsApp transcodes the video if WhatsApp clients wouldn't be able to play it,
+// and generates a thumbnail from the video if ffmpeg is available.
+func (mc *MessageConverter) convertVideoForWhatsApp(ctx context.Context, data []byte, mime string) ([]byte, string, []byte, error) {
+	if !ffmpeg.Supported() {
+		if !isWhatsAppVideoMime(mime) {
+			return nil, mime, nil, fmt.Errorf("%w %s in video message", bridgev2.ErrUnsupportedMediaType, mime)
+		} else if err := mc.checkUnconvertedVideoSize(int64(len(data))); err != nil {
+			return nil, mime, nil, err
+		}
+		return data, mime, nil, nil
+	}
+	tempDir, err := os.MkdirTemp("", "mautrix_whatsapp_video_*")
+	if err != nil {
+		return nil, mime, nil, fmt.Errorf("%w: failed to create temp dir: %w", bridgev2.ErrMediaConvertFailed, err)
+	}
+	defer func() {
+		_ = os.RemoveAll(tempDir)
+	}()
+	inputPath := filepath.Join(tempDir, "input"+exmime.ExtensionFromMimetype(mime))
+	err = os.WriteFile(inputPath, data, 0600)
+	if err != nil {
+		return nil, mime, nil, fmt.Errorf("%w: failed to write temp file: %w", bridgev2.ErrMediaConvertFailed, err)
+	}
+	outputPath := inputPath
+	if needsConversion, probe := mc.needsVideoConversion(ctx, inputPath, mime, int64(len(data))); needsConversion {
+		outputPath, err = mc.convertVideoFile(ctx, inputPath, probe)
+		if err != nil {
+			return nil, mime, nil, err
+		}
+		data, err = os.ReadFile(outputPath)
+		if err != nil {
+			return nil, mime, nil, fmt.Errorf("%w: failed to read converted video: %w", bridgev2.ErrMediaConvertFailed, err)
+		}
+		mime = "video/mp4"
+	}
+	thumbnail, err := generateVideoThumbnail(ctx, outputPath)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to generate video thumbnail")
+	}
+	return data, mime, thumbnail, nil
+}
+
+// convertVideoFileForStream transcodes a downloaded video file if necessary. If the file is already compatible,
+// nil is returned, otherwise the returned file must be closed and removed by the caller.
+func (mc *MessageConverter) convertVideoFileForStream(ctx context.Context, file *os.File, mime string) (*os.File, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to stat video: %w", bridgev2.ErrMediaConvertFailed, err)
+	}
+	needsConversion, probe := mc.needsVideoConversion(ctx, file.Name(), mime, stat.Size())
+	if !needsConversion {
+		return nil, nil
+	}
+	outputPath, err := mc.convertVideoFile(ctx, file.Name(), probe)
+	if err != nil {
+		return nil, err
+	}
+	converted, err := os.OpenFile(outputPath, os.O_RDWR, 0)
+	if err != nil {
+		_ = os.Remove(outputPath)
+		return nil, fmt.Errorf("%w: failed to open converted video: %w", bridgev2.ErrMediaConvertFailed, err)
+	}
+	return converted, nil
+}
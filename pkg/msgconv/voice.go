@@ -0,0 +1,166 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package msgconv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/iKonoTelecomunicaciones/go/event"
+	"github.com/rs/zerolog"
+	"go.mau.fi/util/ffmpeg"
+)
+
+const (
+	voiceMimeType = "audio/ogg; codecs=opus"
+	// WhatsApp voice messages always have 64 waveform samples.
+	waveformSampleCount = 64
+	// MSC1767 waveforms are in the range 0-1024, WhatsApp waveforms in the range 0-100.
+	matrixWaveformMax   = 1024
+	whatsappWaveformMax = 100
+	// Audio is decoded at a low sample rate for waveform generation, as only the amplitude matters.
+	waveformDecodeRate = 8000
+)
+
+// convertToVoiceMessage transcodes arbitrary audio into Opus in Ogg, which WhatsApp requires for voice messages.
+func convertToVoiceMessage(ctx context.Context, data []byte, mime string) ([]byte, error) {
+	return ffmpeg.ConvertBytes(ctx, data, ".opus", nil, []string{
+		"-vn", "-ac", "1", "-ar", "48000",
+		"-c:a", "libopus", "-b:a", "32k", "-application", "voip",
+	}, mime)
+}
+
+// generateWaveform decodes the audio and returns a waveform in the MSC1767 range along with the duration in milliseconds.
+func generateWaveform(ctx context.Context, data []byte, mime string) ([]int, int, error) {
+	pcm, err := ffmpeg.ConvertBytes(ctx, data, ".pcm", nil, []string{
+		"-vn", "-ac", "1", "-ar", fmt.Sprint(waveformDecodeRate), "-f", "s16le",
+	}, mime)
+	if err != nil {
+		return nil, 0, err
+	}
+	sampleCount := len(pcm) / 2
+	if sampleCount == 0 {
+		return nil, 0, fmt.Errorf("audio doesn't contain any samples")
+	}
+	durationMS := sampleCount * 1000 / waveformDecodeRate
+	rms := make([]float64, waveformSampleCount)
+	var loudest float64
+	for i := range rms {
+		start := i * sampleCount / waveformSampleCount
+		end := max((i+1)*sampleCount/waveformSampleCount, start+1)
+		var sum float64
+		for j := start; j < end && j < sampleCount; j++ {
+			sample := float64(int16(binary.LittleEndian.Uint16(pcm[j*2:]))) / math.MaxInt16
+			sum += sample * sample
+		}
+		rms[i] = math.Sqrt(sum / float64(end-start))
+		loudest = max(loudest, rms[i])
+	}
+	waveform := make([]int, waveformSampleCount)
+	if loudest > 0 {
+		for i, val := range rms {
+			waveform[i] = int(math.Round(val / loudest * matrixWaveformMax))
+		}
+	}
+	return waveform, durationMS, nil
+}
+
+// fillVoiceInfo generates the waveform and duration of a voice message if the Matrix client didn't include them.
+func fillVoiceInfo(ctx context.Context, content *event.MessageEventContent, data []byte, mime string) {
+	if content.MSC1767Audio != nil && len(content.MSC1767Audio.Waveform) > 0 && content.MSC1767Audio.Duration > 0 {
+		return
+	}
+	waveform, durationMS, err := generateWaveform(ctx, data, mime)
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to generate waveform for voice message")
+		return
+	}
+	if content.MSC1767Audio == nil {
+		content.MSC1767Audio = &event.MSC1767Audio{}
+	}
+	if len(content.MSC1767Audio.Waveform) == 0 {
+		content.MSC1767Audio.Waveform = waveform
+	}
+	if content.MSC1767Audio.Duration == 0 {
+		content.MSC1767Audio.Duration = durationMS
+	}
+	if content.Info != nil && content.Info.Duration == 0 {
+		content.Info.Duration = durationMS
+	}
+}
+
+// fillCachedVoiceInfo fills the duration and waveform of a voice message from a cached upload of the same file,
+// which is used instead of fillVoiceInfo when the file isn't downloaded.
+func fillCachedVoiceInfo(content *event.MessageEventContent, seconds uint32, waveform []byte) {
+	if content.MSC1767Audio == nil {
+		content.MSC1767Audio = &event.MSC1767Audio{}
+	}
+	durationMS := int(seconds) * 1000
+	if len(content.MSC1767Audio.Waveform) == 0 {
+		content.MSC1767Audio.Waveform = waveformToMatrix(waveform)
+	}
+	if content.MSC1767Audio.Duration == 0 {
+		content.MSC1767Audio.Duration = durationMS
+	}
+	if content.Info != nil && content.Info.Duration == 0 {
+		content.Info.Duration = durationMS
+	}
+}
+
+// waveformToMatrix converts a WhatsApp voice message waveform into the MSC1767 range.
+func waveformToMatrix(waveform []byte) []int {
+	if len(waveform) == 0 {
+		return nil
+	}
+	output := make([]int, len(waveform))
+	for i, val := range waveform {
+		output[i] = min(int(val), whatsappWaveformMax) * matrixWaveformMax / whatsappWaveformMax
+	}
+	return output
+}
+
+// waveformToWhatsApp resamples an MSC1767 waveform to the number of samples WhatsApp uses and converts it into the WhatsApp range.
+func waveformToWhatsApp(waveform []int) []byte {
+	if len(waveform) == 0 {
+		return nil
+	}
+	output := make([]byte, waveformSampleCount)
+	for i := range output {
+		var val int
+		if len(waveform) >= waveformSampleCount {
+			// Downsample by averaging all input samples that fall into this output sample
+			start := i * len(waveform) / waveformSampleCount
+			end := (i + 1) * len(waveform) / waveformSampleCount
+			var sum int
+			for _, part := range waveform[start:end] {
+				sum += part
+			}
+			val = sum / (end - start)
+		} else {
+			// Upsample by linear interpolation between the nearest input samples
+			pos := float64(i) * float64(len(waveform)-1) / float64(waveformSampleCount-1)
+			lower := int(pos)
+			upper := min(lower+1, len(waveform)-1)
+			frac := pos - float64(lower)
+			val = int(math.Round(float64(waveform[lower])*(1-frac) + float64(waveform[upper])*frac))
+		}
+		output[i] = byte(max(0, min(val, matrixWaveformMax)) * whatsappWaveformMax / matrixWaveformMax)
+	}
+	return output
+}
@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image/png"
 	"io"
 	"net/http"
 	"os"
@@ -35,13 +36,14 @@ import (
 	"github.com/iKonoTelecomunicaciones/go/event"
 	"github.com/rs/zerolog"
 	"go.mau.fi/util/exmime"
-	"go.mau.fi/util/exslices"
+	"go.mau.fi/util/ffmpeg"
 	"go.mau.fi/util/lottie"
 	"go.mau.fi/util/random"
 	"go.mau.fi/whatsmeow"
 	"go.mau.fi/whatsmeow/proto/waE2E"
 	"go.mau.fi/whatsmeow/types"
 
+	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/lottierender"
 	"github.com/iKonoTelecomunicaciones/whatsapp/pkg/waid"
 )
 
@@ -72,6 +74,7 @@ func (mc *MessageConverter) convertMediaMessage(
 	}
 	preparedMedia := prepareMediaMessage(msg)
 	preparedMedia.TypeDescription = typeName
+	preparedMedia.ViewOnce = isViewOnce
 	if preparedMedia.FileName != "" && preparedMedia.Body != preparedMedia.FileName {
 		mc.parseFormatting(preparedMedia.MessageEventContent, false, false)
 	}
@@ -129,6 +132,12 @@ func (mc *MessageConverter) convertMediaMessage(
 			status_part = mc.convertExtendedStatusMessage(ctx, messageInfo, msg.GetContextInfo().QuotedMessage)
 		}
 	}
+	if isViewOnce && part.Content.MsgType != event.MsgNotice {
+		if part.Extra == nil {
+			part.Extra = make(map[string]any)
+		}
+		part.Extra[ViewOnceField] = true
+	}
 	return
 }
 
@@ -291,6 +300,8 @@ type PreparedMedia struct {
 	FailedKeys                 *FailedMediaKeys   `json:"whatsapp_media"`          // only for failed media
 	MentionedJID               []string           `json:"mentioned_jid,omitempty"` // only for failed media
 	TypeDescription            string             `json:"type_description"`
+	DocumentThumbnail          []byte             `json:"document_thumbnail,omitempty"`
+	ViewOnce                   bool               `json:"view_once,omitempty"`
 	ContextInfo                *waE2E.ContextInfo `json:"-"`
 }
 
@@ -370,11 +381,15 @@ func prepareMediaMessage(rawMsg MediaMessage) *PreparedMedia {
 	case *waE2E.DocumentMessage:
 		data.MsgType = event.MsgFile
 		data.FileName = msg.GetFileName()
+		if msg.GetPageCount() > 0 {
+			extraInfo[PageCountField] = msg.GetPageCount()
+		}
+		data.DocumentThumbnail = msg.GetJPEGThumbnail()
 	case *waE2E.AudioMessage:
 		data.MsgType = event.MsgAudio
 		data.MSC1767Audio = &event.MSC1767Audio{
 			Duration: int(msg.GetSeconds() * 1000),
-			Waveform: exslices.CastFunc(msg.Waveform, func(from byte) int { return int(from) }),
+			Waveform: waveformToMatrix(msg.GetWaveform()),
 		}
 		data.FileName = "audio" + exmime.ExtensionFromMimetype(msg.GetMimetype())
 		if msg.GetPTT() {
@@ -425,7 +440,7 @@ func (mc *MessageConverter) MediaRetryToMatrix(
 	intent bridgev2.MatrixAPI,
 	portal *bridgev2.Portal,
 	existingPart *database.Message,
-) *bridgev2.ConvertedEdit {
+) (*bridgev2.ConvertedEdit, error) {
 	ctx = context.WithValue(ctx, contextKeyClient, client)
 	ctx = context.WithValue(ctx, contextKeyIntent, intent)
 	ctx = context.WithValue(ctx, contextKeyPortal, portal)
@@ -446,7 +461,7 @@ func (mc *MessageConverter) MediaRetryToMatrix(
 	}
 	return &bridgev2.ConvertedEdit{
 		ModifiedParts: []*bridgev2.ConvertedEditPart{updatedPart.ToEditPart(existingPart)},
-	}
+	}, err
 }
 
 func (mc *MessageConverter) reuploadWhatsAppAttachment(
@@ -459,11 +474,23 @@ func (mc *MessageConverter) reuploadWhatsAppAttachment(
 	portal := getPortal(ctx)
 	var thumbnailData []byte
 	var thumbnailInfo *event.FileInfo
+	if part.DocumentThumbnail != nil {
+		thumbnailData = part.DocumentThumbnail
+		thumbnailInfo = getThumbnailInfo(thumbnailData)
+	}
+	isAnimatedSticker := part.Type == event.EventSticker && part.Info.MimeType == "application/was"
+	// View-once media is deleted from Matrix after it's viewed, so it must never be shared with other messages
+	cacheable := !isAnimatedSticker && !part.ViewOnce
+	if cacheable && mc.getCachedMatrixUpload(ctx, message, part) {
+		mc.uploadThumbnail(ctx, part, thumbnailData, thumbnailInfo)
+		return nil
+	}
 	if part.Info.Size > uploadFileThreshold {
 		var err error
 		part.URL, part.File, err = intent.UploadMediaStream(ctx, portal.MXID, -1, true, func(file io.Writer) (*bridgev2.FileStreamResult, error) {
 			err := client.DownloadToFile(ctx, message, file.(*os.File))
 			if errors.Is(err, whatsmeow.ErrFileLengthMismatch) || errors.Is(err, whatsmeow.ErrInvalidMediaSHA256) {
+				cacheable = false
 				zerolog.Ctx(ctx).Warn().Err(err).Msg("Mismatching media checksums in message. Ignoring because WhatsApp seems to ignore them too")
 			} else if err != nil {
 				return nil, fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
@@ -485,6 +512,8 @@ func (mc *MessageConverter) reuploadWhatsAppAttachment(
 	} else {
 		data, err := client.Download(ctx, message)
 		if errors.Is(err, whatsmeow.ErrFileLengthMismatch) || errors.Is(err, whatsmeow.ErrInvalidMediaSHA256) {
+			// The cache is keyed by the hash in the message, so files that don't match it can't be cached
+			cacheable = false
 			zerolog.Ctx(ctx).Warn().Err(err).Msg("Mismatching media checksums in message. Ignoring because WhatsApp seems to ignore them too")
 		} else if err != nil {
 			return fmt.Errorf("%w: %w", bridgev2.ErrMediaDownloadFailed, err)
@@ -504,24 +533,32 @@ func (mc *MessageConverter) reuploadWhatsAppAttachment(
 			return fmt.Errorf("%w: %w", bridgev2.ErrMediaReuploadFailed, err)
 		}
 	}
-	if thumbnailData != nil && thumbnailInfo != nil {
-		var err error
-		part.Info.ThumbnailURL, part.Info.ThumbnailFile, err = intent.UploadMedia(
-			ctx,
-			portal.MXID,
-			thumbnailData,
-			"thumbnail"+exmime.ExtensionFromMimetype(thumbnailInfo.MimeType),
-			thumbnailInfo.MimeType,
-		)
-		if err != nil {
-			zerolog.Ctx(ctx).Err(err).Msg("Failed to reupload thumbnail")
-		} else {
-			part.Info.ThumbnailInfo = thumbnailInfo
-		}
+	if cacheable {
+		mc.cacheMatrixUpload(ctx, message, part)
 	}
+	mc.uploadThumbnail(ctx, part, thumbnailData, thumbnailInfo)
 	return nil
 }
 
+func (mc *MessageConverter) uploadThumbnail(ctx context.Context, part *PreparedMedia, thumbnailData []byte, thumbnailInfo *event.FileInfo) {
+	if thumbnailData == nil || thumbnailInfo == nil {
+		return
+	}
+	var err error
+	part.Info.ThumbnailURL, part.Info.ThumbnailFile, err = getIntent(ctx).UploadMedia(
+		ctx,
+		getPortal(ctx).MXID,
+		thumbnailData,
+		"thumbnail"+exmime.ExtensionFromMimetype(thumbnailInfo.MimeType),
+		thumbnailInfo.MimeType,
+	)
+	if err != nil {
+		zerolog.Ctx(ctx).Err(err).Msg("Failed to reupload thumbnail")
+	} else {
+		part.Info.ThumbnailInfo = thumbnailInfo
+	}
+}
+
 func (mc *MessageConverter) extractAnimatedSticker(fileInfo *PreparedMedia, data []byte) ([]byte, error) {
 	data, err := ExtractAnimatedSticker(data)
 	if err != nil {
@@ -540,9 +577,20 @@ func (mc *MessageConverter) convertAnimatedSticker(ctx context.Context, fileInfo
 	c := mc.AnimatedStickerConfig
 	if c.Target == "disable" {
 		return data, nil, nil, nil
-	} else if !lottie.Supported() {
-		zerolog.Ctx(ctx).Warn().Msg("Animated sticker conversion is enabled, but lottieconverter is not installed")
-		return data, nil, nil, nil
+	}
+	externalSupported := lottie.Supported() && (c.Target == "png" || c.Target == "gif" || ffmpeg.Supported())
+	switch c.Renderer {
+	case "external":
+		if !externalSupported {
+			zerolog.Ctx(ctx).Warn().Msg("Animated sticker conversion is enabled, but lottieconverter or ffmpeg is not installed")
+			return data, nil, nil, nil
+		}
+	case "builtin":
+		return mc.renderAnimatedSticker(ctx, fileInfo, data)
+	default:
+		if !externalSupported {
+			return mc.renderAnimatedSticker(ctx, fileInfo, data)
+		}
 	}
 	input := bytes.NewReader(data)
 	fileInfo.Info.MimeType = "image/" + c.Target
@@ -584,6 +632,61 @@ func (mc *MessageConverter) convertAnimatedSticker(ctx context.Context, fileInfo
 	}
 }
 
+// renderAnimatedSticker converts a lottie sticker using the built-in renderer instead of lottieconverter and ffmpeg.
+func (mc *MessageConverter) renderAnimatedSticker(
+	ctx context.Context, fileInfo *PreparedMedia, data []byte,
+) (converted, thumbnail []byte, thumbnailInfo *event.FileInfo, err error) {
+	defer func() {
+		// The renderer is only meant for well-formed stickers, so don't let a malicious file crash the bridge
+		if panicErr := recover(); panicErr != nil {
+			converted, thumbnail, thumbnailInfo = nil, nil, nil
+			err = fmt.Errorf("panic while rendering animated sticker: %v", panicErr)
+		}
+	}()
+	c := mc.AnimatedStickerConfig
+	anim, err := lottierender.Parse(data)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	target := c.Target
+	if target == "webm" {
+		// The built-in renderer can't encode videos, so use animated webp instead
+		zerolog.Ctx(ctx).Debug().Msg("Converting animated sticker to webp instead of webm with built-in renderer")
+		target = "webp"
+	}
+	fileInfo.Info.MimeType = "image/" + target
+	fileInfo.FileName = "sticker." + target
+	var output bytes.Buffer
+	switch target {
+	case "png":
+		err = png.Encode(&output, anim.RenderFrame(anim.InPoint, c.Args.Width, c.Args.Height))
+		return output.Bytes(), nil, nil, err
+	case "gif":
+		frames, delay := anim.Render(c.Args.Width, c.Args.Height, float64(c.Args.FPS), 0)
+		data, err = lottierender.EncodeGIF(frames, delay)
+		return data, nil, nil, err
+	case "webp":
+		frames, delay := anim.Render(c.Args.Width, c.Args.Height, float64(c.Args.FPS), 0)
+		data, err = lottierender.EncodeWebP(frames, delay, 80)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		err = png.Encode(&output, frames[0])
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+		}
+		thumbnailInfo = &event.FileInfo{
+			MimeType: "image/png",
+			Width:    c.Args.Width,
+			Height:   c.Args.Height,
+			Size:     output.Len(),
+		}
+		return data, output.Bytes(), thumbnailInfo, nil
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported target format %s", c.Target)
+	}
+}
+
 func (mc *MessageConverter) makeMediaFailure(ctx context.Context, mediaInfo *PreparedMedia, keys *FailedMediaKeys, err error) *bridgev2.ConvertedMessagePart {
 	logLevel := zerolog.ErrorLevel
 	var extra map[string]any
@@ -0,0 +1,422 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// Package pdfpreview contains a minimal PDF reader for counting pages and rendering low-resolution previews.
+//
+// The reader doesn't use the cross-reference table, it scans the file for objects instead, which makes it tolerant
+// of broken files. Previews include vector graphics and images, but text is drawn as bars instead of glyphs, which
+// looks close enough at thumbnail sizes.
+package pdfpreview
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/ascii85"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+)
+
+var (
+	ErrNotPDF    = errors.New("file is not a pdf")
+	ErrNoPages   = errors.New("pdf doesn't contain any pages")
+	ErrEncrypted = errors.New("pdf is encrypted")
+)
+
+var errDecodeLimit = errors.New("decoded data limit exceeded")
+
+const (
+	// maxDecodedStreamSize limits how much data a single stream may decompress into.
+	maxDecodedStreamSize = 64 * 1024 * 1024
+	// maxTotalDecodedSize limits how much data all streams in a document may decompress into combined. Streams are
+	// decoded again every time they're used, so this also limits forms and images that are drawn many times.
+	maxTotalDecodedSize = 256 * 1024 * 1024
+	// maxObjects limits the number of objects that are loaded from a document, including ones in object streams.
+	maxObjects = 100_000
+	// maxPredictorColors limits the number of color components in predictor parameters.
+	maxPredictorColors = 32
+)
+
+const maxResolveDepth = 32
+
+var objectHeaderRegex = regexp.MustCompile(`(\d+)\s+(\d+)\s+obj\b`)
+var trailerRegex = regexp.MustCompile(`trailer\s*<<`)
+
+type Document struct {
+	objects   map[int]any
+	trailer   dict
+	catalog   dict
+	encrypted bool
+	decoded   int
+}
+
+// Open parses a PDF file.
+func Open(data []byte) (*Document, error) {
+	if !bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return nil, ErrNotPDF
+	}
+	d := &Document{
+		objects: make(map[int]any),
+		trailer: make(dict),
+	}
+	skipUntil := 0
+	var objectStreams []*stream
+	for _, match := range objectHeaderRegex.FindAllSubmatchIndex(data, maxObjects) {
+		if match[0] < skipUntil || (match[0] > 0 && !isWhitespace(data[match[0]-1]) && !isDelimiter(data[match[0]-1])) {
+			continue
+		}
+		num, _ := strconv.Atoi(string(data[match[2]:match[3]]))
+		l := &lexer{data: data, pos: match[1]}
+		value, err := l.readObject()
+		if err != nil {
+			continue
+		}
+		if objDict, ok := value.(dict); ok {
+			if raw, isStream := l.readStreamData(objDict); isStream {
+				s := &stream{dict: objDict, raw: raw}
+				value = s
+				switch objDict["Type"] {
+				case name("ObjStm"):
+					objectStreams = append(objectStreams, s)
+				case name("XRef"):
+					d.mergeTrailer(objDict)
+				}
+			}
+		}
+		d.objects[num] = value
+		skipUntil = l.pos
+	}
+	for _, match := range trailerRegex.FindAllIndex(data, -1) {
+		l := &lexer{data: data, pos: match[1] - 2}
+		if trailer, err := l.readObject(); err == nil {
+			if trailerDict, ok := trailer.(dict); ok {
+				d.mergeTrailer(trailerDict)
+			}
+		}
+	}
+	for _, s := range objectStreams {
+		d.loadObjectStream(s)
+	}
+	d.encrypted = d.trailer["Encrypt"] != nil
+	d.catalog, _ = d.resolve(d.trailer["Root"]).(dict)
+	if d.catalog == nil {
+		for _, obj := range d.objects {
+			if objDict, ok := obj.(dict); ok && objDict["Type"] == name("Catalog") {
+				d.catalog = objDict
+				break
+			}
+		}
+	}
+	return d, nil
+}
+
+// mergeTrailer merges a trailer dictionary into the document trailer. Trailers later in the file are newer, so they
+// override previous values.
+func (d *Document) mergeTrailer(trailer dict) {
+	for key, value := range trailer {
+		d.trailer[key] = value
+	}
+}
+
+func (d *Document) loadObjectStream(s *stream) {
+	data, _, err := d.decodeStream(s)
+	if err != nil {
+		return
+	}
+	count, _ := d.resolve(s.dict["N"]).(float64)
+	first, _ := d.resolve(s.dict["First"]).(float64)
+	header := &lexer{data: data}
+	for range min(int(count), maxObjects-len(d.objects)) {
+		num, err1 := header.next()
+		offset, err2 := header.next()
+		objNum, ok1 := num.(float64)
+		objOffset, ok2 := offset.(float64)
+		if err1 != nil || err2 != nil || !ok1 || !ok2 {
+			return
+		}
+		// Objects outside of object streams take precedence, as they're more likely to be from incremental updates
+		if _, exists := d.objects[int(objNum)]; exists {
+			continue
+		}
+		position := int(first) + int(objOffset)
+		if position < 0 || position >= len(data) {
+			continue
+		}
+		l := &lexer{data: data, pos: position}
+		if value, err := l.readObject(); err == nil {
+			d.objects[int(objNum)] = value
+		}
+	}
+}
+
+// resolve follows indirect references.
+func (d *Document) resolve(value any) any {
+	for range maxResolveDepth {
+		r, ok := value.(ref)
+		if !ok {
+			return value
+		}
+		value = d.objects[r.num]
+	}
+	return nil
+}
+
+func (d *Document) resolveDict(value any) dict {
+	switch typed := d.resolve(value).(type) {
+	case dict:
+		return typed
+	case *stream:
+		return typed.dict
+	default:
+		return nil
+	}
+}
+
+func (d *Document) resolveNumber(value any, def float64) float64 {
+	if number, ok := d.resolve(value).(float64); ok {
+		return number
+	}
+	return def
+}
+
+func (d *Document) resolveArray(value any) []any {
+	array, _ := d.resolve(value).([]any)
+	return array
+}
+
+func (d *Document) pagesRoot() dict {
+	if d.catalog == nil {
+		return nil
+	}
+	return d.resolveDict(d.catalog["Pages"])
+}
+
+// PageCount returns the number of pages in the document.
+func (d *Document) PageCount() int {
+	if pages := d.pagesRoot(); pages != nil {
+		if count := d.resolveNumber(pages["Count"], 0); count > 0 {
+			return int(count)
+		}
+	}
+	// Fall back to counting page objects if the page tree is broken
+	var count int
+	for _, obj := range d.objects {
+		if objDict, ok := obj.(dict); ok && objDict["Type"] == name("Page") {
+			count++
+		}
+	}
+	return count
+}
+
+// inheritablePageKeys are the page attributes that can be defined in parent nodes of the page tree.
+var inheritablePageKeys = []name{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+// firstPage finds the first page in the page tree and returns it with inherited attributes filled in.
+func (d *Document) firstPage() dict {
+	node := d.pagesRoot()
+	inherited := make(dict)
+	for range maxResolveDepth {
+		if node == nil {
+			break
+		}
+		for _, key := range inheritablePageKeys {
+			if value, ok := node[key]; ok {
+				inherited[key] = value
+			}
+		}
+		kids := d.resolveArray(node["Kids"])
+		if node["Type"] == name("Page") || kids == nil {
+			page := make(dict, len(node)+len(inherited))
+			for key, value := range inherited {
+				page[key] = value
+			}
+			for key, value := range node {
+				page[key] = value
+			}
+			return page
+		} else if len(kids) == 0 {
+			break
+		}
+		node = d.resolveDict(kids[0])
+	}
+	return nil
+}
+
+// decodeStream decodes the data of a stream. If the stream uses an image filter, the data is returned as soon as
+// that filter is reached and the name of the filter is returned too.
+func (d *Document) decodeStream(s *stream) ([]byte, name, error) {
+	var filters, params []any
+	switch filter := d.resolve(s.dict["Filter"]).(type) {
+	case name:
+		filters = []any{filter}
+		params = []any{s.dict["DecodeParms"]}
+	case []any:
+		filters = filter
+		params = d.resolveArray(s.dict["DecodeParms"])
+	}
+	data := s.raw
+	for i, rawFilter := range filters {
+		filter, _ := d.resolve(rawFilter).(name)
+		var filterParams dict
+		if i < len(params) {
+			filterParams = d.resolveDict(params[i])
+		}
+		var err error
+		switch filter {
+		case "FlateDecode", "Fl":
+			data, err = inflate(data)
+			if err == nil {
+				data, err = d.applyPredictor(data, filterParams)
+			}
+		case "ASCIIHexDecode", "AHx":
+			data, err = decodeASCIIHex(data)
+		case "ASCII85Decode", "A85":
+			data, err = decodeASCII85(data)
+		case "DCTDecode", "DCT", "JPXDecode", "JBIG2Decode", "CCITTFaxDecode", "CCF":
+			return data, filter, nil
+		default:
+			err = fmt.Errorf("unsupported filter %s", filter)
+		}
+		if err != nil {
+			return nil, "", err
+		} else if err = d.useDecodeBudget(len(data)); err != nil {
+			return nil, "", err
+		}
+	}
+	return data, "", nil
+}
+
+// useDecodeBudget counts decoded bytes towards maxTotalDecodedSize and returns an error if the limit is exceeded.
+func (d *Document) useDecodeBudget(size int) error {
+	d.decoded += size
+	if d.decoded > maxTotalDecodedSize {
+		return errDecodeLimit
+	}
+	return nil
+}
+
+func inflate(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		// Some files contain raw deflate data without the zlib header
+		reader = flate.NewReader(bytes.NewReader(data))
+	}
+	defer reader.Close()
+	output, err := io.ReadAll(io.LimitReader(reader, maxDecodedStreamSize))
+	// Truncated streams are common, so return whatever was decoded successfully
+	if len(output) > 0 && (errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, zlib.ErrChecksum)) {
+		err = nil
+	}
+	return output, err
+}
+
+func decodeASCIIHex(data []byte) ([]byte, error) {
+	if end := bytes.IndexByte(data, '>'); end >= 0 {
+		data = data[:end]
+	}
+	digits := make([]byte, 0, len(data))
+	for _, c := range data {
+		if !isWhitespace(c) {
+			digits = append(digits, c)
+		}
+	}
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	output := make([]byte, len(digits)/2)
+	_, err := hex.Decode(output, digits)
+	return output, err
+}
+
+func decodeASCII85(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(bytes.TrimSpace(data), []byte("<~"))
+	if end := bytes.Index(data, []byte("~>")); end >= 0 {
+		data = data[:end]
+	}
+	output := make([]byte, len(data))
+	n, _, err := ascii85.Decode(output, data, true)
+	return output[:n], err
+}
+
+// applyPredictor reverses PNG predictors that are used with flate compression.
+func (d *Document) applyPredictor(data []byte, params dict) ([]byte, error) {
+	predictor := int(d.resolveNumber(params["Predictor"], 1))
+	if predictor < 10 {
+		// TIFF predictors are very rare, so they're not supported
+		return data, nil
+	}
+	colors := d.resolveNumber(params["Colors"], 1)
+	bpc := d.resolveNumber(params["BitsPerComponent"], 8)
+	columns := d.resolveNumber(params["Columns"], 1)
+	// Check the parameters as floats so that huge values can't overflow the row length calculation. Rows longer than
+	// the data can't be decoded anyway, so they're rejected before allocating anything.
+	if colors < 1 || colors > maxPredictorColors || (bpc != 1 && bpc != 2 && bpc != 4 && bpc != 8 && bpc != 16) ||
+		columns < 1 || colors*bpc*columns/8 > float64(len(data)) {
+		return nil, fmt.Errorf("invalid predictor parameters")
+	}
+	bytesPerPixel := max(int(colors)*int(bpc)/8, 1)
+	rowLength := (int(colors)*int(bpc)*int(columns) + 7) / 8
+	output := make([]byte, 0, len(data)/(rowLength+1)*rowLength)
+	previous := make([]byte, rowLength)
+	for offset := 0; offset+rowLength+1 <= len(data); offset += rowLength + 1 {
+		filterType := data[offset]
+		row := make([]byte, rowLength)
+		copy(row, data[offset+1:offset+1+rowLength])
+		for i := range row {
+			var left, upLeft byte
+			if i >= bytesPerPixel {
+				left = row[i-bytesPerPixel]
+				upLeft = previous[i-bytesPerPixel]
+			}
+			up := previous[i]
+			switch filterType {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += up
+			case 3:
+				row[i] += byte((int(left) + int(up)) / 2)
+			case 4:
+				row[i] += paeth(left, up, upLeft)
+			}
+		}
+		output = append(output, row...)
+		previous = row
+	}
+	return output, nil
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	} else if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,239 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package pdfpreview
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"os"
+	"strings"
+	"testing"
+)
+
+// buildPDF creates a PDF file from the given objects, which are numbered starting from 1.
+func buildPDF(objects ...string) []byte {
+	var builder strings.Builder
+	builder.WriteString("%PDF-1.7\n")
+	for i, obj := range objects {
+		_, _ = fmt.Fprintf(&builder, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	builder.WriteString("trailer\n<< /Root 1 0 R >>\n%%EOF\n")
+	return []byte(builder.String())
+}
+
+func readTestPDF(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatalf("failed to read test pdf: %v", err)
+	}
+	return data
+}
+
+func openTestPDF(t *testing.T, data []byte) *Document {
+	t.Helper()
+	doc, err := Open(data)
+	if err != nil {
+		t.Fatalf("failed to open pdf: %v", err)
+	}
+	return doc
+}
+
+func TestOpenNotPDF(t *testing.T) {
+	for _, data := range []string{"", "hello world", "<html></html>", strings.Repeat(" ", 2000) + "%PDF-1.7"} {
+		if _, err := Open([]byte(data)); !errors.Is(err, ErrNotPDF) {
+			t.Errorf("expected ErrNotPDF for %q, got %v", data, err)
+		}
+	}
+}
+
+func TestRenderFirstPage(t *testing.T) {
+	doc := openTestPDF(t, readTestPDF(t, "simple.pdf"))
+	if count := doc.PageCount(); count != 1 {
+		t.Errorf("expected 1 page, got %d", count)
+	}
+	img, err := doc.RenderFirstPage(100)
+	if err != nil {
+		t.Fatalf("failed to render page: %v", err)
+	}
+	if img.Bounds().Dx() != 100 || img.Bounds().Dy() != 50 {
+		t.Fatalf("unexpected image size %s", img.Bounds())
+	}
+	// The red rectangle covers (50, 25) to (150, 75) in page coordinates
+	if c := img.RGBAAt(70, 35); c.R < 200 || c.G > 50 || c.B > 50 {
+		t.Errorf("unexpected color %v inside the rectangle", c)
+	}
+	if c := img.RGBAAt(2, 2); c != (color.RGBA{R: 255, G: 255, B: 255, A: 255}) {
+		t.Errorf("unexpected color %v in the corner", c)
+	}
+}
+
+func TestRenderCompressed(t *testing.T) {
+	// The page tree is in a compressed object stream and the root is only referenced from the xref stream
+	doc := openTestPDF(t, readTestPDF(t, "compressed.pdf"))
+	if count := doc.PageCount(); count != 1 {
+		t.Errorf("expected 1 page, got %d", count)
+	}
+	img, err := doc.RenderFirstPage(60)
+	if err != nil {
+		t.Fatalf("failed to render page: %v", err)
+	}
+	if c := img.RGBAAt(5, 5); c.G < 200 || c.R > 50 || c.B > 50 {
+		t.Errorf("unexpected background color %v", c)
+	}
+	// The image is drawn from (100, 100) to (200, 200) through a form, and its top left pixel is red
+	if c := img.RGBAAt(24, 24); c.R < 200 || c.G > 80 || c.B > 80 {
+		t.Errorf("unexpected image color %v", c)
+	}
+}
+
+func TestOpenTruncated(t *testing.T) {
+	for _, name := range []string{"simple.pdf", "compressed.pdf"} {
+		data := readTestPDF(t, name)
+		for length := range len(data) {
+			doc, err := Open(data[:length])
+			if err != nil {
+				continue
+			}
+			doc.PageCount()
+			_, _ = doc.RenderFirstPage(32)
+		}
+	}
+}
+
+func TestPageCountFallback(t *testing.T) {
+	doc := openTestPDF(t, buildPDF(
+		"<< /Type /Catalog /Pages 5 0 R >>",
+		"<< /Type /Page /MediaBox [0 0 100 100] >>",
+		"<< /Type /Page /MediaBox [0 0 100 100] >>",
+	))
+	if count := doc.PageCount(); count != 2 {
+		t.Errorf("expected 2 pages, got %d", count)
+	}
+}
+
+func TestRecursiveReferences(t *testing.T) {
+	doc := openTestPDF(t, buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [2 0 R] /MediaBox 3 0 R >>",
+		"4 0 R",
+		"3 0 R",
+	))
+	if count := doc.PageCount(); count != 0 {
+		t.Errorf("expected 0 pages, got %d", count)
+	}
+	if _, err := doc.RenderFirstPage(32); !errors.Is(err, ErrNoPages) {
+		t.Errorf("expected ErrNoPages, got %v", err)
+	}
+}
+
+func TestRenderRecursiveForm(t *testing.T) {
+	doc := openTestPDF(t, buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /MediaBox [0 0 100 100] /Resources << /XObject << /Fm 5 0 R >> >> /Contents 4 0 R >>",
+		"<< /Length 21 >>\nstream\n/Fm Do /Fm Do /Fm Do\nendstream",
+		"<< /Type /XObject /Subtype /Form /Resources << /XObject << /Fm 5 0 R >> >> /Length 21 >>\nstream\n/Fm Do /Fm Do /Fm Do\nendstream",
+	))
+	if _, err := doc.RenderFirstPage(32); err != nil {
+		t.Errorf("failed to render page: %v", err)
+	}
+}
+
+func TestObjectStreamCountLimit(t *testing.T) {
+	doc := openTestPDF(t, buildPDF(
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /ObjStm /N 1000000000000 /First 0 /Length 8 >>\nstream\n3 0 4 0 \nendstream",
+	))
+	if len(doc.objects) > maxObjects {
+		t.Errorf("loaded %d objects", len(doc.objects))
+	}
+}
+
+func TestApplyPredictor(t *testing.T) {
+	d := &Document{}
+	// Two rows with two RGB pixels each, the second row using the up filter
+	data := []byte{0, 1, 2, 3, 4, 5, 6, 2, 1, 1, 1, 1, 1, 1}
+	output, err := d.applyPredictor(data, dict{"Predictor": float64(12), "Colors": float64(3), "Columns": float64(2)})
+	if err != nil {
+		t.Fatalf("failed to apply predictor: %v", err)
+	}
+	if expected := []byte{1, 2, 3, 4, 5, 6, 2, 3, 4, 5, 6, 7}; string(output) != string(expected) {
+		t.Errorf("expected %v, got %v", expected, output)
+	}
+	for name, params := range map[string]dict{
+		"huge columns":         {"Predictor": float64(12), "Columns": float64(1e18)},
+		"overflowing row":      {"Predictor": float64(12), "Colors": float64(32), "BitsPerComponent": float64(16), "Columns": float64(1 << 58)},
+		"too many colors":      {"Predictor": float64(12), "Colors": float64(1e9)},
+		"invalid bit depth":    {"Predictor": float64(12), "BitsPerComponent": float64(3)},
+		"no columns":           {"Predictor": float64(12), "Columns": float64(0)},
+		"row longer than data": {"Predictor": float64(12), "Columns": float64(100)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := d.applyPredictor(data, params); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestDecodeBudget(t *testing.T) {
+	d := &Document{}
+	if err := d.useDecodeBudget(maxTotalDecodedSize); err != nil {
+		t.Errorf("unexpected error at the limit: %v", err)
+	}
+	if err := d.useDecodeBudget(1); !errors.Is(err, errDecodeLimit) {
+		t.Errorf("expected errDecodeLimit, got %v", err)
+	}
+	if _, _, err := d.decodeStream(&stream{dict: dict{"Filter": name("AHx")}, raw: []byte("4142>")}); !errors.Is(err, errDecodeLimit) {
+		t.Errorf("expected errDecodeLimit from decodeStream, got %v", err)
+	}
+}
+
+func TestDecodeImageSizeLimit(t *testing.T) {
+	d := &Document{}
+	for _, size := range [][2]float64{{1e10, 1e10}, {1 << 32, 1 << 32}, {8193, 8193}, {-1, 10}, {0, 0}} {
+		s := &stream{dict: dict{
+			"Subtype":          name("Image"),
+			"Width":            size[0],
+			"Height":           size[1],
+			"ColorSpace":       name("DeviceGray"),
+			"BitsPerComponent": float64(8),
+		}}
+		if img := d.decodeImage(s, color.NRGBA{}); img != nil {
+			t.Errorf("expected %vx%v image to be rejected", size[0], size[1])
+		}
+	}
+}
+
+func TestRecursiveColorSpace(t *testing.T) {
+	d := &Document{objects: map[int]any{
+		1: []any{name("ICCBased"), ref{num: 2}},
+		2: dict{"Alternate": ref{num: 1}},
+		3: []any{name("Indexed"), ref{num: 3}, float64(1), []byte{0, 0}},
+		4: []any{name("Indexed"), name("DeviceGray"), float64(-100), []byte{0, 0}},
+	}}
+	for num := range 3 {
+		if cs := d.parseColorSpace(ref{num: num + 1}, 0); cs != nil {
+			t.Errorf("expected color space %d to be rejected", num+1)
+		}
+	}
+	if cs := d.parseColorSpace(ref{num: 4}, 0); cs == nil || len(cs.palette) != 0 {
+		t.Errorf("expected empty palette for negative max index, got %v", cs)
+	}
+}
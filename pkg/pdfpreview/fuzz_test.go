@@ -0,0 +1,48 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package pdfpreview
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func FuzzOpenAndRender(f *testing.F) {
+	seeds, err := filepath.Glob("testdata/*.pdf")
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range seeds {
+		data, err := os.ReadFile(seed)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := Open(data)
+		if err != nil {
+			return
+		}
+		doc.PageCount()
+		img, err := doc.RenderFirstPage(32)
+		if err == nil && (img.Bounds().Dx() > 32 || img.Bounds().Dy() > 32) {
+			t.Errorf("unexpected image size %s", img.Bounds())
+		}
+	})
+}
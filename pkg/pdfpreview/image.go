@@ -0,0 +1,226 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package pdfpreview
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+)
+
+const (
+	// maxImagePixels limits the size of raw images that will be decoded.
+	maxImagePixels = 8192 * 8192
+	// maxColorSpaceDepth limits how deeply color spaces can refer to other color spaces.
+	maxColorSpaceDepth = 4
+)
+
+// colorSpace describes how to convert image samples into colors.
+type colorSpace struct {
+	components int
+	// palette is set for indexed color spaces.
+	palette []color.NRGBA
+}
+
+func (d *Document) parseColorSpace(value any, depth int) *colorSpace {
+	if depth > maxColorSpaceDepth {
+		return nil
+	}
+	switch typed := d.resolve(value).(type) {
+	case name:
+		switch typed {
+		case "DeviceGray", "G", "CalGray":
+			return &colorSpace{components: 1}
+		case "DeviceRGB", "RGB", "CalRGB":
+			return &colorSpace{components: 3}
+		case "DeviceCMYK", "CMYK":
+			return &colorSpace{components: 4}
+		}
+	case []any:
+		if len(typed) == 0 {
+			return nil
+		}
+		family, _ := d.resolve(typed[0]).(name)
+		switch family {
+		case "ICCBased":
+			if len(typed) < 2 {
+				return nil
+			}
+			profile := d.resolveDict(typed[1])
+			if n := int(d.resolveNumber(profile["N"], 0)); n == 1 || n == 3 || n == 4 {
+				return &colorSpace{components: n}
+			}
+			return d.parseColorSpace(profile["Alternate"], depth+1)
+		case "CalGray", "CalRGB":
+			return d.parseColorSpace(family, depth+1)
+		case "Indexed", "I":
+			if len(typed) < 4 {
+				return nil
+			}
+			return d.parseIndexedColorSpace(typed[1], typed[2], typed[3], depth)
+		}
+	}
+	return nil
+}
+
+func (d *Document) parseIndexedColorSpace(rawBase, rawMax, rawLookup any, depth int) *colorSpace {
+	base := d.parseColorSpace(rawBase, depth+1)
+	if base == nil || base.palette != nil {
+		return nil
+	}
+	var lookup []byte
+	switch typed := d.resolve(rawLookup).(type) {
+	case []byte:
+		lookup = typed
+	case *stream:
+		lookup, _, _ = d.decodeStream(typed)
+	}
+	count := max(min(int(d.resolveNumber(rawMax, 0))+1, 256, len(lookup)/base.components), 0)
+	palette := make([]color.NRGBA, count)
+	for i := range palette {
+		palette[i] = base.color(lookup[i*base.components:])
+	}
+	return &colorSpace{components: 1, palette: palette}
+}
+
+// color converts 8-bit samples into a color.
+func (cs *colorSpace) color(samples []byte) color.NRGBA {
+	if cs.palette != nil {
+		if int(samples[0]) < len(cs.palette) {
+			return cs.palette[samples[0]]
+		}
+		return color.NRGBA{A: 255}
+	}
+	switch cs.components {
+	case 1:
+		return color.NRGBA{R: samples[0], G: samples[0], B: samples[0], A: 255}
+	case 3:
+		return color.NRGBA{R: samples[0], G: samples[1], B: samples[2], A: 255}
+	default:
+		k := 255 - int(samples[3])
+		return color.NRGBA{
+			R: uint8((255 - int(samples[0])) * k / 255),
+			G: uint8((255 - int(samples[1])) * k / 255),
+			B: uint8((255 - int(samples[2])) * k / 255),
+			A: 255,
+		}
+	}
+}
+
+// sampleReader reads samples of 1, 2, 4 or 8 bits from image rows.
+type sampleReader struct {
+	data []byte
+	bpc  int
+}
+
+func (sr *sampleReader) sample(rowStart, index int) byte {
+	bitOffset := index * sr.bpc
+	pos := rowStart + bitOffset/8
+	if pos >= len(sr.data) {
+		return 0
+	}
+	if sr.bpc == 8 {
+		return sr.data[pos]
+	}
+	shift := 8 - sr.bpc - bitOffset%8
+	value := (sr.data[pos] >> shift) & (1<<sr.bpc - 1)
+	return value
+}
+
+// decodeImage decodes an image XObject. Image masks are painted with the given fill color. Unsupported images
+// return nil.
+func (d *Document) decodeImage(s *stream, fill color.NRGBA) image.Image {
+	data, filter, err := d.decodeStream(s)
+	if err != nil {
+		return nil
+	}
+	switch filter {
+	case "DCTDecode", "DCT":
+		// Check the size before decoding, as the header can claim dimensions that would need gigabytes of memory
+		config, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil || config.Width <= 0 || config.Height <= 0 || config.Width > maxImagePixels/config.Height ||
+			d.useDecodeBudget(config.Width*config.Height*4) != nil {
+			return nil
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil
+		}
+		return img
+	case "":
+	default:
+		return nil
+	}
+	// Check the size as floats first so that huge values can't overflow
+	rawWidth, rawHeight := d.resolveNumber(s.dict["Width"], 0), d.resolveNumber(s.dict["Height"], 0)
+	if rawWidth < 1 || rawHeight < 1 || rawWidth*rawHeight > maxImagePixels {
+		return nil
+	}
+	width, height := int(rawWidth), int(rawHeight)
+	if d.useDecodeBudget(width*height*4) != nil {
+		return nil
+	}
+	isMask, _ := d.resolve(s.dict["ImageMask"]).(bool)
+	var cs *colorSpace
+	bpc := int(d.resolveNumber(s.dict["BitsPerComponent"], 8))
+	if isMask {
+		cs = &colorSpace{components: 1}
+		bpc = 1
+	} else if cs = d.parseColorSpace(s.dict["ColorSpace"], 0); cs == nil {
+		return nil
+	}
+	if bpc != 1 && bpc != 2 && bpc != 4 && bpc != 8 {
+		return nil
+	}
+	rowLength := (width*cs.components*bpc + 7) / 8
+	if len(data) < rowLength*height {
+		// Pad truncated images instead of failing
+		data = append(data, make([]byte, rowLength*height-len(data))...)
+	}
+	// Masks paint where samples are 0, unless the decode array is inverted
+	maskPaintValue := byte(0)
+	if decode := d.resolveArray(s.dict["Decode"]); isMask && len(decode) > 0 && d.resolveNumber(decode[0], 0) == 1 {
+		maskPaintValue = 1
+	}
+	reader := &sampleReader{data: data, bpc: bpc}
+	maxValue := 1<<bpc - 1
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	samples := make([]byte, cs.components)
+	for y := 0; y < height; y++ {
+		rowStart := y * rowLength
+		for x := 0; x < width; x++ {
+			var c color.NRGBA
+			if isMask {
+				if reader.sample(rowStart, x) == maskPaintValue {
+					c = fill
+				}
+			} else {
+				for i := range samples {
+					sample := reader.sample(rowStart, x*cs.components+i)
+					if cs.palette == nil && bpc != 8 {
+						sample = byte(int(sample) * 255 / maxValue)
+					}
+					samples[i] = sample
+				}
+				c = cs.color(samples)
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
@@ -0,0 +1,331 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package pdfpreview
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strconv"
+)
+
+type name string
+
+type keyword string
+
+type ref struct {
+	num, gen int
+}
+
+type dict map[name]any
+
+type stream struct {
+	dict dict
+	raw  []byte
+}
+
+var (
+	errUnexpectedEOF = errors.New("unexpected end of data")
+	errTooDeep       = errors.New("object nesting too deep")
+)
+
+// maxObjectDepth limits how deeply arrays and dictionaries can be nested, so that malicious files can't overflow
+// the stack in the recursive parser.
+const maxObjectDepth = 64
+
+func isWhitespace(c byte) bool {
+	return c == 0 || c == '\t' || c == '\n' || c == '\f' || c == '\r' || c == ' '
+}
+
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+type lexer struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		if isWhitespace(c) {
+			l.pos++
+		} else if c == '%' {
+			for l.pos < len(l.data) && l.data[l.pos] != '\n' && l.data[l.pos] != '\r' {
+				l.pos++
+			}
+		} else {
+			return
+		}
+	}
+}
+
+func (l *lexer) readRegular() []byte {
+	start := l.pos
+	for l.pos < len(l.data) && !isWhitespace(l.data[l.pos]) && !isDelimiter(l.data[l.pos]) {
+		l.pos++
+	}
+	return l.data[start:l.pos]
+}
+
+// next reads a single token. Numbers are returned as float64, names as name, strings as []byte and everything else
+// (including array and dictionary delimiters) as keyword.
+func (l *lexer) next() (any, error) {
+	l.skipSpace()
+	if l.pos >= len(l.data) {
+		return nil, errUnexpectedEOF
+	}
+	c := l.data[l.pos]
+	switch {
+	case c == '/':
+		l.pos++
+		return name(decodeName(l.readRegular())), nil
+	case c == '(':
+		return l.readLiteralString()
+	case c == '<':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '<' {
+			l.pos += 2
+			return keyword("<<"), nil
+		}
+		return l.readHexString()
+	case c == '>':
+		if l.pos+1 < len(l.data) && l.data[l.pos+1] == '>' {
+			l.pos += 2
+			return keyword(">>"), nil
+		}
+		l.pos++
+		return keyword(">"), nil
+	case c == '[' || c == ']' || c == '{' || c == '}' || c == ')':
+		l.pos++
+		return keyword(c), nil
+	case c == '+' || c == '-' || c == '.' || (c >= '0' && c <= '9'):
+		token := l.readRegular()
+		number, err := strconv.ParseFloat(string(token), 64)
+		if err != nil {
+			// Some writers output malformed numbers like "--1" or "1.-2", just treat them as zero
+			return float64(0), nil
+		}
+		return number, nil
+	default:
+		token := l.readRegular()
+		if len(token) == 0 {
+			l.pos++
+			return keyword(c), nil
+		}
+		return keyword(token), nil
+	}
+}
+
+func decodeName(raw []byte) string {
+	if bytes.IndexByte(raw, '#') < 0 {
+		return string(raw)
+	}
+	output := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		if raw[i] == '#' && i+2 < len(raw) {
+			if decoded, err := hex.DecodeString(string(raw[i+1 : i+3])); err == nil {
+				output = append(output, decoded[0])
+				i += 2
+				continue
+			}
+		}
+		output = append(output, raw[i])
+	}
+	return string(output)
+}
+
+func (l *lexer) readLiteralString() ([]byte, error) {
+	l.pos++
+	var output []byte
+	depth := 1
+	for l.pos < len(l.data) {
+		c := l.data[l.pos]
+		l.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return output, nil
+			}
+		case '\\':
+			if l.pos >= len(l.data) {
+				return output, nil
+			}
+			c = l.data[l.pos]
+			l.pos++
+			switch c {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+					l.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if c >= '0' && c <= '7' {
+					value := int(c - '0')
+					for i := 0; i < 2 && l.pos < len(l.data) && l.data[l.pos] >= '0' && l.data[l.pos] <= '7'; i++ {
+						value = value*8 + int(l.data[l.pos]-'0')
+						l.pos++
+					}
+					c = byte(value)
+				}
+			}
+		}
+		output = append(output, c)
+	}
+	return output, nil
+}
+
+func (l *lexer) readHexString() ([]byte, error) {
+	l.pos++
+	var digits []byte
+	for l.pos < len(l.data) && l.data[l.pos] != '>' {
+		if c := l.data[l.pos]; !isWhitespace(c) {
+			digits = append(digits, c)
+		}
+		l.pos++
+	}
+	l.pos++
+	if len(digits)%2 == 1 {
+		digits = append(digits, '0')
+	}
+	output := make([]byte, len(digits)/2)
+	_, err := hex.Decode(output, digits)
+	return output, err
+}
+
+// readObject reads a complete object. Operators in content streams are returned as keywords.
+func (l *lexer) readObject() (any, error) {
+	token, err := l.next()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case keyword("["), keyword("<<"):
+		if l.depth >= maxObjectDepth {
+			return nil, errTooDeep
+		}
+		l.depth++
+		defer func() {
+			l.depth--
+		}()
+	}
+	switch token {
+	case keyword("["):
+		var array []any
+		for {
+			l.skipSpace()
+			if l.pos < len(l.data) && l.data[l.pos] == ']' {
+				l.pos++
+				return array, nil
+			}
+			item, err := l.readObject()
+			if err != nil {
+				return array, err
+			}
+			array = append(array, item)
+		}
+	case keyword("<<"):
+		output := make(dict)
+		for {
+			key, err := l.next()
+			if err != nil {
+				return output, err
+			} else if key == keyword(">>") {
+				return output, nil
+			}
+			keyName, ok := key.(name)
+			if !ok {
+				continue
+			}
+			value, err := l.readObject()
+			if err != nil {
+				return output, err
+			}
+			output[keyName] = value
+		}
+	case keyword("true"):
+		return true, nil
+	case keyword("false"):
+		return false, nil
+	case keyword("null"):
+		return nil, nil
+	}
+	if number, ok := token.(float64); ok && number == float64(int(number)) {
+		// Check if this is an indirect reference, i.e. "<num> <gen> R"
+		start := l.pos
+		gen, err := l.next()
+		if genNum, ok := gen.(float64); ok && err == nil {
+			if r, err := l.next(); err == nil && r == keyword("R") {
+				return ref{num: int(number), gen: int(genNum)}, nil
+			}
+		}
+		l.pos = start
+	}
+	return token, nil
+}
+
+// readStreamData reads the data of a stream after the dictionary has been parsed.
+func (l *lexer) readStreamData(streamDict dict) ([]byte, bool) {
+	l.skipSpace()
+	if !bytes.HasPrefix(l.data[l.pos:], []byte("stream")) {
+		return nil, false
+	}
+	l.pos += len("stream")
+	if l.pos < len(l.data) && l.data[l.pos] == '\r' {
+		l.pos++
+	}
+	if l.pos < len(l.data) && l.data[l.pos] == '\n' {
+		l.pos++
+	}
+	start := l.pos
+	// Trust the length if it's a direct value and is followed by endstream
+	if length, ok := streamDict["Length"].(float64); ok && length >= 0 && start+int(length) <= len(l.data) {
+		end := start + int(length)
+		after := bytes.TrimLeft(l.data[end:min(end+32, len(l.data))], "\r\n\t \x00")
+		if bytes.HasPrefix(after, []byte("endstream")) {
+			l.pos = end
+			return l.data[start:end], true
+		}
+	}
+	end := bytes.Index(l.data[start:], []byte("endstream"))
+	if end < 0 {
+		l.pos = len(l.data)
+		return l.data[start:], true
+	}
+	l.pos = start + end + len("endstream")
+	return bytes.TrimRight(l.data[start:start+end], "\r\n"), true
+}
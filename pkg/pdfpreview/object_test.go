@@ -0,0 +1,108 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package pdfpreview
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestReadObject(t *testing.T) {
+	l := &lexer{data: []byte(`<< /Type /Page /Kids [1 0 R 2 0 R] /Name (a\(b\)\101) /Hex <4142> /Flag true /N -1.5 >>`)}
+	value, err := l.readObject()
+	if err != nil {
+		t.Fatalf("failed to read object: %v", err)
+	}
+	obj, ok := value.(dict)
+	if !ok {
+		t.Fatalf("expected dict, got %T", value)
+	}
+	if obj["Type"] != name("Page") {
+		t.Errorf("unexpected type %v", obj["Type"])
+	}
+	if kids, _ := obj["Kids"].([]any); len(kids) != 2 || kids[0] != (ref{num: 1}) || kids[1] != (ref{num: 2}) {
+		t.Errorf("unexpected kids %v", obj["Kids"])
+	}
+	if str, _ := obj["Name"].([]byte); string(str) != "a(b)A" {
+		t.Errorf("unexpected literal string %q", str)
+	}
+	if str, _ := obj["Hex"].([]byte); string(str) != "AB" {
+		t.Errorf("unexpected hex string %q", str)
+	}
+	if obj["Flag"] != true || obj["N"] != -1.5 {
+		t.Errorf("unexpected values %v and %v", obj["Flag"], obj["N"])
+	}
+}
+
+func TestReadObjectTruncated(t *testing.T) {
+	for _, data := range []string{``, `[1 2`, `<< /Type`, `<< /Type /Page /Kids [1 0 R`, `(unterminated`, `<4142`} {
+		l := &lexer{data: []byte(data)}
+		// Truncated objects may be returned partially, but must not loop forever or panic
+		_, _ = l.readObject()
+		if l.pos < len(data) {
+			t.Errorf("lexer stopped at %d/%d in %q", l.pos, len(data), data)
+		}
+	}
+}
+
+func TestReadObjectTooDeep(t *testing.T) {
+	for _, open := range []string{"[", "<< /A "} {
+		data := make([]byte, 0, len(open)*maxObjectDepth*100)
+		for range maxObjectDepth * 100 {
+			data = append(data, open...)
+		}
+		l := &lexer{data: data}
+		if _, err := l.readObject(); !errors.Is(err, errTooDeep) {
+			t.Errorf("expected errTooDeep for nested %q, got %v", open, err)
+		}
+	}
+	// Nesting up to the limit is fine
+	data := make([]byte, 0, maxObjectDepth*2)
+	for range maxObjectDepth {
+		data = append(data, '[')
+	}
+	for range maxObjectDepth {
+		data = append(data, ']')
+	}
+	l := &lexer{data: data}
+	if _, err := l.readObject(); err != nil {
+		t.Errorf("unexpected error for nesting at the limit: %v", err)
+	}
+}
+
+func TestReadStreamData(t *testing.T) {
+	for name, test := range map[string]struct {
+		data     string
+		length   any
+		expected string
+	}{
+		"direct length":   {"stream\nabc endstream\nendstream", float64(13), "abc endstream"},
+		"indirect length": {"stream\r\nabc\r\nendstream", ref{num: 5}, "abc"},
+		"wrong length":    {"stream\nabc\nendstream", float64(1000), "abc"},
+		"truncated":       {"stream\nabc", float64(1000), "abc"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			l := &lexer{data: []byte(test.data)}
+			data, ok := l.readStreamData(dict{"Length": test.length})
+			if !ok {
+				t.Fatalf("stream wasn't found")
+			} else if string(data) != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, data)
+			}
+		})
+	}
+}
@@ -0,0 +1,622 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2026 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package pdfpreview
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/vector"
+)
+
+const (
+	// maxFormDepth limits how deeply form XObjects can be nested.
+	maxFormDepth = 8
+	// maxOperations limits the number of content stream operators executed to avoid spending too long on huge pages.
+	maxOperations = 1_000_000
+	// maxImageDraws limits the number of images drawn, as each one is transformed onto the whole canvas.
+	maxImageDraws = 256
+	// textOpacity is used for drawing text bars, as glyphs don't cover their whole bounding box.
+	textOpacity = 0.55
+)
+
+// matrix is a 2D affine transformation where x' = a*x + c*y + e and y' = b*x + d*y + f.
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+func translate(x, y float64) matrix {
+	return matrix{1, 0, 0, 1, x, y}
+}
+
+// mul returns a matrix that applies n first and then m.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m matrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+// scale returns the average scaling factor of the matrix.
+func (m matrix) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+type point [2]float64
+
+type graphicsState struct {
+	ctm         matrix
+	fill        color.NRGBA
+	stroke      color.NRGBA
+	lineWidth   float64
+	font        dict
+	fontSize    float64
+	charSpacing float64
+	wordSpacing float64
+	hScale      float64
+	leading     float64
+	rise        float64
+	renderMode  int
+}
+
+type renderer struct {
+	doc    *Document
+	canvas *image.RGBA
+	raster *vector.Rasterizer
+
+	state      graphicsState
+	stack      []graphicsState
+	subpaths   [][]point
+	current    []point
+	textMatrix matrix
+	lineMatrix matrix
+	operations int
+	imageDraws int
+}
+
+// RenderFirstPage renders the first page of the document so that the longer side is maxSize pixels.
+func (d *Document) RenderFirstPage(maxSize int) (*image.RGBA, error) {
+	if d.encrypted {
+		return nil, ErrEncrypted
+	}
+	page := d.firstPage()
+	if page == nil {
+		return nil, ErrNoPages
+	}
+	box := d.resolveArray(page["CropBox"])
+	if len(box) < 4 {
+		box = d.resolveArray(page["MediaBox"])
+	}
+	x0, y0, x1, y1 := 0.0, 0.0, 612.0, 792.0
+	if len(box) >= 4 {
+		x0, y0 = d.resolveNumber(box[0], 0), d.resolveNumber(box[1], 0)
+		x1, y1 = d.resolveNumber(box[2], 612), d.resolveNumber(box[3], 792)
+	}
+	x0, x1 = min(x0, x1), max(x0, x1)
+	y0, y1 = min(y0, y1), max(y0, y1)
+	width, height := x1-x0, y1-y0
+	if width <= 0 || height <= 0 {
+		return nil, ErrNoPages
+	}
+	// Rotate the page clockwise into its displayed orientation
+	var rotation matrix
+	switch (int(d.resolveNumber(page["Rotate"], 0))%360 + 360) % 360 {
+	case 90:
+		rotation = matrix{0, -1, 1, 0, 0, width}
+		width, height = height, width
+	case 180:
+		rotation = matrix{-1, 0, 0, -1, width, height}
+	case 270:
+		rotation = matrix{0, 1, -1, 0, height, 0}
+		width, height = height, width
+	default:
+		rotation = identity
+	}
+	s := float64(maxSize) / max(width, height)
+	canvasWidth, canvasHeight := max(int(math.Round(width*s)), 1), max(int(math.Round(height*s)), 1)
+	// PDF coordinates have the origin at the bottom left, so flip the y axis
+	flip := matrix{s, 0, 0, -s, 0, float64(canvasHeight)}
+	r := &renderer{
+		doc:    d,
+		canvas: image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight)),
+		raster: vector.NewRasterizer(canvasWidth, canvasHeight),
+		state: graphicsState{
+			ctm:       flip.mul(rotation).mul(translate(-x0, -y0)),
+			fill:      color.NRGBA{A: 255},
+			stroke:    color.NRGBA{A: 255},
+			lineWidth: 1,
+			hScale:    1,
+		},
+	}
+	draw.Draw(r.canvas, r.canvas.Rect, image.White, image.Point{}, draw.Src)
+	var content []byte
+	switch contents := d.resolve(page["Contents"]).(type) {
+	case *stream:
+		content, _, _ = d.decodeStream(contents)
+	case []any:
+		for _, item := range contents {
+			if s, ok := d.resolve(item).(*stream); ok {
+				data, _, err := d.decodeStream(s)
+				if err == nil {
+					content = append(content, data...)
+					content = append(content, '\n')
+				}
+			}
+		}
+	}
+	r.run(content, d.resolveDict(page["Resources"]), 0)
+	return r.canvas, nil
+}
+
+func (r *renderer) run(content []byte, resources dict, depth int) {
+	l := &lexer{data: content}
+	var operands []any
+	for r.operations < maxOperations {
+		token, err := l.readObject()
+		if err != nil {
+			return
+		}
+		op, isOperator := token.(keyword)
+		if !isOperator {
+			operands = append(operands, token)
+			continue
+		}
+		r.operations++
+		if op == "BI" {
+			skipInlineImage(l)
+		} else {
+			r.execute(string(op), operands, resources, depth)
+		}
+		operands = operands[:0]
+	}
+}
+
+// skipInlineImage skips over the data of an inline image, which can't be tokenized.
+func skipInlineImage(l *lexer) {
+	for {
+		token, err := l.next()
+		if err != nil || token == keyword("ID") {
+			break
+		}
+	}
+	l.pos++
+	for l.pos+2 < len(l.data) {
+		if isWhitespace(l.data[l.pos]) && l.data[l.pos+1] == 'E' && l.data[l.pos+2] == 'I' &&
+			(l.pos+3 >= len(l.data) || isWhitespace(l.data[l.pos+3])) {
+			l.pos += 3
+			return
+		}
+		l.pos++
+	}
+	l.pos = len(l.data)
+}
+
+func numbers(operands []any) []float64 {
+	output := make([]float64, 0, len(operands))
+	for _, operand := range operands {
+		if number, ok := operand.(float64); ok {
+			output = append(output, number)
+		}
+	}
+	return output
+}
+
+func numberColor(values []float64) (color.NRGBA, bool) {
+	unit := func(value float64) uint8 {
+		return uint8(min(max(value, 0), 1) * 255)
+	}
+	switch len(values) {
+	case 1:
+		return color.NRGBA{R: unit(values[0]), G: unit(values[0]), B: unit(values[0]), A: 255}, true
+	case 3:
+		return color.NRGBA{R: unit(values[0]), G: unit(values[1]), B: unit(values[2]), A: 255}, true
+	case 4:
+		k := 1 - values[3]
+		return color.NRGBA{
+			R: unit((1 - values[0]) * k),
+			G: unit((1 - values[1]) * k),
+			B: unit((1 - values[2]) * k),
+			A: 255,
+		}, true
+	}
+	return color.NRGBA{}, false
+}
+
+func (r *renderer) execute(op string, operands []any, resources dict, depth int) {
+	nums := numbers(operands)
+	switch op {
+	// Graphics state
+	case "q":
+		r.stack = append(r.stack, r.state)
+	case "Q":
+		if len(r.stack) > 0 {
+			r.state = r.stack[len(r.stack)-1]
+			r.stack = r.stack[:len(r.stack)-1]
+		}
+	case "cm":
+		if len(nums) == 6 {
+			r.state.ctm = r.state.ctm.mul(matrix(nums))
+		}
+	case "w":
+		if len(nums) == 1 {
+			r.state.lineWidth = nums[0]
+		}
+	// Colors
+	case "g", "rg", "k", "sc", "scn":
+		if c, ok := numberColor(nums); ok {
+			r.state.fill = c
+		}
+	case "G", "RG", "K", "SC", "SCN":
+		if c, ok := numberColor(nums); ok {
+			r.state.stroke = c
+		}
+	case "cs":
+		r.state.fill = color.NRGBA{A: 255}
+	case "CS":
+		r.state.stroke = color.NRGBA{A: 255}
+	// Path construction
+	case "m":
+		if len(nums) == 2 {
+			r.closeSubpath(false)
+			r.current = []point{r.transformPoint(nums[0], nums[1])}
+		}
+	case "l":
+		if len(nums) == 2 && len(r.current) > 0 {
+			r.current = append(r.current, r.transformPoint(nums[0], nums[1]))
+		}
+	case "c":
+		if len(nums) == 6 && len(r.current) > 0 {
+			r.curveTo(r.transformPoint(nums[0], nums[1]), r.transformPoint(nums[2], nums[3]), r.transformPoint(nums[4], nums[5]))
+		}
+	case "v":
+		if len(nums) == 4 && len(r.current) > 0 {
+			r.curveTo(r.current[len(r.current)-1], r.transformPoint(nums[0], nums[1]), r.transformPoint(nums[2], nums[3]))
+		}
+	case "y":
+		if len(nums) == 4 && len(r.current) > 0 {
+			end := r.transformPoint(nums[2], nums[3])
+			r.curveTo(r.transformPoint(nums[0], nums[1]), end, end)
+		}
+	case "h":
+		r.closeSubpath(true)
+	case "re":
+		if len(nums) == 4 {
+			r.closeSubpath(false)
+			x, y, w, h := nums[0], nums[1], nums[2], nums[3]
+			r.subpaths = append(r.subpaths, []point{
+				r.transformPoint(x, y), r.transformPoint(x+w, y),
+				r.transformPoint(x+w, y+h), r.transformPoint(x, y+h), r.transformPoint(x, y),
+			})
+		}
+	// Path painting
+	case "f", "F", "f*":
+		r.fillPath(r.state.fill)
+		r.clearPath()
+	case "S":
+		r.strokePath()
+		r.clearPath()
+	case "s":
+		r.closeSubpath(true)
+		r.strokePath()
+		r.clearPath()
+	case "B", "B*":
+		r.fillPath(r.state.fill)
+		r.strokePath()
+		r.clearPath()
+	case "b", "b*":
+		r.closeSubpath(true)
+		r.fillPath(r.state.fill)
+		r.strokePath()
+		r.clearPath()
+	case "n":
+		r.clearPath()
+	// Text
+	case "BT":
+		r.textMatrix, r.lineMatrix = identity, identity
+	case "Tf":
+		if len(operands) == 2 {
+			fontName, _ := operands[0].(name)
+			r.state.font = r.doc.resolveDict(r.doc.resolveDict(resources["Font"])[fontName])
+			r.state.fontSize = r.doc.resolveNumber(operands[1], 0)
+		}
+	case "Tc":
+		if len(nums) == 1 {
+			r.state.charSpacing = nums[0]
+		}
+	case "Tw":
+		if len(nums) == 1 {
+			r.state.wordSpacing = nums[0]
+		}
+	case "Tz":
+		if len(nums) == 1 {
+			r.state.hScale = nums[0] / 100
+		}
+	case "TL":
+		if len(nums) == 1 {
+			r.state.leading = nums[0]
+		}
+	case "Ts":
+		if len(nums) == 1 {
+			r.state.rise = nums[0]
+		}
+	case "Tr":
+		if len(nums) == 1 {
+			r.state.renderMode = int(nums[0])
+		}
+	case "Td", "TD":
+		if len(nums) == 2 {
+			if op == "TD" {
+				r.state.leading = -nums[1]
+			}
+			r.lineMatrix = r.lineMatrix.mul(translate(nums[0], nums[1]))
+			r.textMatrix = r.lineMatrix
+		}
+	case "Tm":
+		if len(nums) == 6 {
+			r.lineMatrix = matrix(nums)
+			r.textMatrix = r.lineMatrix
+		}
+	case "T*":
+		r.nextLine()
+	case "Tj":
+		if len(operands) == 1 {
+			r.showText(operands[0])
+		}
+	case "'":
+		if len(operands) == 1 {
+			r.nextLine()
+			r.showText(operands[0])
+		}
+	case "\"":
+		if len(operands) == 3 {
+			r.state.wordSpacing = r.doc.resolveNumber(operands[0], 0)
+			r.state.charSpacing = r.doc.resolveNumber(operands[1], 0)
+			r.nextLine()
+			r.showText(operands[2])
+		}
+	case "TJ":
+		if len(operands) == 1 {
+			items, _ := operands[0].([]any)
+			for _, item := range items {
+				if adjustment, ok := item.(float64); ok {
+					r.textMatrix = r.textMatrix.mul(translate(-adjustment/1000*r.state.fontSize*r.state.hScale, 0))
+				} else {
+					r.showText(item)
+				}
+			}
+		}
+	// XObjects
+	case "Do":
+		if len(operands) == 1 {
+			xobjectName, _ := operands[0].(name)
+			if xobject, ok := r.doc.resolve(r.doc.resolveDict(resources["XObject"])[xobjectName]).(*stream); ok {
+				r.drawXObject(xobject, resources, depth)
+			}
+		}
+	}
+}
+
+func (r *renderer) transformPoint(x, y float64) point {
+	x, y = r.state.ctm.apply(x, y)
+	return point{x, y}
+}
+
+func (r *renderer) curveTo(c1, c2, end point) {
+	start := r.current[len(r.current)-1]
+	// Flatten the curve based on its approximate length on the canvas
+	length := math.Hypot(c1[0]-start[0], c1[1]-start[1]) +
+		math.Hypot(c2[0]-c1[0], c2[1]-c1[1]) +
+		math.Hypot(end[0]-c2[0], end[1]-c2[1])
+	steps := min(max(int(length/2), 1), 64)
+	for i := 1; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		inv := 1 - t
+		a, b, c, d := inv*inv*inv, 3*inv*inv*t, 3*inv*t*t, t*t*t
+		r.current = append(r.current, point{
+			a*start[0] + b*c1[0] + c*c2[0] + d*end[0],
+			a*start[1] + b*c1[1] + c*c2[1] + d*end[1],
+		})
+	}
+}
+
+func (r *renderer) closeSubpath(closePath bool) {
+	if len(r.current) == 0 {
+		return
+	}
+	if closePath && len(r.current) > 1 {
+		r.current = append(r.current, r.current[0])
+	}
+	r.subpaths = append(r.subpaths, r.current)
+	if closePath {
+		// A new subpath starts at the same point after closing
+		r.current = []point{r.current[0]}
+	} else {
+		r.current = nil
+	}
+}
+
+func (r *renderer) clearPath() {
+	r.subpaths = nil
+	r.current = nil
+}
+
+func (r *renderer) allSubpaths() [][]point {
+	if len(r.current) > 1 {
+		return append(r.subpaths, r.current)
+	}
+	return r.subpaths
+}
+
+func (r *renderer) fillPolygons(polygons [][]point, fill color.NRGBA) {
+	r.raster.Reset(r.canvas.Rect.Dx(), r.canvas.Rect.Dy())
+	var hasPoints bool
+	for _, polygon := range polygons {
+		if len(polygon) < 2 {
+			continue
+		}
+		hasPoints = true
+		r.raster.MoveTo(float32(polygon[0][0]), float32(polygon[0][1]))
+		for _, pt := range polygon[1:] {
+			r.raster.LineTo(float32(pt[0]), float32(pt[1]))
+		}
+		r.raster.ClosePath()
+	}
+	if hasPoints {
+		r.raster.Draw(r.canvas, r.canvas.Rect, image.NewUniform(fill), image.Point{})
+	}
+}
+
+func (r *renderer) fillPath(fill color.NRGBA) {
+	r.fillPolygons(r.allSubpaths(), fill)
+}
+
+func (r *renderer) strokePath() {
+	// Very thin lines are still drawn at a visible width
+	halfWidth := max(r.state.lineWidth*r.state.ctm.scale(), 0.7) / 2
+	var quads [][]point
+	for _, subpath := range r.allSubpaths() {
+		for i := 1; i < len(subpath); i++ {
+			from, to := subpath[i-1], subpath[i]
+			dx, dy := to[0]-from[0], to[1]-from[1]
+			length := math.Hypot(dx, dy)
+			if length == 0 {
+				continue
+			}
+			nx, ny := -dy/length*halfWidth, dx/length*halfWidth
+			// Extend the segments slightly to cover the gaps at joins
+			ex, ey := dx/length*halfWidth, dy/length*halfWidth
+			quads = append(quads, []point{
+				{from[0] + nx - ex, from[1] + ny - ey},
+				{to[0] + nx + ex, to[1] + ny + ey},
+				{to[0] - nx + ex, to[1] - ny + ey},
+				{from[0] - nx - ex, from[1] - ny - ey},
+			})
+		}
+	}
+	r.fillPolygons(quads, r.state.stroke)
+}
+
+func (r *renderer) nextLine() {
+	r.lineMatrix = r.lineMatrix.mul(translate(0, -r.state.leading))
+	r.textMatrix = r.lineMatrix
+}
+
+// showText draws a string as bars covering each word and advances the text position.
+func (r *renderer) showText(operand any) {
+	text, ok := operand.([]byte)
+	if !ok || r.state.fontSize == 0 {
+		return
+	}
+	bytesPerGlyph := 1
+	if r.state.font["Subtype"] == name("Type0") {
+		bytesPerGlyph = 2
+	}
+	// Glyph widths aren't known without parsing fonts, so use half of the font size as the average width
+	const glyphWidth = 0.5
+	fill := r.state.fill
+	fill.A = uint8(float64(fill.A) * textOpacity)
+	visible := r.state.renderMode != 3 && r.state.renderMode != 7
+	m := r.state.ctm.mul(r.textMatrix)
+	// Bars cover the x-height of the font, which is roughly half of the font size
+	bottom, top := r.state.rise, r.state.rise+r.state.fontSize/2
+	corner := func(x, y float64) point {
+		x, y = m.apply(x, y)
+		return point{x, y}
+	}
+	var bars [][]point
+	wordStart := -1.0
+	var x float64
+	endWord := func() {
+		if wordStart >= 0 && visible {
+			bars = append(bars, []point{corner(wordStart, bottom), corner(x, bottom), corner(x, top), corner(wordStart, top)})
+		}
+		wordStart = -1
+	}
+	for i := 0; i+bytesPerGlyph <= len(text); i += bytesPerGlyph {
+		isSpace := bytesPerGlyph == 1 && (text[i] == ' ' || text[i] == 0)
+		if isSpace {
+			endWord()
+		} else if wordStart < 0 {
+			wordStart = x
+		}
+		advance := glyphWidth*r.state.fontSize + r.state.charSpacing
+		if isSpace {
+			advance += r.state.wordSpacing
+		}
+		x += advance * r.state.hScale
+	}
+	endWord()
+	if len(bars) > 0 {
+		r.fillPolygons(bars, fill)
+	}
+	r.textMatrix = r.textMatrix.mul(translate(x, 0))
+}
+
+func (r *renderer) drawXObject(xobject *stream, resources dict, depth int) {
+	switch xobject.dict["Subtype"] {
+	case name("Form"):
+		if depth >= maxFormDepth {
+			return
+		}
+		content, _, err := r.doc.decodeStream(xobject)
+		if err != nil {
+			return
+		}
+		formResources := r.doc.resolveDict(xobject.dict["Resources"])
+		if formResources == nil {
+			formResources = resources
+		}
+		saved := r.state
+		if formMatrix := r.doc.resolveArray(xobject.dict["Matrix"]); len(formMatrix) == 6 {
+			var m matrix
+			for i := range m {
+				m[i] = r.doc.resolveNumber(formMatrix[i], identity[i])
+			}
+			r.state.ctm = r.state.ctm.mul(m)
+		}
+		savedStack := len(r.stack)
+		r.run(content, formResources, depth+1)
+		r.stack = r.stack[:min(savedStack, len(r.stack))]
+		r.state = saved
+		r.clearPath()
+	case name("Image"):
+		if r.imageDraws >= maxImageDraws {
+			return
+		}
+		r.imageDraws++
+		img := r.doc.decodeImage(xobject, r.state.fill)
+		if img == nil {
+			return
+		}
+		bounds := img.Bounds()
+		// Images are drawn into the unit square, with the first row of the image at the top
+		m := r.state.ctm.mul(matrix{1 / float64(bounds.Dx()), 0, 0, -1 / float64(bounds.Dy()), 0, 1})
+		xdraw.ApproxBiLinear.Transform(r.canvas, f64.Aff3{m[0], m[2], m[4], m[1], m[3], m[5]}, img, bounds, xdraw.Over, nil)
+	}
+}
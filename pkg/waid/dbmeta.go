@@ -20,7 +20,13 @@ import (
 	"crypto/ecdh"
 	"crypto/rand"
 	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"time"
 
+	"github.com/iKonoTelecomunicaciones/go/bridgev2/networkid"
+	"github.com/iKonoTelecomunicaciones/go/id"
 	"go.mau.fi/util/exerrors"
 	"go.mau.fi/util/jsontime"
 	"go.mau.fi/util/random"
@@ -38,6 +44,219 @@ type UserLoginMetadata struct {
 	LoggedInAt      jsontime.Unix `json:"logged_in_at,omitempty"`
 
 	HistorySyncPortalsNeedCreating bool `json:"history_sync_portals_need_creating,omitempty"`
+
+	ChatFilter *ChatFilter          `json:"chat_filter,omitempty"`
+	Webhook    *WebhookSubscription `json:"webhook,omitempty"`
+	AutoReply  *AutoReplySettings   `json:"auto_reply,omitempty"`
+}
+
+type ChatFilterType string
+
+const (
+	ChatFilterTypeAll    ChatFilterType = ""
+	ChatFilterTypeGroups ChatFilterType = "groups"
+	ChatFilterTypeDMs    ChatFilterType = "dms"
+)
+
+// ChatFilter contains rules for which chats portals are automatically created for.
+// Allow and Deny contain glob patterns that are matched against the chat JID.
+type ChatFilter struct {
+	Allow              []string       `json:"allow,omitempty"`
+	Deny               []string       `json:"deny,omitempty"`
+	Type               ChatFilterType `json:"type,omitempty"`
+	ContactsOnly       bool           `json:"contacts_only,omitempty"`
+	ExcludeCommunities bool           `json:"exclude_communities,omitempty"`
+	ExcludeNewsletters bool           `json:"exclude_newsletters,omitempty"`
+}
+
+// MatchJIDPatterns checks if the given JID matches any of the given glob patterns.
+func MatchJIDPatterns(patterns []string, jid types.JID) bool {
+	jidStr := jid.String()
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, jidStr); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows checks the rules of the filter that don't need any external data.
+// The deny list takes priority over the allow list, and if the allow list is not empty,
+// only chats matching it are allowed.
+func (cf *ChatFilter) Allows(chatJID types.JID) bool {
+	if cf == nil {
+		return true
+	}
+	if MatchJIDPatterns(cf.Deny, chatJID) {
+		return false
+	} else if len(cf.Allow) > 0 && !MatchJIDPatterns(cf.Allow, chatJID) {
+		return false
+	}
+	switch chatJID.Server {
+	case types.GroupServer:
+		return cf.Type != ChatFilterTypeDMs
+	case types.DefaultUserServer, types.HiddenUserServer:
+		return cf.Type != ChatFilterTypeGroups
+	case types.NewsletterServer:
+		return !cf.ExcludeNewsletters && cf.Type == ChatFilterTypeAll
+	default:
+		return true
+	}
+}
+
+func (cf *ChatFilter) IsEmpty() bool {
+	return cf == nil || (len(cf.Allow) == 0 && len(cf.Deny) == 0 && cf.Type == ChatFilterTypeAll &&
+		!cf.ContactsOnly && !cf.ExcludeCommunities && !cf.ExcludeNewsletters)
+}
+
+func (cf *ChatFilter) Clone() *ChatFilter {
+	if cf == nil {
+		return &ChatFilter{}
+	}
+	clone := *cf
+	clone.Allow = slices.Clone(cf.Allow)
+	clone.Deny = slices.Clone(cf.Deny)
+	return &clone
+}
+
+type WebhookEventType string
+
+const (
+	WebhookEventMessage     WebhookEventType = "message"
+	WebhookEventReceipt     WebhookEventType = "receipt"
+	WebhookEventGroupChange WebhookEventType = "group_change"
+	WebhookEventLogout      WebhookEventType = "logout"
+	WebhookEventBridgeState WebhookEventType = "bridge_state"
+	WebhookEventTest        WebhookEventType = "test"
+)
+
+var WebhookEventTypes = []WebhookEventType{
+	WebhookEventMessage, WebhookEventReceipt, WebhookEventGroupChange, WebhookEventLogout, WebhookEventBridgeState,
+}
+
+func (wet WebhookEventType) IsValid() bool {
+	return slices.Contains(WebhookEventTypes, wet)
+}
+
+// WebhookSubscription is a webhook target configured for a single login.
+// If Events is empty, all event types are delivered.
+type WebhookSubscription struct {
+	URL    string             `json:"url"`
+	Secret string             `json:"secret,omitempty"`
+	Events []WebhookEventType `json:"events,omitempty"`
+}
+
+// Wants checks whether the subscription wants the given event type. Test events are always delivered.
+func (ws *WebhookSubscription) Wants(evtType WebhookEventType) bool {
+	return ws != nil && ws.URL != "" &&
+		(len(ws.Events) == 0 || evtType == WebhookEventTest || slices.Contains(ws.Events, evtType))
+}
+
+// AutoReplySettings configures messages that are automatically sent to contacts in direct chats.
+// The away message is sent outside business hours and the greeting message is sent once to each contact.
+// The signature is appended to both on a new line.
+type AutoReplySettings struct {
+	Enabled         bool            `json:"enabled"`
+	AwayMessage     string          `json:"away_message,omitempty"`
+	GreetingMessage string          `json:"greeting_message,omitempty"`
+	Signature       string          `json:"signature,omitempty"`
+	BusinessHours   []BusinessHours `json:"business_hours,omitempty"`
+}
+
+// BusinessHours is an opening time range on the given weekdays. Start and End are in HH:MM format,
+// and End may be 24:00 to cover the rest of the day.
+type BusinessHours struct {
+	Days  []time.Weekday `json:"days"`
+	Start string         `json:"start"`
+	End   string         `json:"end"`
+}
+
+func parseClockTime(val string) (int, error) {
+	var hour, minute int
+	_, err := fmt.Sscanf(val, "%d:%d", &hour, &minute)
+	if err != nil || len(val) != 5 || hour < 0 || minute < 0 || minute > 59 || hour > 24 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", val)
+	}
+	return hour*60 + minute, nil
+}
+
+func (bh *BusinessHours) Validate() error {
+	if len(bh.Days) == 0 {
+		return fmt.Errorf("no days specified")
+	}
+	for _, day := range bh.Days {
+		if day < time.Sunday || day > time.Saturday {
+			return fmt.Errorf("invalid weekday %d", day)
+		}
+	}
+	start, err := parseClockTime(bh.Start)
+	if err != nil {
+		return err
+	}
+	end, err := parseClockTime(bh.End)
+	if err != nil {
+		return err
+	} else if start >= end {
+		return fmt.Errorf("start time %s is not before end time %s", bh.Start, bh.End)
+	}
+	return nil
+}
+
+// Contains checks if the given time is within the range. The time should already be in the user's timezone.
+func (bh *BusinessHours) Contains(t time.Time) bool {
+	if !slices.Contains(bh.Days, t.Weekday()) {
+		return false
+	}
+	start, err := parseClockTime(bh.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClockTime(bh.End)
+	if err != nil {
+		return false
+	}
+	minutes := t.Hour()*60 + t.Minute()
+	return minutes >= start && minutes < end
+}
+
+func (ars *AutoReplySettings) Validate() error {
+	for i, bh := range ars.BusinessHours {
+		if err := bh.Validate(); err != nil {
+			return fmt.Errorf("invalid business hours #%d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// IsOpen checks if the given time is within business hours. If no business hours are set, it's always open.
+func (ars *AutoReplySettings) IsOpen(t time.Time) bool {
+	if len(ars.BusinessHours) == 0 {
+		return true
+	}
+	for _, bh := range ars.BusinessHours {
+		if bh.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ars *AutoReplySettings) IsEmpty() bool {
+	return ars == nil || (!ars.Enabled && ars.AwayMessage == "" && ars.GreetingMessage == "" &&
+		ars.Signature == "" && len(ars.BusinessHours) == 0)
+}
+
+func (ars *AutoReplySettings) Clone() *AutoReplySettings {
+	if ars == nil {
+		return &AutoReplySettings{}
+	}
+	clone := *ars
+	clone.BusinessHours = make([]BusinessHours, len(ars.BusinessHours))
+	for i, bh := range ars.BusinessHours {
+		clone.BusinessHours[i] = bh
+		clone.BusinessHours[i].Days = slices.Clone(bh.Days)
+	}
+	return &clone
 }
 
 type PushKeys struct {
@@ -105,6 +324,8 @@ func (mm *MessageMetadata) CopyFrom(other any) {
 
 type ReactionMetadata struct {
 	SenderDeviceID uint16 `json:"sender_device_id,omitempty"`
+	// RelayedMessageID is the ID of the text message that was sent instead of a reaction for a relayed user.
+	RelayedMessageID types.MessageID `json:"relayed_message_id,omitempty"`
 }
 
 type PortalMetadata struct {
@@ -114,6 +335,50 @@ type PortalMetadata struct {
 	CommunityAnnouncementGroup bool                 `json:"is_cag,omitempty"`
 	AddressingMode             types.AddressingMode `json:"addressing_mode,omitempty"`
 	LIDMigrationAttempted      bool                 `json:"lid_migration_attempted,omitempty"`
+
+	PreferredLogins map[id.UserID]networkid.UserLoginID `json:"preferred_logins,omitempty"`
+	RelayTemplates  *RelayTemplates                     `json:"relay_templates,omitempty"`
+}
+
+// RelayTemplates contains Go text/templates for formatting messages sent through a relay login.
+// Empty templates fall back to the bridge-wide defaults.
+type RelayTemplates struct {
+	Text            string `json:"text,omitempty" yaml:"text"`
+	Caption         string `json:"caption,omitempty" yaml:"caption"`
+	Edit            string `json:"edit,omitempty" yaml:"edit"`
+	Reaction        string `json:"reaction,omitempty" yaml:"reaction"`
+	ReactionsAsText *bool  `json:"reactions_as_text,omitempty" yaml:"reactions_as_text"`
+}
+
+// Merge returns a copy of the templates with non-empty fields from the override applied.
+func (rt *RelayTemplates) Merge(override *RelayTemplates) *RelayTemplates {
+	var merged RelayTemplates
+	if rt != nil {
+		merged = *rt
+	}
+	if override == nil {
+		return &merged
+	}
+	if override.Text != "" {
+		merged.Text = override.Text
+	}
+	if override.Caption != "" {
+		merged.Caption = override.Caption
+	}
+	if override.Edit != "" {
+		merged.Edit = override.Edit
+	}
+	if override.Reaction != "" {
+		merged.Reaction = override.Reaction
+	}
+	if override.ReactionsAsText != nil {
+		merged.ReactionsAsText = override.ReactionsAsText
+	}
+	return &merged
+}
+
+func (rt *RelayTemplates) IsEmpty() bool {
+	return rt == nil || (rt.Text == "" && rt.Caption == "" && rt.Edit == "" && rt.Reaction == "" && rt.ReactionsAsText == nil)
 }
 
 type GhostMetadata struct {